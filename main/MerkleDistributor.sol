@@ -0,0 +1,64 @@
+//SPDX-License-Identifier: MIT
+/*
+Pays out an EIP20 token to the accounts of a Merkle tree of (account, amount) leaves,
+each leaf claimed once, by anyone on behalf of its account.
+.*/
+pragma solidity ^0.8.0;
+
+interface IEIP20 {
+    function balanceOf(address _owner) external view returns (uint256 balance);
+    function transfer(address _to, uint256 _value) external returns (bool success);
+}
+
+contract MerkleDistributor {
+    address public immutable token;
+    bytes32 public immutable merkleRoot;
+    address public immutable owner;
+
+    /// accounts which claimed their amount
+    mapping(address => bool) public isClaimed;
+
+    event Claimed(address indexed account, uint256 amount);
+    event Withdrawn(address indexed to, uint256 amount);
+
+    constructor(address _token, bytes32 _merkleRoot) {
+        token = _token;
+        merkleRoot = _merkleRoot;
+        owner = msg.sender;
+    }
+
+    /// @notice sends `_amount` tokens to `_account`, once, given the proof of the leaf
+    ///         keccak256(abi.encodePacked(_account, _amount))
+    /// @param _account The account the amount is paid to
+    /// @param _amount The amount of the leaf
+    /// @param _merkleProof The sibling hashes from the leaf up to the root
+    function claim(address _account, uint256 _amount, bytes32[] calldata _merkleProof) external {
+        require(!isClaimed[_account], "MerkleDistributor: already claimed");
+        bytes32 node = keccak256(abi.encodePacked(_account, _amount));
+        require(verify(_merkleProof, node), "MerkleDistributor: invalid proof");
+        isClaimed[_account] = true;
+        require(IEIP20(token).transfer(_account, _amount), "MerkleDistributor: transfer failed");
+        emit Claimed(_account, _amount);
+    }
+
+    /// @notice sends the tokens left unclaimed to `_to`
+    /// @param _to The address of the recipient
+    function withdraw(address _to) external {
+        require(msg.sender == owner, "MerkleDistributor: not the owner");
+        uint256 amount = IEIP20(token).balanceOf(address(this));
+        require(IEIP20(token).transfer(_to, amount), "MerkleDistributor: transfer failed");
+        emit Withdrawn(_to, amount);
+    }
+
+    /// hashes the pairs sorted, as OpenZeppelin's MerkleProof
+    function verify(bytes32[] calldata _proof, bytes32 _leaf) internal view returns (bool) {
+        bytes32 computed = _leaf;
+        for (uint256 i = 0; i < _proof.length; i++) {
+            bytes32 sibling = _proof[i];
+            computed = computed <= sibling
+                ? keccak256(abi.encodePacked(computed, sibling))
+                : keccak256(abi.encodePacked(sibling, computed));
+        }
+        return computed == merkleRoot;
+    }
+}
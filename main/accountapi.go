@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// AccountAPI serves what is private to an account, to the account signed in only:
+//
+//	GET /accounts/<owner>/allowances?token=dtoken
+//
+// It's served behind SiweAuth.Require.
+type AccountAPI struct {
+	Tokens *TokenService
+}
+
+func (a *AccountAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/accounts"), "/"), "/")
+	if len(parts) != 2 || parts[1] != "allowances" || r.Method != http.MethodGet {
+		writeResult(w, http.StatusNotFound, apiError{"not found"})
+		return
+	}
+	if !common.IsHexAddress(parts[0]) {
+		writeResult(w, http.StatusBadRequest, apiError{"invalid address " + parts[0]})
+		return
+	}
+	owner := common.HexToAddress(parts[0])
+	if signer, ok := SessionAddress(r.Context()); !ok || signer != owner {
+		writeResult(w, http.StatusForbidden, apiError{"only " + owner.Hex() + " may see its allowances"})
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token = "dtoken"
+	}
+	allowances, err := a.Tokens.Allowances(r.Context(), token, owner)
+	switch {
+	case errors.Is(err, ErrNotDeployed):
+		writeResult(w, http.StatusNotFound, apiError{err.Error()})
+	case err != nil:
+		writeResult(w, http.StatusInternalServerError, apiError{err.Error()})
+	default:
+		writeResult(w, http.StatusOK, allowances)
+	}
+}
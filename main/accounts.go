@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// DefaultAccount is the name of the account of the top level keys of the config.
+const DefaultAccount = "default"
+
+// ErrUnknownAccount is returned for a signer which is not an account of the config.
+var ErrUnknownAccount = errors.New("unknown account")
+
+// Account is a key of the config which signs txs, with the nonces of its own.
+type Account struct {
+	Name    string
+	Address common.Address
+	Key     *ecdsa.PrivateKey
+	Nonces  *NonceManager
+}
+
+// Accounts are the accounts of the config, looked up by name or address.
+// The first one signs when no other is asked for.
+type Accounts struct {
+	list []*Account
+}
+
+// NewAccounts gives each account a NonceManager of backend.
+func NewAccounts(backend NonceBackend, list []*Account) (*Accounts, error) {
+	if len(list) == 0 {
+		return nil, errors.New("no account")
+	}
+	names := make(map[string]bool)
+	addrs := make(map[common.Address]string)
+	for _, acc := range list {
+		if names[acc.Name] {
+			return nil, fmt.Errorf("account %q is defined twice", acc.Name)
+		}
+		if other, ok := addrs[acc.Address]; ok {
+			return nil, fmt.Errorf("accounts %q and %q are both %s", other, acc.Name, acc.Address)
+		}
+		names[acc.Name], addrs[acc.Address] = true, acc.Name
+		acc.Nonces = &NonceManager{Backend: backend, Account: acc.Address}
+	}
+	return &Accounts{list: list}, nil
+}
+
+// Default returns the account signing when no other is asked for.
+func (a *Accounts) Default() *Account {
+	return a.list[0]
+}
+
+// List returns the accounts, the default first.
+func (a *Accounts) List() []*Account {
+	return a.list
+}
+
+// Get looks an account up by name or hex address, the default one for "".
+func (a *Accounts) Get(nameOrAddress string) (*Account, error) {
+	if nameOrAddress == "" {
+		return a.Default(), nil
+	}
+	for _, acc := range a.list {
+		if acc.Name == nameOrAddress {
+			return acc, nil
+		}
+	}
+	if common.IsHexAddress(nameOrAddress) {
+		if acc := a.byAddress(common.HexToAddress(nameOrAddress)); acc != nil {
+			return acc, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownAccount, nameOrAddress)
+}
+
+// Nonces returns the NonceManager of addr, nil for an address not of the accounts.
+// Nil accounts have none.
+func (a *Accounts) Nonces(addr common.Address) *NonceManager {
+	if a == nil {
+		return nil
+	}
+	if acc := a.byAddress(addr); acc != nil {
+		return acc.Nonces
+	}
+	return nil
+}
+
+func (a *Accounts) byAddress(addr common.Address) *Account {
+	for _, acc := range a.list {
+		if acc.Address == addr {
+			return acc
+		}
+	}
+	return nil
+}
+
+type signerKey struct{}
+
+// withSigner makes the txs sent under ctx signed by the account of nameOrAddress.
+func withSigner(ctx context.Context, nameOrAddress string) context.Context {
+	if nameOrAddress == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, signerKey{}, nameOrAddress)
+}
+
+// signerOf returns the account asked for by withSigner, "" for the default.
+func signerOf(ctx context.Context) string {
+	s, _ := ctx.Value(signerKey{}).(string)
+	return s
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestLoadAccounts(t *testing.T) {
+	a, b, c := newTestAccount(t), newTestAccount(t), newTestAccount(t)
+	secret := func(acc testAccount) string { return hexutil.Encode(crypto.FromECDSA(acc.key))[2:] }
+
+	conf := &Config{
+		AccountConfig: AccountConfig{SecretHex: secret(a)},
+		Accounts:      []AccountConfig{{Name: "ops", SecretHex: secret(b)}},
+	}
+	if err := conf.loadSecret(); err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.accounts) != 2 || conf.accounts[0].Name != DefaultAccount || conf.accounts[0].Address != a.addr ||
+		conf.accounts[1].Name != "ops" || conf.accounts[1].Address != b.addr || conf.PrivateKey() != conf.accounts[0].Key {
+		t.Fatalf("loaded %+v", conf.accounts)
+	}
+
+	// without the top level keys, an account named default is the default
+	conf = &Config{Accounts: []AccountConfig{{Name: "ops", SecretHex: secret(b)}, {Name: DefaultAccount, SecretHex: secret(c)}}}
+	if err := conf.loadSecret(); err != nil {
+		t.Fatal(err)
+	}
+	accounts, err := NewAccounts(nil, conf.accounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accounts.Default().Address != c.addr {
+		t.Fatalf("default is %s, want %s", accounts.Default().Address, c.addr)
+	}
+	for _, key := range []string{"", DefaultAccount, c.addr.Hex()} {
+		if acc, err := accounts.Get(key); err != nil || acc.Address != c.addr {
+			t.Errorf("got %q: %v", key, err)
+		}
+	}
+	if acc, err := accounts.Get("ops"); err != nil || acc.Address != b.addr {
+		t.Errorf("got ops: %v", err)
+	}
+	if _, err := accounts.Get(a.addr.Hex()); !errors.Is(err, ErrUnknownAccount) {
+		t.Errorf("got an address of no account: %v", err)
+	}
+
+	for _, bad := range []*Config{
+		{Accounts: []AccountConfig{{SecretHex: secret(b)}}},
+		{Accounts: []AccountConfig{{Name: "ops", AccountType: 7}}},
+	} {
+		if err := bad.loadSecret(); err == nil {
+			t.Errorf("loaded %+v", bad.Accounts)
+		}
+	}
+	twice := &Config{AccountConfig: AccountConfig{SecretHex: secret(a)}, Accounts: []AccountConfig{{Name: "ops", SecretHex: secret(a)}}}
+	if err := twice.loadSecret(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewAccounts(nil, twice.accounts); err == nil {
+		t.Error("two accounts of the same address")
+	}
+}
+
+func TestAccountNonces(t *testing.T) {
+	env := newTestEnv(t, 2)
+	accounts, err := NewAccounts(env.backend, []*Account{
+		{Name: DefaultAccount, Address: env.accounts[0].addr, Key: env.accounts[0].key},
+		{Name: "ops", Address: env.accounts[1].addr, Key: env.accounts[1].key},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := signers
+	signers = accounts
+	t.Cleanup(func() { signers = saved })
+
+	ctx := context.Background()
+	pay := func(i int) *types.Transaction {
+		t.Helper()
+		auth := env.accounts[i].transactor(t)
+		auth.Value, auth.GasLimit = big.NewInt(1), 21000
+		tx, err := send(ctx, auth, "pay", bind.NewBoundContract(common.Address{0x01}, abi.ABI{}, env.backend, env.backend, env.backend).Transfer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+	// each account counts its own nonces, before any tx is mined
+	for i, want := range []struct{ account, nonce int }{{0, 0}, {0, 1}, {1, 0}, {0, 2}, {1, 1}} {
+		if tx := pay(want.account); tx.Nonce() != uint64(want.nonce) {
+			t.Fatalf("tx %d of account %d has nonce %d, want %d", i, want.account, tx.Nonce(), want.nonce)
+		}
+	}
+
+	// a failed send gives its nonce back
+	_, err = send(ctx, env.accounts[1].transactor(t), "fail", func(*bind.TransactOpts) (*types.Transaction, error) {
+		return nil, errors.New("refused")
+	})
+	if err == nil {
+		t.Fatal("failed send without error")
+	}
+	env.backend.Commit()
+	if tx := pay(1); tx.Nonce() != 2 {
+		t.Fatalf("nonce %d after a failed send, want 2", tx.Nonce())
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const AirdropFile = "airdrop.json"
+
+var (
+	// ErrNoClaim is returned for an account without a leaf in the airdrop tree.
+	ErrNoClaim = errors.New("no airdrop claim")
+	// ErrAlreadyClaimed is returned for an account which claimed its amount.
+	ErrAlreadyClaimed = errors.New("airdrop already claimed")
+	// ErrClaimPending is returned for an account whose claim tx is neither mined nor dropped yet.
+	ErrClaimPending = errors.New("airdrop claim pending")
+)
+
+// ReadBalancesCSV reads the address,amount rows of r. A first row which is
+// not an address is taken for a header.
+func ReadBalancesCSV(r io.Reader) ([]*HolderBalance, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var balances []*HolderBalance
+	seen := make(map[common.Address]bool)
+	for i, row := range rows {
+		if len(row) < 2 {
+			return nil, fmt.Errorf("row %d: want address,amount", i+1)
+		}
+		if i == 0 && !common.IsHexAddress(row[0]) {
+			continue
+		}
+		if !common.IsHexAddress(row[0]) {
+			return nil, fmt.Errorf("row %d: invalid address %q", i+1, row[0])
+		}
+		amount, ok := new(big.Int).SetString(strings.TrimSpace(row[1]), 10)
+		if !ok || amount.Sign() <= 0 {
+			return nil, fmt.Errorf("row %d: invalid amount %q", i+1, row[1])
+		}
+		addr := common.HexToAddress(row[0])
+		if seen[addr] {
+			return nil, fmt.Errorf("row %d: %s is listed twice", i+1, addr)
+		}
+		seen[addr] = true
+		balances = append(balances, &HolderBalance{addr, amount})
+	}
+	return balances, nil
+}
+
+// Airdrop is a MerkleDistributor paying out the amounts of its Distribution.
+type Airdrop struct {
+	ChainID      uint64              `json:"chainId"`
+	Token        common.Address      `json:"token"`
+	Distributor  common.Address      `json:"distributor"`
+	TxHash       common.Hash         `json:"txHash"`
+	BlockNumber  uint64              `json:"blockNumber"`
+	FundTxHash   *common.Hash        `json:"fundTxHash,omitempty"`
+	Distribution *MerkleDistribution `json:"distribution"`
+}
+
+// LoadAirdrop reads the airdrop file. A missing file gives nil.
+func LoadAirdrop(path string) (*Airdrop, error) {
+	bs, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var a Airdrop
+	if err := json.Unmarshal(bs, &a); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &a, nil
+}
+
+// Save writes the airdrop to path.
+func (a *Airdrop) Save(path string) error {
+	bs, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, bs)
+}
+
+// AirdropClaim is the claim of an account, and whether it's claimed already.
+type AirdropClaim struct {
+	*MerkleClaim
+	Distributor common.Address `json:"distributor"`
+	Claimed     bool           `json:"claimed"`
+}
+
+// AirdropService deploys, funds and claims the airdrops.
+type AirdropService struct {
+	Backend AirdropBackend
+	ChainID *big.Int
+	// Transactor signs the txs sent under ctx
+	Transactor func(ctx context.Context) (*bind.TransactOpts, error)
+	// Wait waits for the receipt of a sent tx
+	Wait func(ctx context.Context, tx *types.Transaction) (*types.Receipt, error)
+
+	mu sync.Mutex
+	// pending are the last claim txs sent by account, a zero hash while one is sent
+	pending map[common.Address]common.Hash
+}
+
+// AirdropBackend is what the AirdropService needs from the rpc-server.
+type AirdropBackend interface {
+	bind.ContractBackend
+	TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+// Deploy deploys the MerkleDistributor of dist paying out token, and waits for it.
+func (s *AirdropService) Deploy(ctx context.Context, token common.Address, dist *MerkleDistribution) (*Airdrop, error) {
+	auth, err := s.Transactor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var addr common.Address
+	tx, err := send(ctx, auth, "MerkleDistributor.deploy", func(opts *bind.TransactOpts) (tx *types.Transaction, err error) {
+		addr, tx, _, err = DeployMerkleDistributor(opts, s.Backend, token, dist.Root)
+		return tx, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("DeployMerkleDistributor: %w", err)
+	}
+	ctx = withTxLog(withLog(ctx, "contract", addr), tx)
+	logger(ctx).Info("Send distributor deploy tx", "token", token, "root", dist.Root, "claims", len(dist.Claims))
+	receipt, err := s.wait(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	logger(ctx).Info("Distributor is deployed", "block", receipt.BlockNumber)
+	return &Airdrop{
+		ChainID:      s.ChainID.Uint64(),
+		Token:        token,
+		Distributor:  addr,
+		TxHash:       tx.Hash(),
+		BlockNumber:  receipt.BlockNumber.Uint64(),
+		Distribution: dist,
+	}, nil
+}
+
+// Fund transfers to the distributor the tokens it misses to pay out the unclaimed amounts,
+// and waits for it. A funded distributor gets nothing.
+func (s *AirdropService) Fund(ctx context.Context, a *Airdrop) error {
+	distributor, err := NewMerkleDistributorCaller(a.Distributor, s.Backend)
+	if err != nil {
+		return err
+	}
+	token, err := NewEIP20(a.Token, s.Backend)
+	if err != nil {
+		return err
+	}
+	opts := &bind.CallOpts{Context: ctx}
+	owed := new(big.Int)
+	for _, c := range a.Distribution.Claims {
+		claimed, err := distributor.IsClaimed(opts, c.Account)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			owed.Add(owed, c.Amount)
+		}
+	}
+	balance, err := token.BalanceOf(opts, a.Distributor)
+	if err != nil {
+		return err
+	}
+	missing := owed.Sub(owed, balance)
+	if missing.Sign() <= 0 {
+		return nil
+	}
+	auth, err := s.Transactor(ctx)
+	if err != nil {
+		return err
+	}
+	tx, err := send(ctx, auth, "EIP20.transfer", func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return token.Transfer(opts, a.Distributor, missing)
+	})
+	if err != nil {
+		return fmt.Errorf("fund: %w", err)
+	}
+	ctx = withTxLog(withLog(ctx, "contract", a.Token), tx)
+	logger(ctx).Info("Send distributor funding tx", "distributor", a.Distributor, "amount", missing)
+	if _, err := s.wait(ctx, tx); err != nil {
+		return err
+	}
+	hash := tx.Hash()
+	a.FundTxHash = &hash
+	return nil
+}
+
+// Status returns the claim of account.
+func (s *AirdropService) Status(ctx context.Context, a *Airdrop, account common.Address) (*AirdropClaim, error) {
+	c := a.Distribution.Claim(account)
+	if c == nil {
+		return nil, fmt.Errorf("%w for %s", ErrNoClaim, account)
+	}
+	distributor, err := NewMerkleDistributorCaller(a.Distributor, s.Backend)
+	if err != nil {
+		return nil, err
+	}
+	claimed, err := distributor.IsClaimed(&bind.CallOpts{Context: ctx}, account)
+	if err != nil {
+		return nil, err
+	}
+	return &AirdropClaim{MerkleClaim: c, Distributor: a.Distributor, Claimed: claimed}, nil
+}
+
+// Claim sends the claim of account, paid for by the transactor, without waiting for it.
+// One claim of account is sent at a time, until its tx is mined or dropped.
+func (s *AirdropService) Claim(ctx context.Context, a *Airdrop, account common.Address) (tx *types.Transaction, err error) {
+	if err := s.reserve(ctx, account); err != nil {
+		return nil, err
+	}
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err != nil {
+			delete(s.pending, account)
+		} else {
+			s.pending[account] = tx.Hash()
+		}
+	}()
+	status, err := s.Status(ctx, a, account)
+	if err != nil {
+		return nil, err
+	}
+	if status.Claimed {
+		return nil, fmt.Errorf("%w by %s", ErrAlreadyClaimed, account)
+	}
+	distributor, err := NewMerkleDistributor(a.Distributor, s.Backend)
+	if err != nil {
+		return nil, err
+	}
+	proof := make([][32]byte, len(status.Proof))
+	for i, h := range status.Proof {
+		proof[i] = h
+	}
+	auth, err := s.Transactor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tx, err = send(ctx, auth, "MerkleDistributor.claim", func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return distributor.Claim(opts, account, status.Amount, proof)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("claim: %w", err)
+	}
+	logger(withTxLog(withLog(ctx, "contract", a.Distributor), tx)).Info("Send airdrop claim tx", "account", account, "amount", status.Amount)
+	return tx, nil
+}
+
+// reserve marks a claim of account as sent, unless the last one is still pending.
+// The lock is held while asking for the last one, for two claims not to pass together.
+func (s *AirdropService) reserve(ctx context.Context, account common.Address) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hash, ok := s.pending[account]; ok && (hash == common.Hash{} || s.inFlight(ctx, hash)) {
+		return fmt.Errorf("%w for %s: tx %s", ErrClaimPending, account, hash)
+	}
+	if s.pending == nil {
+		s.pending = make(map[common.Address]common.Hash)
+	}
+	s.pending[account] = common.Hash{}
+	return nil
+}
+
+// inFlight tells if the tx of hash is neither mined nor dropped by the rpc-server.
+// It is, when the rpc-server can't tell.
+func (s *AirdropService) inFlight(ctx context.Context, hash common.Hash) bool {
+	if _, err := s.Backend.TransactionReceipt(ctx, hash); err == nil {
+		return false
+	}
+	_, _, err := s.Backend.TransactionByHash(ctx, hash)
+	return !errors.Is(err, ethereum.NotFound)
+}
+
+// wait waits for tx, even if a shutdown starts, and fails unless it succeeded.
+func (s *AirdropService) wait(ctx context.Context, tx *types.Transaction) (*types.Receipt, error) {
+	waitCtx, cancel := withGrace(ctx)
+	defer cancel()
+	receipt, err := s.Wait(waitCtx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return nil, fmt.Errorf("tx %s failed", tx.Hash())
+	}
+	return receipt, nil
+}
+
+// AirdropAPI serves the proofs of an Airdrop, and claims on behalf of the accounts:
+//
+//	GET  /airdrop
+//	GET  /airdrop/<account>
+//	POST /airdrop/<account>/claim
+//
+// A client address claims once every ClaimInterval, and an account has one claim pending at a time.
+type AirdropAPI struct {
+	Service *AirdropService
+	Airdrop *Airdrop
+	// ClaimInterval is the least time between two claims of a client address, no limit if 0
+	ClaimInterval time.Duration
+	// Auth signs the accounts in, an account claims in its own session only.
+	// No claims are served without it, their gas is paid by the server.
+	Auth *SiweAuth
+
+	mu     sync.Mutex
+	claims map[string]time.Time
+}
+
+// allowClaim tells if client may claim now, and if so counts its claim.
+func (a *AirdropAPI) allowClaim(client string) bool {
+	if a.ClaimInterval == 0 {
+		return true
+	}
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.claims == nil {
+		a.claims = make(map[string]time.Time)
+	}
+	for c, last := range a.claims {
+		if now.Sub(last) >= a.ClaimInterval {
+			delete(a.claims, c)
+		}
+	}
+	if _, ok := a.claims[client]; ok {
+		return false
+	}
+	a.claims[client] = now
+	return true
+}
+
+func (a *AirdropAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/airdrop"), "/")
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		writeResult(w, http.StatusOK, map[string]interface{}{
+			"token":       a.Airdrop.Token,
+			"distributor": a.Airdrop.Distributor,
+			"root":        a.Airdrop.Distribution.Root,
+			"total":       a.Airdrop.Distribution.Total,
+			"claims":      len(a.Airdrop.Distribution.Claims),
+		})
+	case path != "" && !common.IsHexAddress(parts[0]):
+		writeResult(w, http.StatusBadRequest, apiError{fmt.Sprintf("invalid account %q", parts[0])})
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		status, err := a.Service.Status(r.Context(), a.Airdrop, common.HexToAddress(parts[0]))
+		if err != nil {
+			a.fail(w, err)
+			return
+		}
+		writeResult(w, http.StatusOK, status)
+	case len(parts) == 2 && parts[1] == "claim" && r.Method == http.MethodPost:
+		account := common.HexToAddress(parts[0])
+		if a.Auth == nil {
+			writeResult(w, http.StatusNotFound, apiError{"claims are not served without sign-in"})
+			return
+		}
+		if s, ok := a.Auth.Session(bearerToken(r)); !ok {
+			writeResult(w, http.StatusUnauthorized, apiError{"a session token is required, sign in at /auth/login"})
+			return
+		} else if s.Address != account {
+			writeResult(w, http.StatusForbidden, apiError{fmt.Sprintf("signed in as %s, not %s", s.Address, account)})
+			return
+		}
+		if !a.allowClaim(clientAddress(r)) {
+			writeResult(w, http.StatusTooManyRequests, apiError{fmt.Sprintf("one claim every %s", a.ClaimInterval)})
+			return
+		}
+		tx, err := a.Service.Claim(r.Context(), a.Airdrop, account)
+		if err != nil {
+			a.fail(w, err)
+			return
+		}
+		writeResult(w, http.StatusAccepted, map[string]interface{}{"txHash": tx.Hash(), "nonce": tx.Nonce()})
+	default:
+		writeResult(w, http.StatusNotFound, apiError{"not found"})
+	}
+}
+
+func (a *AirdropAPI) fail(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNoClaim):
+		writeResult(w, http.StatusNotFound, apiError{err.Error()})
+	case errors.Is(err, ErrAlreadyClaimed), errors.Is(err, ErrClaimPending):
+		writeResult(w, http.StatusConflict, apiError{err.Error()})
+	case errors.Is(err, ErrPolicyViolation):
+		writeResult(w, http.StatusForbidden, apiError{err.Error()})
+	default:
+		writeResult(w, http.StatusInternalServerError, apiError{err.Error()})
+	}
+}
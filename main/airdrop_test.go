@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestReadBalancesCSV(t *testing.T) {
+	balances, err := ReadBalancesCSV(strings.NewReader("address,balance\n0x0100000000000000000000000000000000000000,10\n0x0200000000000000000000000000000000000000,20\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 2 || balances[1].Address != (common.Address{0x02}) || balances[1].Balance.Int64() != 20 {
+		t.Fatalf("got %v", balances)
+	}
+	for _, bad := range []string{
+		"0x0100000000000000000000000000000000000000,ten\n",
+		"0x0100000000000000000000000000000000000000,0\n",
+		"0x0100000000000000000000000000000000000000,1\n0x01,1\n",
+		"0x0100000000000000000000000000000000000000,1\n0x0100000000000000000000000000000000000000,2\n",
+	} {
+		if _, err := ReadBalancesCSV(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q read without error", bad)
+		}
+	}
+}
+
+func TestAirdrop(t *testing.T) {
+	env := newTestEnv(t, 1)
+	token, _, contract := env.deploy(10000)
+	s := &AirdropService{
+		Backend: env.backend,
+		ChainID: simChainID,
+		Transactor: func(ctx context.Context) (*bind.TransactOpts, error) {
+			return env.accounts[0].transactor(t), nil
+		},
+		Wait: func(ctx context.Context, tx *types.Transaction) (*types.Receipt, error) {
+			env.backend.Commit()
+			return env.backend.TransactionReceipt(ctx, tx.Hash())
+		},
+	}
+	alice, bob, carol := common.Address{0x0a}, common.Address{0x0b}, common.Address{0x0c}
+	dist := NewMerkleDistribution([]*HolderBalance{{alice, big.NewInt(100)}, {bob, big.NewInt(200)}, {carol, big.NewInt(300)}})
+
+	ctx := context.Background()
+	a, err := s.Deploy(ctx, token, dist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Fund(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	env.requireBalance(contract, a.Distributor, 600)
+
+	// bob and alice are signed in
+	auth := &SiweAuth{sessions: map[string]*Session{}}
+	for token, account := range map[string]common.Address{"bob-session": bob, "alice-session": alice} {
+		auth.sessions[token] = &Session{Token: token, Address: account, ExpiresAt: time.Now().Add(time.Hour)}
+	}
+	srv := httptest.NewServer(&AirdropAPI{Service: s, Airdrop: a, Auth: auth})
+	defer srv.Close()
+	call := func(method, path string, out interface{}) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer bob-session")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if out != nil {
+			json.NewDecoder(resp.Body).Decode(out)
+		}
+		return resp.StatusCode
+	}
+
+	// the proof served is the one the contract checks
+	var status AirdropClaim
+	if code := call("GET", "/airdrop/"+bob.Hex(), &status); code != http.StatusOK || status.Claimed || status.Amount.Int64() != 200 {
+		t.Fatalf("status of bob: %d %+v", code, status)
+	}
+	if !VerifyMerkleProof(dist.Root, BalanceLeaf(bob, status.Amount), status.Proof) {
+		t.Fatal("served proof does not verify")
+	}
+	// the server pays the gas of the claims: only the account claims, signed in
+	if code := call("POST", "/airdrop/"+alice.Hex()+"/claim", nil); code != http.StatusForbidden {
+		t.Fatalf("claim of alice by bob: %d", code)
+	}
+	for bearer, want := range map[string]int{"": http.StatusUnauthorized, "expired": http.StatusUnauthorized} {
+		req, _ := http.NewRequest("POST", srv.URL+"/airdrop/"+bob.Hex()+"/claim", nil)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("claim of bob with %q: %d, want %d", bearer, resp.StatusCode, want)
+		}
+	}
+	if code := call("POST", "/airdrop/"+bob.Hex()+"/claim", nil); code != http.StatusAccepted {
+		t.Fatalf("claim of bob: %d", code)
+	}
+	// not mined yet, a second claim would fail and cost its gas
+	if code := call("POST", "/airdrop/"+bob.Hex()+"/claim", nil); code != http.StatusConflict {
+		t.Fatalf("claim of bob while pending: %d", code)
+	}
+	env.backend.Commit()
+	env.requireBalance(contract, bob, 200)
+	if code := call("POST", "/airdrop/"+bob.Hex()+"/claim", nil); code != http.StatusConflict {
+		t.Fatalf("second claim of bob: %d", code)
+	}
+	if code := call("GET", "/airdrop/"+common.Address{0x0d}.Hex(), nil); code != http.StatusNotFound {
+		t.Fatalf("status of a stranger: %d", code)
+	}
+	if code := call("GET", "/airdrop/nope", nil); code != http.StatusBadRequest {
+		t.Fatalf("status of an invalid account: %d", code)
+	}
+
+	// a wrong amount is refused by the contract
+	distributor, err := NewMerkleDistributor(a.Distributor, env.backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := dist.Claim(alice)
+	proof := make([][32]byte, len(c.Proof))
+	for i, h := range c.Proof {
+		proof[i] = h
+	}
+	if _, err := distributor.Claim(env.accounts[0].transactor(t), alice, big.NewInt(101), proof); err == nil || !strings.Contains(err.Error(), "invalid proof") {
+		t.Fatalf("claim of another amount: %v", err)
+	}
+
+	// funding again tops up only what is owed
+	if err := s.Fund(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	env.requireBalance(contract, a.Distributor, 400)
+	// a claim tx dropped by the rpc-server doesn't hold the next one
+	s.pending[carol] = common.Hash{0x01}
+	if _, err := s.Claim(ctx, a, carol); err != nil {
+		t.Fatal(err)
+	}
+	env.backend.Commit()
+	env.requireBalance(contract, carol, 300)
+	if _, err := s.Claim(ctx, a, carol); !errors.Is(err, ErrAlreadyClaimed) {
+		t.Fatalf("second claim of carol: %v", err)
+	}
+
+	noAuth := httptest.NewServer(&AirdropAPI{Service: s, Airdrop: a})
+	defer noAuth.Close()
+	resp, err := http.Post(noAuth.URL+"/airdrop/"+alice.Hex()+"/claim", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("claim without sign-in: %d", resp.StatusCode)
+	}
+	limited := httptest.NewServer(&AirdropAPI{Service: s, Airdrop: a, ClaimInterval: time.Hour, Auth: auth})
+	defer limited.Close()
+	for _, want := range []int{http.StatusAccepted, http.StatusTooManyRequests} {
+		req, _ := http.NewRequest("POST", limited.URL+"/airdrop/"+alice.Hex()+"/claim", nil)
+		req.Header.Set("Authorization", "Bearer alice-session")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("claim of alice: %d, want %d", resp.StatusCode, want)
+		}
+	}
+}
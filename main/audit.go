@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEntry records a state transition, who made it and why.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Subject is what changed, like a withdrawal ID
+	Subject string `json:"subject"`
+	From    string `json:"from,omitempty"`
+	To      string `json:"to"`
+	Actor   string `json:"actor"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// AuditLog appends the entries to a JSON lines file, synced before a
+// transition goes on, which is never rewritten.
+type AuditLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{f: f}, nil
+}
+
+// Append writes e, stamped with the current time.
+func (a *AuditLog) Append(e AuditEntry) error {
+	e.Time = time.Now().UTC()
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.f.Write(append(bs, '\n')); err != nil {
+		return err
+	}
+	return a.f.Sync()
+}
+
+func (a *AuditLog) Close() error {
+	return a.f.Close()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// simChainID is the chain ID the simulated backend always uses.
+var simChainID = big.NewInt(1337)
+
+// simBackend adds the ethclient methods missing from the simulated backend.
+type simBackend struct {
+	*backends.SimulatedBackend
+}
+
+func (b simBackend) BlockNumber(ctx context.Context) (uint64, error) {
+	return b.Blockchain().CurrentBlock().NumberU64(), nil
+}
+
+func (b simBackend) ChainID(ctx context.Context) (*big.Int, error) {
+	return new(big.Int).Set(simChainID), nil
+}
+
+type testAccount struct {
+	key  *ecdsa.PrivateKey
+	addr common.Address
+}
+
+func newTestAccount(t *testing.T) testAccount {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testAccount{key, crypto.PubkeyToAddress(key.PublicKey)}
+}
+
+func (a testAccount) transactor(t *testing.T) *bind.TransactOpts {
+	t.Helper()
+	auth, err := bind.NewKeyedTransactorWithChainID(a.key, simChainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.GasTipCap = big.NewInt(1 * params.GWei)
+	return auth
+}
+
+// testEnv is a simulated chain with funded accounts.
+type testEnv struct {
+	t        *testing.T
+	backend  simBackend
+	accounts []testAccount
+}
+
+// newTestEnv starts a simulated chain with n accounts holding 100 ether each.
+func newTestEnv(t *testing.T, n int) *testEnv {
+	t.Helper()
+	env := &testEnv{t: t}
+	alloc := core.GenesisAlloc{}
+	for i := 0; i < n; i++ {
+		acc := newTestAccount(t)
+		env.accounts = append(env.accounts, acc)
+		alloc[acc.addr] = core.GenesisAccount{Balance: new(big.Int).Mul(big.NewInt(100), big.NewInt(params.Ether))}
+	}
+	env.backend = simBackend{backends.NewSimulatedBackend(alloc, 30_000_000)}
+	t.Cleanup(func() { env.backend.Close() })
+	return env
+}
+
+// mine commits a block every interval until the test ends,
+// like a node with a fixed block time.
+func (env *testEnv) mine(interval time.Duration) {
+	done := make(chan struct{})
+	env.t.Cleanup(func() { close(done) })
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				env.backend.Commit()
+			}
+		}
+	}()
+}
+
+// deploy deploys the token from the first account and mines it.
+func (env *testEnv) deploy(supply int64) (common.Address, *types.Transaction, *EIP20) {
+	env.t.Helper()
+	addr, tx, contract, err := DeployEIP20(env.accounts[0].transactor(env.t), env.backend, big.NewInt(supply), "dtoken", 8, "dt")
+	if err != nil {
+		env.t.Fatalf("DeployEIP20 failed: %v", err)
+	}
+	env.backend.Commit()
+	env.requireSuccess(tx)
+	return addr, tx, contract
+}
+
+// requireSuccess fails the test unless tx is mined successfully.
+func (env *testEnv) requireSuccess(tx *types.Transaction) *types.Receipt {
+	env.t.Helper()
+	receipt, err := env.backend.TransactionReceipt(context.Background(), tx.Hash())
+	if err != nil {
+		env.t.Fatalf("no receipt for %s: %v", tx.Hash(), err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		env.t.Fatalf("tx %s failed", tx.Hash())
+	}
+	return receipt
+}
+
+func (env *testEnv) requireBalance(contract *EIP20, owner common.Address, want int64) {
+	env.t.Helper()
+	got, err := contract.BalanceOf(nil, owner)
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	if got.Cmp(big.NewInt(want)) != 0 {
+		env.t.Fatalf("balance of %s = %s, want %d", owner, got, want)
+	}
+}
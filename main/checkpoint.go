@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const CheckpointFile = "checkpoints.json"
+
+// Checkpoints keeps the next block to scan of each poller, so it can resume
+// from there after a restart. The changes are kept in memory until Save.
+// A nil *Checkpoints keeps nothing.
+type Checkpoints struct {
+	path string
+
+	mu     sync.Mutex
+	blocks map[string]uint64
+	dirty  bool
+}
+
+// CheckpointKey is the key of the poller of contract on chainID.
+func CheckpointKey(chainID *big.Int, contract common.Address) string {
+	return fmt.Sprintf("%s/%s", chainID, contract)
+}
+
+// LoadCheckpoints reads the checkpoint file. A missing file gives no checkpoints.
+func LoadCheckpoints(path string) (*Checkpoints, error) {
+	c := &Checkpoints{path: path, blocks: make(map[string]uint64)}
+	bs, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &c.blocks); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Get returns the checkpoint of key, if any.
+func (c *Checkpoints) Get(key string) (uint64, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	block, ok := c.blocks[key]
+	return block, ok
+}
+
+// Set moves the checkpoint of key to block.
+func (c *Checkpoints) Set(key string, block uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.blocks[key]; !ok || old != block {
+		c.blocks[key] = block
+		c.dirty = true
+	}
+}
+
+// Save writes the checkpoints to disk, if they have changed.
+func (c *Checkpoints) Save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	bs, err := json.MarshalIndent(c.blocks, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.path, bs); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpoints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), CheckpointFile)
+	c, err := LoadCheckpoints(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("got a checkpoint from a missing file")
+	}
+	c.Set("a", 10)
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = LoadCheckpoints(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, ok := c.Get("a"); !ok || block != 10 {
+		t.Fatalf("got checkpoint %d, want 10", block)
+	}
+
+	// a nil *Checkpoints keeps nothing
+	var none *Checkpoints
+	none.Set("a", 1)
+	if _, ok := none.Get("a"); ok {
+		t.Fatal("got a checkpoint from nil")
+	}
+	if err := none.Save(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Client is an ethclient.Client recording the count and latency of its calls,
+// by JSON-RPC method and endpoint, and tracing each call in a span.
+type Client struct {
+	*ethclient.Client
+	Transport Transport
+	rpc       *rpc.Client
+	endpoint  string
+}
+
+// Dial connects a client to the given URL.
+func Dial(ctx context.Context, rawurl string) (*Client, error) {
+	transport, err := ParseTransport(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	var rc *rpc.Client
+	if transport == TransportIPC {
+		// rpc.DialContext takes a windows path for a url
+		rc, err = rpc.DialIPC(ctx, ipcFile(rawurl))
+	} else {
+		rc, err = rpc.DialContext(ctx, rawurl)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Client{Client: ethclient.NewClient(rc), Transport: transport, rpc: rc, endpoint: endpointLabel(rawurl)}, nil
+}
+
+// observe starts timing and tracing a call of method. Call the returned func
+// with the error of the call once it's done:
+//
+//	defer c.observe(ctx, "eth_blockNumber")(&err)
+func (c *Client) observe(ctx context.Context, method string) func(*error) {
+	start := time.Now()
+	_, span := tracer.Start(ctx, method, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "jsonrpc"),
+			attribute.String("rpc.method", method),
+			attribute.String("rpc.endpoint", c.endpoint),
+		))
+	return func(err *error) {
+		endSpan(span, *err)
+		rpcDuration.WithLabelValues(method, c.endpoint).Observe(time.Since(start).Seconds())
+		status := "ok"
+		if *err != nil {
+			status = "error"
+		}
+		rpcCalls.WithLabelValues(method, c.endpoint, status).Inc()
+	}
+}
+
+func (c *Client) ChainID(ctx context.Context) (id *big.Int, err error) {
+	defer c.observe(ctx, "eth_chainId")(&err)
+	return c.Client.ChainID(ctx)
+}
+
+func (c *Client) BlockNumber(ctx context.Context) (n uint64, err error) {
+	defer c.observe(ctx, "eth_blockNumber")(&err)
+	return c.Client.BlockNumber(ctx)
+}
+
+func (c *Client) BlockByNumber(ctx context.Context, number *big.Int) (b *types.Block, err error) {
+	defer c.observe(ctx, "eth_getBlockByNumber")(&err)
+	return c.Client.BlockByNumber(ctx, number)
+}
+
+func (c *Client) HeaderByNumber(ctx context.Context, number *big.Int) (h *types.Header, err error) {
+	defer c.observe(ctx, "eth_getBlockByNumber")(&err)
+	return c.Client.HeaderByNumber(ctx, number)
+}
+
+func (c *Client) HeaderByHash(ctx context.Context, hash common.Hash) (h *types.Header, err error) {
+	defer c.observe(ctx, "eth_getBlockByHash")(&err)
+	return c.Client.HeaderByHash(ctx, hash)
+}
+
+func (c *Client) TransactionByHash(ctx context.Context, hash common.Hash) (tx *types.Transaction, isPending bool, err error) {
+	defer c.observe(ctx, "eth_getTransactionByHash")(&err)
+	return c.Client.TransactionByHash(ctx, hash)
+}
+
+func (c *Client) TransactionReceipt(ctx context.Context, txHash common.Hash) (r *types.Receipt, err error) {
+	defer c.observe(ctx, "eth_getTransactionReceipt")(&err)
+	return c.Client.TransactionReceipt(ctx, txHash)
+}
+
+func (c *Client) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (sub ethereum.Subscription, err error) {
+	defer c.observe(ctx, "eth_subscribe")(&err)
+	return c.Client.SubscribeNewHead(ctx, ch)
+}
+
+func (c *Client) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (balance *big.Int, err error) {
+	defer c.observe(ctx, "eth_getBalance")(&err)
+	return c.Client.BalanceAt(ctx, account, blockNumber)
+}
+
+func (c *Client) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) (code []byte, err error) {
+	defer c.observe(ctx, "eth_getCode")(&err)
+	return c.Client.CodeAt(ctx, account, blockNumber)
+}
+
+func (c *Client) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (nonce uint64, err error) {
+	defer c.observe(ctx, "eth_getTransactionCount")(&err)
+	return c.Client.NonceAt(ctx, account, blockNumber)
+}
+
+func (c *Client) FilterLogs(ctx context.Context, q ethereum.FilterQuery) (logs []types.Log, err error) {
+	defer c.observe(ctx, "eth_getLogs")(&err)
+	return c.Client.FilterLogs(ctx, q)
+}
+
+func (c *Client) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (sub ethereum.Subscription, err error) {
+	defer c.observe(ctx, "eth_subscribe")(&err)
+	return c.Client.SubscribeFilterLogs(ctx, q, ch)
+}
+
+func (c *Client) PendingCodeAt(ctx context.Context, account common.Address) (code []byte, err error) {
+	defer c.observe(ctx, "eth_getCode")(&err)
+	return c.Client.PendingCodeAt(ctx, account)
+}
+
+func (c *Client) PendingNonceAt(ctx context.Context, account common.Address) (nonce uint64, err error) {
+	defer c.observe(ctx, "eth_getTransactionCount")(&err)
+	return c.Client.PendingNonceAt(ctx, account)
+}
+
+func (c *Client) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) (out []byte, err error) {
+	defer c.observe(ctx, "eth_call")(&err)
+	return c.Client.CallContract(ctx, msg, blockNumber)
+}
+
+func (c *Client) PendingCallContract(ctx context.Context, msg ethereum.CallMsg) (out []byte, err error) {
+	defer c.observe(ctx, "eth_call")(&err)
+	return c.Client.PendingCallContract(ctx, msg)
+}
+
+func (c *Client) SuggestGasPrice(ctx context.Context) (price *big.Int, err error) {
+	defer c.observe(ctx, "eth_gasPrice")(&err)
+	return c.Client.SuggestGasPrice(ctx)
+}
+
+func (c *Client) SuggestGasTipCap(ctx context.Context) (tip *big.Int, err error) {
+	defer c.observe(ctx, "eth_maxPriorityFeePerGas")(&err)
+	return c.Client.SuggestGasTipCap(ctx)
+}
+
+func (c *Client) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (gas uint64, err error) {
+	defer c.observe(ctx, "eth_estimateGas")(&err)
+	return c.Client.EstimateGas(ctx, msg)
+}
+
+// SendTransaction sends the tx, and counts it as sent once the rpc-server accepts it.
+func (c *Client) SendTransaction(ctx context.Context, tx *types.Transaction) (err error) {
+	defer c.observe(ctx, "eth_sendRawTransaction")(&err)
+	if err = c.Client.SendTransaction(ctx, tx); err == nil {
+		txSent.WithLabelValues(tx.ChainId().String()).Inc()
+	}
+	return err
+}
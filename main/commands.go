@@ -0,0 +1,1246 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xcoolface/backend-dapp-demo/main/signing"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// newTransactor signs with the account asked for by withSigner, the default one
+// if none is. send takes the nonces from the NonceManager of the account.
+func newTransactor(ctx context.Context) (*bind.TransactOpts, error) {
+	account, err := signers.Get(signerOf(ctx))
+	if err != nil {
+		return nil, err
+	}
+	auth, err := bind.NewKeyedTransactorWithChainID(account.Key, chainID)
+	if err != nil {
+		return nil, err
+	}
+	if err := chain.Network.Fee.apply(ctx, auth, client); err != nil {
+		return nil, err
+	}
+	auth.Context = ctx
+	return spendingPolicy.Guard(auth), nil
+}
+
+func runDemo(ctx context.Context, args []string) error {
+	ctx = withLog(ctx, "requestId", newRequestID(), "chainId", chainID)
+	// deploy
+	d, err := tokens.Deploy(ctx, "dtoken", DeployArgs{big.NewInt(1e18), "dtoken", 8, "dt"})
+	if err != nil {
+		return err
+	}
+
+	// transact
+	ctx = withLog(ctx, "contract", d.Address)
+	if _, err := tokens.Transfer(ctx, d.Address.Hex(), common.Address{0x11}, big.NewInt(12345)); err != nil {
+		return err
+	}
+
+	if err := watchTransfer(ctx, d.Address); err != nil {
+		return err
+	}
+	logger(ctx).Info("Done")
+	return nil
+}
+
+func runDeploy(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("deploy", flag.ExitOnError)
+	name := fs.String("name", "dtoken", "registry name of the contract")
+	supply := fs.String("supply", "1000000000000000000", "initial amount")
+	tokenName := fs.String("token-name", "dtoken", "token name")
+	decimals := fs.Uint("decimals", 8, "decimal units")
+	symbol := fs.String("symbol", "dt", "token symbol")
+	from := fs.String("from", "", "name or address of the account signing, default to the default account")
+	requestID := fs.String("request-id", "", "ID correlating the logs of this request, default to a random one")
+	fs.Parse(args)
+
+	if *requestID == "" {
+		*requestID = newRequestID()
+	}
+	ctx = withSigner(withLog(ctx, "requestId", *requestID, "chainId", chainID), *from)
+	amount, ok := new(big.Int).SetString(*supply, 10)
+	if !ok {
+		return fmt.Errorf("invalid supply %q", *supply)
+	}
+	_, err := tokens.Deploy(ctx, *name, DeployArgs{amount, *tokenName, uint8(*decimals), *symbol})
+	return err
+}
+
+func runTransfer(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("transfer", flag.ExitOnError)
+	token := fs.String("token", "dtoken", "registry name or address of the token")
+	to := fs.String("to", "", "recipient address")
+	value := fs.String("amount", "", "amount to transfer")
+	from := fs.String("from", "", "name or address of the account signing, default to the default account")
+	requestID := fs.String("request-id", "", "ID correlating the logs of this request, default to a random one")
+	fs.Parse(args)
+
+	if !common.IsHexAddress(*to) {
+		return fmt.Errorf("invalid recipient %q", *to)
+	}
+	amount, ok := new(big.Int).SetString(*value, 10)
+	if !ok {
+		return fmt.Errorf("invalid amount %q", *value)
+	}
+	if *requestID == "" {
+		*requestID = newRequestID()
+	}
+	addr, err := tokens.TokenAddress(*token)
+	if err != nil {
+		return err
+	}
+	ctx = withLog(ctx, "requestId", *requestID, "chainId", chainID, "contract", addr)
+	tx, err := tokens.Transfer(withSigner(ctx, *from), addr.Hex(), common.HexToAddress(*to), amount)
+	if err != nil {
+		return err
+	}
+	return waitConfirmed(withTxLog(ctx, tx), tx, "transfer")
+}
+
+func runApprove(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("approve", flag.ExitOnError)
+	token := fs.String("token", "dtoken", "registry name or address of the token")
+	spender := fs.String("spender", "", "spender address")
+	value := fs.String("amount", "", "amount the spender may spend")
+	from := fs.String("from", "", "name or address of the account signing, default to the default account")
+	requestID := fs.String("request-id", "", "ID correlating the logs of this request, default to a random one")
+	fs.Parse(args)
+
+	if !common.IsHexAddress(*spender) {
+		return fmt.Errorf("invalid spender %q", *spender)
+	}
+	amount, ok := new(big.Int).SetString(*value, 10)
+	if !ok {
+		return fmt.Errorf("invalid amount %q", *value)
+	}
+	if *requestID == "" {
+		*requestID = newRequestID()
+	}
+	addr, err := tokens.TokenAddress(*token)
+	if err != nil {
+		return err
+	}
+	ctx = withLog(ctx, "requestId", *requestID, "chainId", chainID, "contract", addr)
+	tx, err := tokens.Approve(withSigner(ctx, *from), addr.Hex(), common.HexToAddress(*spender), amount)
+	if err != nil {
+		return err
+	}
+	return waitConfirmed(withTxLog(ctx, tx), tx, "approve")
+}
+
+// waitConfirmed waits for the receipt of a sent tx, even if a shutdown starts.
+func waitConfirmed(ctx context.Context, tx *types.Transaction, what string) error {
+	waitCtx, cancel := withGrace(ctx)
+	defer cancel()
+	receipt, err := waitReceipt(waitCtx, tx)
+	if err != nil {
+		return err
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return fmt.Errorf("%s tx %s failed", what, tx.Hash())
+	}
+	logger(ctx).Info(strings.ToUpper(what[:1])+what[1:]+" is confirmed", "block", receipt.BlockNumber, "gasUsed", receipt.GasUsed)
+	return nil
+}
+
+func runWatch(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	token := fs.String("token", "dtoken", "registry name or address of the token")
+	fs.Parse(args)
+
+	addr, err := tokens.TokenAddress(*token)
+	if err != nil {
+		return err
+	}
+	ctx = withLog(ctx, "chainId", chainID, "contract", addr)
+	if err := watchTransfer(ctx, addr); err != nil {
+		return err
+	}
+	logger(ctx).Info("Done")
+	return nil
+}
+
+func runVerify(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	token := fs.String("token", "dtoken", "registry name or address of the token")
+	txHash := fs.String("tx", "", "deploy tx hash, default to the registry record")
+	supply := fs.String("supply", "", "expected initial amount")
+	tokenName := fs.String("token-name", "", "expected token name")
+	decimals := fs.Int("decimals", -1, "expected decimal units")
+	symbol := fs.String("symbol", "", "expected token symbol")
+	fs.Parse(args)
+
+	// expect the registry record, overridden by the flags
+	var addr common.Address
+	var want DeployArgs
+	wantDecimals := false
+	if common.IsHexAddress(*token) {
+		addr = common.HexToAddress(*token)
+	} else {
+		d, err := registry.Lookup(chainID, *token)
+		if err != nil {
+			return err
+		}
+		addr, want, wantDecimals = d.Address, d.Args, true
+		if *txHash == "" {
+			*txHash = d.TxHash.Hex()
+		}
+	}
+	if *txHash == "" {
+		return errors.New("the deploy tx hash is required")
+	}
+	if *supply != "" {
+		amount, ok := new(big.Int).SetString(*supply, 10)
+		if !ok {
+			return fmt.Errorf("invalid supply %q", *supply)
+		}
+		want.InitialAmount = amount
+	}
+	if *tokenName != "" {
+		want.Name = *tokenName
+	}
+	if *decimals >= 0 {
+		want.Decimals, wantDecimals = uint8(*decimals), true
+	}
+	if *symbol != "" {
+		want.Symbol = *symbol
+	}
+
+	got, err := VerifyDeployment(ctx, client, addr, common.HexToHash(*txHash))
+	if err != nil {
+		return fmt.Errorf("verify %s: %w", addr, err)
+	}
+	l := log.New("chainId", chainID, "contract", addr, "txHash", common.HexToHash(*txHash))
+	l.Info("Code matches EIP20", "name", got.Name, "symbol", got.Symbol, "decimals", got.Decimals, "initialAmount", got.InitialAmount)
+	if !wantDecimals {
+		want.Decimals = got.Decimals
+	}
+	if diffs := got.Mismatches(&want); len(diffs) > 0 {
+		return fmt.Errorf("constructor args mismatch: %s", strings.Join(diffs, ", "))
+	}
+	l.Info("Verified")
+	return nil
+}
+
+func runServe(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", ":9100", "listen address of the http server")
+	confirms := fs.Uint64("confirms", 0, "confirmations before indexing a block, default to those of each network or 3")
+	tokenNames := fs.String("tokens", "", "comma separated names or addresses of the tokens, default to all those of the network and the registry")
+	webhooks := fs.String("webhooks", WebhookFile, "webhook subscribers file")
+	deadLetter := fs.String("dead-letter", DeadLetterFile, "file the failed webhook deliveries are appended to")
+	pendingDeliveries := fs.String("webhooks-pending", PendingDeliveryFile, "file the pending webhook deliveries are kept in over a restart")
+	wsOrigins := fs.String("ws-origins", "", "comma separated origins allowed to open the push websocket, * for any, default to the same origin")
+	grpcListen := fs.String("grpc-listen", "", "listen address of the gRPC server like 127.0.0.1:9090, disabled by default")
+	grpcCredentials := fs.String("grpc-credentials", GRPCCredentialsFile, "credentials which may call the gRPC server and list the webhook deliveries, required to serve them")
+	grpcTLSCert := fs.String("grpc-tls-cert", "", "TLS cert of the gRPC server, required unless it listens on a loopback address")
+	grpcTLSKey := fs.String("grpc-tls-key", "", "TLS key of the gRPC server")
+	grpcClientCA := fs.String("grpc-client-ca", "", "CA of the client certs the gRPC server requires, none by default")
+	withdrawalPolicy := fs.String("withdrawal-policy", WithdrawalPolicyFile, "withdrawal policy file, no withdrawal API without it")
+	airdropFile := fs.String("airdrop", AirdropFile, "airdrop file, no airdrop API without it")
+	siweDomain := fs.String("siwe-domain", "", "domain of the Sign-In with Ethereum messages, no sign-in nor account API without it")
+	signCredentials := fs.String("sign-credentials", SignCredentialsFile, "credentials which may sign with the account of the sign policy, no signing API without it")
+	signPolicy := fs.String("sign-policy", SignPolicyFile, "account and typed data of the signing API, no signing API without it")
+	claimInterval := fs.Duration("airdrop-claim-interval", 10*time.Second, "least time between two airdrop claims of a client address")
+	fs.Parse(args)
+
+	ix, tracker, err := newChainIndexer(chain, *tokenNames, *confirms)
+	if err != nil {
+		return err
+	}
+
+	subs, err := LoadWebhooks(*webhooks)
+	if err != nil {
+		return fmt.Errorf("load webhooks: %w", err)
+	}
+	adminCreds, err := LoadCredentials(*grpcCredentials)
+	if err != nil {
+		return fmt.Errorf("load gRPC credentials: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	pushServer := &PushServer{ChainID: chainID.Uint64()}
+	if *wsOrigins != "" {
+		pushServer.Upgrader.CheckOrigin = allowOrigins(strings.Split(*wsOrigins, ","))
+	}
+	mux.Handle("/ws", pushServer)
+	go func() {
+		if err := tracker.Run(ctx); !errors.Is(err, context.Canceled) {
+			log.Error("Tracker stopped", "err", err)
+		}
+	}()
+	// the other networks are indexed, the APIs serve the first one
+	for _, c := range chains[1:] {
+		ix, tracker, err := newChainIndexer(c, *tokenNames, *confirms)
+		if err != nil {
+			return fmt.Errorf("network %s: %w", c.Name, err)
+		}
+		l := log.New("network", c.Name)
+		go func() {
+			if err := tracker.Run(ctx); !errors.Is(err, context.Canceled) {
+				l.Error("Tracker stopped", "err", err)
+			}
+		}()
+		go func() {
+			if err := ix.Run(ctx); !errors.Is(err, context.Canceled) {
+				l.Error("Indexer stopped", "err", err)
+			}
+		}()
+		l.Info("Index network", "chainId", c.ChainID, "tokens", len(ix.Tokens))
+	}
+	if len(subs) > 0 {
+		dispatcher := NewDispatcher(subs, *deadLetter)
+		dispatcher.Pending, dispatcher.Credentials = *pendingDeliveries, adminCreds
+		mux.Handle("/webhooks/deliveries", dispatcher)
+		events := make(chan *TokenEvent, 64)
+		sub := SubscribeTokenEvents(events)
+		dispatchCtx, stopDispatch := context.WithCancel(ctx)
+		dispatched := make(chan struct{})
+		go func() {
+			defer close(dispatched)
+			dispatcher.Run(dispatchCtx, events)
+			// the indexer may be sending an event, don't keep it waiting
+			sub.Unsubscribe()
+		}()
+		// the pending deliveries are saved before returning
+		defer func() {
+			stopDispatch()
+			<-dispatched
+		}()
+		log.Info("Deliver events to webhooks", "subscribers", len(subs))
+	}
+	policy, err := LoadWithdrawalPolicy(*withdrawalPolicy)
+	if err != nil {
+		return fmt.Errorf("load withdrawal policy: %w", err)
+	}
+	if policy != nil {
+		queue, err := NewWithdrawalQueue(WithdrawalFile)
+		if err != nil {
+			return fmt.Errorf("load withdrawals: %w", err)
+		}
+		audit, err := OpenAuditLog(WithdrawalAuditFile)
+		if err != nil {
+			return err
+		}
+		defer audit.Close()
+		queue.Backend, queue.Policy, queue.Audit = client, policy, audit
+		queue.Transactor, queue.Nonces = newTransactor, signers.Default().Nonces
+		queue.PollInterval, queue.Confirms = 3*time.Second, chain.Network.confirms(3)
+		api := &WithdrawalAPI{Queue: queue, Tokens: tokens}
+		mux.Handle("/withdrawals", api)
+		mux.Handle("/withdrawals/", api)
+		go queue.Run(ctx)
+		log.Info("Process withdrawals", "approvers", len(policy.Approvers), "approvalsRequired", policy.ApprovalsRequired)
+	}
+	var auth *SiweAuth
+	if *siweDomain != "" {
+		auth = &SiweAuth{
+			Domain:          *siweDomain,
+			ChainID:         chainID,
+			Statement:       "Sign in to the dapp backend.",
+			NonceTTL:        10 * time.Minute,
+			SessionTTL:      24 * time.Hour,
+			NoncesPerClient: defaultNoncesPerClient,
+			MaxNonces:       defaultMaxNonces,
+		}
+		mux.Handle("/auth/", auth)
+		mux.Handle("/accounts/", auth.Require(&AccountAPI{Tokens: tokens}))
+		log.Info("Sign in with Ethereum", "domain", *siweDomain)
+	}
+	signCreds, err := LoadCredentials(*signCredentials)
+	if err != nil {
+		return fmt.Errorf("load sign credentials: %w", err)
+	}
+	signPol, err := LoadSignPolicy(*signPolicy)
+	if err != nil {
+		return fmt.Errorf("load sign policy: %w", err)
+	}
+	signAPI := &SignAPI{Policy: signPol, Credentials: signCreds}
+	if len(signCreds) > 0 && signPol != nil {
+		acc, err := signers.Get(signPol.Account)
+		if err != nil {
+			return fmt.Errorf("sign policy: %w", err)
+		}
+		signAPI.Signer = signing.NewSigner(acc.Key)
+		log.Info("Sign messages and typed data", "account", acc.Name, "address", acc.Address,
+			"typedData", len(signPol.TypedData), "credentials", len(signCreds))
+	}
+	mux.Handle("/sign/", signAPI)
+	mux.Handle("/verify/", signAPI)
+	airdrop, err := LoadAirdrop(*airdropFile)
+	if err != nil {
+		return fmt.Errorf("load airdrop: %w", err)
+	}
+	if airdrop != nil {
+		if airdrop.ChainID != chainID.Uint64() {
+			return fmt.Errorf("%s is an airdrop of chain %d", *airdropFile, airdrop.ChainID)
+		}
+		spendingPolicy.AddDistributor(airdrop.Distributor, airdrop.Token)
+		api := &AirdropAPI{Service: newAirdropService(), Airdrop: airdrop, ClaimInterval: *claimInterval, Auth: auth}
+		mux.Handle("/airdrop", api)
+		mux.Handle("/airdrop/", api)
+		log.Info("Serve airdrop proofs", "distributor", airdrop.Distributor, "claims", len(airdrop.Distribution.Claims), "sendClaims", auth != nil)
+	}
+	srv := &http.Server{Addr: *listen, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		for sleep(ctx, 15*time.Second) == nil {
+			for _, acc := range signers.List() {
+				observeNonceGap(ctx, acc.Address)
+			}
+		}
+	}()
+	if *grpcListen != "" {
+		sec := GRPCSecurity{Signers: signers, Credentials: adminCreds}
+		switch {
+		case *grpcTLSCert != "":
+			if sec.TLS, err = GRPCTLSConfig(*grpcTLSCert, *grpcTLSKey, *grpcClientCA); err != nil {
+				return fmt.Errorf("load gRPC TLS: %w", err)
+			}
+		case *grpcClientCA != "":
+			return errors.New("-grpc-client-ca needs -grpc-tls-cert")
+		case !isLoopback(*grpcListen):
+			return fmt.Errorf("gRPC on %s needs -grpc-tls-cert, plaintext is for a loopback address only", *grpcListen)
+		}
+		grpcSrv, err := NewGRPCServer(tokens, client, ix.Tokens, 3*time.Second, sec)
+		if err != nil {
+			return fmt.Errorf("%w in %s", err, *grpcCredentials)
+		}
+		lis, err := net.Listen("tcp", *grpcListen)
+		if err != nil {
+			return err
+		}
+		go func() {
+			<-ctx.Done()
+			grpcSrv.GracefulStop()
+		}()
+		go func() {
+			log.Info("Serve gRPC", "addr", *grpcListen, "tls", sec.TLS != nil, "credentials", len(sec.Credentials))
+			if err := grpcSrv.Serve(lis); err != nil {
+				log.Error("gRPC server failed", "err", err)
+			}
+		}()
+	}
+	go func() {
+		log.Info("Serve", "addr", *listen)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error("Server failed", "err", err)
+		}
+	}()
+
+	err = ix.Run(ctx)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// newChainIndexer returns the Indexer and the Tracker of the tokens of c, all
+// the tokens of c if tokenNames is "", at the confirms of c if confirms is 0.
+func newChainIndexer(c *Chain, tokenNames string, confirms uint64) (*Indexer, *Tracker, error) {
+	names := c.Tokens.Names()
+	if tokenNames != "" {
+		names = strings.Split(tokenNames, ",")
+	}
+	if confirms == 0 {
+		confirms = c.Network.confirms(3)
+	}
+	ix := &Indexer{
+		Backend:      c.Client,
+		ChainID:      c.ChainID,
+		Confirms:     confirms,
+		Checkpoints:  checkpoints,
+		Subscribe:    c.Capabilities.Subscribe,
+		PollInterval: 3 * time.Second,
+		MaxRange:     1000,
+	}
+	for _, name := range names {
+		addr, err := c.Tokens.TokenAddress(strings.TrimSpace(name))
+		if err != nil {
+			return nil, nil, err
+		}
+		ix.Tokens = append(ix.Tokens, addr)
+	}
+	tracker := &Tracker{
+		Backend:      c.Client,
+		ChainID:      c.ChainID,
+		Tokens:       ix.Tokens,
+		Confirms:     confirms,
+		Subscribe:    c.Capabilities.Subscribe,
+		PollInterval: 3 * time.Second,
+	}
+	return ix, tracker, nil
+}
+
+func runDeposits(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("deposits", flag.ExitOnError)
+	addresses := fs.String("addresses", DepositAddressFile, "JSON array of the deposit addresses")
+	credits := fs.String("credits", CreditFile, "ledger the credited deposits are appended to")
+	confirms := fs.Uint64("confirms", chain.Network.confirms(12), "confirmations before crediting a deposit, default to those of the network or 12")
+	tokenNames := fs.String("tokens", "", "comma separated names or addresses of the tokens, default to all those of the network and the registry")
+	webhooks := fs.String("webhooks", WebhookFile, "webhook subscribers file, notified of the credited deposits")
+	listen := fs.String("listen", "", "listen address of the metrics server, empty to disable it")
+	fs.Parse(args)
+
+	addrs, err := LoadDepositAddresses(*addresses)
+	if err != nil {
+		return fmt.Errorf("load deposit addresses: %w", err)
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("no deposit address in %s", *addresses)
+	}
+	ledger, err := OpenCredits(*credits)
+	if err != nil {
+		return fmt.Errorf("open credits: %w", err)
+	}
+	defer ledger.Close()
+	w := &DepositWatcher{
+		Backend:      client,
+		ChainID:      chainID,
+		Addresses:    addrs,
+		Confirms:     *confirms,
+		Credits:      ledger,
+		Checkpoints:  checkpoints,
+		PollInterval: 3 * time.Second,
+		MaxRange:     1000,
+	}
+	names := tokens.Names()
+	if *tokenNames != "" {
+		names = strings.Split(*tokenNames, ",")
+	}
+	for _, name := range names {
+		addr, err := tokens.TokenAddress(strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+		w.Tokens = append(w.Tokens, addr)
+	}
+
+	subs, err := LoadWebhooks(*webhooks)
+	if err != nil {
+		return fmt.Errorf("load webhooks: %w", err)
+	}
+	if len(subs) > 0 {
+		dispatcher := NewDispatcher(subs, DeadLetterFile)
+		dispatcher.Pending = "deposits-" + PendingDeliveryFile
+		events := make(chan *TokenEvent, 64)
+		sub := SubscribeDeposits(events)
+		dispatchCtx, stopDispatch := context.WithCancel(ctx)
+		dispatched := make(chan struct{})
+		go func() {
+			defer close(dispatched)
+			dispatcher.Run(dispatchCtx, events)
+			sub.Unsubscribe()
+		}()
+		defer func() {
+			stopDispatch()
+			<-dispatched
+		}()
+	}
+	if *listen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		srv := &http.Server{Addr: *listen, Handler: mux}
+		go func() {
+			<-ctx.Done()
+			srv.Close()
+		}()
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Error("Server failed", "err", err)
+			}
+		}()
+	}
+	log.Info("Watch deposits", "addresses", len(addrs), "tokens", len(w.Tokens), "confirms", *confirms)
+	err = w.Run(ctx)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+func runAddresses(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("addresses", flag.ExitOnError)
+	count := fs.Uint("count", 100, "number of deposit addresses")
+	out := fs.String("out", DepositAddressFile, "file the JSON array of the deposit addresses is written to")
+	fs.Parse(args)
+
+	keys, err := cfg.DepositKeys()
+	if err != nil {
+		return err
+	}
+	addrs := make([]common.Address, 0, *count)
+	for i := uint32(0); i < uint32(*count); i++ {
+		addr, err := keys.Address(i)
+		if errors.Is(err, errInvalidChild) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		addrs = append(addrs, addr)
+	}
+	bs, err := json.MarshalIndent(addrs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(*out, bs); err != nil {
+		return err
+	}
+	log.Info("Derived deposit addresses", "count", len(addrs), "file", *out)
+	return nil
+}
+
+func runSweep(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("sweep", flag.ExitOnError)
+	token := fs.String("token", "dtoken", "registry name or address of the token")
+	count := fs.Uint("count", 100, "number of deposit addresses")
+	threshold := fs.String("threshold", "1", "least token balance worth sweeping")
+	treasury := fs.String("treasury", cfg.Treasury, "address receiving the swept tokens, default to the config treasury")
+	state := fs.String("state", SweepFile, "file the sweeps are kept in")
+	fs.Parse(args)
+
+	if !common.IsHexAddress(*treasury) {
+		return fmt.Errorf("invalid treasury %q", *treasury)
+	}
+	min, ok := new(big.Int).SetString(*threshold, 10)
+	if !ok || min.Sign() <= 0 {
+		return fmt.Errorf("invalid threshold %q", *threshold)
+	}
+	addr, err := tokens.TokenAddress(*token)
+	if err != nil {
+		return err
+	}
+	keys, err := cfg.DepositKeys()
+	if err != nil {
+		return err
+	}
+	store, err := LoadSweeps(*state)
+	if err != nil {
+		return err
+	}
+	w := &Sweeper{
+		Backend:      client,
+		ChainID:      chainID,
+		Token:        addr,
+		Keys:         keys,
+		Count:        uint32(*count),
+		Treasury:     common.HexToAddress(*treasury),
+		Threshold:    min,
+		Hot:          newTransactor,
+		Fee:          chain.Network.Fee,
+		Policy:       spendingPolicy,
+		Store:        store,
+		PollInterval: 15 * time.Second,
+	}
+	log.Info("Sweep deposits", "contract", addr, "addresses", *count, "treasury", w.Treasury)
+	err = w.Run(ctx)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+func runReconcile(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	bookFile := fs.String("book", BookFile, "internal ledger of the expected balances and supplies")
+	out := fs.String("out", ReconcileReportFile, "discrepancy report, CSV when it ends with .csv, - for stdout")
+	block := fs.Uint64("block", 0, "block to read the chain at, default to -confirms under the head")
+	confirms := fs.Uint64("confirms", chain.Network.confirms(12), "blocks under the head of the default block, default to the confirms of the network or 12")
+	every := fs.Duration("every", 0, "interval of the reconciliations, 0 to reconcile once")
+	fs.Parse(args)
+	if *every > 0 && len(chains) > 1 {
+		return errors.New("reconcile -every runs on one network, run one per network")
+	}
+
+	r := &Reconciler{Backend: client, ChainID: chainID, Confirms: *confirms, MaxRange: 1000}
+	for {
+		// the book is read again each time, to reconcile its latest state
+		book, err := LoadBook(*bookFile)
+		if err != nil {
+			return fmt.Errorf("load book: %w", err)
+		}
+		report, err := r.Reconcile(ctx, book, *block)
+		if err == nil {
+			err = WriteReport(*out, report)
+		}
+		switch {
+		case err != nil && *every == 0:
+			return err
+		case err != nil:
+			log.Error("Reconcile failed", "err", err)
+		default:
+			logf := log.Info
+			if report.Unexplained() > 0 {
+				logf = log.Warn
+			}
+			logf("Reconciled the book", "block", report.Block, "since", report.Since, "checked", report.Checked,
+				"discrepancies", len(report.Discrepancies), "unexplained", report.Unexplained(), "report", *out)
+			if *every == 0 && report.Unexplained() > 0 {
+				return errUnexplained
+			}
+		}
+		if *every == 0 {
+			return nil
+		}
+		if err := sleep(ctx, *every); err != nil {
+			return nil
+		}
+	}
+}
+
+func runSnapshot(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	token := fs.String("token", "dtoken", "registry name or address of the token")
+	txHash := fs.String("tx", "", "deploy tx hash, default to the registry record")
+	block := fs.Uint64("block", 0, "block of the snapshot, default to -confirms under the head")
+	confirms := fs.Uint64("confirms", chain.Network.confirms(12), "blocks under the head of the default block, default to the confirms of the network or 12")
+	out := fs.String("out", SnapshotFile, "JSON snapshot file, empty to skip it")
+	csvOut := fs.String("csv", SnapshotCSVFile, "CSV snapshot file, empty to skip it")
+	merkleOut := fs.String("merkle", MerkleFile, "Merkle tree file of the (address, balance) leaves, empty to skip it")
+	fs.Parse(args)
+
+	addr, err := tokens.TokenAddress(*token)
+	if err != nil {
+		return err
+	}
+	if *txHash == "" {
+		d, err := registry.Lookup(chainID, *token)
+		if err != nil {
+			return fmt.Errorf("the deploy tx hash is required: %w", err)
+		}
+		*txHash = d.TxHash.Hex()
+	}
+	if *block == 0 {
+		head, err := client.BlockNumber(ctx)
+		if err != nil {
+			return err
+		}
+		if head < *confirms {
+			return fmt.Errorf("head %d under %d confirmations", head, *confirms)
+		}
+		*block = head - *confirms
+	}
+
+	s := &Snapshotter{Backend: client, ChainID: chainID, MaxRange: 1000}
+	snap, err := s.Take(ctx, addr, common.HexToHash(*txHash), *block)
+	if snap == nil {
+		return err
+	}
+	// the mismatching snapshot is written too, to look into
+	write := func(path string, w func(io.Writer) error) error {
+		if path == "" {
+			return nil
+		}
+		var buf bytes.Buffer
+		if err := w(&buf); err != nil {
+			return err
+		}
+		return writeFileAtomic(path, buf.Bytes())
+	}
+	dist := NewMerkleDistribution(snap.Holders)
+	if err := write(*out, snap.WriteJSON); err != nil {
+		return err
+	}
+	if err := write(*csvOut, snap.WriteCSV); err != nil {
+		return err
+	}
+	if err := write(*merkleOut, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(dist)
+	}); err != nil {
+		return err
+	}
+	log.Info("Took snapshot", "contract", addr, "block", snap.Block, "holders", len(snap.Holders),
+		"totalSupply", snap.TotalSupply, "verified", snap.Verified, "root", dist.Root)
+	return err
+}
+
+func newAirdropService() *AirdropService {
+	return &AirdropService{Backend: client, ChainID: chainID, Transactor: newTransactor, Wait: waitReceipt}
+}
+
+func runAirdrop(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("airdrop", flag.ExitOnError)
+	token := fs.String("token", "dtoken", "registry name or address of the token")
+	balances := fs.String("csv", SnapshotCSVFile, "CSV of the address,amount to pay out")
+	out := fs.String("out", AirdropFile, "file the distributor and its Merkle tree are kept in")
+	from := fs.String("from", "", "name or address of the account signing, default to the default account")
+	fs.Parse(args)
+
+	ctx = withSigner(ctx, *from)
+	addr, err := tokens.TokenAddress(*token)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(*balances)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	leaves, err := ReadBalancesCSV(f)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", *balances, err)
+	}
+	if len(leaves) == 0 {
+		return fmt.Errorf("no balance in %s", *balances)
+	}
+	dist := NewMerkleDistribution(leaves)
+
+	// a deployed distributor of the same tree is funded again, another one is kept
+	s := newAirdropService()
+	a, err := LoadAirdrop(*out)
+	if err != nil {
+		return err
+	}
+	if a != nil && (a.Distribution.Root != dist.Root || a.Token != addr || a.ChainID != chainID.Uint64()) {
+		return fmt.Errorf("%s holds the airdrop of distributor %s, with another tree", *out, a.Distributor)
+	}
+	if a == nil {
+		if a, err = s.Deploy(ctx, addr, dist); err != nil {
+			return err
+		}
+		if err := a.Save(*out); err != nil {
+			return err
+		}
+	}
+	if err := s.Fund(ctx, a); err != nil {
+		return err
+	}
+	if err := a.Save(*out); err != nil {
+		return err
+	}
+	log.Info("Airdrop is funded", "distributor", a.Distributor, "root", dist.Root, "claims", len(dist.Claims), "total", dist.Total)
+	return nil
+}
+
+func runClaim(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("claim", flag.ExitOnError)
+	account := fs.String("account", "", "account to claim the airdrop of")
+	file := fs.String("airdrop", AirdropFile, "file the distributor and its Merkle tree are kept in")
+	from := fs.String("from", "", "name or address of the account signing, default to the default account")
+	fs.Parse(args)
+
+	ctx = withSigner(ctx, *from)
+	if !common.IsHexAddress(*account) {
+		return fmt.Errorf("invalid account %q", *account)
+	}
+	a, err := LoadAirdrop(*file)
+	if err != nil {
+		return err
+	}
+	if a == nil {
+		return fmt.Errorf("no airdrop in %s", *file)
+	}
+	if a.ChainID != chainID.Uint64() {
+		return fmt.Errorf("%s is an airdrop of chain %d", *file, a.ChainID)
+	}
+	spendingPolicy.AddDistributor(a.Distributor, a.Token)
+	s := newAirdropService()
+	tx, err := s.Claim(ctx, a, common.HexToAddress(*account))
+	if err != nil {
+		return err
+	}
+	waitCtx, cancel := withGrace(ctx)
+	defer cancel()
+	receipt, err := s.Wait(waitCtx, tx)
+	if err != nil {
+		return err
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return fmt.Errorf("claim tx %s failed", tx.Hash())
+	}
+	log.Info("Claimed airdrop", "account", common.HexToAddress(*account), "txHash", tx.Hash(), "block", receipt.BlockNumber)
+	return nil
+}
+
+// allowOrigins accepts the requests from the given origins, or any with "*".
+func allowOrigins(origins []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		for _, o := range origins {
+			if o = strings.TrimSpace(o); o == "*" || o == origin {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// observeNonceGap records how many txs of account are pending.
+func observeNonceGap(ctx context.Context, account common.Address) {
+	pending, err := client.PendingNonceAt(ctx, account)
+	if err != nil {
+		return
+	}
+	latest, err := client.NonceAt(ctx, account, nil)
+	if err != nil || pending < latest {
+		return
+	}
+	nonceGap.WithLabelValues(chainID.String(), account.Hex()).Set(float64(pending - latest))
+}
+
+func waitReceipt(ctx context.Context, tx *types.Transaction) (*types.Receipt, error) {
+	txHash := tx.Hash()
+	start := time.Now()
+	var receipt *types.Receipt
+	var err error
+	confirms := int(chain.Network.confirms(3))
+	if !chain.Capabilities.Subscribe {
+		receipt, err = WaitReceipt(ctx, client, txHash, 3*time.Second, 5, confirms)
+	} else {
+		receipt, err = WaitReceiptOnNewHead(ctx, client, txHash, 5, confirms)
+	}
+	if err != nil {
+		txFailed.WithLabelValues(tx.ChainId().String(), "timeout").Inc()
+		return nil, fmt.Errorf("wait receipt of %s: %w", txHash, err)
+	}
+	var baseFee *big.Int
+	if header, err := client.HeaderByHash(ctx, receipt.BlockHash); err == nil {
+		baseFee = header.BaseFee
+	}
+	observeReceipt(tx, receipt, baseFee, time.Since(start))
+	return receipt, nil
+}
+
+// observeReceipt records the outcome, gas and fee of a mined tx.
+// The fee is left out when the base fee of its block is unknown.
+func observeReceipt(tx *types.Transaction, receipt *types.Receipt, baseFee *big.Int, elapsed time.Duration) {
+	chain := tx.ChainId().String()
+	if receipt.Status == types.ReceiptStatusSuccessful {
+		txConfirmed.WithLabelValues(chain).Inc()
+		txConfirmSeconds.WithLabelValues(chain).Observe(elapsed.Seconds())
+	} else {
+		txFailed.WithLabelValues(chain, "reverted").Inc()
+	}
+	txGasUsed.WithLabelValues(chain).Add(float64(receipt.GasUsed))
+	if price := effectiveGasPrice(tx, baseFee); price != nil {
+		fee := new(big.Int).Mul(price, new(big.Int).SetUint64(receipt.GasUsed))
+		f, _ := new(big.Float).SetInt(fee).Float64()
+		txFeesWei.WithLabelValues(chain).Add(f)
+	}
+}
+
+// effectiveGasPrice is the price per gas paid by tx in a block of baseFee.
+func effectiveGasPrice(tx *types.Transaction, baseFee *big.Int) *big.Int {
+	if tx.Type() == types.LegacyTxType {
+		return tx.GasPrice()
+	}
+	if baseFee == nil {
+		return nil
+	}
+	tip, err := tx.EffectiveGasTip(baseFee)
+	if err != nil {
+		return nil
+	}
+	return tip.Add(tip, baseFee)
+}
+
+func watchTransfer(ctx context.Context, addr common.Address) error {
+	contract, err := NewEIP20(addr, client)
+	if err != nil {
+		return err
+	}
+	if !chain.Capabilities.Subscribe {
+		return FilterTransferEvent(ctx, contract, client, int(chain.Network.confirms(3)), checkpoints, CheckpointKey(chainID, addr))
+	}
+	return WatchTransferEvent(ctx, contract)
+}
+
+// runKeystore runs without the config and the rpc-server:
+//
+//	keystore new     -out key.json
+//	keystore import  -out key.json -key-file key.hex
+//	keystore passwd  -file key.json
+//	keystore address -file key.json
+//
+// The passwords and the hex key are read from their files, or else one line each from stdin.
+func runKeystore(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("keystore needs an action: new, import, passwd or address")
+	}
+	action, args := args[0], args[1:]
+	fs := flag.NewFlagSet("keystore "+action, flag.ExitOnError)
+	out := fs.String("out", "", "keystore file to write, never overwritten")
+	file := fs.String("file", "", "keystore file to read")
+	keyFile := fs.String("key-file", "", "file of the hex private key to import, default to stdin")
+	passwordFile := fs.String("password-file", "", "file of the password, default to stdin")
+	newPasswordFile := fs.String("new-password-file", "", "file of the new password of passwd, default to stdin")
+	scryptN := fs.Int("scrypt-n", keystore.StandardScryptN, "scrypt N of the key derivation, "+strconv.Itoa(keystore.LightScryptN)+" for a light one")
+	scryptP := fs.Int("scrypt-p", keystore.StandardScryptP, "scrypt P of the key derivation")
+	fs.Parse(args)
+
+	stdin := bufio.NewReader(os.Stdin)
+	scrypt := ScryptParams{N: *scryptN, P: *scryptP}
+	var addr common.Address
+	var err error
+	switch action {
+	case "new", "import":
+		if *out == "" {
+			return errors.New("-out is required")
+		}
+		var key *ecdsa.PrivateKey
+		if action == "new" {
+			key, err = crypto.GenerateKey()
+		} else {
+			var hexKey string
+			if hexKey, err = readSecret(*keyFile, stdin); err == nil {
+				key, err = crypto.HexToECDSA(strings.TrimPrefix(strings.TrimSpace(hexKey), "0x"))
+			}
+		}
+		if err != nil {
+			return err
+		}
+		password, err := readSecret(*passwordFile, stdin)
+		if err != nil {
+			return fmt.Errorf("read password: %w", err)
+		}
+		if addr, err = WriteKeyStore(*out, key, password, scrypt); err != nil {
+			return err
+		}
+		log.Info("Wrote keystore", "file", *out, "address", addr)
+	case "passwd":
+		password, err := readSecret(*passwordFile, stdin)
+		if err != nil {
+			return fmt.Errorf("read password: %w", err)
+		}
+		newPassword, err := readSecret(*newPasswordFile, stdin)
+		if err != nil {
+			return fmt.Errorf("read new password: %w", err)
+		}
+		if addr, err = ChangeKeyStorePassword(*file, password, newPassword, scrypt); err != nil {
+			return err
+		}
+		log.Info("Changed keystore password", "file", *file, "address", addr)
+	case "address":
+		if addr, err = ReadKeyStoreAddress(*file); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown keystore action %q", action)
+	}
+	fmt.Println(addr.Hex())
+	return nil
+}
+
+func runRotate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+	from := fs.String("from", DefaultAccount, "name or address of the account to rotate")
+	to := fs.String("to", "", "name or address of the account taking over, an account of the config")
+	tokenNames := fs.String("tokens", "", "comma separated names or addresses of the tokens, default to all those of the network and the registry")
+	dryRun := fs.Bool("dry-run", false, "print the steps of the rotation without sending them")
+	fs.Parse(args)
+
+	old, err := signers.Get(*from)
+	if err != nil {
+		return err
+	}
+	if *to == "" {
+		return errors.New("-to is required")
+	}
+	next, err := signers.Get(*to)
+	if err != nil {
+		return err
+	}
+	names := tokens.Names()
+	if *tokenNames != "" {
+		names = strings.Split(*tokenNames, ",")
+	}
+	var addrs []common.Address
+	for _, name := range names {
+		addr, err := tokens.TokenAddress(strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+		addrs = append(addrs, addr)
+	}
+	r := &Rotator{Tokens: tokens, Backend: client}
+	steps, err := r.Plan(ctx, addrs, old.Address, next.Address)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(steps)
+	}
+	if err := r.Rotate(ctx, steps, old.Name, next.Name); err != nil {
+		return err
+	}
+	log.Info("Rotated account", "from", old.Address, "to", next.Address, "steps", len(steps))
+	return nil
+}
+
+func newSafeService() *SafeService {
+	return &SafeService{Backend: client, ChainID: chainID, Transactor: newTransactor, Wait: waitReceipt}
+}
+
+// runSafe builds a token transfer or approval of a Safe for its owners to sign
+// with safe-sign, and executes it once signed:
+//
+//	safe propose -token dtoken -method transfer -to 0x.. -amount 100
+//	safe exec
+func runSafe(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("safe needs an action: propose or exec")
+	}
+	action, args := args[0], args[1:]
+	fs := flag.NewFlagSet("safe "+action, flag.ExitOnError)
+	file := fs.String("tx", SafeTxFile, "file the Safe transaction is kept in")
+	safe := fs.String("safe", cfg.Treasury, "address of the Safe, default to the treasury")
+	token := fs.String("token", "dtoken", "registry name or address of the token")
+	method := fs.String("method", "transfer", "transfer or approve")
+	to := fs.String("to", "", "recipient of a transfer, spender of an approval")
+	value := fs.String("amount", "", "amount of tokens")
+	from := fs.String("from", "", "name or address of the account sending execTransaction, default to the default account")
+	fs.Parse(args)
+
+	s := newSafeService()
+	switch action {
+	case "propose":
+		if !common.IsHexAddress(*safe) {
+			return fmt.Errorf("invalid safe %q", *safe)
+		}
+		if *method != "transfer" && *method != "approve" {
+			return fmt.Errorf("method %q is not transfer or approve", *method)
+		}
+		if !common.IsHexAddress(*to) {
+			return fmt.Errorf("invalid address %q", *to)
+		}
+		amount, ok := new(big.Int).SetString(*value, 10)
+		if !ok {
+			return fmt.Errorf("invalid amount %q", *value)
+		}
+		addr, err := tokens.TokenAddress(*token)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(*file); err == nil {
+			return fmt.Errorf("%s holds another Safe transaction already", *file)
+		}
+		t, err := s.NewTokenTx(ctx, common.HexToAddress(*safe), addr, *method, common.HexToAddress(*to), amount)
+		if err != nil {
+			return err
+		}
+		if err := t.Save(*file); err != nil {
+			return err
+		}
+		hash, _ := t.Hash()
+		log.Info("Proposed safe transaction", "file", *file, "safe", t.Safe, "safeTxHash", hash, "nonce", t.Nonce, "threshold", t.Threshold)
+		return nil
+	case "exec":
+		t, err := LoadSafeTx(*file)
+		if err != nil {
+			return err
+		}
+		if t.ChainID != chainID.Uint64() {
+			return fmt.Errorf("%s is a transaction of chain %d", *file, t.ChainID)
+		}
+		_, execErr := s.Exec(withSigner(ctx, *from), t)
+		if t.ExecTxHash != nil {
+			if err := t.Save(*file); err != nil {
+				return err
+			}
+		}
+		return execErr
+	}
+	return fmt.Errorf("unknown safe action %q", action)
+}
+
+// runSafeSign runs without the config and the rpc-server, for the owners to
+// sign a Safe transaction offline, with their keystore file or their wallet:
+//
+//	safe-sign -keystore owner.json
+//	safe-sign -typed-data > safetx-typed.json    # for eth_signTypedData_v4
+//	safe-sign -signature 0x..
+func runSafeSign(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("safe-sign", flag.ExitOnError)
+	file := fs.String("tx", SafeTxFile, "file the Safe transaction is kept in")
+	keyStore := fs.String("keystore", "", "keystore file of the owner signing")
+	passwordFile := fs.String("password-file", "", "file of the keystore password, default to stdin")
+	signature := fs.String("signature", "", "hex signature of an owner, of the typed data or of the hash with eth_sign")
+	typedData := fs.Bool("typed-data", false, "print the typed data to sign, without signing")
+	fs.Parse(args)
+
+	t, err := LoadSafeTx(*file)
+	if err != nil {
+		return err
+	}
+	hash, err := t.Hash()
+	if err != nil {
+		return err
+	}
+	var owner common.Address
+	switch {
+	case *typedData:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(t.TypedData())
+	case *keyStore != "":
+		keyjson, err := ioutil.ReadFile(*keyStore)
+		if err != nil {
+			return err
+		}
+		password, err := readSecret(*passwordFile, bufio.NewReader(os.Stdin))
+		if err != nil {
+			return fmt.Errorf("read password: %w", err)
+		}
+		key, err := keystore.DecryptKey(keyjson, password)
+		if err != nil {
+			return err
+		}
+		signer := signing.NewSigner(key.PrivateKey)
+		if err := t.Sign(signer); err != nil {
+			return err
+		}
+		owner = signer.Address()
+	case *signature != "":
+		sig, err := hexutil.Decode(*signature)
+		if err != nil {
+			return fmt.Errorf("invalid signature: %w", err)
+		}
+		if owner, err = t.AddSignature(sig); err != nil {
+			return err
+		}
+	default:
+		return errors.New("one of -keystore, -signature or -typed-data is required")
+	}
+	if err := t.Save(*file); err != nil {
+		return err
+	}
+	log.Info("Signed safe transaction", "safeTxHash", hash, "owner", owner, "signatures", len(t.Signatures), "threshold", t.Threshold)
+	return nil
+}
@@ -3,12 +3,13 @@ package main
 import (
 	"crypto/ecdsa"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
-	"strings"
 
+	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
@@ -19,63 +20,161 @@ const (
 	AccountKeyStoreFile
 )
 
-type Config struct {
+// AccountConfig is the key of an account, from the source of AccountType.
+type AccountConfig struct {
+	Name         string `json:"name,omitempty"`
 	AccountType  uint   `json:"accountType"`
 	SecretHex    string `json:"secretHex,omitempty"`
 	KeyStoreFile string `json:"keyStoreFile,omitempty"`
 	Password     string `json:"password,omitempty"`
-	RpcUrl       string `json:"rpcUrl"`
+}
+
+type Config struct {
+	// AccountConfig is the DefaultAccount, left out when Accounts has a default already
+	AccountConfig
+	// Accounts are more accounts, which the operations may sign with by name or address
+	Accounts []AccountConfig `json:"accounts,omitempty"`
+	// RpcUrl is the rpc-server of the DefaultNetwork, left out with Networks only
+	RpcUrl string `json:"rpcUrl,omitempty"`
+	// Networks are the chains the commands may run on, by name
+	Networks map[string]*NetworkConfig `json:"networks,omitempty"`
+	// Network is the network of the commands without -network, DefaultNetwork by default
+	Network string `json:"network,omitempty"`
+	// LogFormat is LogFormatJSON (default) or LogFormatTerminal
+	LogFormat string `json:"logFormat,omitempty"`
+	// LogLevel is one of trace, debug, info (default), warn, error, crit
+	LogLevel string `json:"logLevel,omitempty"`
+	// TraceExporter is TraceExporterOTLP, TraceExporterStdout or TraceExporterFile, none by default
+	TraceExporter string `json:"traceExporter,omitempty"`
+	// TraceEndpoint is the OTLP/HTTP collector url, like http://localhost:4318
+	TraceEndpoint string `json:"traceEndpoint,omitempty"`
+	// TraceFile is the file TraceExporterFile appends the spans to
+	TraceFile string `json:"traceFile,omitempty"`
+	// DepositSeed is the hex BIP32 seed of the deposit addresses
+	DepositSeed string `json:"depositSeed,omitempty"`
+	// DepositPath is the derivation path of the deposit addresses, m/44'/60'/0'/0 by default
+	DepositPath string `json:"depositPath,omitempty"`
+	// Treasury receives the swept deposits
+	Treasury string `json:"treasury,omitempty"`
 
-	isHttp bool
-	secret *ecdsa.PrivateKey
+	// secret is the key of the first of accounts, the default
+	secret   *ecdsa.PrivateKey
+	accounts []*Account
 }
 
-func LoadConfig() *Config {
+func LoadConfig() (*Config, error) {
 	bs, err := ioutil.ReadFile(ConfigFile)
 	var conf Config
 	if err == nil {
 		err = json.Unmarshal(bs, &conf)
 	}
 	if err != nil {
-		log.Panicf("load config failed. err=%s\n", err)
+		return nil, fmt.Errorf("load config: %w", err)
 	}
 
 	err = conf.loadSecret()
 	if err != nil {
-		log.Panicf("load secret failed. err=%s\n", err)
+		return nil, fmt.Errorf("load secret: %w", err)
 	}
 
-	if strings.HasPrefix(strings.TrimSpace(conf.RpcUrl), "http") {
-		conf.isHttp = true
+	if err = conf.loadNetworks(); err != nil {
+		return nil, fmt.Errorf("load networks: %w", err)
 	}
+	return &conf, nil
+}
 
-	return &conf
+// loadNetworks adds the top level rpcUrl as the DefaultNetwork, and checks the networks.
+func (c *Config) loadNetworks() error {
+	if c.RpcUrl != "" {
+		if c.Networks[DefaultNetwork] != nil {
+			return fmt.Errorf("rpcUrl and network %s are both set", DefaultNetwork)
+		}
+		if c.Networks == nil {
+			c.Networks = make(map[string]*NetworkConfig)
+		}
+		c.Networks[DefaultNetwork] = &NetworkConfig{RpcUrls: []string{c.RpcUrl}}
+	}
+	if len(c.Networks) == 0 {
+		return errors.New("no rpcUrl nor networks")
+	}
+	for name, n := range c.Networks {
+		if n == nil {
+			return fmt.Errorf("network %s is null", name)
+		}
+		if err := n.check(); err != nil {
+			return fmt.Errorf("network %s: %w", name, err)
+		}
+	}
+	if c.Network != "" && c.Networks[c.Network] == nil {
+		return fmt.Errorf("%w %q", ErrUnknownNetwork, c.Network)
+	}
+	return nil
 }
 
+// loadSecret loads the keys of the accounts. The top level keys are the
+// DefaultAccount, unless they are left out and an account is named so.
 func (c *Config) loadSecret() error {
-	switch c.AccountType {
-	case AccountSecretKey:
-		sk, err := crypto.HexToECDSA(c.SecretHex)
+	var defaults []AccountConfig
+	if c.SecretHex != "" || c.KeyStoreFile != "" || len(c.Accounts) == 0 {
+		def := c.AccountConfig
+		def.Name = DefaultAccount
+		defaults = append(defaults, def)
+	}
+	c.accounts = nil
+	for _, ac := range append(defaults, c.Accounts...) {
+		if ac.Name == "" {
+			return errors.New("an account has no name")
+		}
+		key, err := ac.loadKey()
 		if err != nil {
-			return err
+			return fmt.Errorf("account %s: %w", ac.Name, err)
+		}
+		c.accounts = append(c.accounts, &Account{Name: ac.Name, Address: crypto.PubkeyToAddress(key.PublicKey), Key: key})
+	}
+	// the default first
+	for i, acc := range c.accounts {
+		if acc.Name == DefaultAccount {
+			c.accounts[0], c.accounts[i] = c.accounts[i], c.accounts[0]
 		}
-		c.secret = sk
+	}
+	c.secret = c.accounts[0].Key
+	return nil
+}
+
+func (ac *AccountConfig) loadKey() (*ecdsa.PrivateKey, error) {
+	switch ac.AccountType {
+	case AccountSecretKey:
+		return crypto.HexToECDSA(ac.SecretHex)
 	case AccountKeyStoreFile:
 		// Load the key from the keystore and decrypt its contents
-		keyjson, err := ioutil.ReadFile(c.KeyStoreFile)
+		keyjson, err := ioutil.ReadFile(ac.KeyStoreFile)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		key, err := keystore.DecryptKey(keyjson, c.Password)
+		key, err := keystore.DecryptKey(keyjson, ac.Password)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		c.secret = key.PrivateKey
+		return key.PrivateKey, nil
 
 	default:
-		return fmt.Errorf("unsupported AccountType %d", c.AccountType)
+		return nil, fmt.Errorf("unsupported AccountType %d", ac.AccountType)
 	}
-	return nil
+}
+
+// DepositKeys derives the keys of the deposit addresses from DepositSeed.
+func (c *Config) DepositKeys() (*DepositKeys, error) {
+	if c.DepositSeed == "" {
+		return nil, errors.New("no depositSeed in the config")
+	}
+	path := accounts.DefaultRootDerivationPath
+	if c.DepositPath != "" {
+		var err error
+		if path, err = accounts.ParseDerivationPath(c.DepositPath); err != nil {
+			return nil, err
+		}
+	}
+	return NewDepositKeys(common.FromHex(c.DepositSeed), path)
 }
 
 func (c *Config) PrivateKey() *ecdsa.PrivateKey {
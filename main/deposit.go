@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	DepositAddressFile = "deposit-addresses.json"
+	CreditFile         = "credits.jsonl"
+
+	// EventDeposit is the type of a credited deposit, a Transfer to a deposit address
+	EventDeposit = "Deposit"
+
+	// depositTopicChunk is the most deposit addresses in the topic filter of one eth_getLogs
+	depositTopicChunk = 500
+)
+
+// LoadDepositAddresses reads the JSON array of deposit addresses. A missing file gives none.
+func LoadDepositAddresses(path string) ([]common.Address, error) {
+	bs, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var addrs []common.Address
+	if err := json.Unmarshal(bs, &addrs); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return addrs, nil
+}
+
+// Credits is the ledger of the credited deposits, one JSON line each,
+// which makes sure a deposit is credited once per (chainId, txHash, logIndex),
+// across restarts too.
+type Credits struct {
+	mu   sync.Mutex
+	f    *os.File
+	seen map[creditKey]bool
+}
+
+// creditKey is a deposit of a chain, the ledger may hold those of several.
+type creditKey struct {
+	ChainID  uint64
+	TxHash   common.Hash
+	LogIndex uint
+}
+
+// OpenCredits reads the credited deposits of the ledger file, and opens it to append the new ones.
+func OpenCredits(path string) (*Credits, error) {
+	c := &Credits{seen: make(map[creditKey]bool)}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var e TokenEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("parse %s line %d: %w", path, line, err)
+		}
+		c.seen[creditKey{e.ChainID, e.TxHash, e.LogIndex}] = true
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	c.f = f
+	return c, nil
+}
+
+// Credit records e, unless it's credited already. It tells if e is new.
+func (c *Credits) Credit(e *TokenEvent) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := creditKey{e.ChainID, e.TxHash, e.LogIndex}
+	if c.seen[key] {
+		return false, nil
+	}
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return false, err
+	}
+	if _, err := c.f.Write(append(bs, '\n')); err != nil {
+		return false, err
+	}
+	if err := c.f.Sync(); err != nil {
+		return false, err
+	}
+	c.seen[key] = true
+	return true, nil
+}
+
+// Credited tells if the deposit of txHash and logIndex on chainID is credited.
+func (c *Credits) Credited(chainID uint64, txHash common.Hash, logIndex uint) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.seen[creditKey{chainID, txHash, logIndex}]
+}
+
+func (c *Credits) Close() error {
+	return c.f.Close()
+}
+
+// depositEvents feeds the credited deposits.
+var depositEvents event.Feed
+
+// SubscribeDeposits sends every credited deposit to ch, once.
+func SubscribeDeposits(ch chan<- *TokenEvent) event.Subscription {
+	return depositEvents.Subscribe(ch)
+}
+
+// DepositCheckpointKey is the key of the deposit scanner of contract on chainID.
+func DepositCheckpointKey(chainID *big.Int, contract common.Address) string {
+	return "deposits/" + CheckpointKey(chainID, contract)
+}
+
+// DepositWatcher credits the Transfers of the tokens to the deposit addresses,
+// once their blocks have Confirms confirmations and are still canonical.
+// The transfers are selected by the rpc-server, with the addresses as topics.
+type DepositWatcher struct {
+	Backend     TrackerBackend
+	ChainID     *big.Int
+	Tokens      []common.Address
+	Addresses   []common.Address
+	Confirms    uint64
+	Credits     *Credits
+	Checkpoints *Checkpoints
+	// PollInterval is how often the head is checked
+	PollInterval time.Duration
+	// MaxRange is the most blocks scanned by one eth_getLogs
+	MaxRange uint64
+	// TopicChunk is the most addresses filtered by one eth_getLogs, default to 500
+	TopicChunk int
+}
+
+// Run watches for deposits until ctx is done.
+func (w *DepositWatcher) Run(ctx context.Context) error {
+	filterers := make(map[common.Address]*EIP20Filterer)
+	for _, token := range w.Tokens {
+		f, err := NewEIP20Filterer(token, w.Backend)
+		if err != nil {
+			return err
+		}
+		filterers[token] = f
+	}
+	for {
+		head, err := w.Backend.BlockNumber(ctx)
+		if err != nil {
+			log.Warn("Get BlockNumber failed", "chainId", w.ChainID, "err", err)
+		} else if err := w.scan(ctx, filterers, head); err != nil {
+			log.Error("Scan deposits failed", "chainId", w.ChainID, "head", head, "err", err)
+		}
+		if err := sleep(ctx, w.PollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// scan credits the deposits of every token up to the confirmed block under head.
+func (w *DepositWatcher) scan(ctx context.Context, filterers map[common.Address]*EIP20Filterer, head uint64) error {
+	if head < w.Confirms || len(w.Addresses) == 0 {
+		return nil
+	}
+	confirmed := head - w.Confirms
+	maxRange := w.MaxRange
+	if maxRange == 0 {
+		maxRange = 1000
+	}
+	var failed error
+	for _, token := range w.Tokens {
+		key := DepositCheckpointKey(w.ChainID, token)
+		next, ok := w.Checkpoints.Get(key)
+		if !ok {
+			next = confirmed
+		}
+		for next <= confirmed {
+			end := next + maxRange - 1
+			if end > confirmed {
+				end = confirmed
+			}
+			if err := w.scanRange(ctx, filterers[token], next, end, head); err != nil {
+				failed = fmt.Errorf("scan %s: %w", token, err)
+				break
+			}
+			next = end + 1
+			w.Checkpoints.Set(key, next)
+		}
+	}
+	if err := w.Checkpoints.Save(); err != nil {
+		return err
+	}
+	return failed
+}
+
+// scanRange credits the deposits of the blocks from start to end. Nothing is
+// credited if one of their blocks has been reorged, the range is scanned again later.
+func (w *DepositWatcher) scanRange(ctx context.Context, f *EIP20Filterer, start, end, head uint64) error {
+	deposits, err := w.filterDeposits(ctx, f, start, end)
+	if err != nil {
+		return err
+	}
+	canonical := make(map[uint64]common.Hash)
+	for _, e := range deposits {
+		hash, ok := canonical[e.BlockNumber]
+		if !ok {
+			h, err := w.Backend.HeaderByNumber(ctx, new(big.Int).SetUint64(e.BlockNumber))
+			if err != nil {
+				return err
+			}
+			hash = h.Hash()
+			canonical[e.BlockNumber] = hash
+		}
+		if hash != e.BlockHash {
+			return fmt.Errorf("block %d of deposit %s has been reorged", e.BlockNumber, e.TxHash)
+		}
+	}
+	for _, e := range deposits {
+		e.Type = EventDeposit
+		e.Confirmations = head - e.BlockNumber
+		fresh, err := w.Credits.Credit(e)
+		if err != nil {
+			return fmt.Errorf("credit %s/%d: %w", e.TxHash, e.LogIndex, err)
+		}
+		if !fresh {
+			continue
+		}
+		depositsCredited.WithLabelValues(w.ChainID.String(), e.Contract.Hex()).Inc()
+		log.Info("Credit deposit", "chainId", w.ChainID, "contract", e.Contract, "to", e.To, "from", e.From,
+			"value", e.Value, "txHash", e.TxHash, "logIndex", e.LogIndex, "block", e.BlockNumber)
+		depositEvents.Send(e)
+	}
+	return nil
+}
+
+// filterDeposits gets the transfers to the deposit addresses, TopicChunk addresses at a time,
+// in chain order.
+func (w *DepositWatcher) filterDeposits(ctx context.Context, f *EIP20Filterer, start, end uint64) ([]*TokenEvent, error) {
+	chunk := w.TopicChunk
+	if chunk <= 0 {
+		chunk = depositTopicChunk
+	}
+	opts := &bind.FilterOpts{Start: start, End: &end, Context: ctx}
+	var deposits []*TokenEvent
+	for i := 0; i < len(w.Addresses); i += chunk {
+		j := i + chunk
+		if j > len(w.Addresses) {
+			j = len(w.Addresses)
+		}
+		it, err := f.FilterTransfer(opts, nil, w.Addresses[i:j])
+		if err != nil {
+			return nil, err
+		}
+		for it.Next() {
+			if !it.Event.Raw.Removed {
+				deposits = append(deposits, transferEvent(w.ChainID, it.Event))
+			}
+		}
+		it.Close()
+		if err := it.Error(); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(deposits, func(i, j int) bool {
+		a, b := deposits[i], deposits[j]
+		if a.BlockNumber != b.BlockNumber {
+			return a.BlockNumber < b.BlockNumber
+		}
+		return a.LogIndex < b.LogIndex
+	})
+	return deposits, nil
+}
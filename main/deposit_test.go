@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// forkedBackend answers another header for the blocks in forked, as if they were reorged
+// after their logs were read.
+type forkedBackend struct {
+	simBackend
+	forked map[uint64]bool
+}
+
+func (b *forkedBackend) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	h, err := b.simBackend.HeaderByNumber(ctx, number)
+	if err != nil || !b.forked[number.Uint64()] {
+		return h, err
+	}
+	h = types.CopyHeader(h)
+	h.Extra = []byte("fork")
+	return h, nil
+}
+
+func newDepositWatcher(t *testing.T, backend TrackerBackend, token common.Address, addrs []common.Address, credits string) (*DepositWatcher, map[common.Address]*EIP20Filterer) {
+	t.Helper()
+	ledger, err := OpenCredits(credits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ledger.Close() })
+	f, err := NewEIP20Filterer(token, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &DepositWatcher{
+		Backend:    backend,
+		ChainID:    simChainID,
+		Tokens:     []common.Address{token},
+		Addresses:  addrs,
+		Confirms:   2,
+		Credits:    ledger,
+		TopicChunk: 2,
+	}
+	return w, map[common.Address]*EIP20Filterer{token: f}
+}
+
+func TestDepositWatcher(t *testing.T) {
+	env := newTestEnv(t, 1)
+	token, _, contract := env.deploy(1000)
+	start := env.backend.Blockchain().CurrentBlock().NumberU64()
+
+	deposits := []common.Address{{0x01}, {0x02}, {0x03}}
+	credits := filepath.Join(t.TempDir(), CreditFile)
+	w, filterers := newDepositWatcher(t, env.backend, token, deposits, credits)
+	w.Checkpoints, _ = LoadCheckpoints(filepath.Join(t.TempDir(), CheckpointFile))
+	w.Checkpoints.Set(DepositCheckpointKey(simChainID, token), start)
+
+	events := make(chan *TokenEvent, 16)
+	sub := SubscribeDeposits(events)
+	defer sub.Unsubscribe()
+
+	auth := env.accounts[0].transactor(t)
+	var txs []*types.Transaction
+	for i, to := range []common.Address{{0x01}, {0x09}, {0x03}, {0x02}} {
+		tx, err := contract.Transfer(auth, to, big.NewInt(int64(i+1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, tx)
+	}
+	env.backend.Commit()
+	ctx := context.Background()
+	head := func() uint64 { return env.backend.Blockchain().CurrentBlock().NumberU64() }
+
+	// not confirmed yet
+	env.backend.Commit()
+	if err := w.scan(ctx, filterers, head()); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("credited %d deposits before the confirmations", len(events))
+	}
+
+	env.backend.Commit()
+	for i := 0; i < 2; i++ {
+		if err := w.scan(ctx, filterers, head()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// in chain order, once, and not the transfer to another address
+	for _, i := range []int{0, 2, 3} {
+		e := <-events
+		if e.Type != EventDeposit || e.TxHash != txs[i].Hash() || e.Confirmations != 2 {
+			t.Fatalf("got %+v, want the deposit of tx %d", e, i)
+		}
+	}
+	if len(events) != 0 {
+		t.Fatalf("credited %d more deposits", len(events))
+	}
+
+	// a restart without checkpoints scans again, the ledger keeps the credits
+	w, filterers = newDepositWatcher(t, env.backend, token, deposits, credits)
+	w.Checkpoints, _ = LoadCheckpoints(filepath.Join(t.TempDir(), CheckpointFile))
+	w.Checkpoints.Set(DepositCheckpointKey(simChainID, token), start)
+	if !w.Credits.Credited(simChainID.Uint64(), txs[3].Hash(), 3) {
+		t.Fatal("credit lost on reopen")
+	}
+	if w.Credits.Credited(1, txs[3].Hash(), 3) {
+		t.Fatal("the credit of the deposit counts on another chain")
+	}
+	if err := w.scan(ctx, filterers, head()); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("credited %d deposits again", len(events))
+	}
+}
+
+func TestDepositWatcherReorg(t *testing.T) {
+	env := newTestEnv(t, 1)
+	token, _, contract := env.deploy(1000)
+	start := env.backend.Blockchain().CurrentBlock().NumberU64()
+	tx, err := contract.Transfer(env.accounts[0].transactor(t), common.Address{0x01}, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.backend.Commit()
+	receipt := env.requireSuccess(tx)
+	for i := 0; i < 2; i++ {
+		env.backend.Commit()
+	}
+
+	backend := &forkedBackend{env.backend, map[uint64]bool{receipt.BlockNumber.Uint64(): true}}
+	w, filterers := newDepositWatcher(t, backend, token, []common.Address{{0x01}}, filepath.Join(t.TempDir(), CreditFile))
+	w.Checkpoints, _ = LoadCheckpoints(filepath.Join(t.TempDir(), CheckpointFile))
+	key := DepositCheckpointKey(simChainID, token)
+	w.Checkpoints.Set(key, start)
+
+	head := env.backend.Blockchain().CurrentBlock().NumberU64()
+	if err := w.scan(context.Background(), filterers, head); err == nil {
+		t.Fatal("scanned a reorged block")
+	}
+	if w.Credits.Credited(simChainID.Uint64(), tx.Hash(), 0) {
+		t.Fatal("credited a reorged deposit")
+	}
+	if next, _ := w.Checkpoints.Get(key); next > receipt.BlockNumber.Uint64() {
+		t.Fatalf("checkpoint moved past the reorged block to %d", next)
+	}
+
+	// the block is canonical again
+	delete(backend.forked, receipt.BlockNumber.Uint64())
+	if err := w.scan(context.Background(), filterers, head); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Credits.Credited(simChainID.Uint64(), tx.Hash(), 0) {
+		t.Fatal("deposit not credited")
+	}
+}
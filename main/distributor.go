@@ -0,0 +1,655 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var (
+	_ = errors.New
+	_ = big.NewInt
+	_ = strings.NewReader
+	_ = ethereum.NotFound
+	_ = bind.Bind
+	_ = common.Big1
+	_ = types.BloomLookup
+	_ = event.NewSubscription
+)
+
+// MerkleDistributorMetaData contains all meta data concerning the MerkleDistributor contract.
+var MerkleDistributorMetaData = &bind.MetaData{
+	ABI: "[{\"inputs\":[{\"internalType\":\"address\",\"name\":\"_token\",\"type\":\"address\"},{\"internalType\":\"bytes32\",\"name\":\"_merkleRoot\",\"type\":\"bytes32\"}],\"stateMutability\":\"nonpayable\",\"type\":\"constructor\"},{\"anonymous\":false,\"inputs\":[{\"indexed\":true,\"internalType\":\"address\",\"name\":\"account\",\"type\":\"address\"},{\"indexed\":false,\"internalType\":\"uint256\",\"name\":\"amount\",\"type\":\"uint256\"}],\"name\":\"Claimed\",\"type\":\"event\"},{\"anonymous\":false,\"inputs\":[{\"indexed\":true,\"internalType\":\"address\",\"name\":\"to\",\"type\":\"address\"},{\"indexed\":false,\"internalType\":\"uint256\",\"name\":\"amount\",\"type\":\"uint256\"}],\"name\":\"Withdrawn\",\"type\":\"event\"},{\"inputs\":[{\"internalType\":\"address\",\"name\":\"_account\",\"type\":\"address\"},{\"internalType\":\"uint256\",\"name\":\"_amount\",\"type\":\"uint256\"},{\"internalType\":\"bytes32[]\",\"name\":\"_merkleProof\",\"type\":\"bytes32[]\"}],\"name\":\"claim\",\"outputs\":[],\"stateMutability\":\"nonpayable\",\"type\":\"function\"},{\"inputs\":[{\"internalType\":\"address\",\"name\":\"\",\"type\":\"address\"}],\"name\":\"isClaimed\",\"outputs\":[{\"internalType\":\"bool\",\"name\":\"\",\"type\":\"bool\"}],\"stateMutability\":\"view\",\"type\":\"function\"},{\"inputs\":[],\"name\":\"merkleRoot\",\"outputs\":[{\"internalType\":\"bytes32\",\"name\":\"\",\"type\":\"bytes32\"}],\"stateMutability\":\"view\",\"type\":\"function\"},{\"inputs\":[],\"name\":\"owner\",\"outputs\":[{\"internalType\":\"address\",\"name\":\"\",\"type\":\"address\"}],\"stateMutability\":\"view\",\"type\":\"function\"},{\"inputs\":[],\"name\":\"token\",\"outputs\":[{\"internalType\":\"address\",\"name\":\"\",\"type\":\"address\"}],\"stateMutability\":\"view\",\"type\":\"function\"},{\"inputs\":[{\"internalType\":\"address\",\"name\":\"_to\",\"type\":\"address\"}],\"name\":\"withdraw\",\"outputs\":[],\"stateMutability\":\"nonpayable\",\"type\":\"function\"}]",
+	Bin: "0x60e060405234801561001057600080fd5b506040516108e13803806108e183398101604081905261002f91610049565b6001600160a01b0390911660805260a0523360c052610083565b6000806040838503121561005c57600080fd5b82516001600160a01b038116811461007357600080fd5b6020939093015192949293505050565b60805160a05160c05161080d6100d4600039600081816101010152610395015260008181606c015261062d015260008181610140015281816102b50152818161041a01526104b6015261080d6000f3fe608060405234801561001057600080fd5b50600436106100625760003560e01c80632eb4a7ab146100675780633d13f874146100a157806351cff8d9146100b65780638cc08025146100c95780638da5cb5b146100fc578063fc0c546a1461013b575b600080fd5b61008e7f000000000000000000000000000000000000000000000000000000000000000081565b6040519081526020015b60405180910390f35b6100b46100af366004610671565b610162565b005b6100b46100c43660046106fb565b61038a565b6100ec6100d73660046106fb565b60006020819052908152604090205460ff1681565b6040519015158152602001610098565b6101237f000000000000000000000000000000000000000000000000000000000000000081565b6040516001600160a01b039091168152602001610098565b6101237f000000000000000000000000000000000000000000000000000000000000000081565b6001600160a01b03841660009081526020819052604090205460ff16156101db5760405162461bcd60e51b815260206004820152602260248201527f4d65726b6c654469737472696275746f723a20616c726561647920636c61696d604482015261195960f21b60648201526084015b60405180910390fd5b6040516bffffffffffffffffffffffff19606086901b16602082015260348101849052600090605401604051602081830303815290604052805190602001209050610227838383610588565b6102735760405162461bcd60e51b815260206004820181905260248201527f4d65726b6c654469737472696275746f723a20696e76616c69642070726f6f6660448201526064016101d2565b6001600160a01b0385811660008181526020819052604090819020805460ff191660011790555163a9059cbb60e01b81526004810191909152602481018690527f00000000000000000000000000000000000000000000000000000000000000009091169063a9059cbb906044016020604051808303816000875af1158015610300573d6000803e3d6000fd5b505050506040513d601f19601f82011682018060405250810190610324919061071d565b6103405760405162461bcd60e51b81526004016101d29061073f565b846001600160a01b03167fd8138f8a3f377c5259ca548e70e4c2de94f129f5a11036a15b69513cba2b426a8560405161037b91815260200190565b60405180910390a25050505050565b336001600160a01b037f000000000000000000000000000000000000000000000000000000000000000016146104025760405162461bcd60e51b815260206004820181905260248201527f4d65726b6c654469737472696275746f723a206e6f7420746865206f776e657260448201526064016101d2565b6040516370a0823160e01b81523060048201526000907f00000000000000000000000000000000000000000000000000000000000000006001600160a01b0316906370a0823190602401602060405180830381865afa158015610469573d6000803e3d6000fd5b505050506040513d601f19601f8201168201806040525081019061048d9190610781565b60405163a9059cbb60e01b81526001600160a01b038481166004830152602482018390529192507f00000000000000000000000000000000000000000000000000000000000000009091169063a9059cbb906044016020604051808303816000875af1158015610501573d6000803e3d6000fd5b505050506040513d601f19601f82011682018060405250810190610525919061071d565b6105415760405162461bcd60e51b81526004016101d29061073f565b816001600160a01b03167f7084f5476618d8e60b11ef0d7d3f06914655adb8793e28ff7f018d4c76d505d58260405161057c91815260200190565b60405180910390a25050565b600081815b8481101561062a5760008686838181106105a9576105a961079a565b905060200201359050808311156105e957604080516020810183905290810184905260600160405160208183030381529060405280519060200120610614565b6040805160208101859052908101829052606001604051602081830303815290604052805190602001205b9250508080610622906107b0565b91505061058d565b507f000000000000000000000000000000000000000000000000000000000000000014949350505050565b80356001600160a01b038116811461066c57600080fd5b919050565b6000806000806060858703121561068757600080fd5b61069085610655565b935060208501359250604085013567ffffffffffffffff808211156106b457600080fd5b818701915087601f8301126106c857600080fd5b8135818111156106d757600080fd5b8860208260051b85010111156106ec57600080fd5b95989497505060200194505050565b60006020828403121561070d57600080fd5b61071682610655565b9392505050565b60006020828403121561072f57600080fd5b8151801515811461071657600080fd5b60208082526022908201527f4d65726b6c654469737472696275746f723a207472616e73666572206661696c604082015261195960f21b606082015260800190565b60006020828403121561079357600080fd5b5051919050565b634e487b7160e01b600052603260045260246000fd5b6000600182016107d057634e487b7160e01b600052601160045260246000fd5b506001019056fea2646970667358221220097aa1cb4975bfd73806bbd7476fa3cf59ce484664c95604b5904221b6a1a69464736f6c63430008150033",
+}
+
+// MerkleDistributorABI is the input ABI used to generate the binding from.
+// Deprecated: Use MerkleDistributorMetaData.ABI instead.
+var MerkleDistributorABI = MerkleDistributorMetaData.ABI
+
+// MerkleDistributorBin is the compiled bytecode used for deploying new contracts.
+// Deprecated: Use MerkleDistributorMetaData.Bin instead.
+var MerkleDistributorBin = MerkleDistributorMetaData.Bin
+
+// DeployMerkleDistributor deploys a new Ethereum contract, binding an instance of MerkleDistributor to it.
+func DeployMerkleDistributor(auth *bind.TransactOpts, backend bind.ContractBackend, _token common.Address, _merkleRoot [32]byte) (common.Address, *types.Transaction, *MerkleDistributor, error) {
+	parsed, err := MerkleDistributorMetaData.GetAbi()
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	if parsed == nil {
+		return common.Address{}, nil, nil, errors.New("GetABI returned nil")
+	}
+
+	address, tx, contract, err := bind.DeployContract(auth, *parsed, common.FromHex(MerkleDistributorBin), backend, _token, _merkleRoot)
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	return address, tx, &MerkleDistributor{MerkleDistributorCaller: MerkleDistributorCaller{contract: contract}, MerkleDistributorTransactor: MerkleDistributorTransactor{contract: contract}, MerkleDistributorFilterer: MerkleDistributorFilterer{contract: contract}}, nil
+}
+
+// MerkleDistributor is an auto generated Go binding around an Ethereum contract.
+type MerkleDistributor struct {
+	MerkleDistributorCaller     // Read-only binding to the contract
+	MerkleDistributorTransactor // Write-only binding to the contract
+	MerkleDistributorFilterer   // Log filterer for contract events
+}
+
+// MerkleDistributorCaller is an auto generated read-only Go binding around an Ethereum contract.
+type MerkleDistributorCaller struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// MerkleDistributorTransactor is an auto generated write-only Go binding around an Ethereum contract.
+type MerkleDistributorTransactor struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// MerkleDistributorFilterer is an auto generated log filtering Go binding around an Ethereum contract events.
+type MerkleDistributorFilterer struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// MerkleDistributorSession is an auto generated Go binding around an Ethereum contract,
+// with pre-set call and transact options.
+type MerkleDistributorSession struct {
+	Contract     *MerkleDistributor // Generic contract binding to set the session for
+	CallOpts     bind.CallOpts      // Call options to use throughout this session
+	TransactOpts bind.TransactOpts  // Transaction auth options to use throughout this session
+}
+
+// MerkleDistributorCallerSession is an auto generated read-only Go binding around an Ethereum contract,
+// with pre-set call options.
+type MerkleDistributorCallerSession struct {
+	Contract *MerkleDistributorCaller // Generic contract caller binding to set the session for
+	CallOpts bind.CallOpts            // Call options to use throughout this session
+}
+
+// MerkleDistributorTransactorSession is an auto generated write-only Go binding around an Ethereum contract,
+// with pre-set transact options.
+type MerkleDistributorTransactorSession struct {
+	Contract     *MerkleDistributorTransactor // Generic contract transactor binding to set the session for
+	TransactOpts bind.TransactOpts            // Transaction auth options to use throughout this session
+}
+
+// MerkleDistributorRaw is an auto generated low-level Go binding around an Ethereum contract.
+type MerkleDistributorRaw struct {
+	Contract *MerkleDistributor // Generic contract binding to access the raw methods on
+}
+
+// MerkleDistributorCallerRaw is an auto generated low-level read-only Go binding around an Ethereum contract.
+type MerkleDistributorCallerRaw struct {
+	Contract *MerkleDistributorCaller // Generic read-only contract binding to access the raw methods on
+}
+
+// MerkleDistributorTransactorRaw is an auto generated low-level write-only Go binding around an Ethereum contract.
+type MerkleDistributorTransactorRaw struct {
+	Contract *MerkleDistributorTransactor // Generic write-only contract binding to access the raw methods on
+}
+
+// NewMerkleDistributor creates a new instance of MerkleDistributor, bound to a specific deployed contract.
+func NewMerkleDistributor(address common.Address, backend bind.ContractBackend) (*MerkleDistributor, error) {
+	contract, err := bindMerkleDistributor(address, backend, backend, backend)
+	if err != nil {
+		return nil, err
+	}
+	return &MerkleDistributor{MerkleDistributorCaller: MerkleDistributorCaller{contract: contract}, MerkleDistributorTransactor: MerkleDistributorTransactor{contract: contract}, MerkleDistributorFilterer: MerkleDistributorFilterer{contract: contract}}, nil
+}
+
+// NewMerkleDistributorCaller creates a new read-only instance of MerkleDistributor, bound to a specific deployed contract.
+func NewMerkleDistributorCaller(address common.Address, caller bind.ContractCaller) (*MerkleDistributorCaller, error) {
+	contract, err := bindMerkleDistributor(address, caller, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &MerkleDistributorCaller{contract: contract}, nil
+}
+
+// NewMerkleDistributorTransactor creates a new write-only instance of MerkleDistributor, bound to a specific deployed contract.
+func NewMerkleDistributorTransactor(address common.Address, transactor bind.ContractTransactor) (*MerkleDistributorTransactor, error) {
+	contract, err := bindMerkleDistributor(address, nil, transactor, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &MerkleDistributorTransactor{contract: contract}, nil
+}
+
+// NewMerkleDistributorFilterer creates a new log filterer instance of MerkleDistributor, bound to a specific deployed contract.
+func NewMerkleDistributorFilterer(address common.Address, filterer bind.ContractFilterer) (*MerkleDistributorFilterer, error) {
+	contract, err := bindMerkleDistributor(address, nil, nil, filterer)
+	if err != nil {
+		return nil, err
+	}
+	return &MerkleDistributorFilterer{contract: contract}, nil
+}
+
+// bindMerkleDistributor binds a generic wrapper to an already deployed contract.
+func bindMerkleDistributor(address common.Address, caller bind.ContractCaller, transactor bind.ContractTransactor, filterer bind.ContractFilterer) (*bind.BoundContract, error) {
+	parsed, err := abi.JSON(strings.NewReader(MerkleDistributorABI))
+	if err != nil {
+		return nil, err
+	}
+	return bind.NewBoundContract(address, parsed, caller, transactor, filterer), nil
+}
+
+// Call invokes the (constant) contract method with params as input values and
+// sets the output to result. The result type might be a single field for simple
+// returns, a slice of interfaces for anonymous returns and a struct for named
+// returns.
+func (_MerkleDistributor *MerkleDistributorRaw) Call(opts *bind.CallOpts, result *[]interface{}, method string, params ...interface{}) error {
+	return _MerkleDistributor.Contract.MerkleDistributorCaller.contract.Call(opts, result, method, params...)
+}
+
+// Transfer initiates a plain transaction to move funds to the contract, calling
+// its default method if one is available.
+func (_MerkleDistributor *MerkleDistributorRaw) Transfer(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return _MerkleDistributor.Contract.MerkleDistributorTransactor.contract.Transfer(opts)
+}
+
+// Transact invokes the (paid) contract method with params as input values.
+func (_MerkleDistributor *MerkleDistributorRaw) Transact(opts *bind.TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
+	return _MerkleDistributor.Contract.MerkleDistributorTransactor.contract.Transact(opts, method, params...)
+}
+
+// Call invokes the (constant) contract method with params as input values and
+// sets the output to result. The result type might be a single field for simple
+// returns, a slice of interfaces for anonymous returns and a struct for named
+// returns.
+func (_MerkleDistributor *MerkleDistributorCallerRaw) Call(opts *bind.CallOpts, result *[]interface{}, method string, params ...interface{}) error {
+	return _MerkleDistributor.Contract.contract.Call(opts, result, method, params...)
+}
+
+// Transfer initiates a plain transaction to move funds to the contract, calling
+// its default method if one is available.
+func (_MerkleDistributor *MerkleDistributorTransactorRaw) Transfer(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return _MerkleDistributor.Contract.contract.Transfer(opts)
+}
+
+// Transact invokes the (paid) contract method with params as input values.
+func (_MerkleDistributor *MerkleDistributorTransactorRaw) Transact(opts *bind.TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
+	return _MerkleDistributor.Contract.contract.Transact(opts, method, params...)
+}
+
+// IsClaimed is a free data retrieval call binding the contract method 0x8cc08025.
+//
+// Solidity: function isClaimed(address ) view returns(bool)
+func (_MerkleDistributor *MerkleDistributorCaller) IsClaimed(opts *bind.CallOpts, arg0 common.Address) (bool, error) {
+	var out []interface{}
+	err := _MerkleDistributor.contract.Call(opts, &out, "isClaimed", arg0)
+
+	if err != nil {
+		return *new(bool), err
+	}
+
+	out0 := *abi.ConvertType(out[0], new(bool)).(*bool)
+
+	return out0, err
+
+}
+
+// IsClaimed is a free data retrieval call binding the contract method 0x8cc08025.
+//
+// Solidity: function isClaimed(address ) view returns(bool)
+func (_MerkleDistributor *MerkleDistributorSession) IsClaimed(arg0 common.Address) (bool, error) {
+	return _MerkleDistributor.Contract.IsClaimed(&_MerkleDistributor.CallOpts, arg0)
+}
+
+// IsClaimed is a free data retrieval call binding the contract method 0x8cc08025.
+//
+// Solidity: function isClaimed(address ) view returns(bool)
+func (_MerkleDistributor *MerkleDistributorCallerSession) IsClaimed(arg0 common.Address) (bool, error) {
+	return _MerkleDistributor.Contract.IsClaimed(&_MerkleDistributor.CallOpts, arg0)
+}
+
+// MerkleRoot is a free data retrieval call binding the contract method 0x2eb4a7ab.
+//
+// Solidity: function merkleRoot() view returns(bytes32)
+func (_MerkleDistributor *MerkleDistributorCaller) MerkleRoot(opts *bind.CallOpts) ([32]byte, error) {
+	var out []interface{}
+	err := _MerkleDistributor.contract.Call(opts, &out, "merkleRoot")
+
+	if err != nil {
+		return *new([32]byte), err
+	}
+
+	out0 := *abi.ConvertType(out[0], new([32]byte)).(*[32]byte)
+
+	return out0, err
+
+}
+
+// MerkleRoot is a free data retrieval call binding the contract method 0x2eb4a7ab.
+//
+// Solidity: function merkleRoot() view returns(bytes32)
+func (_MerkleDistributor *MerkleDistributorSession) MerkleRoot() ([32]byte, error) {
+	return _MerkleDistributor.Contract.MerkleRoot(&_MerkleDistributor.CallOpts)
+}
+
+// MerkleRoot is a free data retrieval call binding the contract method 0x2eb4a7ab.
+//
+// Solidity: function merkleRoot() view returns(bytes32)
+func (_MerkleDistributor *MerkleDistributorCallerSession) MerkleRoot() ([32]byte, error) {
+	return _MerkleDistributor.Contract.MerkleRoot(&_MerkleDistributor.CallOpts)
+}
+
+// Owner is a free data retrieval call binding the contract method 0x8da5cb5b.
+//
+// Solidity: function owner() view returns(address)
+func (_MerkleDistributor *MerkleDistributorCaller) Owner(opts *bind.CallOpts) (common.Address, error) {
+	var out []interface{}
+	err := _MerkleDistributor.contract.Call(opts, &out, "owner")
+
+	if err != nil {
+		return *new(common.Address), err
+	}
+
+	out0 := *abi.ConvertType(out[0], new(common.Address)).(*common.Address)
+
+	return out0, err
+
+}
+
+// Owner is a free data retrieval call binding the contract method 0x8da5cb5b.
+//
+// Solidity: function owner() view returns(address)
+func (_MerkleDistributor *MerkleDistributorSession) Owner() (common.Address, error) {
+	return _MerkleDistributor.Contract.Owner(&_MerkleDistributor.CallOpts)
+}
+
+// Owner is a free data retrieval call binding the contract method 0x8da5cb5b.
+//
+// Solidity: function owner() view returns(address)
+func (_MerkleDistributor *MerkleDistributorCallerSession) Owner() (common.Address, error) {
+	return _MerkleDistributor.Contract.Owner(&_MerkleDistributor.CallOpts)
+}
+
+// Token is a free data retrieval call binding the contract method 0xfc0c546a.
+//
+// Solidity: function token() view returns(address)
+func (_MerkleDistributor *MerkleDistributorCaller) Token(opts *bind.CallOpts) (common.Address, error) {
+	var out []interface{}
+	err := _MerkleDistributor.contract.Call(opts, &out, "token")
+
+	if err != nil {
+		return *new(common.Address), err
+	}
+
+	out0 := *abi.ConvertType(out[0], new(common.Address)).(*common.Address)
+
+	return out0, err
+
+}
+
+// Token is a free data retrieval call binding the contract method 0xfc0c546a.
+//
+// Solidity: function token() view returns(address)
+func (_MerkleDistributor *MerkleDistributorSession) Token() (common.Address, error) {
+	return _MerkleDistributor.Contract.Token(&_MerkleDistributor.CallOpts)
+}
+
+// Token is a free data retrieval call binding the contract method 0xfc0c546a.
+//
+// Solidity: function token() view returns(address)
+func (_MerkleDistributor *MerkleDistributorCallerSession) Token() (common.Address, error) {
+	return _MerkleDistributor.Contract.Token(&_MerkleDistributor.CallOpts)
+}
+
+// Claim is a paid mutator transaction binding the contract method 0x3d13f874.
+//
+// Solidity: function claim(address _account, uint256 _amount, bytes32[] _merkleProof) returns()
+func (_MerkleDistributor *MerkleDistributorTransactor) Claim(opts *bind.TransactOpts, _account common.Address, _amount *big.Int, _merkleProof [][32]byte) (*types.Transaction, error) {
+	return _MerkleDistributor.contract.Transact(opts, "claim", _account, _amount, _merkleProof)
+}
+
+// Claim is a paid mutator transaction binding the contract method 0x3d13f874.
+//
+// Solidity: function claim(address _account, uint256 _amount, bytes32[] _merkleProof) returns()
+func (_MerkleDistributor *MerkleDistributorSession) Claim(_account common.Address, _amount *big.Int, _merkleProof [][32]byte) (*types.Transaction, error) {
+	return _MerkleDistributor.Contract.Claim(&_MerkleDistributor.TransactOpts, _account, _amount, _merkleProof)
+}
+
+// Claim is a paid mutator transaction binding the contract method 0x3d13f874.
+//
+// Solidity: function claim(address _account, uint256 _amount, bytes32[] _merkleProof) returns()
+func (_MerkleDistributor *MerkleDistributorTransactorSession) Claim(_account common.Address, _amount *big.Int, _merkleProof [][32]byte) (*types.Transaction, error) {
+	return _MerkleDistributor.Contract.Claim(&_MerkleDistributor.TransactOpts, _account, _amount, _merkleProof)
+}
+
+// Withdraw is a paid mutator transaction binding the contract method 0x51cff8d9.
+//
+// Solidity: function withdraw(address _to) returns()
+func (_MerkleDistributor *MerkleDistributorTransactor) Withdraw(opts *bind.TransactOpts, _to common.Address) (*types.Transaction, error) {
+	return _MerkleDistributor.contract.Transact(opts, "withdraw", _to)
+}
+
+// Withdraw is a paid mutator transaction binding the contract method 0x51cff8d9.
+//
+// Solidity: function withdraw(address _to) returns()
+func (_MerkleDistributor *MerkleDistributorSession) Withdraw(_to common.Address) (*types.Transaction, error) {
+	return _MerkleDistributor.Contract.Withdraw(&_MerkleDistributor.TransactOpts, _to)
+}
+
+// Withdraw is a paid mutator transaction binding the contract method 0x51cff8d9.
+//
+// Solidity: function withdraw(address _to) returns()
+func (_MerkleDistributor *MerkleDistributorTransactorSession) Withdraw(_to common.Address) (*types.Transaction, error) {
+	return _MerkleDistributor.Contract.Withdraw(&_MerkleDistributor.TransactOpts, _to)
+}
+
+// MerkleDistributorClaimedIterator is returned from FilterClaimed and is used to iterate over the raw logs and unpacked data for Claimed events raised by the MerkleDistributor contract.
+type MerkleDistributorClaimedIterator struct {
+	Event *MerkleDistributorClaimed // Event containing the contract specifics and raw log
+
+	contract *bind.BoundContract // Generic contract to use for unpacking event data
+	event    string              // Event name to use for unpacking event data
+
+	logs chan types.Log        // Log channel receiving the found contract events
+	sub  ethereum.Subscription // Subscription for errors, completion and termination
+	done bool                  // Whether the subscription completed delivering logs
+	fail error                 // Occurred error to stop iteration
+}
+
+// Next advances the iterator to the subsequent event, returning whether there
+// are any more events found. In case of a retrieval or parsing error, false is
+// returned and Error() can be queried for the exact failure.
+func (it *MerkleDistributorClaimedIterator) Next() bool {
+	// If the iterator failed, stop iterating
+	if it.fail != nil {
+		return false
+	}
+	// If the iterator completed, deliver directly whatever's available
+	if it.done {
+		select {
+		case log := <-it.logs:
+			it.Event = new(MerkleDistributorClaimed)
+			if err := it.contract.UnpackLog(it.Event, it.event, log); err != nil {
+				it.fail = err
+				return false
+			}
+			it.Event.Raw = log
+			return true
+
+		default:
+			return false
+		}
+	}
+	// Iterator still in progress, wait for either a data or an error event
+	select {
+	case log := <-it.logs:
+		it.Event = new(MerkleDistributorClaimed)
+		if err := it.contract.UnpackLog(it.Event, it.event, log); err != nil {
+			it.fail = err
+			return false
+		}
+		it.Event.Raw = log
+		return true
+
+	case err := <-it.sub.Err():
+		it.done = true
+		it.fail = err
+		return it.Next()
+	}
+}
+
+// Error returns any retrieval or parsing error occurred during filtering.
+func (it *MerkleDistributorClaimedIterator) Error() error {
+	return it.fail
+}
+
+// Close terminates the iteration process, releasing any pending underlying
+// resources.
+func (it *MerkleDistributorClaimedIterator) Close() error {
+	it.sub.Unsubscribe()
+	return nil
+}
+
+// MerkleDistributorClaimed represents a Claimed event raised by the MerkleDistributor contract.
+type MerkleDistributorClaimed struct {
+	Account common.Address
+	Amount  *big.Int
+	Raw     types.Log // Blockchain specific contextual infos
+}
+
+// FilterClaimed is a free log retrieval operation binding the contract event 0xd8138f8a3f377c5259ca548e70e4c2de94f129f5a11036a15b69513cba2b426a.
+//
+// Solidity: event Claimed(address indexed account, uint256 amount)
+func (_MerkleDistributor *MerkleDistributorFilterer) FilterClaimed(opts *bind.FilterOpts, account []common.Address) (*MerkleDistributorClaimedIterator, error) {
+
+	var accountRule []interface{}
+	for _, accountItem := range account {
+		accountRule = append(accountRule, accountItem)
+	}
+
+	logs, sub, err := _MerkleDistributor.contract.FilterLogs(opts, "Claimed", accountRule)
+	if err != nil {
+		return nil, err
+	}
+	return &MerkleDistributorClaimedIterator{contract: _MerkleDistributor.contract, event: "Claimed", logs: logs, sub: sub}, nil
+}
+
+// WatchClaimed is a free log subscription operation binding the contract event 0xd8138f8a3f377c5259ca548e70e4c2de94f129f5a11036a15b69513cba2b426a.
+//
+// Solidity: event Claimed(address indexed account, uint256 amount)
+func (_MerkleDistributor *MerkleDistributorFilterer) WatchClaimed(opts *bind.WatchOpts, sink chan<- *MerkleDistributorClaimed, account []common.Address) (event.Subscription, error) {
+
+	var accountRule []interface{}
+	for _, accountItem := range account {
+		accountRule = append(accountRule, accountItem)
+	}
+
+	logs, sub, err := _MerkleDistributor.contract.WatchLogs(opts, "Claimed", accountRule)
+	if err != nil {
+		return nil, err
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case log := <-logs:
+				// New log arrived, parse the event and forward to the user
+				event := new(MerkleDistributorClaimed)
+				if err := _MerkleDistributor.contract.UnpackLog(event, "Claimed", log); err != nil {
+					return err
+				}
+				event.Raw = log
+
+				select {
+				case sink <- event:
+				case err := <-sub.Err():
+					return err
+				case <-quit:
+					return nil
+				}
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	}), nil
+}
+
+// ParseClaimed is a log parse operation binding the contract event 0xd8138f8a3f377c5259ca548e70e4c2de94f129f5a11036a15b69513cba2b426a.
+//
+// Solidity: event Claimed(address indexed account, uint256 amount)
+func (_MerkleDistributor *MerkleDistributorFilterer) ParseClaimed(log types.Log) (*MerkleDistributorClaimed, error) {
+	event := new(MerkleDistributorClaimed)
+	if err := _MerkleDistributor.contract.UnpackLog(event, "Claimed", log); err != nil {
+		return nil, err
+	}
+	event.Raw = log
+	return event, nil
+}
+
+// MerkleDistributorWithdrawnIterator is returned from FilterWithdrawn and is used to iterate over the raw logs and unpacked data for Withdrawn events raised by the MerkleDistributor contract.
+type MerkleDistributorWithdrawnIterator struct {
+	Event *MerkleDistributorWithdrawn // Event containing the contract specifics and raw log
+
+	contract *bind.BoundContract // Generic contract to use for unpacking event data
+	event    string              // Event name to use for unpacking event data
+
+	logs chan types.Log        // Log channel receiving the found contract events
+	sub  ethereum.Subscription // Subscription for errors, completion and termination
+	done bool                  // Whether the subscription completed delivering logs
+	fail error                 // Occurred error to stop iteration
+}
+
+// Next advances the iterator to the subsequent event, returning whether there
+// are any more events found. In case of a retrieval or parsing error, false is
+// returned and Error() can be queried for the exact failure.
+func (it *MerkleDistributorWithdrawnIterator) Next() bool {
+	// If the iterator failed, stop iterating
+	if it.fail != nil {
+		return false
+	}
+	// If the iterator completed, deliver directly whatever's available
+	if it.done {
+		select {
+		case log := <-it.logs:
+			it.Event = new(MerkleDistributorWithdrawn)
+			if err := it.contract.UnpackLog(it.Event, it.event, log); err != nil {
+				it.fail = err
+				return false
+			}
+			it.Event.Raw = log
+			return true
+
+		default:
+			return false
+		}
+	}
+	// Iterator still in progress, wait for either a data or an error event
+	select {
+	case log := <-it.logs:
+		it.Event = new(MerkleDistributorWithdrawn)
+		if err := it.contract.UnpackLog(it.Event, it.event, log); err != nil {
+			it.fail = err
+			return false
+		}
+		it.Event.Raw = log
+		return true
+
+	case err := <-it.sub.Err():
+		it.done = true
+		it.fail = err
+		return it.Next()
+	}
+}
+
+// Error returns any retrieval or parsing error occurred during filtering.
+func (it *MerkleDistributorWithdrawnIterator) Error() error {
+	return it.fail
+}
+
+// Close terminates the iteration process, releasing any pending underlying
+// resources.
+func (it *MerkleDistributorWithdrawnIterator) Close() error {
+	it.sub.Unsubscribe()
+	return nil
+}
+
+// MerkleDistributorWithdrawn represents a Withdrawn event raised by the MerkleDistributor contract.
+type MerkleDistributorWithdrawn struct {
+	To     common.Address
+	Amount *big.Int
+	Raw    types.Log // Blockchain specific contextual infos
+}
+
+// FilterWithdrawn is a free log retrieval operation binding the contract event 0x7084f5476618d8e60b11ef0d7d3f06914655adb8793e28ff7f018d4c76d505d5.
+//
+// Solidity: event Withdrawn(address indexed to, uint256 amount)
+func (_MerkleDistributor *MerkleDistributorFilterer) FilterWithdrawn(opts *bind.FilterOpts, to []common.Address) (*MerkleDistributorWithdrawnIterator, error) {
+
+	var toRule []interface{}
+	for _, toItem := range to {
+		toRule = append(toRule, toItem)
+	}
+
+	logs, sub, err := _MerkleDistributor.contract.FilterLogs(opts, "Withdrawn", toRule)
+	if err != nil {
+		return nil, err
+	}
+	return &MerkleDistributorWithdrawnIterator{contract: _MerkleDistributor.contract, event: "Withdrawn", logs: logs, sub: sub}, nil
+}
+
+// WatchWithdrawn is a free log subscription operation binding the contract event 0x7084f5476618d8e60b11ef0d7d3f06914655adb8793e28ff7f018d4c76d505d5.
+//
+// Solidity: event Withdrawn(address indexed to, uint256 amount)
+func (_MerkleDistributor *MerkleDistributorFilterer) WatchWithdrawn(opts *bind.WatchOpts, sink chan<- *MerkleDistributorWithdrawn, to []common.Address) (event.Subscription, error) {
+
+	var toRule []interface{}
+	for _, toItem := range to {
+		toRule = append(toRule, toItem)
+	}
+
+	logs, sub, err := _MerkleDistributor.contract.WatchLogs(opts, "Withdrawn", toRule)
+	if err != nil {
+		return nil, err
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case log := <-logs:
+				// New log arrived, parse the event and forward to the user
+				event := new(MerkleDistributorWithdrawn)
+				if err := _MerkleDistributor.contract.UnpackLog(event, "Withdrawn", log); err != nil {
+					return err
+				}
+				event.Raw = log
+
+				select {
+				case sink <- event:
+				case err := <-sub.Err():
+					return err
+				case <-quit:
+					return nil
+				}
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	}), nil
+}
+
+// ParseWithdrawn is a log parse operation binding the contract event 0x7084f5476618d8e60b11ef0d7d3f06914655adb8793e28ff7f018d4c76d505d5.
+//
+// Solidity: event Withdrawn(address indexed to, uint256 amount)
+func (_MerkleDistributor *MerkleDistributorFilterer) ParseWithdrawn(log types.Log) (*MerkleDistributorWithdrawn, error) {
+	event := new(MerkleDistributorWithdrawn)
+	if err := _MerkleDistributor.contract.UnpackLog(event, "Withdrawn", log); err != nil {
+		return nil, err
+	}
+	event.Raw = log
+	return event, nil
+}
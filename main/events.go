@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// event types of TokenEvent
+const (
+	EventTransfer = "Transfer"
+	EventApproval = "Approval"
+)
+
+// TokenEvent is a decoded EIP20 event, as handed over to the consumers.
+type TokenEvent struct {
+	Type        string         `json:"type"`
+	ChainID     uint64         `json:"chainId"`
+	Contract    common.Address `json:"contract"`
+	BlockNumber uint64         `json:"blockNumber"`
+	BlockHash   common.Hash    `json:"blockHash"`
+	TxHash      common.Hash    `json:"txHash"`
+	LogIndex    uint           `json:"logIndex"`
+	// From and To are set for a Transfer
+	From *common.Address `json:"from,omitempty"`
+	To   *common.Address `json:"to,omitempty"`
+	// Owner and Spender are set for an Approval
+	Owner   *common.Address `json:"owner,omitempty"`
+	Spender *common.Address `json:"spender,omitempty"`
+	// Value is the decimal amount
+	Value string `json:"value"`
+	// Confirmations are the blocks on top of the event's block, when known
+	Confirmations uint64 `json:"confirmations,omitempty"`
+	// Removed is set when a reorg dropped the event
+	Removed bool `json:"removed,omitempty"`
+}
+
+func newTokenEvent(typ string, chainID *big.Int, raw types.Log, value *big.Int) *TokenEvent {
+	e := &TokenEvent{
+		Type:        typ,
+		Contract:    raw.Address,
+		BlockNumber: raw.BlockNumber,
+		BlockHash:   raw.BlockHash,
+		TxHash:      raw.TxHash,
+		LogIndex:    raw.Index,
+		Value:       value.String(),
+		Removed:     raw.Removed,
+	}
+	if chainID != nil {
+		e.ChainID = chainID.Uint64()
+	}
+	return e
+}
+
+func transferEvent(chainID *big.Int, e *EIP20Transfer) *TokenEvent {
+	ev := newTokenEvent(EventTransfer, chainID, e.Raw, e.Value)
+	ev.From, ev.To = &e.From, &e.To
+	return ev
+}
+
+func approvalEvent(chainID *big.Int, e *EIP20Approval) *TokenEvent {
+	ev := newTokenEvent(EventApproval, chainID, e.Raw, e.Value)
+	ev.Owner, ev.Spender = &e.Owner, &e.Spender
+	return ev
+}
+
+// Involves tells if addr sends, receives, owns or spends the tokens of the event.
+func (e *TokenEvent) Involves(addr common.Address) bool {
+	for _, a := range []*common.Address{e.From, e.To, e.Owner, e.Spender} {
+		if a != nil && *a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenEvents feeds every delivered event to the consumers, like the webhooks.
+// Subscribers must keep reading their channel, the delivery waits for them.
+var tokenEvents event.Feed
+
+// SubscribeTokenEvents sends the delivered events to ch.
+func SubscribeTokenEvents(ch chan<- *TokenEvent) event.Subscription {
+	return tokenEvents.Subscribe(ch)
+}
+
+// deliverTransfer hands a transfer event over, in a span linked to the
+// request which sent its tx.
+func deliverTransfer(ctx context.Context, l log.Logger, chainID *big.Int, e *EIP20Transfer) {
+	_, span := traceEvent(ctx, "EIP20Transfer", e.Raw)
+	defer span.End()
+	l.Info("Got ERC20 transfer event", "block", e.Raw.BlockNumber, "txHash", e.Raw.TxHash, "logIndex", e.Raw.Index,
+		"from", e.From, "to", e.To, "value", e.Value)
+
+	tokenEvents.Send(transferEvent(chainID, e))
+}
+
+// deliverApproval hands an approval event over, in a span linked to the
+// request which sent its tx.
+func deliverApproval(ctx context.Context, l log.Logger, chainID *big.Int, e *EIP20Approval) {
+	_, span := traceEvent(ctx, "EIP20Approval", e.Raw)
+	defer span.End()
+	l.Info("Got ERC20 approval event", "block", e.Raw.BlockNumber, "txHash", e.Raw.TxHash, "logIndex", e.Raw.Index,
+		"owner", e.Owner, "spender", e.Spender, "value", e.Value)
+
+	tokenEvents.Send(approvalEvent(chainID, e))
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/gorilla/websocket"
+)
+
+type faultKind int
+
+const (
+	faultLatency    faultKind = iota // delay the call
+	faultDrop                        // forward the call, but drop the response
+	faultError                       // answer with a JSON-RPC error, without forwarding
+	faultStaleHead                   // report a head Lag blocks behind, hold back newHeads notifications
+	faultReorg                       // run the proxy's reorg hook before forwarding
+	faultDisconnect                  // close the websocket connection
+)
+
+// faultStep is one entry of a scenario. It applies to the calls, or websocket
+// notifications, of Method ("" matches all), skipping the first After of them
+// and affecting the next Times (0 means all the rest).
+type faultStep struct {
+	Kind   faultKind
+	Method string
+	After  int
+	Times  int
+	Delay  time.Duration // for faultLatency
+	Lag    uint64        // for faultStaleHead
+
+	seen int
+}
+
+func (s *faultStep) match(method string) bool {
+	if s.Method != "" && s.Method != method {
+		return false
+	}
+	s.seen++
+	if s.seen <= s.After {
+		return false
+	}
+	return s.Times == 0 || s.seen <= s.After+s.Times
+}
+
+type rpcMessage struct {
+	Version string          `json:"jsonrpc,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// faultProxy is a JSON-RPC proxy between ethclient and a node, which injects
+// faults following a scripted scenario. It serves both http and websocket.
+type faultProxy struct {
+	t        *testing.T
+	upstream string // http url of the node
+	srv      *httptest.Server
+	// reorg is run on a faultReorg step
+	reorg func()
+
+	mu       sync.Mutex
+	scenario []*faultStep
+	calls    map[string]int
+	conns    []*websocket.Conn
+}
+
+func newFaultProxy(t *testing.T, upstream string, scenario ...*faultStep) *faultProxy {
+	p := &faultProxy{t: t, upstream: upstream, scenario: scenario, calls: make(map[string]int)}
+	p.srv = httptest.NewServer(http.HandlerFunc(p.serve))
+	t.Cleanup(p.srv.Close)
+	return p
+}
+
+// URL returns the http url of the proxy.
+func (p *faultProxy) URL() string { return p.srv.URL }
+
+// WSURL returns the websocket url of the proxy.
+func (p *faultProxy) WSURL() string { return "ws" + strings.TrimPrefix(p.srv.URL, "http") }
+
+// Script appends steps to the scenario.
+func (p *faultProxy) Script(steps ...*faultStep) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.scenario = append(p.scenario, steps...)
+}
+
+// Calls returns how many times method was called through the proxy.
+func (p *faultProxy) Calls(method string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls[method]
+}
+
+// fault returns the first step of the scenario that applies to method.
+func (p *faultProxy) fault(method string, count bool) *faultStep {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if count {
+		p.calls[method]++
+	}
+	for _, s := range p.scenario {
+		if s.match(method) {
+			return s
+		}
+	}
+	return nil
+}
+
+func (p *faultProxy) serve(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		p.serveWS(w, r)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var msg rpcMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		// batches are forwarded as they are
+		p.forwardHTTP(w, body)
+		return
+	}
+
+	step := p.fault(msg.Method, true)
+	if step == nil {
+		p.forwardHTTP(w, body)
+		return
+	}
+	switch step.Kind {
+	case faultLatency:
+		time.Sleep(step.Delay)
+		p.forwardHTTP(w, body)
+	case faultDrop:
+		p.roundTrip(body)
+		p.hangUp(w)
+	case faultDisconnect:
+		p.hangUp(w)
+	case faultError:
+		writeJSON(w, p.errorResponse(msg))
+	case faultStaleHead:
+		resp, err := p.staleHead(msg, step.Lag, p.roundTrip)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, resp)
+	case faultReorg:
+		if p.reorg != nil {
+			p.reorg()
+		}
+		p.forwardHTTP(w, body)
+	}
+}
+
+func (p *faultProxy) forwardHTTP(w http.ResponseWriter, body []byte) {
+	resp, err := p.roundTrip(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+func (p *faultProxy) roundTrip(body []byte) ([]byte, error) {
+	resp, err := http.Post(p.upstream, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// hangUp closes the connection without a response.
+func (p *faultProxy) hangUp(w http.ResponseWriter) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		p.t.Errorf("hijack: %v", err)
+		return
+	}
+	conn.Close()
+}
+
+func (p *faultProxy) errorResponse(msg rpcMessage) rpcMessage {
+	return rpcMessage{Version: "2.0", ID: msg.ID, Error: &rpcError{Code: -32000, Message: "injected fault"}}
+}
+
+// staleHead answers the head queries as if the node were lag blocks behind.
+func (p *faultProxy) staleHead(msg rpcMessage, lag uint64, call func([]byte) ([]byte, error)) (rpcMessage, error) {
+	head, err := p.upstreamHead(call)
+	if err != nil {
+		return rpcMessage{}, err
+	}
+	stale := hexutil.Uint64(0)
+	if head > lag {
+		stale = hexutil.Uint64(head - lag)
+	}
+	switch msg.Method {
+	case "eth_blockNumber":
+		result, _ := json.Marshal(stale)
+		return rpcMessage{Version: "2.0", ID: msg.ID, Result: result}, nil
+	case "eth_getBlockByNumber":
+		var params []json.RawMessage
+		if err := json.Unmarshal(msg.Params, &params); err == nil && len(params) > 0 && string(params[0]) == `"latest"` {
+			params[0], _ = json.Marshal(stale)
+			msg.Params, _ = json.Marshal(params)
+		}
+	}
+	body, _ := json.Marshal(msg)
+	resp, err := call(body)
+	if err != nil {
+		return rpcMessage{}, err
+	}
+	var out rpcMessage
+	err = json.Unmarshal(resp, &out)
+	return out, err
+}
+
+func (p *faultProxy) upstreamHead(call func([]byte) ([]byte, error)) (uint64, error) {
+	resp, err := call([]byte(`{"jsonrpc":"2.0","id":0,"method":"eth_blockNumber","params":[]}`))
+	if err != nil {
+		return 0, err
+	}
+	var msg rpcMessage
+	if err := json.Unmarshal(resp, &msg); err != nil {
+		return 0, err
+	}
+	var head hexutil.Uint64
+	err = json.Unmarshal(msg.Result, &head)
+	return uint64(head), err
+}
+
+var proxyUpgrader = websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+func (p *faultProxy) serveWS(w http.ResponseWriter, r *http.Request) {
+	up, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(p.upstream, "http"), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	down, err := proxyUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		up.Close()
+		return
+	}
+	p.mu.Lock()
+	p.conns = append(p.conns, down)
+	p.mu.Unlock()
+
+	session := &wsSession{proxy: p, up: up, down: down, pending: make(map[string]chan rpcMessage)}
+	go session.pumpUp()
+	session.pumpDown()
+}
+
+// Disconnect closes every websocket connection of the proxy.
+func (p *faultProxy) Disconnect() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.conns {
+		c.Close()
+	}
+	p.conns = nil
+}
+
+// wsSession relays one websocket connection between the client (down) and the node (up).
+type wsSession struct {
+	proxy    *faultProxy
+	up, down *websocket.Conn
+
+	downMu sync.Mutex // serializes the writes to the client
+	upMu   sync.Mutex // serializes the writes to the node
+	mu     sync.Mutex
+	// responses to the calls the proxy itself makes upstream, by id
+	pending map[string]chan rpcMessage
+	nextID  int
+}
+
+func (s *wsSession) close() {
+	s.up.Close()
+	s.down.Close()
+}
+
+func (s *wsSession) writeDown(msg interface{}) {
+	s.downMu.Lock()
+	defer s.downMu.Unlock()
+	s.down.WriteJSON(msg)
+}
+
+func (s *wsSession) writeUp(data []byte) error {
+	s.upMu.Lock()
+	defer s.upMu.Unlock()
+	return s.up.WriteMessage(websocket.TextMessage, data)
+}
+
+// call sends a request of the proxy upstream and waits for its response.
+func (s *wsSession) call(body []byte) ([]byte, error) {
+	var msg rpcMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.nextID++
+	id := fmt.Sprintf(`"proxy-%d"`, s.nextID)
+	ch := make(chan rpcMessage, 1)
+	s.pending[id] = ch
+	s.mu.Unlock()
+
+	msg.ID = json.RawMessage(id)
+	data, _ := json.Marshal(msg)
+	if err := s.writeUp(data); err != nil {
+		return nil, err
+	}
+	select {
+	case resp := <-ch:
+		return json.Marshal(resp)
+	case <-time.After(5 * time.Second):
+		return nil, io.ErrUnexpectedEOF
+	}
+}
+
+// pumpDown reads the client's calls and forwards them upstream.
+func (s *wsSession) pumpDown() {
+	defer s.close()
+	for {
+		_, data, err := s.down.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg rpcMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.writeUp(data)
+			continue
+		}
+		step := s.proxy.fault(msg.Method, true)
+		if step == nil {
+			s.writeUp(data)
+			continue
+		}
+		switch step.Kind {
+		case faultLatency:
+			go func() {
+				time.Sleep(step.Delay)
+				s.writeUp(data)
+			}()
+		case faultDrop:
+			s.mu.Lock()
+			s.pending[string(msg.ID)] = make(chan rpcMessage, 1) // swallowed by pumpUp
+			s.mu.Unlock()
+			s.writeUp(data)
+		case faultDisconnect:
+			return
+		case faultError:
+			s.writeDown(s.proxy.errorResponse(msg))
+		case faultStaleHead:
+			go func() {
+				resp, err := s.proxy.staleHead(msg, step.Lag, s.call)
+				if err != nil {
+					s.close()
+					return
+				}
+				resp.ID = msg.ID
+				s.writeDown(resp)
+			}()
+		case faultReorg:
+			if s.proxy.reorg != nil {
+				s.proxy.reorg()
+			}
+			s.writeUp(data)
+		}
+	}
+}
+
+// pumpUp reads the node's responses and notifications and relays them to the client.
+func (s *wsSession) pumpUp() {
+	defer s.close()
+	for {
+		_, data, err := s.up.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg rpcMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		if msg.ID != nil {
+			s.mu.Lock()
+			ch, ok := s.pending[string(msg.ID)]
+			delete(s.pending, string(msg.ID))
+			s.mu.Unlock()
+			if ok {
+				ch <- msg
+				continue
+			}
+		}
+		if msg.Method == "eth_subscription" {
+			step := s.proxy.fault(msg.Method, false)
+			if step != nil {
+				switch step.Kind {
+				case faultLatency:
+					time.Sleep(step.Delay)
+				case faultDrop:
+					continue
+				case faultStaleHead:
+					if isHeadNotification(msg) {
+						continue
+					}
+				case faultDisconnect:
+					return
+				}
+			}
+		}
+		s.downMu.Lock()
+		s.down.WriteMessage(websocket.TextMessage, data)
+		s.downMu.Unlock()
+	}
+}
+
+// isHeadNotification tells a newHeads notification from a logs one.
+func isHeadNotification(msg rpcMessage) bool {
+	var params struct {
+		Result map[string]json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return false
+	}
+	_, ok := params.Result["parentHash"]
+	return ok
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/0xcoolface/backend-dapp-demo/main/tokenpb"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDHeader is the gRPC metadata key of the request ID, for the
+// requests which don't carry one.
+const requestIDHeader = "x-request-id"
+
+// signerHeader is the gRPC metadata key of the name or address of the account
+// signing the txs of the request, the default account without it. It must be
+// one of the accounts of the credential of the caller.
+const signerHeader = "x-signer"
+
+// GRPCCredentialsFile lists the Credentials which may call the gRPC API.
+const GRPCCredentialsFile = "grpc-credentials.json"
+
+// ErrNoGRPCCredentials is returned instead of serving the gRPC API to anyone.
+var ErrNoGRPCCredentials = errors.New("no gRPC credentials")
+
+// GRPCSecurity is who may call the gRPC server, and how.
+type GRPCSecurity struct {
+	// Credentials are the bearer tokens of the callers, in the authorization metadata
+	Credentials []Credential
+	// Signers are the accounts the callers pick from with x-signer, as their credential allows
+	Signers *Accounts
+	// TLS serves over TLS, with the client certs it requires if any; plaintext without it
+	TLS *tls.Config
+}
+
+// NewGRPCServer serves the TokenService of tokens over gRPC, to the callers of
+// sec only. The transfer events are those of the Tracker, which must follow
+// the tracked tokens.
+func NewGRPCServer(tokens *TokenService, receipts ReceiptBackend, tracked []common.Address, pollInterval time.Duration, sec GRPCSecurity) (*grpc.Server, error) {
+	if len(sec.Credentials) == 0 {
+		return nil, ErrNoGRPCCredentials
+	}
+	if sec.Signers == nil {
+		return nil, errors.New("no gRPC signers")
+	}
+	auth := &grpcAuth{credentials: sec.Credentials, signers: sec.Signers}
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryObserver, auth.unary),
+		grpc.ChainStreamInterceptor(streamObserver, auth.stream),
+	}
+	if sec.TLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(sec.TLS)))
+	}
+	srv := grpc.NewServer(opts...)
+	ts := &tokenServer{
+		tokens:       tokens,
+		receipts:     receipts,
+		tracked:      make(map[common.Address]bool),
+		pollInterval: pollInterval,
+	}
+	for _, addr := range tracked {
+		ts.tracked[addr] = true
+	}
+	tokenpb.RegisterTokenServiceServer(srv, ts)
+	return srv, nil
+}
+
+// GRPCTLSConfig loads the cert of the server, and the CA of the client certs to require if clientCA is not "".
+func GRPCTLSConfig(certFile, keyFile, clientCA string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if clientCA != "" {
+		pem, err := ioutil.ReadFile(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientCAs = x509.NewCertPool()
+		if !conf.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no cert in %s", clientCA)
+		}
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// isLoopback is true for a listen address of the local host only.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// grpcAuth lets the calls of the credentials in, by the bearer token of their
+// authorization metadata, and has them signed by the account of x-signer their credential allows.
+type grpcAuth struct {
+	credentials []Credential
+	signers     *Accounts
+}
+
+func (a *grpcAuth) authorize(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	token := ""
+	if len(md.Get("authorization")) > 0 {
+		token = strings.TrimPrefix(md.Get("authorization")[0], "Bearer ")
+	}
+	cred, ok := credentialOf(a.credentials, token)
+	if token == "" || !ok {
+		return nil, status.Error(codes.Unauthenticated, "a bearer token of the gRPC credentials is required")
+	}
+	signer := ""
+	if len(md.Get(signerHeader)) > 0 {
+		signer = md.Get(signerHeader)[0]
+	}
+	acc, err := a.signers.Get(signer)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if !cred.MaySign(acc.Name) {
+		return nil, status.Errorf(codes.PermissionDenied, "%s may not sign with account %s", cred.Name, acc.Name)
+	}
+	return withSigner(withLog(ctx, "caller", cred.Name), acc.Name), nil
+}
+
+func (a *grpcAuth) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *grpcAuth) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authorize(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &tracedStream{ss, ctx})
+}
+
+// unaryObserver runs each call in a span, with the request ID in its logs.
+func unaryObserver(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	ctx, span := tracer.Start(ctx, info.FullMethod)
+	defer func() { endSpan(span, err) }()
+
+	id := ""
+	if r, ok := req.(interface{ GetRequestId() string }); ok {
+		id = r.GetRequestId()
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok && id == "" && len(md.Get(requestIDHeader)) > 0 {
+		id = md.Get(requestIDHeader)[0]
+	}
+	if id == "" {
+		id = newRequestID()
+	}
+	ctx = withLog(ctx, "requestId", id, "method", info.FullMethod)
+	resp, err = handler(ctx, req)
+	if err != nil {
+		logger(ctx).Warn("gRPC call failed", "err", err)
+	}
+	return resp, err
+}
+
+// streamObserver runs each stream in a span.
+func streamObserver(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	ctx, span := tracer.Start(ss.Context(), info.FullMethod)
+	defer func() { endSpan(span, err) }()
+	return handler(srv, &tracedStream{ss, ctx})
+}
+
+type tracedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tracedStream) Context() context.Context {
+	return s.ctx
+}
+
+type tokenServer struct {
+	tokenpb.UnimplementedTokenServiceServer
+
+	tokens       *TokenService
+	receipts     ReceiptBackend
+	tracked      map[common.Address]bool
+	pollInterval time.Duration
+}
+
+// grpcError gives err the status code of its cause.
+func grpcError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrNotDeployed):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrPolicyViolation):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, ErrUnknownAccount):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func parseAddress(field, s string) (common.Address, error) {
+	if !common.IsHexAddress(s) {
+		return common.Address{}, status.Errorf(codes.InvalidArgument, "invalid %s %q", field, s)
+	}
+	return common.HexToAddress(s), nil
+}
+
+func parseAmount(field, s string) (*big.Int, error) {
+	amount, ok := new(big.Int).SetString(s, 10)
+	if !ok || amount.Sign() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q", field, s)
+	}
+	return amount, nil
+}
+
+func txResponse(tx *types.Transaction) *tokenpb.TxResponse {
+	return &tokenpb.TxResponse{TxHash: tx.Hash().Hex(), Nonce: tx.Nonce()}
+}
+
+func (s *tokenServer) Deploy(ctx context.Context, req *tokenpb.DeployRequest) (*tokenpb.DeployResponse, error) {
+	amount, err := parseAmount("initial_amount", req.InitialAmount)
+	if err != nil {
+		return nil, err
+	}
+	if req.Name == "" || req.Decimals > 255 {
+		return nil, status.Error(codes.InvalidArgument, "a name and decimals up to 255 are required")
+	}
+	d, err := s.tokens.Deploy(ctx, req.Name, DeployArgs{amount, req.TokenName, uint8(req.Decimals), req.Symbol})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &tokenpb.DeployResponse{Address: d.Address.Hex(), TxHash: d.TxHash.Hex(), BlockNumber: d.BlockNumber}, nil
+}
+
+func (s *tokenServer) Transfer(ctx context.Context, req *tokenpb.TransferRequest) (*tokenpb.TxResponse, error) {
+	to, err := parseAddress("to", req.To)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := parseAmount("amount", req.Amount)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := s.tokens.Transfer(ctx, req.Token, to, amount)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return txResponse(tx), nil
+}
+
+func (s *tokenServer) Approve(ctx context.Context, req *tokenpb.ApproveRequest) (*tokenpb.TxResponse, error) {
+	spender, err := parseAddress("spender", req.Spender)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := parseAmount("amount", req.Amount)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := s.tokens.Approve(ctx, req.Token, spender, amount)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return txResponse(tx), nil
+}
+
+func (s *tokenServer) TransferFrom(ctx context.Context, req *tokenpb.TransferFromRequest) (*tokenpb.TxResponse, error) {
+	from, err := parseAddress("from", req.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseAddress("to", req.To)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := parseAmount("amount", req.Amount)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := s.tokens.TransferFrom(ctx, req.Token, from, to, amount)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return txResponse(tx), nil
+}
+
+func (s *tokenServer) GetTokenInfo(ctx context.Context, req *tokenpb.TokenRequest) (*tokenpb.TokenInfo, error) {
+	info, err := s.tokens.Info(ctx, req.Token)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &tokenpb.TokenInfo{
+		Address:     info.Address.Hex(),
+		Name:        info.Name,
+		Symbol:      info.Symbol,
+		Decimals:    uint32(info.Decimals),
+		TotalSupply: info.TotalSupply.String(),
+	}, nil
+}
+
+func (s *tokenServer) BalanceOf(ctx context.Context, req *tokenpb.BalanceOfRequest) (*tokenpb.AmountResponse, error) {
+	owner, err := parseAddress("owner", req.Owner)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := s.tokens.BalanceOf(ctx, req.Token, owner)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &tokenpb.AmountResponse{Amount: amount.String()}, nil
+}
+
+func (s *tokenServer) Allowance(ctx context.Context, req *tokenpb.AllowanceRequest) (*tokenpb.AmountResponse, error) {
+	owner, err := parseAddress("owner", req.Owner)
+	if err != nil {
+		return nil, err
+	}
+	spender, err := parseAddress("spender", req.Spender)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := s.tokens.Allowance(ctx, req.Token, owner, spender)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &tokenpb.AmountResponse{Amount: amount.String()}, nil
+}
+
+func (s *tokenServer) WatchTransfers(req *tokenpb.WatchTransfersRequest, stream tokenpb.TokenService_WatchTransfersServer) error {
+	if req.ChainId != 0 && req.ChainId != s.tokens.ChainID.Uint64() {
+		return status.Errorf(codes.FailedPrecondition, "chain %d is not served, chain %s is", req.ChainId, s.tokens.ChainID)
+	}
+	addr, err := s.tokens.TokenAddress(req.Token)
+	if err != nil {
+		return grpcError(err)
+	}
+	if !s.tracked[addr] {
+		return status.Errorf(codes.FailedPrecondition, "token %s is not tracked", addr)
+	}
+	filter := &PushFilter{ChainID: s.tokens.ChainID.Uint64(), Contract: addr}
+	if req.From != "" {
+		from, err := parseAddress("from", req.From)
+		if err != nil {
+			return err
+		}
+		filter.From = &from
+	}
+	if req.To != "" {
+		to, err := parseAddress("to", req.To)
+		if err != nil {
+			return err
+		}
+		filter.To = &to
+	}
+
+	ctx := stream.Context()
+	events := make(chan *TokenEvent, pushQueue)
+	sub := SubscribeLiveEvents(events)
+	defer sub.Unsubscribe()
+	// queue the matching events, to drop a slow client instead of holding the Tracker back
+	out := make(chan *TokenEvent, pushQueue)
+	slow := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-events:
+				if e.Type != EventTransfer || !filter.Match(e) {
+					continue
+				}
+				select {
+				case out <- e:
+				default:
+					close(slow)
+					return
+				}
+			}
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-slow:
+			return status.Error(codes.ResourceExhausted, "client too slow")
+		case e := <-out:
+			if err := stream.Send(tokenEventPB(e)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func tokenEventPB(e *TokenEvent) *tokenpb.TokenEvent {
+	hex := func(a *common.Address) string {
+		if a == nil {
+			return ""
+		}
+		return a.Hex()
+	}
+	return &tokenpb.TokenEvent{
+		Type:          e.Type,
+		ChainId:       e.ChainID,
+		Contract:      e.Contract.Hex(),
+		BlockNumber:   e.BlockNumber,
+		BlockHash:     e.BlockHash.Hex(),
+		TxHash:        e.TxHash.Hex(),
+		LogIndex:      uint32(e.LogIndex),
+		From:          hex(e.From),
+		To:            hex(e.To),
+		Owner:         hex(e.Owner),
+		Spender:       hex(e.Spender),
+		Value:         e.Value,
+		Confirmations: e.Confirmations,
+		Removed:       e.Removed,
+	}
+}
+
+func (s *tokenServer) WatchTx(req *tokenpb.WatchTxRequest, stream tokenpb.TokenService_WatchTxServer) error {
+	if len(common.FromHex(req.TxHash)) != common.HashLength {
+		return status.Errorf(codes.InvalidArgument, "invalid tx_hash %q", req.TxHash)
+	}
+	txHash := common.HexToHash(req.TxHash)
+	confirms := req.Confirmations
+	if confirms == 0 {
+		confirms = 3
+	}
+
+	ctx := stream.Context()
+	var last *tokenpb.TxStatus
+	for {
+		st, err := s.txStatus(ctx, txHash, confirms)
+		if err != nil {
+			return grpcError(err)
+		}
+		if last == nil || st.State != last.State || st.Confirmations != last.Confirmations || st.BlockNumber != last.BlockNumber {
+			if err := stream.Send(st); err != nil {
+				return err
+			}
+			last = st
+		}
+		if st.State == tokenpb.TxStatus_STATE_CONFIRMED || st.State == tokenpb.TxStatus_STATE_FAILED {
+			return nil
+		}
+		if err := sleep(ctx, s.pollInterval); err != nil {
+			return nil
+		}
+	}
+}
+
+// txStatus reads the status of txHash. A tx without receipt is pending,
+// even once mined if a reorg dropped it since.
+func (s *tokenServer) txStatus(ctx context.Context, txHash common.Hash, confirms uint64) (*tokenpb.TxStatus, error) {
+	st := &tokenpb.TxStatus{TxHash: txHash.Hex(), State: tokenpb.TxStatus_STATE_PENDING}
+	r, err := s.receipts.TransactionReceipt(ctx, txHash)
+	if errors.Is(err, ethereum.NotFound) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	head, err := s.receipts.BlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+	st.BlockNumber, st.GasUsed = r.BlockNumber.Uint64(), r.GasUsed
+	st.Confirmations = confirmations(head, r)
+	switch {
+	case r.Status != types.ReceiptStatusSuccessful:
+		st.State = tokenpb.TxStatus_STATE_FAILED
+	case st.Confirmations >= confirms:
+		st.State = tokenpb.TxStatus_STATE_CONFIRMED
+	default:
+		st.State = tokenpb.TxStatus_STATE_MINED
+	}
+	return st, nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xcoolface/backend-dapp-demo/main/tokenpb"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// bearer sends a bearer token in the authorization metadata of each call.
+type bearer string
+
+func (b bearer) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(b)}, nil
+}
+
+func (b bearer) RequireTransportSecurity() bool {
+	return false
+}
+
+// newGRPCClient serves the token service of env over an in-memory listener,
+// and calls it with the token of the credential ops.
+func newGRPCClient(t *testing.T, env *testEnv) tokenpb.TokenServiceClient {
+	t.Helper()
+	return newGRPCClientOf(t, env, "ops-token")
+}
+
+func newGRPCClientOf(t *testing.T, env *testEnv, token string) tokenpb.TokenServiceClient {
+	t.Helper()
+	reg, err := LoadRegistry(filepath.Join(t.TempDir(), RegistryFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &TokenService{
+		Backend:  env.backend,
+		ChainID:  simChainID,
+		Registry: reg,
+		Transactor: func(ctx context.Context) (*bind.TransactOpts, error) {
+			return env.accounts[0].transactor(t), nil
+		},
+		Wait: func(ctx context.Context, tx *types.Transaction) (*types.Receipt, error) {
+			env.backend.Commit()
+			return env.backend.TransactionReceipt(ctx, tx.Hash())
+		},
+	}
+	treasury := newTestAccount(t)
+	signers, err := NewAccounts(nil, []*Account{
+		{Name: DefaultAccount, Address: env.accounts[0].addr, Key: env.accounts[0].key},
+		{Name: "treasury", Address: treasury.addr, Key: treasury.key},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := NewGRPCServer(svc, env.backend, nil, 10*time.Millisecond, GRPCSecurity{
+		Credentials: []Credential{
+			{Name: "ops", TokenHash: tokenHash("ops-token")},
+			{Name: "admin", TokenHash: tokenHash("admin-token"), Accounts: []string{DefaultAccount, "treasury"}},
+		},
+		Signers: signers,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(bearer(token)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return tokenpb.NewTokenServiceClient(conn)
+}
+
+func TestGRPCTransfer(t *testing.T) {
+	env := newTestEnv(t, 1)
+	c := newGRPCClient(t, env)
+	ctx := context.Background()
+
+	d, err := c.Deploy(ctx, &tokenpb.DeployRequest{Name: "dtoken", InitialAmount: "1000", TokenName: "dtoken", Decimals: 8, Symbol: "dt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := c.GetTokenInfo(ctx, &tokenpb.TokenRequest{Token: "dtoken"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Address != d.Address || info.Symbol != "dt" || info.Decimals != 8 || info.TotalSupply != "1000" {
+		t.Fatalf("GetTokenInfo = %v", info)
+	}
+
+	to := common.Address{0x11}
+	tx, err := c.Transfer(ctx, &tokenpb.TransferRequest{Token: "dtoken", To: to.Hex(), Amount: "250", RequestId: "req-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := c.WatchTx(ctx, &tokenpb.WatchTxRequest{TxHash: tx.TxHash, Confirmations: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []tokenpb.TxStatus_State{tokenpb.TxStatus_STATE_PENDING, tokenpb.TxStatus_STATE_MINED, tokenpb.TxStatus_STATE_CONFIRMED}
+	for _, state := range want {
+		st, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.State != state {
+			t.Fatalf("state = %v, want %v", st.State, state)
+		}
+		env.backend.Commit()
+	}
+
+	bal, err := c.BalanceOf(ctx, &tokenpb.BalanceOfRequest{Token: d.Address, Owner: to.Hex()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bal.Amount != "250" {
+		t.Fatalf("balance = %s, want 250", bal.Amount)
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	env := newTestEnv(t, 1)
+	c := newGRPCClient(t, env)
+	ctx := context.Background()
+
+	_, err := c.GetTokenInfo(ctx, &tokenpb.TokenRequest{Token: "missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("unknown token: %v, want NotFound", err)
+	}
+	_, err = c.Transfer(ctx, &tokenpb.TransferRequest{Token: "missing", To: "0x11", Amount: "1"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("invalid address: %v, want InvalidArgument", err)
+	}
+	_, err = c.Transfer(ctx, &tokenpb.TransferRequest{Token: "missing", To: common.Address{0x11}.Hex(), Amount: "-1"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("negative amount: %v, want InvalidArgument", err)
+	}
+	stream, err := c.WatchTransfers(ctx, &tokenpb.WatchTransfersRequest{Token: common.Address{0x22}.Hex()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("untracked token: %v, want FailedPrecondition", err)
+	}
+	stream, err = c.WatchTransfers(ctx, &tokenpb.WatchTransfersRequest{Token: common.Address{0x22}.Hex(), ChainId: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), "chain 5") {
+		t.Fatalf("token of another chain: %v, want FailedPrecondition", err)
+	}
+}
+
+func TestGRPCAuth(t *testing.T) {
+	env := newTestEnv(t, 1)
+	ctx := context.Background()
+	for _, token := range []string{"", "other-token"} {
+		c := newGRPCClientOf(t, env, token)
+		if _, err := c.GetTokenInfo(ctx, &tokenpb.TokenRequest{Token: "dtoken"}); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("called with token %q: %v, want Unauthenticated", token, err)
+		}
+		stream, err := c.WatchTx(ctx, &tokenpb.WatchTxRequest{TxHash: common.Hash{1}.Hex()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stream.Recv(); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("streamed with token %q: %v, want Unauthenticated", token, err)
+		}
+	}
+
+	// x-signer picks one of the accounts of the credential only; NotFound is a call let in
+	for _, tc := range []struct {
+		token, signer string
+		want          codes.Code
+	}{
+		{"ops-token", "", codes.NotFound},
+		{"ops-token", DefaultAccount, codes.NotFound},
+		{"ops-token", "treasury", codes.PermissionDenied},
+		{"admin-token", "treasury", codes.NotFound},
+		{"admin-token", "nobody", codes.InvalidArgument},
+	} {
+		c := newGRPCClientOf(t, env, tc.token)
+		callCtx := ctx
+		if tc.signer != "" {
+			callCtx = metadata.AppendToOutgoingContext(ctx, signerHeader, tc.signer)
+		}
+		if _, err := c.GetTokenInfo(callCtx, &tokenpb.TokenRequest{Token: "missing"}); status.Code(err) != tc.want {
+			t.Errorf("%s signing with %q: %v, want %v", tc.token, tc.signer, err, tc.want)
+		}
+	}
+
+	if _, err := NewGRPCServer(&TokenService{}, env.backend, nil, time.Second, GRPCSecurity{}); !errors.Is(err, ErrNoGRPCCredentials) {
+		t.Fatalf("served gRPC without credentials: %v", err)
+	}
+	for addr, want := range map[string]bool{"127.0.0.1:9090": true, "localhost:9090": true, "[::1]:9090": true, ":9090": false, "0.0.0.0:9090": false, "10.0.0.1:9090": false} {
+		if isLoopback(addr) != want {
+			t.Errorf("isLoopback(%q) = %v", addr, !want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// hardenedBit is added to the index of a hardened child key.
+const hardenedBit = 0x80000000
+
+// errInvalidChild is the rare BIP32 case of a child key out of range,
+// which is skipped by using the next index.
+var errInvalidChild = errors.New("invalid child key")
+
+// hdKey is a BIP32 extended private key.
+type hdKey struct {
+	key       *big.Int
+	chainCode []byte
+}
+
+func newMasterKey(seed []byte) (*hdKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, fmt.Errorf("seed of %d bytes, want 16 to 64", len(seed))
+	}
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	key := new(big.Int).SetBytes(sum[:32])
+	if key.Sign() == 0 || key.Cmp(crypto.S256().Params().N) >= 0 {
+		return nil, errInvalidChild
+	}
+	return &hdKey{key, sum[32:]}, nil
+}
+
+// child derives the child key at index, hardened from hardenedBit up.
+func (k *hdKey) child(index uint32) (*hdKey, error) {
+	data := make([]byte, 0, 37)
+	if index >= hardenedBit {
+		data = append(data, 0)
+		data = append(data, common.LeftPadBytes(k.key.Bytes(), 32)...)
+	} else {
+		data = append(data, crypto.CompressPubkey(&k.private().PublicKey)...)
+	}
+	var i [4]byte
+	binary.BigEndian.PutUint32(i[:], index)
+	data = append(data, i[:]...)
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	n := crypto.S256().Params().N
+	il := new(big.Int).SetBytes(sum[:32])
+	if il.Cmp(n) >= 0 {
+		return nil, errInvalidChild
+	}
+	key := il.Add(il, k.key)
+	key.Mod(key, n)
+	if key.Sign() == 0 {
+		return nil, errInvalidChild
+	}
+	return &hdKey{key, sum[32:]}, nil
+}
+
+func (k *hdKey) private() *ecdsa.PrivateKey {
+	sk, _ := crypto.ToECDSA(common.LeftPadBytes(k.key.Bytes(), 32))
+	return sk
+}
+
+// DepositKeys derives the keys of the deposit addresses from a BIP32 seed,
+// the key of index i at the path Base/i, like m/44'/60'/0'/0/i.
+type DepositKeys struct {
+	base *hdKey
+}
+
+// NewDepositKeys derives the base key of path from seed.
+func NewDepositKeys(seed []byte, path accounts.DerivationPath) (*DepositKeys, error) {
+	k, err := newMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range path {
+		if k, err = k.child(index); err != nil {
+			return nil, fmt.Errorf("derive %s: %w", path, err)
+		}
+	}
+	return &DepositKeys{k}, nil
+}
+
+// Key derives the key of deposit address index.
+func (d *DepositKeys) Key(index uint32) (*ecdsa.PrivateKey, error) {
+	k, err := d.base.child(index)
+	if err != nil {
+		return nil, err
+	}
+	return k.private(), nil
+}
+
+// Address derives deposit address index.
+func (d *DepositKeys) Address(index uint32) (common.Address, error) {
+	sk, err := d.Key(index)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(sk.PublicKey), nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// IndexerBackend is what the Indexer needs from the rpc-server.
+type IndexerBackend interface {
+	bind.ContractFilterer
+	BlockNumber(ctx context.Context) (uint64, error)
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+}
+
+// Indexer follows the chain head, and scans the Transfer and Approval events
+// of the tokens once their blocks have Confirms confirmations.
+// The next block to scan of each token is kept in Checkpoints.
+type Indexer struct {
+	Backend     IndexerBackend
+	ChainID     *big.Int
+	Tokens      []common.Address
+	Confirms    uint64
+	Checkpoints *Checkpoints
+	// Subscribe follows the head with eth_subscribe, instead of polling every PollInterval
+	Subscribe    bool
+	PollInterval time.Duration
+	// MaxRange is the most blocks scanned by one eth_getLogs
+	MaxRange uint64
+
+	filterers map[common.Address]*EIP20Filterer
+}
+
+// Run indexes until ctx is done.
+func (ix *Indexer) Run(ctx context.Context) error {
+	ix.filterers = make(map[common.Address]*EIP20Filterer)
+	for _, token := range ix.Tokens {
+		f, err := NewEIP20Filterer(token, ix.Backend)
+		if err != nil {
+			return err
+		}
+		ix.filterers[token] = f
+	}
+
+	heads := make(chan uint64)
+	if ix.Subscribe {
+		go ix.subscribeHeads(ctx, heads)
+	} else {
+		go ix.pollHeads(ctx, heads)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case head := <-heads:
+			if err := ix.scan(ctx, head); err != nil {
+				log.Error("Index failed", "chainId", ix.ChainID, "head", head, "err", err)
+			}
+		}
+	}
+}
+
+// subscribeHeads sends the new heads to out, subscribing again whenever the subscription fails.
+func (ix *Indexer) subscribeHeads(ctx context.Context, out chan<- uint64) {
+	backoff := time.Second
+	for {
+		err := ix.followHeads(ctx, out)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warn("Head subscription failed, subscribe again", "chainId", ix.ChainID, "err", err, "backoff", backoff)
+		if sleep(ctx, backoff) != nil {
+			return
+		}
+		subscriptionReconnects.WithLabelValues(ix.ChainID.String(), "newHeads").Inc()
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+func (ix *Indexer) followHeads(ctx context.Context, out chan<- uint64) error {
+	headers := make(chan *types.Header)
+	sub, err := ix.Backend.SubscribeNewHead(ctx, headers)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+	// catch up with the head before the first new one
+	head, err := ix.Backend.BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	select {
+	case out <- head:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-sub.Err():
+			return err
+		case header := <-headers:
+			select {
+			case out <- header.Number.Uint64():
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+func (ix *Indexer) pollHeads(ctx context.Context, out chan<- uint64) {
+	for {
+		head, err := ix.Backend.BlockNumber(ctx)
+		if err != nil {
+			log.Warn("Get BlockNumber failed", "chainId", ix.ChainID, "err", err)
+		} else {
+			select {
+			case out <- head:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if sleep(ctx, ix.PollInterval) != nil {
+			return
+		}
+	}
+}
+
+// IndexerCheckpointKey is the key of the Indexer of contract on chainID,
+// apart from the poller of the watch command.
+func IndexerCheckpointKey(chainID *big.Int, contract common.Address) string {
+	return "indexer/" + CheckpointKey(chainID, contract)
+}
+
+// scan indexes every token up to the confirmed block under head.
+func (ix *Indexer) scan(ctx context.Context, head uint64) error {
+	chain := ix.ChainID.String()
+	chainHead.WithLabelValues(chain).Set(float64(head))
+	if head < ix.Confirms {
+		return nil
+	}
+	confirmed := head - ix.Confirms
+
+	lowest := confirmed
+	var failed error
+	for _, token := range ix.Tokens {
+		key := IndexerCheckpointKey(ix.ChainID, token)
+		next, ok := ix.Checkpoints.Get(key)
+		if !ok {
+			// start at the head, like FilterTransferEvent
+			next = confirmed
+		}
+		for next <= confirmed {
+			end := next + ix.MaxRange - 1
+			if end > confirmed {
+				end = confirmed
+			}
+			if err := ix.scanRange(ctx, token, next, end); err != nil {
+				failed = fmt.Errorf("scan %s: %w", token, err)
+				break
+			}
+			next = end + 1
+			ix.Checkpoints.Set(key, next)
+		}
+		if next-1 < lowest {
+			lowest = next - 1
+		}
+	}
+	indexerBlock.WithLabelValues(chain).Set(float64(lowest))
+	indexerLag.WithLabelValues(chain).Set(float64(head - lowest))
+	if err := ix.Checkpoints.Save(); err != nil {
+		return err
+	}
+	return failed
+}
+
+func (ix *Indexer) scanRange(ctx context.Context, token common.Address, start, end uint64) error {
+	opts := &bind.FilterOpts{Start: start, End: &end, Context: ctx}
+	filterer := ix.filterers[token]
+	l := log.New("chainId", ix.ChainID, "contract", token)
+
+	transfers, err := filterer.FilterTransfer(opts, nil, nil)
+	if err != nil {
+		return err
+	}
+	for transfers.Next() {
+		deliverTransfer(ctx, l, ix.ChainID, transfers.Event)
+	}
+	transfers.Close()
+	if err := transfers.Error(); err != nil {
+		return err
+	}
+
+	approvals, err := filterer.FilterApproval(opts, nil, nil)
+	if err != nil {
+		return err
+	}
+	for approvals.Next() {
+		deliverApproval(ctx, l, ix.ChainID, approvals.Event)
+	}
+	approvals.Close()
+	return approvals.Error()
+}
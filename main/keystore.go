@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+)
+
+// ErrKeyStoreExists is returned instead of overwriting a keystore file.
+var ErrKeyStoreExists = errors.New("keystore file exists already")
+
+// ScryptParams are the cost of the key derivation of a keystore file,
+// like keystore.StandardScryptN and keystore.StandardScryptP.
+type ScryptParams struct {
+	N int
+	P int
+}
+
+// WriteKeyStore encrypts key with password into a new keystore file at path, readable by the owner only.
+func WriteKeyStore(path string, key *ecdsa.PrivateKey, password string, scrypt ScryptParams) (common.Address, error) {
+	if _, err := os.Stat(path); err == nil {
+		return common.Address{}, fmt.Errorf("%w: %s", ErrKeyStoreExists, path)
+	}
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return common.Address{}, err
+	}
+	k := &keystore.Key{Id: id, Address: crypto.PubkeyToAddress(key.PublicKey), PrivateKey: key}
+	return k.Address, writeKey(path, k, password, scrypt)
+}
+
+// ChangeKeyStorePassword encrypts the keystore file at path again, with newPassword.
+func ChangeKeyStorePassword(path, password, newPassword string, scrypt ScryptParams) (common.Address, error) {
+	keyjson, err := ioutil.ReadFile(path)
+	if err != nil {
+		return common.Address{}, err
+	}
+	k, err := keystore.DecryptKey(keyjson, password)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return k.Address, writeKey(path, k, newPassword, scrypt)
+}
+
+func writeKey(path string, k *keystore.Key, password string, scrypt ScryptParams) error {
+	keyjson, err := keystore.EncryptKey(k, password, scrypt.N, scrypt.P)
+	if err != nil {
+		return err
+	}
+	// the temp file is created 0600, and keeps it
+	return writeFileAtomic(path, keyjson)
+}
+
+// ReadKeyStoreAddress returns the address of a keystore file, without decrypting it.
+func ReadKeyStoreAddress(path string) (common.Address, error) {
+	keyjson, err := ioutil.ReadFile(path)
+	if err != nil {
+		return common.Address{}, err
+	}
+	var v struct {
+		Address string `json:"address"`
+	}
+	if err := json.Unmarshal(keyjson, &v); err != nil {
+		return common.Address{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if !common.IsHexAddress(v.Address) {
+		return common.Address{}, fmt.Errorf("%s has no address", path)
+	}
+	return common.HexToAddress(v.Address), nil
+}
+
+// readSecret reads the first line of the file at path, or the next line of stdin if path is "".
+func readSecret(path string, stdin *bufio.Reader) (string, error) {
+	r := stdin
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		r = bufio.NewReader(f)
+	}
+	line, err := r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestKeyStore(t *testing.T) {
+	scrypt := ScryptParams{N: keystore.LightScryptN, P: keystore.LightScryptP}
+	path := filepath.Join(t.TempDir(), "key.json")
+	key, _ := crypto.GenerateKey()
+	addr, err := WriteKeyStore(path, key, "one", scrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("wrote %s", addr)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("keystore file mode %v: %v", fi.Mode(), err)
+	}
+	if _, err := WriteKeyStore(path, key, "one", scrypt); !errors.Is(err, ErrKeyStoreExists) {
+		t.Fatalf("overwrote a keystore: %v", err)
+	}
+	if got, err := ReadKeyStoreAddress(path); err != nil || got != addr {
+		t.Fatalf("address %s: %v", got, err)
+	}
+
+	if _, err := ChangeKeyStorePassword(path, "wrong", "two", scrypt); !errors.Is(err, keystore.ErrDecrypt) {
+		t.Fatalf("changed the password with a wrong one: %v", err)
+	}
+	if _, err := ChangeKeyStorePassword(path, "one", "two", scrypt); err != nil {
+		t.Fatal(err)
+	}
+	// the config reads it with the new password
+	conf := &Config{AccountConfig: AccountConfig{AccountType: AccountKeyStoreFile, KeyStoreFile: path, Password: "two"}}
+	if err := conf.loadSecret(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.accounts[0].Address != addr {
+		t.Fatalf("loaded %s, want %s", conf.accounts[0].Address, addr)
+	}
+}
+
+func TestReadSecret(t *testing.T) {
+	stdin := bufio.NewReader(strings.NewReader("first\r\nsecond"))
+	for _, want := range []string{"first", "second"} {
+		if got, err := readSecret("", stdin); err != nil || got != want {
+			t.Fatalf("read %q, want %q: %v", got, want, err)
+		}
+	}
+	if _, err := readSecret("", stdin); err == nil {
+		t.Fatal("read past the end")
+	}
+	path := filepath.Join(t.TempDir(), "password")
+	ioutil.WriteFile(path, []byte("secret\nignored\n"), 0600)
+	if got, err := readSecret(path, stdin); err != nil || got != "secret" {
+		t.Fatalf("read %q from a file: %v", got, err)
+	}
+}
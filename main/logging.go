@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// log formats of the config
+const (
+	LogFormatJSON     = "json"
+	LogFormatTerminal = "terminal"
+)
+
+// setupLogging writes the logs of level and above to stderr, as JSON lines
+// unless format is LogFormatTerminal.
+func setupLogging(format, level string) error {
+	if level == "" {
+		level = "info"
+	}
+	lvl, err := log.LvlFromString(level)
+	if err != nil {
+		return err
+	}
+	var f log.Format
+	switch format {
+	case "", LogFormatJSON:
+		f = log.JSONFormat()
+	case LogFormatTerminal:
+		f = log.TerminalFormat(false)
+	default:
+		return fmt.Errorf("unsupported log format %q", format)
+	}
+	log.Root().SetHandler(log.LvlFilterHandler(lvl, log.StreamHandler(os.Stderr, f)))
+	return nil
+}
+
+type loggerKey struct{}
+
+// withLog returns a ctx whose logger adds the key/value pairs to every line,
+// like the chain ID, contract and tx of the work done under ctx.
+func withLog(ctx context.Context, keyvals ...interface{}) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger(ctx).New(keyvals...))
+}
+
+// withTxLog adds the hash and nonce of tx to the logger of ctx.
+func withTxLog(ctx context.Context, tx *types.Transaction) context.Context {
+	return withLog(ctx, "txHash", tx.Hash(), "nonce", tx.Nonce())
+}
+
+// logger returns the logger of ctx, or the root logger.
+func logger(ctx context.Context) log.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(log.Logger); ok {
+		return l
+	}
+	return log.Root()
+}
+
+// newRequestID makes a random ID, for the requests which don't bring one.
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+func TestWithLog(t *testing.T) {
+	var buf bytes.Buffer
+	handler := log.Root().GetHandler()
+	log.Root().SetHandler(log.StreamHandler(&buf, log.JSONFormat()))
+	t.Cleanup(func() { log.Root().SetHandler(handler) })
+
+	tx := types.NewTx(&types.DynamicFeeTx{Nonce: 7})
+	ctx := withLog(context.Background(), "requestId", "r1", "chainId", simChainID, "contract", common.Address{0x22})
+	ctx = withTxLog(ctx, tx)
+	// the grace context keeps the logger
+	graceCtx, cancel := withGrace(ctx)
+	defer cancel()
+	logger(graceCtx).Info("Got receipt", "confirms", 3)
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("not a JSON line %q: %v", buf.String(), err)
+	}
+	want := map[string]interface{}{
+		"msg":       "Got receipt",
+		"lvl":       "info",
+		"requestId": "r1",
+		"chainId":   "1337",
+		"contract":  common.Address{0x22}.Hex(),
+		"txHash":    tx.Hash().Hex(),
+		"nonce":     float64(7),
+		"confirms":  float64(3),
+	}
+	for k, v := range want {
+		if line[k] != v {
+			t.Errorf("%s = %v, want %v", k, line[k], v)
+		}
+	}
+}
@@ -2,112 +2,340 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"log"
+	"flag"
+	"fmt"
 	"math/big"
-	"sync"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	ethereum "github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
-	cfg    *Config
-	client *ethclient.Client
+	cfg *Config
+	// chains are the networks of -network, chain is the one the command runs on
+	chains []*Chain
+	chain  *Chain
+	// client, chainID, signers and tokens are those of chain
+	client      *Client
+	chainID     *big.Int
+	registry    *Registry
+	checkpoints *Checkpoints
+	// signers are the accounts of the config, which sign the txs
+	signers *Accounts
+	// spendingPolicy guards every tx signed, nil allows any
+	spendingPolicy *SpendingPolicy
+	tokens         *TokenService
+	// stopTracing flushes the spans and stops the exporter
+	stopTracing func(context.Context) error
 )
 
+// exit status codes
+const (
+	exitOK          = 0
+	exitFailure     = 1
+	exitUsage       = 2
+	exitInterrupted = 130
+)
+
+// shutdownGrace is how long the work in flight, like waiting for the receipt
+// of a sent tx, may go on after a shutdown signal. A second signal exits at once.
+const shutdownGrace = 30 * time.Second
+
+const usage = `usage: main [-network names] [command] [flags]
+
+  -network   comma separated networks of the config to run the command on, one
+             after the other; serve indexes them all and serves the first, deposits,
+             sweep and reconcile -every take one network
+
+commands:
+  deploy     deploy an EIP20 token and record it in the registry
+  transfer   transfer tokens of a registered token
+  approve    approve a spender of a registered token
+  watch      wait for a transfer event of a registered token
+  verify     check the code and constructor args of a deployed token
+  serve      index the registered tokens and expose the metrics
+  deposits   credit the transfers to the deposit addresses
+  addresses  derive the deposit addresses
+  sweep      sweep the deposit addresses to the treasury
+  reconcile  compare the book balances with the chain
+  snapshot   list the holders of a token at a block
+  airdrop    deploy and fund a Merkle distributor of a CSV
+  claim      claim an airdrop on behalf of an account
+  rotate     move the tokens and approvals of an account to another
+  safe       propose or execute a token transfer or approval of a Safe
+  keystore   create, import, re-encrypt or read a keystore file, without the config
+  safe-sign  sign a Safe transaction as an owner, without the config
+
+Without a command, deploy the demo token, transfer and watch the event.`
+
+// multiChainCommands run once on all the networks of -network, the others once on each.
+var multiChainCommands = map[string]bool{"serve": true}
+
+// singleChainCommands run until stopped, they would never get to a second network.
+// reconcile refuses more than one network with -every itself.
+var singleChainCommands = map[string]bool{"deposits": true, "sweep": true}
+
+var commands = map[string]func(ctx context.Context, args []string) error{
+	"":          runDemo,
+	"deploy":    runDeploy,
+	"transfer":  runTransfer,
+	"approve":   runApprove,
+	"watch":     runWatch,
+	"verify":    runVerify,
+	"serve":     runServe,
+	"deposits":  runDeposits,
+	"addresses": runAddresses,
+	"sweep":     runSweep,
+	"reconcile": runReconcile,
+	"snapshot":  runSnapshot,
+	"airdrop":   runAirdrop,
+	"claim":     runClaim,
+	"rotate":    runRotate,
+	"safe":      runSafe,
+}
+
+// offlineCommands run without the config and the rpc-server.
+var offlineCommands = map[string]func(ctx context.Context, args []string) error{
+	"keystore":  runKeystore,
+	"safe-sign": runSafeSign,
+}
+
 func main() {
-	cfg = LoadConfig()
-	var err error
-	client, err = ethclient.Dial(cfg.RpcUrl)
+	os.Exit(run())
+}
 
-	if err != nil {
-		log.Panicf("dial %s failed, err=%v\n", cfg.RpcUrl, err)
+func run() int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		// restore the default behavior, so a second signal kills the process
+		stop()
+	}()
+
+	setupLogging(LogFormatJSON, "info")
+
+	global := flag.NewFlagSet("main", flag.ExitOnError)
+	global.Usage = func() { fmt.Fprintln(os.Stderr, usage) }
+	networks := global.String("network", "", "")
+	global.Parse(os.Args[1:])
+	cmd, args := "", []string(nil)
+	if global.NArg() > 0 {
+		cmd, args = global.Arg(0), global.Args()[1:]
+	}
+	if runOffline, ok := offlineCommands[cmd]; ok {
+		if err := runOffline(ctx, args); err != nil {
+			log.Error("Command failed", "cmd", cmd, "err", err)
+			return exitCode(ctx)
+		}
+		return exitOK
+	}
+	runCmd, ok := commands[cmd]
+	if !ok {
+		fmt.Fprintln(os.Stderr, usage)
+		return exitUsage
 	}
 
-	chainID, err := client.ChainID(context.Background())
-	if err != nil {
-		log.Panicf("get chainid failed, err=%v\n", err)
+	var names []string
+	if *networks != "" {
+		names = strings.Split(*networks, ",")
+	}
+	if err := setup(ctx, names); err != nil {
+		log.Error("Setup failed", "err", err)
+		return exitCode(ctx)
 	}
-	log.Println("chainID ", chainID)
+	defer func() {
+		for _, c := range chains {
+			c.Client.Close()
+		}
+	}()
+	defer func() {
+		// flush the spans, even after a shutdown signal
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := stopTracing(flushCtx); err != nil {
+			log.Warn("Flush traces failed", "err", err)
+		}
+	}()
 
-	auth, _ := bind.NewKeyedTransactorWithChainID(cfg.secret, chainID)
-	// if the account will send transactions concurrently,
-	// you need to manage the nonce by yourself
-	// auth.Nonce = manualSetNonce
+	if singleChainCommands[cmd] && len(chains) > 1 {
+		log.Error("Command runs on one network, run one per network", "cmd", cmd, "networks", len(chains))
+		return exitUsage
+	}
 
-	auth.GasTipCap = big.NewInt(1 * params.GWei)
-	// deploy
-	_, tx, contract, err := DeployEIP20(auth, client, big.NewInt(1e18), "dtoken", 8, "dt")
+	name := cmd
+	if name == "" {
+		name = "demo"
+	}
+	ctx, span := tracer.Start(ctx, name)
+	if span.SpanContext().IsValid() {
+		ctx = withLog(ctx, "traceId", span.SpanContext().TraceID())
+	}
+	targets := chains
+	if multiChainCommands[cmd] {
+		targets = chains[:1]
+	}
+	var err error
+	for _, c := range targets {
+		useChain(c)
+		if err = runCmd(withLog(ctx, "network", c.Name), args); err != nil {
+			err = fmt.Errorf("network %s: %w", c.Name, err)
+			break
+		}
+	}
+	endSpan(span, err)
+	if err := checkpoints.Save(); err != nil {
+		log.Error("Save checkpoints failed", "err", err)
+		return exitFailure
+	}
 	if err != nil {
-		log.Panicf("DeployEIP20 failed, err=%v\n", err)
+		log.Error("Command failed", "cmd", cmd, "err", err)
+		return exitCode(ctx)
 	}
+	return exitOK
+}
 
-	var receipt *types.Receipt
-	if cfg.isHttp {
-		receipt, err = WaitReceipt(client, tx.Hash(), 3*time.Second, 5, 3)
-	} else {
-		receipt, err = WaitReceiptOnNewHead(client, tx.Hash(), 5, 3)
+func setup(ctx context.Context, networks []string) error {
+	var err error
+	if cfg, err = LoadConfig(); err != nil {
+		return err
 	}
-	if err != nil {
-		log.Panicf("WaitReceiptOnNewHead failed, err=%v\n", err)
+	if err = setupLogging(cfg.LogFormat, cfg.LogLevel); err != nil {
+		return fmt.Errorf("setup logging: %w", err)
+	}
+	if stopTracing, err = setupTracing(ctx, cfg); err != nil {
+		return fmt.Errorf("setup tracing: %w", err)
+	}
+	if networks, err = cfg.SelectNetworks(networks); err != nil {
+		return err
+	}
+	if registry, err = LoadRegistry(RegistryFile); err != nil {
+		return fmt.Errorf("load registry: %w", err)
+	}
+	if checkpoints, err = LoadCheckpoints(CheckpointFile); err != nil {
+		return fmt.Errorf("load checkpoints: %w", err)
 	}
-	if receipt.Status == types.ReceiptStatusSuccessful {
-		log.Printf("contract is deployed at %s", receipt.ContractAddress.String())
-	} else {
-		strres, _ := json.Marshal(receipt)
-		log.Println(string(strres))
-		log.Panic("deploy contract failed")
+	if spendingPolicy, err = LoadSpendingPolicy(SpendingPolicyFile, SpendingFile); err != nil {
+		return fmt.Errorf("load spending policy: %w", err)
 	}
+	for _, name := range networks {
+		c, err := ConnectNetwork(ctx, name, cfg.Networks[name], cfg.accounts)
+		if err != nil {
+			for _, c := range chains {
+				c.Client.Close()
+			}
+			return err
+		}
+		c.Tokens = &TokenService{
+			Backend:    c.Client,
+			ChainID:    c.ChainID,
+			Registry:   registry,
+			Addresses:  c.Network.Tokens,
+			Transactor: newTransactor,
+			Wait:       waitReceipt,
+		}
+		chains = append(chains, c)
+	}
+	return nil
+}
 
-	// transact
-	toAddr := common.Address{0x11}
-	tx, err = contract.Transfer(auth, toAddr, big.NewInt(12345))
-	if err != nil {
-		log.Panicf("Transfer failed, err=%v\n", err)
+// useChain makes c the chain of the commands.
+func useChain(c *Chain) {
+	chain = c
+	client, chainID, signers, tokens = c.Client, c.ChainID, c.Signers, c.Tokens
+}
+
+// exitCode tells an interrupted run from a failed one.
+func exitCode(ctx context.Context) int {
+	if ctx.Err() != nil {
+		return exitInterrupted
 	}
-	log.Printf("Send erc20 transfer tx, hash=%s\n", tx.Hash())
+	return exitFailure
+}
 
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-	if cfg.isHttp {
-		go FilterTransferEvent(wg, contract, client, 3)
-	} else {
-		go WatchTransferEvent(wg, contract)
+// withGrace returns a context which is done shutdownGrace after ctx,
+// for the work in flight to finish after a shutdown signal.
+func withGrace(ctx context.Context) (context.Context, context.CancelFunc) {
+	// keep the logger, but not the cancellation of ctx
+	graceCtx, cancel := context.WithCancel(context.WithValue(context.Background(), loggerKey{}, logger(ctx)))
+	go func() {
+		select {
+		case <-graceCtx.Done():
+		case <-ctx.Done():
+			logger(ctx).Warn("Shutting down, wait for the pending work", "grace", shutdownGrace)
+			select {
+			case <-graceCtx.Done():
+			case <-time.After(shutdownGrace):
+				cancel()
+			}
+		}
+	}()
+	return graceCtx, cancel
+}
+
+// sleep waits for d, or returns the error of ctx once it's done.
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
 	}
-	wg.Wait()
-	log.Println("Done")
 }
 
-func WaitReceipt(conn *ethclient.Client, txHash common.Hash, tryInterval time.Duration, tryTimes int, confirms int) (*types.Receipt, error) {
+// ReceiptBackend is what WaitReceipt needs from the rpc-server.
+type ReceiptBackend interface {
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+	BlockNumber(ctx context.Context) (uint64, error)
+}
+
+// HeadBackend is what WaitReceiptOnNewHead needs from the rpc-server.
+type HeadBackend interface {
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+}
+
+func WaitReceipt(ctx context.Context, conn ReceiptBackend, txHash common.Hash, tryInterval time.Duration, tryTimes int, confirms int) (*types.Receipt, error) {
 	ticker := time.NewTicker(tryInterval)
 	defer ticker.Stop()
 	i := 0
 	for {
 		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		case <-ticker.C:
 			i++
-			r, err := conn.TransactionReceipt(context.Background(), txHash)
+			tryCtx, span := startTry(ctx, txHash, i)
+			r, err := conn.TransactionReceipt(tryCtx, txHash)
 			if err != nil {
+				endSpan(span, err)
 				if i >= tryTimes {
 					return nil, err
 				}
-				log.Printf("can't get the receipt, err: %v\nwill try again.\n", err)
-			} else {
-				head, err := conn.BlockNumber(context.Background())
-				if err != nil {
-					return nil, err
-				}
-				confirm := head - r.BlockNumber.Uint64()
-				log.Printf("got receipt, txHash=%x, confirms=%d \n", txHash, confirm)
-				if confirm >= uint64(confirms) {
-					return r, nil
-				}
+				logger(ctx).Debug("Receipt not available, will try again", "err", err)
+				continue
+			}
+			head, err := conn.BlockNumber(tryCtx)
+			if err != nil {
+				endSpan(span, err)
+				return nil, err
+			}
+			confirm := confirmations(head, r)
+			endTry(span, confirm)
+			logger(ctx).Info("Got receipt", "block", r.BlockNumber, "confirms", confirm)
+			if confirm >= uint64(confirms) {
+				return r, nil
 			}
 		}
 	}
@@ -115,97 +343,124 @@ func WaitReceipt(conn *ethclient.Client, txHash common.Hash, tryInterval time.Du
 
 // If the rpc-server supports subscription,
 // then we can check the receipt only when there's a new block.
-func WaitReceiptOnNewHead(conn *ethclient.Client, txHash common.Hash, waitBlocks int, confirms int) (*types.Receipt, error) {
+func WaitReceiptOnNewHead(ctx context.Context, conn HeadBackend, txHash common.Hash, waitBlocks int, confirms int) (*types.Receipt, error) {
 	headers := make(chan *types.Header)
-	sub, err := conn.SubscribeNewHead(context.Background(), headers)
+	sub, err := conn.SubscribeNewHead(ctx, headers)
 	if err != nil {
-		log.Fatal("Error to SubscribeNewHead:", err)
+		return nil, fmt.Errorf("SubscribeNewHead: %w", err)
 	}
 	defer sub.Unsubscribe()
 	for i := 0; i < waitBlocks+confirms; i++ {
 		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		case err := <-sub.Err():
 			return nil, err
 		case header := <-headers:
-			r, err := conn.TransactionReceipt(context.Background(), txHash)
+			tryCtx, span := startTry(ctx, txHash, i+1)
+			r, err := conn.TransactionReceipt(tryCtx, txHash)
 			if err != nil {
-				log.Printf("can't get the receipt, err: %v\nwill try again.\n", err)
-			} else {
-				confirm := header.Number.Uint64() - r.BlockNumber.Uint64()
-				log.Printf("got receipt, txHash=%x, confirms=%d \n", txHash, confirm)
-				if confirm >= uint64(confirms) {
-					return r, nil
-				}
+				endSpan(span, err)
+				logger(ctx).Debug("Receipt not available, will try again", "err", err)
+				continue
+			}
+			confirm := confirmations(header.Number.Uint64(), r)
+			endTry(span, confirm)
+			logger(ctx).Info("Got receipt", "block", r.BlockNumber, "confirms", confirm)
+			if confirm >= uint64(confirms) {
+				return r, nil
 			}
 		}
 	}
 	return nil, errors.New("not found")
 }
 
-func WatchTransferEvent(wg *sync.WaitGroup, contract *EIP20) {
-	defer wg.Done()
+// startTry starts the span of one try of waiting for the receipt of txHash.
+func startTry(ctx context.Context, txHash common.Hash, attempt int) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "WaitReceipt.try", trace.WithAttributes(
+		attribute.String("eth.tx_hash", txHash.Hex()),
+		attribute.Int("attempt", attempt),
+	))
+}
+
+func endTry(span trace.Span, confirms uint64) {
+	span.SetAttributes(attribute.Int64("eth.confirmations", int64(confirms)))
+	span.End()
+}
+
+// confirmations counts the blocks on top of the receipt's block.
+// A lagging rpc-server may report a head below the receipt, which gives 0.
+func confirmations(head uint64, r *types.Receipt) uint64 {
+	if head < r.BlockNumber.Uint64() {
+		return 0
+	}
+	return head - r.BlockNumber.Uint64()
+}
+
+func WatchTransferEvent(ctx context.Context, contract *EIP20) error {
 	sink := make(chan *EIP20Transfer)
-	sub, err := contract.WatchTransfer(new(bind.WatchOpts), sink, []common.Address{}, []common.Address{})
+	sub, err := contract.WatchTransfer(&bind.WatchOpts{Context: ctx}, sink, []common.Address{}, []common.Address{})
 	if err != nil {
-		log.Printf("WatchTransfer failed: %v\n", err)
-		return
+		return fmt.Errorf("WatchTransfer: %w", err)
 	}
 	defer sub.Unsubscribe()
 	for {
 		select {
+		case <-ctx.Done():
+			return ctx.Err()
 		case err := <-sub.Err():
-			if err != nil {
-				log.Println("subscribe return: ", err)
-			}
-			return
+			return fmt.Errorf("subscribe return: %w", err)
 		case transfer := <-sink:
-			log.Printf("Got ERC20 transfer event at Block %d,\ntxHash: %s, from: %s, to: %s, value: %d\n",
-				transfer.Raw.BlockNumber, transfer.Raw.TxHash, transfer.From, transfer.To, transfer.Value)
-			log.Println("Stop watching")
-			sub.Unsubscribe()
+			deliverTransfer(ctx, logger(ctx), chainID, transfer)
+			logger(ctx).Info("Stop watching")
+			return nil
 		}
 	}
 }
 
-func FilterTransferEvent(wg *sync.WaitGroup, contract *EIP20, client *ethclient.Client, confirms int) {
-	defer wg.Done()
-
-	var start uint64
-	for {
-		num, err := client.BlockNumber(context.Background())
+// FilterTransferEvent polls the blocks confirms behind the head for a transfer event.
+// The next block to scan is kept in checkpoints under key, to resume from there.
+func FilterTransferEvent(ctx context.Context, contract *EIP20, client ReceiptBackend, confirms int, checkpoints *Checkpoints, key string) error {
+	start, ok := checkpoints.Get(key)
+	for !ok {
+		num, err := client.BlockNumber(ctx)
 		if err != nil {
-			log.Printf("Get BlockNumber failed: %v\n", err)
-			return
+			return fmt.Errorf("get BlockNumber: %w", err)
 		}
 		if num > uint64(confirms) {
 			start = num - uint64(confirms)
 			break
 		}
-		time.Sleep(3 * time.Second)
+		if err := sleep(ctx, 3*time.Second); err != nil {
+			return err
+		}
 	}
 	for {
+		checkpoints.Set(key, start)
 		isOk := false
+		next := start
 		end := start + 1
-		opts := bind.FilterOpts{Start: start, End: &end}
+		opts := bind.FilterOpts{Start: start, End: &end, Context: ctx}
 		it, err := contract.FilterTransfer(&opts, []common.Address{}, []common.Address{})
 		if err != nil {
-			log.Printf("FilterTransfer failed: %v\n", err)
-			return
+			return fmt.Errorf("FilterTransfer: %w", err)
 		}
 
 		for it.Next() {
 			transfer := it.Event
-			log.Printf("Got ERC20 transfer event at Block %d,\ntxHash: %s, from: %s, to: %s, value: %d\n",
-				transfer.Raw.BlockNumber, transfer.Raw.TxHash, transfer.From, transfer.To, transfer.Value)
-			log.Println("Close filter")
+			deliverTransfer(ctx, logger(ctx), chainID, transfer)
+			logger(ctx).Info("Close filter")
 			it.Close()
 			isOk = true
+			next = transfer.Raw.BlockNumber + 1
 		}
 		if isOk {
-			return
+			checkpoints.Set(key, next)
+			return nil
 		}
 		start++ // next block
-		time.Sleep(3 * time.Second)
+		if err := sleep(ctx, 3*time.Second); err != nil {
+			return err
+		}
 	}
-
 }
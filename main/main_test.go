@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestDeployEIP20(t *testing.T) {
+	env := newTestEnv(t, 1)
+	addr, tx, contract := env.deploy(1e18)
+
+	receipt := env.requireSuccess(tx)
+	if receipt.ContractAddress != addr {
+		t.Fatalf("contract address %s, want %s", receipt.ContractAddress, addr)
+	}
+	name, _ := contract.Name(nil)
+	symbol, _ := contract.Symbol(nil)
+	decimals, _ := contract.Decimals(nil)
+	if name != "dtoken" || symbol != "dt" || decimals != 8 {
+		t.Fatalf("got name=%q symbol=%q decimals=%d", name, symbol, decimals)
+	}
+	supply, err := contract.TotalSupply(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if supply.Cmp(big.NewInt(1e18)) != 0 {
+		t.Fatalf("total supply %s", supply)
+	}
+	env.requireBalance(contract, env.accounts[0].addr, 1e18)
+}
+
+func TestTransfer(t *testing.T) {
+	env := newTestEnv(t, 1)
+	_, _, contract := env.deploy(1000)
+	owner, to := env.accounts[0], common.Address{0x11}
+
+	tx, err := contract.Transfer(owner.transactor(t), to, big.NewInt(300))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.backend.Commit()
+	receipt := env.requireSuccess(tx)
+
+	env.requireBalance(contract, owner.addr, 700)
+	env.requireBalance(contract, to, 300)
+	if len(receipt.Logs) != 1 {
+		t.Fatalf("got %d logs, want 1", len(receipt.Logs))
+	}
+	event, err := contract.ParseTransfer(*receipt.Logs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.From != owner.addr || event.To != to || event.Value.Int64() != 300 {
+		t.Fatalf("unexpected event %+v", event)
+	}
+}
+
+func TestApproveTransferFrom(t *testing.T) {
+	env := newTestEnv(t, 2)
+	_, _, contract := env.deploy(1000)
+	owner, spender, to := env.accounts[0], env.accounts[1], common.Address{0x22}
+
+	tx, err := contract.Approve(owner.transactor(t), spender.addr, big.NewInt(500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.backend.Commit()
+	env.requireSuccess(tx)
+	allowance, _ := contract.Allowance(nil, owner.addr, spender.addr)
+	if allowance.Int64() != 500 {
+		t.Fatalf("allowance %s, want 500", allowance)
+	}
+
+	tx, err = contract.TransferFrom(spender.transactor(t), owner.addr, to, big.NewInt(200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.backend.Commit()
+	env.requireSuccess(tx)
+	env.requireBalance(contract, owner.addr, 800)
+	env.requireBalance(contract, to, 200)
+	allowance, _ = contract.Allowance(nil, owner.addr, spender.addr)
+	if allowance.Int64() != 300 {
+		t.Fatalf("allowance %s, want 300", allowance)
+	}
+
+	// more than the remaining allowance
+	if _, err := contract.TransferFrom(spender.transactor(t), owner.addr, to, big.NewInt(301)); err == nil {
+		t.Fatal("transferFrom above the allowance succeeded")
+	}
+}
+
+func TestTransferInsufficientBalance(t *testing.T) {
+	env := newTestEnv(t, 2)
+	_, _, contract := env.deploy(1000)
+	poor := env.accounts[1]
+
+	// the gas estimation catches the revert
+	if _, err := contract.Transfer(poor.transactor(t), common.Address{0x11}, big.NewInt(1)); err == nil {
+		t.Fatal("transfer without balance succeeded")
+	}
+
+	// with a fixed gas limit the tx is mined and fails
+	auth := poor.transactor(t)
+	auth.GasLimit = 100000
+	tx, err := contract.Transfer(auth, common.Address{0x11}, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.backend.Commit()
+	receipt, err := env.backend.TransactionReceipt(context.Background(), tx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Status != types.ReceiptStatusFailed {
+		t.Fatal("transfer without balance is mined successfully")
+	}
+	env.requireBalance(contract, common.Address{0x11}, 0)
+}
+
+func TestFilterTransfer(t *testing.T) {
+	env := newTestEnv(t, 2)
+	_, _, contract := env.deploy(1000)
+	owner, other := env.accounts[0], env.accounts[1]
+
+	for _, to := range []common.Address{other.addr, {0x11}, other.addr} {
+		if _, err := contract.Transfer(owner.transactor(t), to, big.NewInt(10)); err != nil {
+			t.Fatal(err)
+		}
+		env.backend.Commit()
+	}
+
+	it, err := contract.FilterTransfer(&bind.FilterOpts{Start: 0}, []common.Address{owner.addr}, []common.Address{other.addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	var blocks []uint64
+	for it.Next() {
+		if it.Event.To != other.addr {
+			t.Fatalf("filter returned a transfer to %s", it.Event.To)
+		}
+		blocks = append(blocks, it.Event.Raw.BlockNumber)
+	}
+	if it.Error() != nil {
+		t.Fatal(it.Error())
+	}
+	if len(blocks) != 2 || blocks[0] != 2 || blocks[1] != 4 {
+		t.Fatalf("got transfers at blocks %v, want [2 4]", blocks)
+	}
+}
+
+func TestFilterTransferEvent(t *testing.T) {
+	env := newTestEnv(t, 1)
+	_, _, contract := env.deploy(1000)
+	if _, err := contract.Transfer(env.accounts[0].transactor(t), common.Address{0x11}, big.NewInt(10)); err != nil {
+		t.Fatal(err)
+	}
+	env.backend.Commit()
+
+	checkpoints, err := LoadCheckpoints(filepath.Join(t.TempDir(), CheckpointFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := goAsync(func() error {
+		return FilterTransferEvent(context.Background(), contract, env.backend, 0, checkpoints, "dtoken")
+	})
+	if err := waitResult(t, done, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// resume after the transfer
+	if next, _ := checkpoints.Get("dtoken"); next != 3 {
+		t.Fatalf("checkpoint at block %d, want 3", next)
+	}
+}
+
+func TestFilterTransferEventResume(t *testing.T) {
+	env := newTestEnv(t, 1)
+	_, _, contract := env.deploy(1000)
+	for i := 0; i < 3; i++ {
+		if _, err := contract.Transfer(env.accounts[0].transactor(t), common.Address{0x11}, big.NewInt(10)); err != nil {
+			t.Fatal(err)
+		}
+		env.backend.Commit()
+	}
+
+	// the transfers are at blocks 2 to 4, without the checkpoint the poller
+	// would start at the head and resume at block 5
+	checkpoints, _ := LoadCheckpoints(filepath.Join(t.TempDir(), CheckpointFile))
+	checkpoints.Set("dtoken", 2)
+	done := goAsync(func() error {
+		return FilterTransferEvent(context.Background(), contract, env.backend, 0, checkpoints, "dtoken")
+	})
+	if err := waitResult(t, done, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if next, _ := checkpoints.Get("dtoken"); next != 4 {
+		t.Fatalf("checkpoint at block %d, want 4", next)
+	}
+}
+
+func TestFilterTransferEventCancel(t *testing.T) {
+	env := newTestEnv(t, 1)
+	_, _, contract := env.deploy(1000)
+	checkpoints, _ := LoadCheckpoints(filepath.Join(t.TempDir(), CheckpointFile))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := goAsync(func() error {
+		return FilterTransferEvent(ctx, contract, env.backend, 0, checkpoints, "dtoken")
+	})
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := waitResult(t, done, time.Second); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	// the scanned block is kept
+	if next, ok := checkpoints.Get("dtoken"); !ok || next != 1 {
+		t.Fatalf("checkpoint at block %d, want 1", next)
+	}
+}
+
+func TestWatchTransferEvent(t *testing.T) {
+	env := newTestEnv(t, 1)
+	_, _, contract := env.deploy(1000)
+
+	done := goAsync(func() error {
+		return WatchTransferEvent(context.Background(), contract)
+	})
+
+	// keep transferring until the watcher has subscribed and seen one
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			return
+		case <-timeout:
+			t.Fatal("watcher didn't return")
+		case <-ticker.C:
+			if _, err := contract.Transfer(env.accounts[0].transactor(t), common.Address{0x11}, big.NewInt(1)); err != nil {
+				t.Fatal(err)
+			}
+			env.backend.Commit()
+		}
+	}
+}
+
+func TestWaitReceipt(t *testing.T) {
+	env := newTestEnv(t, 1)
+	_, _, contract := env.deploy(1000)
+	tx, err := contract.Transfer(env.accounts[0].transactor(t), common.Address{0x11}, big.NewInt(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.mine(20 * time.Millisecond)
+
+	receipt, err := WaitReceipt(context.Background(), env.backend, tx.Hash(), 10*time.Millisecond, 50, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, _ := env.backend.BlockNumber(context.Background())
+	if head-receipt.BlockNumber.Uint64() < 2 {
+		t.Fatalf("receipt at block %d returned at head %d", receipt.BlockNumber, head)
+	}
+}
+
+func TestWaitReceiptNotFound(t *testing.T) {
+	env := newTestEnv(t, 1)
+	env.mine(20 * time.Millisecond)
+
+	if _, err := WaitReceipt(context.Background(), env.backend, common.Hash{0x1}, 10*time.Millisecond, 3, 1); err == nil {
+		t.Fatal("got a receipt of an unknown tx")
+	}
+	if _, err := WaitReceiptOnNewHead(context.Background(), env.backend, common.Hash{0x1}, 2, 1); err == nil {
+		t.Fatal("got a receipt of an unknown tx")
+	}
+}
+
+func TestWaitReceiptOnNewHead(t *testing.T) {
+	env := newTestEnv(t, 1)
+	_, _, contract := env.deploy(1000)
+	tx, err := contract.Transfer(env.accounts[0].transactor(t), common.Address{0x11}, big.NewInt(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.mine(20 * time.Millisecond)
+
+	receipt, err := WaitReceiptOnNewHead(context.Background(), env.backend, tx.Hash(), 5, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		t.Fatal("transfer failed")
+	}
+}
+
+func TestWatchTransferEventCancel(t *testing.T) {
+	env := newTestEnv(t, 1)
+	_, _, contract := env.deploy(1000)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := goAsync(func() error {
+		return WatchTransferEvent(ctx, contract)
+	})
+	cancel()
+	if err := waitResult(t, done, time.Second); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestWaitReceiptCancel(t *testing.T) {
+	env := newTestEnv(t, 1)
+	env.mine(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := WaitReceipt(ctx, env.backend, common.Hash{0x1}, 10*time.Millisecond, 1000, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+	if _, err := WaitReceiptOnNewHead(ctx, env.backend, common.Hash{0x1}, 1000, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestWithGrace(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	graceCtx, stop := withGrace(ctx)
+	defer stop()
+
+	cancel()
+	select {
+	case <-graceCtx.Done():
+		t.Fatal("the grace context is done with its parent")
+	case <-time.After(50 * time.Millisecond):
+	}
+	stop()
+	<-graceCtx.Done()
+}
+
+// goAsync runs f in a goroutine, and sends its error on the returned channel.
+func goAsync(f func() error) <-chan error {
+	ch := make(chan error, 1)
+	go func() { ch <- f() }()
+	return ch
+}
+
+func waitResult(t *testing.T, ch <-chan error, timeout time.Duration) error {
+	t.Helper()
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(timeout):
+		t.Fatal("timed out waiting for the goroutine")
+		return nil
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// BalanceLeaf is the Merkle leaf of amount for account:
+// keccak256(abi.encodePacked(account, uint256(amount))).
+func BalanceLeaf(account common.Address, amount *big.Int) common.Hash {
+	return crypto.Keccak256Hash(account.Bytes(), common.LeftPadBytes(amount.Bytes(), 32))
+}
+
+// hashPair hashes the pair sorted, so the proofs don't need to tell left from right.
+func hashPair(a, b common.Hash) common.Hash {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+	return crypto.Keccak256Hash(a[:], b[:])
+}
+
+// MerkleTree is a binary tree of sorted pair hashes, like OpenZeppelin's MerkleProof
+// verifies. An odd node is carried up to the next layer as is.
+type MerkleTree struct {
+	layers [][]common.Hash
+}
+
+// NewMerkleTree builds the tree of the leaves, in their order.
+func NewMerkleTree(leaves []common.Hash) *MerkleTree {
+	t := &MerkleTree{layers: [][]common.Hash{leaves}}
+	for layer := leaves; len(layer) > 1; {
+		next := make([]common.Hash, 0, (len(layer)+1)/2)
+		for i := 0; i < len(layer); i += 2 {
+			if i+1 == len(layer) {
+				next = append(next, layer[i])
+			} else {
+				next = append(next, hashPair(layer[i], layer[i+1]))
+			}
+		}
+		t.layers = append(t.layers, next)
+		layer = next
+	}
+	return t
+}
+
+// Root returns the root hash, zero for a tree without leaves.
+func (t *MerkleTree) Root() common.Hash {
+	top := t.layers[len(t.layers)-1]
+	if len(top) == 0 {
+		return common.Hash{}
+	}
+	return top[0]
+}
+
+// Proof returns the sibling hashes from the leaf at index i up to the root.
+func (t *MerkleTree) Proof(i int) []common.Hash {
+	proof := []common.Hash{}
+	for _, layer := range t.layers[:len(t.layers)-1] {
+		if sibling := i ^ 1; sibling < len(layer) {
+			proof = append(proof, layer[sibling])
+		}
+		i /= 2
+	}
+	return proof
+}
+
+// VerifyMerkleProof tells if proof takes leaf up to root.
+func VerifyMerkleProof(root, leaf common.Hash, proof []common.Hash) bool {
+	for _, sibling := range proof {
+		leaf = hashPair(leaf, sibling)
+	}
+	return leaf == root
+}
+
+// MerkleClaim is the amount of an account, with the proof of its leaf.
+type MerkleClaim struct {
+	Account common.Address `json:"account"`
+	Amount  *big.Int       `json:"amount"`
+	Leaf    common.Hash    `json:"leaf"`
+	Proof   []common.Hash  `json:"proof"`
+}
+
+// MerkleDistribution is the Merkle tree of the (account, amount) leaves, with
+// the proof of every leaf.
+type MerkleDistribution struct {
+	Root   common.Hash    `json:"root"`
+	Total  *big.Int       `json:"total"`
+	Claims []*MerkleClaim `json:"claims"`
+}
+
+// NewMerkleDistribution builds the tree of the balances, leaves in their order.
+func NewMerkleDistribution(balances []*HolderBalance) *MerkleDistribution {
+	leaves := make([]common.Hash, len(balances))
+	total := new(big.Int)
+	for i, b := range balances {
+		leaves[i] = BalanceLeaf(b.Address, b.Balance)
+		total.Add(total, b.Balance)
+	}
+	tree := NewMerkleTree(leaves)
+	d := &MerkleDistribution{Root: tree.Root(), Total: total, Claims: make([]*MerkleClaim, len(balances))}
+	for i, b := range balances {
+		d.Claims[i] = &MerkleClaim{Account: b.Address, Amount: b.Balance, Leaf: leaves[i], Proof: tree.Proof(i)}
+	}
+	return d
+}
+
+// Claim returns the claim of account, or nil.
+func (d *MerkleDistribution) Claim(account common.Address) *MerkleClaim {
+	for _, c := range d.Claims {
+		if c.Account == account {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "dapp"
+
+var (
+	rpcCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rpc_calls_total",
+		Help:      "JSON-RPC calls by method, endpoint and status.",
+	}, []string{"method", "endpoint", "status"})
+	rpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "rpc_call_duration_seconds",
+		Help:      "Latency of the JSON-RPC calls by method and endpoint.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"method", "endpoint"})
+
+	txSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tx_sent_total",
+		Help:      "Transactions sent.",
+	}, []string{"chain"})
+	txConfirmed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tx_confirmed_total",
+		Help:      "Transactions confirmed successfully.",
+	}, []string{"chain"})
+	txFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tx_failed_total",
+		Help:      "Transactions reverted, or whose receipt could not be waited for.",
+	}, []string{"chain", "reason"})
+	txConfirmSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "tx_confirmation_seconds",
+		Help:      "Time from waiting for the receipt to its confirmation.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	}, []string{"chain"})
+	txGasUsed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tx_gas_used_total",
+		Help:      "Gas used by the mined transactions.",
+	}, []string{"chain"})
+	txFeesWei = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tx_fees_wei_total",
+		Help:      "Fees paid by the mined transactions, in wei.",
+	}, []string{"chain"})
+	nonceGap = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "account_nonce_gap",
+		Help:      "Pending nonce minus the latest nonce, the transactions not mined yet.",
+	}, []string{"chain", "account"})
+
+	chainHead = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "chain_head_block",
+		Help:      "Latest block number seen.",
+	}, []string{"chain"})
+	indexerBlock = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "indexer_block",
+		Help:      "Last block scanned by the indexer.",
+	}, []string{"chain"})
+	indexerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "indexer_head_lag_blocks",
+		Help:      "Blocks between the chain head and the last block scanned by the indexer.",
+	}, []string{"chain"})
+	subscriptionReconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "subscription_reconnects_total",
+		Help:      "Subscriptions made again after a failure.",
+	}, []string{"chain", "subscription"})
+	webhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Webhook delivery attempts by outcome: delivered, retry or dead.",
+	}, []string{"status"})
+	depositsCredited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "deposits_credited_total",
+		Help:      "Deposits credited by token contract.",
+	}, []string{"chain", "contract"})
+	sweepTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "sweep_transitions_total",
+		Help:      "Deposit address sweeps entering each state.",
+	}, []string{"chain", "state"})
+	reconcileDiscrepancies = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_discrepancies",
+		Help:      "Book amounts differing from the chain at the last reconciliation, by token contract.",
+	}, []string{"chain", "contract"})
+)
+
+// endpointLabel keeps the scheme and host of an rpc url, dropping the path
+// and credentials, which often carry API keys.
+func endpointLabel(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host == "" {
+		return "ipc"
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// DefaultNetwork is the name of the network of the top level rpcUrl of the config.
+const DefaultNetwork = "default"
+
+var (
+	// ErrUnknownNetwork is returned for a network which is not a profile of the config.
+	ErrUnknownNetwork = errors.New("unknown network")
+	// ErrWrongChain is returned when an rpc-server is not on the chain of its network.
+	ErrWrongChain = errors.New("rpc-server on the wrong chain")
+)
+
+// NetworkConfig is a chain the commands may run on, a profile of the config.
+type NetworkConfig struct {
+	// RpcUrls are tried in order, the first one answering on ChainID is used
+	RpcUrls []string `json:"rpcUrls"`
+	// ChainID is the chain the rpc-servers must be on, not checked if 0
+	ChainID uint64 `json:"chainId,omitempty"`
+	// Confirms is the confirmation depth on this chain, the default of each command if 0
+	Confirms uint64    `json:"confirms,omitempty"`
+	Fee      FeeConfig `json:"fee"`
+	// Tokens are the addresses of the tokens on this chain by name, looked up before the registry
+	Tokens map[string]common.Address `json:"tokens,omitempty"`
+}
+
+// FeeConfig is how the txs of a network are priced, in wei.
+type FeeConfig struct {
+	// Legacy sends gasPrice txs, for the chains without EIP-1559
+	Legacy bool `json:"legacy,omitempty"`
+	// GasPrice is the price of the legacy txs, suggested by the rpc-server by default
+	GasPrice *big.Int `json:"gasPrice,omitempty"`
+	// TipCap is the max priority fee per gas, 1 gwei by default
+	TipCap *big.Int `json:"tipCap,omitempty"`
+	// FeeCap is the max fee per gas, the tip and twice the base fee by default
+	FeeCap *big.Int `json:"feeCap,omitempty"`
+}
+
+// GasPriceBackend suggests the gas price of the legacy txs.
+type GasPriceBackend interface {
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+}
+
+func (n *NetworkConfig) check() error {
+	if len(n.RpcUrls) == 0 {
+		return errors.New("no rpcUrls")
+	}
+	if n.Fee.Legacy && (n.Fee.TipCap != nil || n.Fee.FeeCap != nil) {
+		return errors.New("legacy fee with a tipCap or feeCap")
+	}
+	if !n.Fee.Legacy && n.Fee.GasPrice != nil {
+		return errors.New("gasPrice of a fee which is not legacy")
+	}
+	return nil
+}
+
+// confirms returns the confirmation depth of the network, def if it has none.
+func (n *NetworkConfig) confirms(def uint64) uint64 {
+	if n.Confirms > 0 {
+		return n.Confirms
+	}
+	return def
+}
+
+// CheckChainID returns ErrWrongChain if id is not the chain of the network.
+func (n *NetworkConfig) CheckChainID(id *big.Int) error {
+	if n.ChainID != 0 && (!id.IsUint64() || id.Uint64() != n.ChainID) {
+		return fmt.Errorf("%w: chain %s, want %d", ErrWrongChain, id, n.ChainID)
+	}
+	return nil
+}
+
+// apply prices auth, asking backend for the gas price of a legacy tx when the fee has none.
+func (f *FeeConfig) apply(ctx context.Context, auth *bind.TransactOpts, backend GasPriceBackend) error {
+	auth.GasPrice, auth.GasTipCap, auth.GasFeeCap = nil, nil, nil
+	if f.Legacy {
+		if f.GasPrice != nil {
+			auth.GasPrice = new(big.Int).Set(f.GasPrice)
+			return nil
+		}
+		price, err := backend.SuggestGasPrice(ctx)
+		if err != nil {
+			return fmt.Errorf("suggest gas price: %w", err)
+		}
+		auth.GasPrice = price
+		return nil
+	}
+	auth.GasTipCap = big.NewInt(1 * params.GWei)
+	if f.TipCap != nil {
+		auth.GasTipCap = new(big.Int).Set(f.TipCap)
+	}
+	if f.FeeCap != nil {
+		auth.GasFeeCap = new(big.Int).Set(f.FeeCap)
+	}
+	return nil
+}
+
+// maxFeePerGas returns the most auth pays per gas: its gas price, or else its
+// fee cap, filled in from the base fee of the head like bind does when auth has none.
+func maxFeePerGas(ctx context.Context, backend bind.ContractTransactor, auth *bind.TransactOpts) (*big.Int, error) {
+	if auth.GasPrice != nil {
+		return auth.GasPrice, nil
+	}
+	if auth.GasFeeCap != nil {
+		return auth.GasFeeCap, nil
+	}
+	head, err := backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if head.BaseFee == nil {
+		return nil, errors.New("the chain has no base fee, its network needs a legacy fee")
+	}
+	if auth.GasTipCap == nil {
+		if auth.GasTipCap, err = backend.SuggestGasTipCap(ctx); err != nil {
+			return nil, err
+		}
+	}
+	auth.GasFeeCap = new(big.Int).Mul(head.BaseFee, big.NewInt(2))
+	auth.GasFeeCap.Add(auth.GasFeeCap, auth.GasTipCap)
+	return auth.GasFeeCap, nil
+}
+
+// Chain is a network connected to, with the accounts and tokens on it.
+type Chain struct {
+	Name    string
+	Network *NetworkConfig
+	Client  *Client
+	ChainID *big.Int
+	// Signers are the accounts of the config, with nonces of their own on this chain
+	Signers *Accounts
+	Tokens  *TokenService
+	// Capabilities are those probed of the rpc-server
+	Capabilities Capabilities
+}
+
+// ConnectNetwork dials the rpc-servers of n in order, until one answers on
+// the chain of n, and gives accounts a NonceManager of that rpc-server.
+func ConnectNetwork(ctx context.Context, name string, n *NetworkConfig, accounts []*Account) (*Chain, error) {
+	var err error
+	for _, url := range n.RpcUrls {
+		var c *Chain
+		if c, err = connect(ctx, url, n); err != nil {
+			log.Warn("Connect failed", "network", name, "endpoint", endpointLabel(url), "err", err)
+			continue
+		}
+		c.Name = name
+		// the accounts are shared by the chains, their nonces are not
+		own := make([]*Account, len(accounts))
+		for i, acc := range accounts {
+			a := *acc
+			own[i] = &a
+		}
+		if c.Signers, err = NewAccounts(c.Client, own); err != nil {
+			c.Client.Close()
+			return nil, fmt.Errorf("load accounts: %w", err)
+		}
+		log.Info("Connected", "network", name, "endpoint", c.Client.endpoint, "transport", c.Client.Transport,
+			"subscribe", c.Capabilities.Subscribe, "chainId", c.ChainID)
+		return c, nil
+	}
+	return nil, fmt.Errorf("network %s: %w", name, err)
+}
+
+func connect(ctx context.Context, url string, n *NetworkConfig) (*Chain, error) {
+	client, err := Dial(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	id, err := client.ChainID(ctx)
+	if err == nil {
+		err = n.CheckChainID(id)
+	}
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &Chain{Network: n, Client: client, ChainID: id, Capabilities: client.Probe(ctx)}, nil
+}
+
+// SelectNetworks returns the names of networks, or else the network of the config,
+// the DefaultNetwork or the only network.
+func (c *Config) SelectNetworks(networks []string) ([]string, error) {
+	if len(networks) == 0 {
+		switch {
+		case c.Network != "":
+			networks = []string{c.Network}
+		case c.Networks[DefaultNetwork] != nil:
+			networks = []string{DefaultNetwork}
+		case len(c.Networks) == 1:
+			for name := range c.Networks {
+				networks = []string{name}
+			}
+		default:
+			return nil, fmt.Errorf("choose a network of %v with -network", c.NetworkNames())
+		}
+	}
+	seen := make(map[string]bool)
+	for _, name := range networks {
+		if c.Networks[name] == nil {
+			return nil, fmt.Errorf("%w %q", ErrUnknownNetwork, name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("network %s is chosen twice", name)
+		}
+		seen[name] = true
+	}
+	return networks, nil
+}
+
+// NetworkNames returns the names of the networks of the config, sorted.
+func (c *Config) NetworkNames() []string {
+	var names []string
+	for name := range c.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestLoadNetworks(t *testing.T) {
+	var conf Config
+	err := json.Unmarshal([]byte(`{
+		"rpcUrl": "ws://localhost:8546",
+		"networks": {
+			"sepolia": {
+				"rpcUrls": ["https://a.example", "https://b.example"],
+				"chainId": 11155111,
+				"confirms": 6,
+				"fee": {"tipCap": 2000000000, "feeCap": 50000000000},
+				"tokens": {"dtoken": "0x1100000000000000000000000000000000000000"}
+			},
+			"bsc": {"rpcUrls": ["https://c.example"], "chainId": 56, "fee": {"legacy": true}}
+		}
+	}`), &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.loadNetworks(); err != nil {
+		t.Fatal(err)
+	}
+	if names := conf.NetworkNames(); strings.Join(names, ",") != "bsc,default,sepolia" {
+		t.Fatalf("networks %v", names)
+	}
+	sepolia := conf.Networks["sepolia"]
+	if sepolia.confirms(3) != 6 || conf.Networks[DefaultNetwork].confirms(3) != 3 ||
+		sepolia.Fee.TipCap.Int64() != 2e9 || sepolia.Tokens["dtoken"] != (common.Address{0x11}) {
+		t.Fatalf("loaded %+v", sepolia)
+	}
+	if err := sepolia.CheckChainID(big.NewInt(1)); !errors.Is(err, ErrWrongChain) {
+		t.Errorf("chain 1 is sepolia: %v", err)
+	}
+	if err := conf.Networks[DefaultNetwork].CheckChainID(big.NewInt(1)); err != nil {
+		t.Errorf("the chain of the default network is checked: %v", err)
+	}
+
+	for _, tc := range []struct {
+		network string
+		chosen  []string
+		want    string
+	}{
+		{"", nil, "default"},
+		{"bsc", nil, "bsc"},
+		{"bsc", []string{"sepolia", "default"}, "sepolia,default"},
+	} {
+		conf.Network = tc.network
+		names, err := conf.SelectNetworks(tc.chosen)
+		if err != nil || strings.Join(names, ",") != tc.want {
+			t.Errorf("selected %v of %v with network %q: %v", names, tc.chosen, tc.network, err)
+		}
+	}
+	conf.Network = ""
+	if _, err := conf.SelectNetworks([]string{"mainnet"}); !errors.Is(err, ErrUnknownNetwork) {
+		t.Errorf("selected an unknown network: %v", err)
+	}
+	if _, err := conf.SelectNetworks([]string{"bsc", "bsc"}); err == nil {
+		t.Error("selected a network twice")
+	}
+	delete(conf.Networks, DefaultNetwork)
+	if _, err := conf.SelectNetworks(nil); err == nil {
+		t.Error("selected a network of two without a default")
+	}
+
+	for _, bad := range []*Config{
+		{},
+		{Networks: map[string]*NetworkConfig{"a": {}}},
+		{Networks: map[string]*NetworkConfig{"a": {RpcUrls: []string{"x"}, Fee: FeeConfig{Legacy: true, TipCap: big.NewInt(1)}}}},
+		{Networks: map[string]*NetworkConfig{"a": {RpcUrls: []string{"x"}, Fee: FeeConfig{GasPrice: big.NewInt(1)}}}},
+		{RpcUrl: "x", Networks: map[string]*NetworkConfig{DefaultNetwork: {RpcUrls: []string{"y"}}}},
+		{RpcUrl: "x", Network: "b"},
+	} {
+		if err := bad.loadNetworks(); err == nil {
+			t.Errorf("loaded %+v", bad)
+		}
+	}
+}
+
+func TestConnectNetwork(t *testing.T) {
+	env := newTestEnv(t, 1)
+	srv := newSimRPCServer(env, true)
+	accounts := []*Account{{Name: DefaultAccount, Address: env.accounts[0].addr, Key: env.accounts[0].key}}
+	ctx := context.Background()
+
+	// the first rpc-server is down, the second one is used
+	n := &NetworkConfig{RpcUrls: []string{"http://127.0.0.1:1", srv.URL}, ChainID: simChainID.Uint64()}
+	c, err := ConnectNetwork(ctx, "sim", n, accounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Client.Close()
+	if c.Name != "sim" || c.ChainID.Cmp(simChainID) != 0 || c.Capabilities.Subscribe {
+		t.Fatalf("connected %+v", c)
+	}
+	acc := c.Signers.Default()
+	if acc == accounts[0] || acc.Nonces == nil || accounts[0].Nonces != nil {
+		t.Fatal("the accounts share their nonces with the other chains")
+	}
+
+	n.ChainID = 1
+	if _, err := ConnectNetwork(ctx, "mainnet", n, accounts); !errors.Is(err, ErrWrongChain) {
+		t.Fatalf("connected to chain %s as mainnet: %v", simChainID, err)
+	}
+}
+
+func TestFeeConfig(t *testing.T) {
+	env := newTestEnv(t, 1)
+	_, _, contract := env.deploy(10000)
+	ctx := context.Background()
+	for _, tc := range []struct {
+		fee  FeeConfig
+		want func(tx *types.Transaction) bool
+	}{
+		{FeeConfig{}, func(tx *types.Transaction) bool {
+			return tx.Type() == types.DynamicFeeTxType && tx.GasTipCap().Int64() == 1e9
+		}},
+		{FeeConfig{TipCap: big.NewInt(2e9), FeeCap: big.NewInt(9e9)}, func(tx *types.Transaction) bool {
+			return tx.Type() == types.DynamicFeeTxType && tx.GasTipCap().Int64() == 2e9 && tx.GasFeeCap().Int64() == 9e9
+		}},
+		{FeeConfig{Legacy: true, GasPrice: big.NewInt(7e9)}, func(tx *types.Transaction) bool {
+			return tx.Type() == types.LegacyTxType && tx.GasPrice().Int64() == 7e9
+		}},
+		// the gas price suggested by the backend
+		{FeeConfig{Legacy: true}, func(tx *types.Transaction) bool {
+			return tx.Type() == types.LegacyTxType && tx.GasPrice().Sign() > 0
+		}},
+	} {
+		auth := env.accounts[0].transactor(t)
+		if err := tc.fee.apply(ctx, auth, env.backend); err != nil {
+			t.Fatal(err)
+		}
+		tx, err := contract.Transfer(auth, common.Address{0x11}, big.NewInt(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.backend.Commit()
+		env.requireSuccess(tx)
+		if !tc.want(tx) {
+			t.Errorf("fee %+v sent a tx of type %d, tip %s, fee cap %s", tc.fee, tx.Type(), tx.GasTipCap(), tx.GasFeeCap())
+		}
+	}
+}
+
+func TestTokenServiceNetworkAddresses(t *testing.T) {
+	reg := &Registry{}
+	s := &TokenService{ChainID: simChainID, Registry: reg, Addresses: map[string]common.Address{"dtoken": {0x11}}}
+	if addr, err := s.TokenAddress("dtoken"); err != nil || addr != (common.Address{0x11}) {
+		t.Fatalf("dtoken is %s: %v", addr, err)
+	}
+	if _, err := s.TokenAddress("other"); err == nil {
+		t.Fatal("found a token of no network nor registry")
+	}
+	if names := s.Names(); len(names) != 1 || names[0] != "dtoken" {
+		t.Fatalf("names %v", names)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// NonceBackend is what the NonceManager needs from the rpc-server.
+type NonceBackend interface {
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+}
+
+// NonceManager hands out the nonces of an account which sends txs faster than
+// they are mined, or which signs txs before sending them, where the pending
+// nonce of the rpc-server falls behind.
+type NonceManager struct {
+	Backend NonceBackend
+	Account common.Address
+
+	mu    sync.Mutex
+	next  uint64
+	known bool
+	// held are the nonces of txs signed but not sent yet, which the rpc-server doesn't count
+	held map[uint64]bool
+}
+
+// Next returns the next nonce, starting at the pending nonce of the rpc-server.
+func (m *NonceManager) Next(ctx context.Context) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.known {
+		pending, err := m.Backend.PendingNonceAt(ctx, m.Account)
+		if err != nil {
+			return 0, err
+		}
+		if pending > m.next {
+			m.next = pending
+		}
+		m.known = true
+	}
+	n := m.next
+	m.next++
+	return n, nil
+}
+
+// Observe records a nonce used already, like that of a tx signed before a restart.
+func (m *NonceManager) Observe(nonce uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if nonce >= m.next {
+		m.next = nonce + 1
+	}
+}
+
+// Hold keeps nonce used over the resets, for a tx signed but not sent yet.
+func (m *NonceManager) Hold(nonce uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.held == nil {
+		m.held = make(map[uint64]bool)
+	}
+	m.held[nonce] = true
+	if nonce >= m.next {
+		m.next = nonce + 1
+	}
+}
+
+// Release leaves nonce to the rpc-server, once its tx is sent.
+func (m *NonceManager) Release(nonce uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.held, nonce)
+}
+
+// Reset asks the rpc-server for the pending nonce again, after a nonce is
+// handed out but not used, or used by another sender. The held nonces stay used.
+func (m *NonceManager) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.known, m.next = false, 0
+	for nonce := range m.held {
+		if nonce >= m.next {
+			m.next = nonce + 1
+		}
+	}
+}
+
+// defaultDropTimeout is how long a tx unknown to the rpc-server is waited for, by default.
+const defaultDropTimeout = 10 * time.Minute
+
+var (
+	// ErrTxDropped is returned for a sent tx which won't be mined: another tx
+	// took its nonce, or the rpc-server forgot it.
+	ErrTxDropped = errors.New("tx dropped")
+	// ErrNonceTaken is a dropped tx which can't be sent again, another tx took its nonce.
+	ErrNonceTaken = fmt.Errorf("%w, its nonce is taken", ErrTxDropped)
+)
+
+// SentTxBackend is what sentReceipt needs from the rpc-server.
+type SentTxBackend interface {
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+// sentReceipt returns the receipt of the tx of hash, sent by from with nonce at
+// sent, nil until it's mined. The tx is dropped once the nonce of from is past
+// nonce without it, or once the rpc-server hasn't known it for timeout. A zero
+// from skips the nonce check.
+func sentReceipt(ctx context.Context, backend SentTxBackend, hash common.Hash, from common.Address, nonce uint64, sent time.Time, timeout time.Duration) (*types.Receipt, error) {
+	receipt, err := backend.TransactionReceipt(ctx, hash)
+	if !errors.Is(err, ethereum.NotFound) {
+		return receipt, err
+	}
+	if from != (common.Address{}) {
+		mined, err := backend.NonceAt(ctx, from, nil)
+		if err != nil {
+			return nil, err
+		}
+		if mined > nonce {
+			// the tx may be mined since the receipt was asked for
+			receipt, err := backend.TransactionReceipt(ctx, hash)
+			if errors.Is(err, ethereum.NotFound) {
+				return nil, fmt.Errorf("%w: %s, nonce %d of %s", ErrNonceTaken, hash, nonce, from)
+			}
+			return receipt, err
+		}
+	}
+	_, _, err = backend.TransactionByHash(ctx, hash)
+	if errors.Is(err, ethereum.NotFound) {
+		if time.Since(sent) > timeout {
+			return nil, fmt.Errorf("%w: %s is unknown to the rpc-server", ErrTxDropped, hash)
+		}
+		return nil, nil
+	}
+	return nil, err
+}
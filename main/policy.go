@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	SpendingPolicyFile = "spending-policy.json"
+	// SpendingFile keeps what was signed in the window, across restarts
+	SpendingFile = "spending.json"
+
+	// nativeLimit is the key of the limits of the native currency, in wei
+	nativeLimit = "native"
+	// anyTokenLimit is the key of the limits of the tokens without their own
+	anyTokenLimit = "*"
+)
+
+// ErrPolicyViolation is the cause of every tx the SpendingPolicy refuses to sign.
+var ErrPolicyViolation = errors.New("spending policy violation")
+
+// SpendingLimit caps the amounts sent, in base units of the token or wei.
+type SpendingLimit struct {
+	// MaxAmount is the most sent by one tx
+	MaxAmount *big.Int `json:"maxAmount,omitempty"`
+	// WindowAmount is the most sent by all the txs of the window
+	WindowAmount *big.Int `json:"windowAmount,omitempty"`
+}
+
+// SpendingPolicy checks every tx before it's sent: its recipient, amount,
+// approval and gas price. The amounts of transfer, transferFrom and approve
+// count against the limits of the token, the value of a tx against "native",
+// each chain apart.
+type SpendingPolicy struct {
+	// Allow lists the recipients, spenders and raw call callees allowed, any when empty
+	Allow []common.Address `json:"allow,omitempty"`
+	// Deny lists the recipients, spenders, tokens and callees never allowed
+	Deny []common.Address `json:"deny,omitempty"`
+	// Limits are keyed by token address, "native" or "*" for any other token, of
+	// every chain, or of one with a "<chainId>/" prefix which comes first
+	Limits map[string]SpendingLimit `json:"limits,omitempty"`
+	// Window is the period of the WindowAmount limits, 24h by default
+	Window string `json:"window,omitempty"`
+	// MaxGasPrice caps the gas price, or the fee cap, in wei
+	MaxGasPrice *big.Int `json:"maxGasPrice,omitempty"`
+	// AllowInfiniteApprovals allows approving MAX_UINT256
+	AllowInfiniteApprovals bool `json:"allowInfiniteApprovals,omitempty"`
+	// AllowDeploy allows deploying contracts
+	AllowDeploy bool `json:"allowDeploy,omitempty"`
+	// AllowRawCalls allows calls with data other than the EIP20 transfer, transferFrom and approve,
+	// whether sent or executed by a Safe, and the claims of unknown distributors
+	AllowRawCalls bool `json:"allowRawCalls,omitempty"`
+
+	window    time.Duration
+	spentPath string
+	mu        sync.Mutex
+	spent     []spending
+	// distributors are the MerkleDistributors known, with their token
+	distributors map[common.Address]common.Address
+}
+
+// spending is an amount signed for on a chain, counting against the window limits.
+type spending struct {
+	Time    time.Time `json:"time"`
+	ChainID uint64    `json:"chainId"`
+	Limit   string    `json:"limit"`
+	Amount  *big.Int  `json:"amount"`
+}
+
+// LoadSpendingPolicy reads the policy file, and what was signed in the window from spentPath.
+// A missing policy file gives nil, which allows anything.
+func LoadSpendingPolicy(path, spentPath string) (*SpendingPolicy, error) {
+	bs, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := &SpendingPolicy{spentPath: spentPath}
+	if err := json.Unmarshal(bs, p); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	p.window = 24 * time.Hour
+	if p.Window != "" {
+		if p.window, err = time.ParseDuration(p.Window); err != nil {
+			return nil, fmt.Errorf("%s: window: %w", path, err)
+		}
+	}
+	for key := range p.Limits {
+		k := key
+		if i := strings.IndexByte(k, '/'); i >= 0 {
+			if _, err := strconv.ParseUint(k[:i], 10, 64); err != nil {
+				return nil, fmt.Errorf("%s: limit of %q, want a chain ID before the /", path, key)
+			}
+			k = k[i+1:]
+		}
+		if k != nativeLimit && k != anyTokenLimit && !common.IsHexAddress(k) {
+			return nil, fmt.Errorf("%s: limit of %q, want a token address, %q or %q", path, key, nativeLimit, anyTokenLimit)
+		}
+	}
+	if spentPath == "" {
+		return p, nil
+	}
+	bs, err = ioutil.ReadFile(spentPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &p.spent); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", spentPath, err)
+	}
+	return p, nil
+}
+
+// Guard makes auth give only the signed txs passing the policy. A nil policy guards nothing.
+// The signed tx is checked, the chain of a legacy tx is only known once signed.
+func (p *SpendingPolicy) Guard(auth *bind.TransactOpts) *bind.TransactOpts {
+	if p == nil {
+		return auth
+	}
+	signer := auth.Signer
+	auth.Signer = func(from common.Address, tx *types.Transaction) (*types.Transaction, error) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		signed, err := signer(from, tx)
+		if err != nil {
+			return nil, err
+		}
+		amounts, err := p.check(signed, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return signed, p.record(amounts)
+	}
+	return auth
+}
+
+// violation describes why tx is refused.
+func violation(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrPolicyViolation, fmt.Sprintf(format, args...))
+}
+
+// check tells why tx is refused, or what it spends.
+func (p *SpendingPolicy) check(tx *types.Transaction, now time.Time) ([]spending, error) {
+	if p.MaxGasPrice != nil && tx.GasFeeCap().Cmp(p.MaxGasPrice) > 0 {
+		return nil, violation("gas price %s wei over the ceiling of %s", tx.GasFeeCap(), p.MaxGasPrice)
+	}
+	chain := tx.ChainId().Uint64()
+	var amounts []spending
+	if tx.Value().Sign() > 0 {
+		amounts = append(amounts, spending{now, chain, nativeLimit, tx.Value()})
+	}
+	if tx.To() == nil {
+		if !p.AllowDeploy {
+			return nil, violation("contract deployment is not allowed")
+		}
+		return amounts, p.checkAmounts(amounts, now)
+	}
+	return p.checkCall(chain, *tx.To(), tx.Data(), amounts, now)
+}
+
+// checkCall tells why a call of data to to is refused, or adds what it spends
+// to amounts. The call a Safe executes, and the claim of a known distributor,
+// are checked like the EIP20 calls they make.
+func (p *SpendingPolicy) checkCall(chain uint64, to common.Address, data []byte, amounts []spending, now time.Time) ([]spending, error) {
+	if len(data) == 0 {
+		if err := p.checkRecipient("recipient", to); err != nil {
+			return nil, err
+		}
+		return amounts, p.checkAmounts(amounts, now)
+	}
+	inner, err := decodeExecTransaction(data)
+	if err != nil {
+		return nil, err
+	}
+	if inner != nil {
+		if p.denied(to) {
+			return nil, violation("safe %s is denylisted", to)
+		}
+		if inner.Value.Sign() > 0 {
+			amounts = append(amounts, spending{now, chain, nativeLimit, inner.Value})
+		}
+		if inner.Operation != SafeCall {
+			if !p.AllowRawCalls {
+				return nil, violation("delegatecall of safe %s to %s is not allowed", to, inner.To)
+			}
+			if err := p.checkRecipient("callee", inner.To); err != nil {
+				return nil, err
+			}
+			return amounts, p.checkAmounts(amounts, now)
+		}
+		return p.checkCall(chain, inner.To, inner.Data, amounts, now)
+	}
+	if token, ok := p.distributors[to]; ok {
+		account, amount, err := decodeClaim(data)
+		if err != nil {
+			return nil, err
+		}
+		if amount != nil {
+			if p.denied(token) {
+				return nil, violation("token %s is denylisted", token)
+			}
+			if err := p.checkRecipient("recipient", account); err != nil {
+				return nil, err
+			}
+			amounts = append(amounts, spending{now, chain, strings.ToLower(token.Hex()), amount})
+			return amounts, p.checkAmounts(amounts, now)
+		}
+	}
+	recipient, amount, err := p.decodeCall(data)
+	if err != nil {
+		return nil, err
+	}
+	if amount == nil {
+		// a raw call, to an allowed callee
+		if err := p.checkRecipient("callee", to); err != nil {
+			return nil, err
+		}
+		return amounts, p.checkAmounts(amounts, now)
+	}
+	if p.denied(to) {
+		return nil, violation("token %s is denylisted", to)
+	}
+	if err := p.checkRecipient("recipient", recipient); err != nil {
+		return nil, err
+	}
+	amounts = append(amounts, spending{now, chain, strings.ToLower(to.Hex()), amount})
+	return amounts, p.checkAmounts(amounts, now)
+}
+
+// safeCall is the call a Safe makes in execTransaction.
+type safeCall struct {
+	To        common.Address
+	Value     *big.Int
+	Data      []byte
+	Operation uint8
+}
+
+// decodeExecTransaction returns the call of a Safe execTransaction, or nil for other data.
+func decodeExecTransaction(data []byte) (*safeCall, error) {
+	args, err := unpackCall(SafeMetaData, "execTransaction", data)
+	if args == nil || err != nil {
+		return nil, err
+	}
+	return &safeCall{
+		To:        args[0].(common.Address),
+		Value:     args[1].(*big.Int),
+		Data:      args[2].([]byte),
+		Operation: args[3].(uint8),
+	}, nil
+}
+
+// decodeClaim returns the account and amount of a MerkleDistributor claim, or nothing for other data.
+func decodeClaim(data []byte) (common.Address, *big.Int, error) {
+	args, err := unpackCall(MerkleDistributorMetaData, "claim", data)
+	if args == nil || err != nil {
+		return common.Address{}, nil, err
+	}
+	return args[0].(common.Address), args[1].(*big.Int), nil
+}
+
+// unpackCall returns the arguments of a call of method in data, or nil for a call of another method.
+func unpackCall(meta *bind.MetaData, method string, data []byte) ([]interface{}, error) {
+	parsed, err := meta.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	m, ok := parsed.Methods[method]
+	if !ok || len(data) < 4 || !bytes.Equal(data[:4], m.ID) {
+		return nil, nil
+	}
+	args, err := m.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, violation("invalid %s call: %v", method, err)
+	}
+	return args, nil
+}
+
+// AddDistributor makes the claims of distributor count against the limits of
+// its token, and their account a recipient. A nil policy ignores it.
+func (p *SpendingPolicy) AddDistributor(distributor, token common.Address) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.distributors == nil {
+		p.distributors = make(map[common.Address]common.Address)
+	}
+	p.distributors[distributor] = token
+}
+
+// decodeCall returns the recipient and amount of an EIP20 call, or nothing for a raw call it allows.
+func (p *SpendingPolicy) decodeCall(data []byte) (common.Address, *big.Int, error) {
+	parsed, err := EIP20MetaData.GetAbi()
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	var method string
+	var args []interface{}
+	if len(data) >= 4 {
+		if m, err := parsed.MethodById(data[:4]); err == nil {
+			method = m.Name
+			args, err = m.Inputs.Unpack(data[4:])
+			if err != nil {
+				return common.Address{}, nil, violation("invalid %s call: %v", method, err)
+			}
+		}
+	}
+	switch method {
+	case "transfer":
+		return args[0].(common.Address), args[1].(*big.Int), nil
+	case "transferFrom":
+		return args[1].(common.Address), args[2].(*big.Int), nil
+	case "approve":
+		amount := args[1].(*big.Int)
+		if !p.AllowInfiniteApprovals && amount.Cmp(math.MaxBig256) == 0 {
+			return common.Address{}, nil, violation("infinite approval of %s", args[0].(common.Address))
+		}
+		return args[0].(common.Address), amount, nil
+	}
+	if !p.AllowRawCalls {
+		selector := data
+		if len(selector) > 4 {
+			selector = selector[:4]
+		}
+		return common.Address{}, nil, violation("call %#x is not an EIP20 transfer, transferFrom or approve", selector)
+	}
+	return common.Address{}, nil, nil
+}
+
+func (p *SpendingPolicy) denied(addr common.Address) bool {
+	for _, a := range p.Deny {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *SpendingPolicy) checkRecipient(role string, addr common.Address) error {
+	if p.denied(addr) {
+		return violation("%s %s is denylisted", role, addr)
+	}
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	for _, a := range p.Allow {
+		if a == addr {
+			return nil
+		}
+	}
+	return violation("%s %s is not allowlisted", role, addr)
+}
+
+// limit returns the limit of key on chain, that of the chain before that of
+// every chain, falling back to "*" for the tokens.
+func (p *SpendingPolicy) limit(chain uint64, key string) (SpendingLimit, bool) {
+	keys := []string{fmt.Sprintf("%d/%s", chain, key), key}
+	if key != nativeLimit {
+		keys = append(keys, fmt.Sprintf("%d/%s", chain, anyTokenLimit), anyTokenLimit)
+	}
+	for _, want := range keys {
+		for k, l := range p.Limits {
+			if strings.EqualFold(k, want) {
+				return l, true
+			}
+		}
+	}
+	return SpendingLimit{}, false
+}
+
+func (p *SpendingPolicy) checkAmounts(amounts []spending, now time.Time) error {
+	for _, a := range amounts {
+		l, ok := p.limit(a.ChainID, a.Limit)
+		if !ok {
+			continue
+		}
+		if l.MaxAmount != nil && a.Amount.Cmp(l.MaxAmount) > 0 {
+			return violation("amount %s of %s on chain %d over the limit of %s per tx", a.Amount, a.Limit, a.ChainID, l.MaxAmount)
+		}
+		if l.WindowAmount == nil {
+			continue
+		}
+		total := new(big.Int).Set(a.Amount)
+		for _, s := range p.spent {
+			if s.ChainID == a.ChainID && s.Limit == a.Limit && now.Sub(s.Time) < p.window {
+				total.Add(total, s.Amount)
+			}
+		}
+		if total.Cmp(l.WindowAmount) > 0 {
+			return violation("amount %s of %s would make %s on chain %d over the limit of %s per %s", a.Amount, a.Limit, total, a.ChainID, l.WindowAmount, p.window)
+		}
+	}
+	return nil
+}
+
+// record counts the amounts of a signed tx, and drops those out of the window.
+func (p *SpendingPolicy) record(amounts []spending) error {
+	if len(amounts) == 0 {
+		return nil
+	}
+	now := time.Now()
+	kept := p.spent[:0]
+	for _, s := range p.spent {
+		if now.Sub(s.Time) < p.window {
+			kept = append(kept, s)
+		}
+	}
+	p.spent = append(kept, amounts...)
+	if p.spentPath == "" {
+		return nil
+	}
+	bs, err := json.MarshalIndent(p.spent, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p.spentPath, bs)
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestSpendingPolicyCheck(t *testing.T) {
+	parsed, err := EIP20MetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := common.Address{0xaa}
+	call := func(method string, args ...interface{}) []byte {
+		data, err := parsed.Pack(method, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	tx := func(to *common.Address, value int64, data []byte) *types.Transaction {
+		return types.NewTx(&types.DynamicFeeTx{
+			To:        to,
+			Value:     big.NewInt(value),
+			Data:      data,
+			Gas:       100000,
+			GasFeeCap: big.NewInt(10 * params.GWei),
+		})
+	}
+	friend, stranger, thief := common.Address{0x01}, common.Address{0x02}, common.Address{0x03}
+	policy := &SpendingPolicy{
+		Allow: []common.Address{friend, thief},
+		Deny:  []common.Address{thief},
+		Limits: map[string]SpendingLimit{
+			nativeLimit:   {MaxAmount: big.NewInt(1000)},
+			anyTokenLimit: {MaxAmount: big.NewInt(500), WindowAmount: big.NewInt(800)},
+		},
+		window: time.Hour,
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		tx     *types.Transaction
+		reason string
+	}{
+		{"native to an allowed recipient", tx(&friend, 1000, nil), ""},
+		{"native over the limit", tx(&friend, 1001, nil), "per tx"},
+		{"native to a stranger", tx(&stranger, 1, nil), "not allowlisted"},
+		{"native to a denied recipient", tx(&thief, 1, nil), "denylisted"},
+		{"transfer", tx(&token, 0, call("transfer", friend, big.NewInt(500))), ""},
+		{"transfer over the limit", tx(&token, 0, call("transfer", friend, big.NewInt(501))), "per tx"},
+		{"transfer to a stranger", tx(&token, 0, call("transfer", stranger, big.NewInt(1))), "not allowlisted"},
+		{"transferFrom to a denied recipient", tx(&token, 0, call("transferFrom", friend, thief, big.NewInt(1))), "denylisted"},
+		{"approve", tx(&token, 0, call("approve", friend, big.NewInt(1))), ""},
+		{"infinite approval", tx(&token, 0, call("approve", friend, math.MaxBig256)), "infinite approval"},
+		{"deploy", tx(nil, 0, []byte{0x60, 0x80}), "deployment"},
+		{"raw call", tx(&friend, 0, []byte{0xde, 0xad, 0xbe, 0xef}), "not an EIP20"},
+	}
+	for _, tt := range tests {
+		_, err := policy.check(tt.tx, now)
+		switch {
+		case tt.reason == "" && err != nil:
+			t.Errorf("%s: refused: %v", tt.name, err)
+		case tt.reason != "" && (!errors.Is(err, ErrPolicyViolation) || !strings.Contains(err.Error(), tt.reason)):
+			t.Errorf("%s: got %v, want a violation with %q", tt.name, err, tt.reason)
+		}
+	}
+
+	policy.MaxGasPrice = big.NewInt(5 * params.GWei)
+	if _, err := policy.check(tx(&friend, 1, nil), now); err == nil || !strings.Contains(err.Error(), "ceiling") {
+		t.Errorf("over the gas ceiling: %v", err)
+	}
+	policy.MaxGasPrice = nil
+
+	// the window limit counts what was signed in the window only
+	policy.spent = []spending{
+		{now.Add(-2 * time.Hour), 0, strings.ToLower(token.Hex()), big.NewInt(500)},
+		{now.Add(-time.Minute), 0, strings.ToLower(token.Hex()), big.NewInt(500)},
+	}
+	if _, err := policy.check(tx(&token, 0, call("transfer", friend, big.NewInt(300))), now); err != nil {
+		t.Errorf("within the window limit: %v", err)
+	}
+	if _, err := policy.check(tx(&token, 0, call("transfer", friend, big.NewInt(301))), now); err == nil || !strings.Contains(err.Error(), "per 1h0m0s") {
+		t.Errorf("over the window limit: %v", err)
+	}
+}
+
+func TestSpendingPolicySafe(t *testing.T) {
+	pack := func(meta *bind.MetaData, method string, args ...interface{}) []byte {
+		parsed, err := meta.GetAbi()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := parsed.Pack(method, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	safe, token, distributor := common.Address{0xa1}, common.Address{0xa2}, common.Address{0xa3}
+	friend, stranger, thief := common.Address{0x01}, common.Address{0x02}, common.Address{0x03}
+	exec := func(to common.Address, value int64, data []byte, operation uint8) *types.Transaction {
+		return types.NewTx(&types.DynamicFeeTx{
+			To:        &safe,
+			Data:      pack(SafeMetaData, "execTransaction", to, big.NewInt(value), data, operation, new(big.Int), new(big.Int), new(big.Int), common.Address{}, common.Address{}, []byte{}),
+			Gas:       100000,
+			GasFeeCap: big.NewInt(params.GWei),
+		})
+	}
+	claim := func(account common.Address, amount int64) *types.Transaction {
+		return types.NewTx(&types.DynamicFeeTx{
+			To:        &distributor,
+			Data:      pack(MerkleDistributorMetaData, "claim", account, big.NewInt(amount), [][32]byte{}),
+			Gas:       100000,
+			GasFeeCap: big.NewInt(params.GWei),
+		})
+	}
+	transfer := func(to common.Address, amount int64) []byte {
+		return pack(EIP20MetaData, "transfer", to, big.NewInt(amount))
+	}
+	policy := &SpendingPolicy{
+		Allow: []common.Address{friend, thief},
+		Deny:  []common.Address{thief},
+		Limits: map[string]SpendingLimit{
+			nativeLimit:   {MaxAmount: big.NewInt(1000)},
+			anyTokenLimit: {MaxAmount: big.NewInt(500)},
+		},
+		window: time.Hour,
+	}
+	policy.AddDistributor(distributor, token)
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		tx     *types.Transaction
+		reason string
+	}{
+		{"safe transfer", exec(token, 0, transfer(friend, 500), SafeCall), ""},
+		{"safe transfer over the limit", exec(token, 0, transfer(friend, 501), SafeCall), "per tx"},
+		{"safe transfer to a stranger", exec(token, 0, transfer(stranger, 1), SafeCall), "not allowlisted"},
+		{"safe transfer to a denied recipient", exec(token, 0, transfer(thief, 1), SafeCall), "denylisted"},
+		{"safe native over the limit", exec(friend, 1001, nil, SafeCall), "per tx"},
+		{"safe raw call", exec(friend, 0, []byte{0xde, 0xad, 0xbe, 0xef}, SafeCall), "not an EIP20"},
+		{"safe delegatecall", exec(token, 0, transfer(friend, 1), 1), "delegatecall"},
+		{"claim", claim(friend, 500), ""},
+		{"claim over the limit", claim(friend, 501), "per tx"},
+		{"claim to a stranger", claim(stranger, 1), "not allowlisted"},
+	}
+	for _, tt := range tests {
+		_, err := policy.check(tt.tx, now)
+		switch {
+		case tt.reason == "" && err != nil:
+			t.Errorf("%s: refused: %v", tt.name, err)
+		case tt.reason != "" && (!errors.Is(err, ErrPolicyViolation) || !strings.Contains(err.Error(), tt.reason)):
+			t.Errorf("%s: got %v, want a violation with %q", tt.name, err, tt.reason)
+		}
+	}
+
+	// the Safe spends the limits of the token it transfers
+	amounts, err := policy.check(exec(token, 0, transfer(friend, 100), SafeCall), now)
+	if err != nil || len(amounts) != 1 || amounts[0].Limit != strings.ToLower(token.Hex()) {
+		t.Errorf("safe transfer spends %v %v, want 100 of %s", amounts, err, token)
+	}
+	// a claim of an unknown distributor stays a raw call
+	other := common.Address{0xa4}
+	unknown := claim(friend, 1)
+	unknown = types.NewTx(&types.DynamicFeeTx{To: &other, Data: unknown.Data(), Gas: 100000, GasFeeCap: big.NewInt(params.GWei)})
+	if _, err := policy.check(unknown, now); err == nil || !strings.Contains(err.Error(), "not an EIP20") {
+		t.Errorf("claim of an unknown distributor: %v", err)
+	}
+	policy.AllowRawCalls = true
+	if _, err := policy.check(exec(token, 0, transfer(stranger, 1), 1), now); err == nil || !strings.Contains(err.Error(), "not allowlisted") {
+		t.Errorf("delegatecall to a callee not allowlisted: %v", err)
+	}
+}
+
+func TestSpendingPolicyNetworks(t *testing.T) {
+	parsed, err := EIP20MetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same token address on two chains
+	token, friend := common.Address{0xaa}, common.Address{0x01}
+	transfer, err := parsed.Pack("transfer", friend, big.NewInt(300))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := func(chainID int64, value int64, data []byte) *types.Transaction {
+		to := friend
+		if data != nil {
+			to = token
+		}
+		return types.NewTx(&types.DynamicFeeTx{ChainID: big.NewInt(chainID), To: &to, Value: big.NewInt(value), Data: data, Gas: 100000})
+	}
+
+	policyFile := filepath.Join(t.TempDir(), SpendingPolicyFile)
+	if err := writeFileAtomic(policyFile, []byte(`{"limits": {
+		"native": {"maxAmount": 1000},
+		"5/native": {"maxAmount": 10},
+		"*": {"windowAmount": 500}
+	}}`)); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadSpendingPolicy(policyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if _, err := policy.check(tx(1, 1000, nil), now); err != nil {
+		t.Errorf("native within the limit of every chain: %v", err)
+	}
+	if _, err := policy.check(tx(5, 11, nil), now); !errors.Is(err, ErrPolicyViolation) {
+		t.Errorf("native over the limit of chain 5: %v", err)
+	}
+
+	// what was spent on chain 1 doesn't count on chain 5
+	amounts, err := policy.check(tx(1, 0, transfer), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.record(amounts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := policy.check(tx(1, 0, transfer), now); !errors.Is(err, ErrPolicyViolation) {
+		t.Errorf("over the window limit on chain 1: %v", err)
+	}
+	if _, err := policy.check(tx(5, 0, transfer), now); err != nil {
+		t.Errorf("within the window limit on chain 5: %v", err)
+	}
+
+	// the chain of a legacy tx is known once signed
+	key, _ := crypto.GenerateKey()
+	for chainID, ok := range map[int64]bool{1: true, 5: false} {
+		auth, err := bind.NewKeyedTransactorWithChainID(key, big.NewInt(chainID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		legacy := types.NewTx(&types.LegacyTx{To: &friend, Value: big.NewInt(11), Gas: params.TxGas, GasPrice: big.NewInt(1)})
+		if _, err := policy.Guard(auth).Signer(auth.From, legacy); (err == nil) != ok {
+			t.Errorf("legacy tx of 11 wei on chain %d: %v", chainID, err)
+		}
+	}
+
+	for _, bad := range []string{`{"limits": {"x/native": {}}}`, `{"limits": {"5/other": {}}}`} {
+		if err := writeFileAtomic(policyFile, []byte(bad)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadSpendingPolicy(policyFile, ""); err == nil {
+			t.Errorf("loaded %s", bad)
+		}
+	}
+}
+
+func TestSpendingPolicyGuard(t *testing.T) {
+	env := newTestEnv(t, 1)
+	_, _, contract := env.deploy(10000)
+	spentFile := filepath.Join(t.TempDir(), SpendingFile)
+	policy := &SpendingPolicy{
+		Limits:    map[string]SpendingLimit{anyTokenLimit: {WindowAmount: big.NewInt(150)}},
+		window:    24 * time.Hour,
+		spentPath: spentFile,
+	}
+
+	auth := policy.Guard(env.accounts[0].transactor(t))
+	tx, err := contract.Transfer(auth, common.Address{0x01}, big.NewInt(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.backend.Commit()
+	env.requireSuccess(tx)
+	if _, err := contract.Transfer(auth, common.Address{0x01}, big.NewInt(100)); !errors.Is(err, ErrPolicyViolation) {
+		t.Fatalf("second transfer over the window limit: %v", err)
+	}
+	env.backend.Commit()
+	env.requireBalance(contract, common.Address{0x01}, 100)
+
+	// the spending survives a restart
+	restarted, err := LoadSpendingPolicy(filepath.Join(t.TempDir(), "missing.json"), spentFile)
+	if err != nil || restarted != nil {
+		t.Fatalf("missing policy file gave %v %v, want nothing", restarted, err)
+	}
+	policyFile := filepath.Join(t.TempDir(), SpendingPolicyFile)
+	if err := writeFileAtomic(policyFile, []byte(`{"limits": {"*": {"windowAmount": 150}}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if restarted, err = LoadSpendingPolicy(policyFile, spentFile); err != nil {
+		t.Fatal(err)
+	}
+	auth = restarted.Guard(env.accounts[0].transactor(t))
+	if _, err := contract.Transfer(auth, common.Address{0x01}, big.NewInt(51)); !errors.Is(err, ErrPolicyViolation) {
+		t.Fatalf("transfer after a restart over the window limit: %v", err)
+	}
+	if _, err := contract.Transfer(auth, common.Address{0x01}, big.NewInt(50)); err != nil {
+		t.Fatalf("transfer after a restart within the window limit: %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	pushWriteTimeout = 10 * time.Second
+	pushPingInterval = 30 * time.Second
+	// pushQueue is how many messages a client may fall behind before it's dropped
+	pushQueue = 256
+	// pushReadLimit is the largest request of a client, a larger one drops it
+	pushReadLimit = 4096
+	// pushSubscriptions are the most subscriptions of a client
+	pushSubscriptions = 100
+)
+
+// PushFilter selects the events a push client subscribes to.
+type PushFilter struct {
+	// ChainID is the chain of the contract, the serving one when 0
+	ChainID  uint64         `json:"chainId,omitempty"`
+	Contract common.Address `json:"contract"`
+	// From matches the sender of a Transfer or the owner of an Approval
+	From *common.Address `json:"from,omitempty"`
+	// To matches the recipient of a Transfer or the spender of an Approval
+	To *common.Address `json:"to,omitempty"`
+}
+
+// Match tells if e passes the filter.
+func (f *PushFilter) Match(e *TokenEvent) bool {
+	if e.Contract != f.Contract || (f.ChainID != 0 && e.ChainID != f.ChainID) {
+		return false
+	}
+	from, to := e.From, e.To
+	if e.Type == EventApproval {
+		from, to = e.Owner, e.Spender
+	}
+	if f.From != nil && (from == nil || *from != *f.From) {
+		return false
+	}
+	if f.To != nil && (to == nil || *to != *f.To) {
+		return false
+	}
+	return true
+}
+
+// pushRequest is a message from a client:
+//
+//	{"id": 1, "method": "subscribe", "params": {"chainId": 1, "contract": "0x..", "from": "0x.."}}
+//	{"id": 2, "method": "unsubscribe", "params": {"subscription": "1"}}
+type pushRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type pushResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result interface{}     `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// pushNotification carries an event of a subscription.
+type pushNotification struct {
+	Subscription string      `json:"subscription"`
+	Event        *TokenEvent `json:"event"`
+}
+
+// PushServer streams the events of the Trackers to websocket clients,
+// with their confirmation updates and reorg retractions.
+type PushServer struct {
+	Upgrader websocket.Upgrader
+	// ChainID is the chain of the subscriptions without one
+	ChainID uint64
+}
+
+func (p *PushServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := p.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has replied
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(pushReadLimit)
+	l := log.New("client", r.RemoteAddr)
+	l.Debug("Push client connected")
+
+	events := make(chan *TokenEvent, pushQueue)
+	sub := SubscribeLiveEvents(events)
+	defer sub.Unsubscribe()
+
+	out := make(chan interface{}, pushQueue)
+	done := make(chan struct{})
+	defer close(done)
+	go pushWriter(conn, out, done)
+	requests := make(chan *pushRequest)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			var req pushRequest
+			if err := conn.ReadJSON(&req); err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case requests <- &req:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	filters := make(map[string]*PushFilter)
+	nextID := 0
+	for {
+		var msg interface{}
+		select {
+		case err := <-readErr:
+			l.Debug("Push client disconnected", "err", err)
+			return
+		case req := <-requests:
+			resp := &pushResponse{ID: req.ID}
+			switch req.Method {
+			case "subscribe":
+				var f PushFilter
+				if err := json.Unmarshal(req.Params, &f); err != nil || f.Contract == (common.Address{}) {
+					resp.Error = "invalid params, the contract is required"
+					break
+				}
+				if len(filters) >= pushSubscriptions {
+					resp.Error = "too many subscriptions, the most is " + strconv.Itoa(pushSubscriptions)
+					break
+				}
+				if f.ChainID == 0 {
+					f.ChainID = p.ChainID
+				}
+				nextID++
+				id := strconv.Itoa(nextID)
+				filters[id] = &f
+				resp.Result = id
+			case "unsubscribe":
+				var params struct {
+					Subscription string `json:"subscription"`
+				}
+				json.Unmarshal(req.Params, &params)
+				_, ok := filters[params.Subscription]
+				delete(filters, params.Subscription)
+				resp.Result = ok
+			default:
+				resp.Error = "unknown method " + strconv.Quote(req.Method)
+			}
+			msg = resp
+		case e := <-events:
+			for id, f := range filters {
+				if f.Match(e) {
+					if !push(out, &pushNotification{id, e}) {
+						l.Warn("Drop slow push client")
+						return
+					}
+				}
+			}
+			continue
+		}
+		if !push(out, msg) {
+			l.Warn("Drop slow push client")
+			return
+		}
+	}
+}
+
+// push queues msg unless the client is too far behind.
+func push(out chan<- interface{}, msg interface{}) bool {
+	select {
+	case out <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+func pushWriter(conn *websocket.Conn, out <-chan interface{}, done <-chan struct{}) {
+	ping := time.NewTicker(pushPingInterval)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case <-done:
+			return
+		case msg := <-out:
+			conn.SetWriteDeadline(time.Now().Add(pushWriteTimeout))
+			err = conn.WriteJSON(msg)
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pushWriteTimeout))
+		}
+		if err != nil {
+			// unblock the reader, which ends the connection
+			conn.Close()
+			if !errors.Is(err, websocket.ErrCloseSent) {
+				log.Debug("Push write failed", "err", err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/websocket"
+)
+
+// nextEvent waits for an event of ch.
+func nextEvent(t *testing.T, ch <-chan *TokenEvent) *TokenEvent {
+	t.Helper()
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+		return nil
+	}
+}
+
+func TestTrackerConfirmationsAndReorg(t *testing.T) {
+	env := newTestEnv(t, 1)
+	addr, _, contract := env.deploy(1000)
+
+	events := make(chan *TokenEvent, 16)
+	sub := SubscribeLiveEvents(events)
+	defer sub.Unsubscribe()
+	tracker := &Tracker{
+		Backend:      env.backend,
+		ChainID:      simChainID,
+		Tokens:       []common.Address{addr},
+		Confirms:     3,
+		PollInterval: 10 * time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := goAsync(func() error { return tracker.Run(ctx) })
+	defer func() {
+		cancel()
+		waitResult(t, done, 5*time.Second)
+	}()
+	// let it start at the current head
+	time.Sleep(50 * time.Millisecond)
+
+	tx := env.pendingTransfer(contract)
+	env.backend.Commit()
+	e := nextEvent(t, events)
+	if e.Type != EventTransfer || e.TxHash != tx.Hash() || e.Confirmations != 0 || e.Removed {
+		t.Fatalf("got %+v, want the mined transfer", e)
+	}
+	if *e.To != (common.Address{0x11}) || e.Value != "1" {
+		t.Fatalf("got %+v", e)
+	}
+
+	env.backend.Commit()
+	if e := nextEvent(t, events); e.TxHash != tx.Hash() || e.Confirmations != 1 {
+		t.Fatalf("got %+v, want 1 confirmation", e)
+	}
+
+	// replace the block of the transfer
+	env.reorg(2)
+	if e := nextEvent(t, events); e.TxHash != tx.Hash() || !e.Removed {
+		t.Fatalf("got %+v, want the transfer retracted", e)
+	}
+}
+
+func TestTrackerFinal(t *testing.T) {
+	env := newTestEnv(t, 1)
+	addr, _, contract := env.deploy(1000)
+
+	events := make(chan *TokenEvent, 16)
+	sub := SubscribeLiveEvents(events)
+	defer sub.Unsubscribe()
+	tracker := &Tracker{
+		Backend:      env.backend,
+		ChainID:      simChainID,
+		Tokens:       []common.Address{addr},
+		Confirms:     2,
+		PollInterval: 10 * time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := goAsync(func() error { return tracker.Run(ctx) })
+	defer func() {
+		cancel()
+		waitResult(t, done, 5*time.Second)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	env.pendingTransfer(contract)
+	env.backend.Commit()
+	for want := uint64(0); want <= 2; want++ {
+		if e := nextEvent(t, events); e.Confirmations != want {
+			t.Fatalf("got %d confirmations, want %d", e.Confirmations, want)
+		}
+		env.backend.Commit()
+	}
+	// no more updates once final
+	select {
+	case e := <-events:
+		t.Fatalf("got %+v after the final confirmation", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPushServer(t *testing.T) {
+	srv := httptest.NewServer(&PushServer{ChainID: simChainID.Uint64()})
+	defer srv.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	call := func(msg string) map[string]interface{} {
+		t.Helper()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		var resp map[string]interface{}
+		if err := conn.ReadJSON(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	token, bob := common.Address{0xee}, common.Address{0xb0}
+	if resp := call(`{"id":1,"method":"subscribe","params":{}}`); resp["error"] == nil {
+		t.Fatalf("subscribed without a contract: %v", resp)
+	}
+	resp := call(`{"id":2,"method":"subscribe","params":{"contract":"` + token.Hex() + `","to":"` + bob.Hex() + `"}}`)
+	id, ok := resp["result"].(string)
+	if !ok || resp["id"] != float64(2) {
+		t.Fatalf("subscribe returned %v", resp)
+	}
+
+	// wait for the connection to subscribe to the feed
+	for liveEvents.Send(&TokenEvent{Contract: common.Address{0x01}}) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	liveEvents.Send(testTransferEvent(common.Address{0xa1}, common.Address{0xc0}, 0))
+	match := testTransferEvent(common.Address{0xa1}, bob, 1)
+	match.Contract = token
+	match.Confirmations = 2
+	// the same contract on another chain
+	other := *match
+	other.ChainID, other.LogIndex = 5, 9
+	liveEvents.Send(&other)
+	liveEvents.Send(match)
+
+	var n struct {
+		Subscription string          `json:"subscription"`
+		Event        json.RawMessage `json:"event"`
+	}
+	if err := conn.ReadJSON(&n); err != nil {
+		t.Fatal(err)
+	}
+	var got TokenEvent
+	json.Unmarshal(n.Event, &got)
+	if n.Subscription != id || got.LogIndex != 1 || got.Confirmations != 2 {
+		t.Fatalf("got %s for %s", n.Event, n.Subscription)
+	}
+
+	if resp := call(`{"id":3,"method":"unsubscribe","params":{"subscription":"` + id + `"}}`); resp["result"] != true {
+		t.Fatalf("unsubscribe returned %v", resp)
+	}
+	liveEvents.Send(match)
+	if resp := call(`{"id":4,"method":"nope"}`); resp["id"] != float64(4) || resp["error"] == nil {
+		t.Fatalf("got %v, want the error of an unknown method", resp)
+	}
+
+	// a client has pushSubscriptions at most
+	subscribe := `{"id":5,"method":"subscribe","params":{"contract":"` + token.Hex() + `"}}`
+	for i := 0; i < pushSubscriptions; i++ {
+		if resp := call(subscribe); resp["error"] != nil {
+			t.Fatalf("subscription %d: %v", i, resp)
+		}
+	}
+	if resp := call(subscribe); resp["result"] != nil || !strings.Contains(resp["error"].(string), "too many") {
+		t.Fatalf("got %v, want too many subscriptions", resp)
+	}
+	// and a larger request than pushReadLimit drops it
+	big := `{"id":6,"method":"` + strings.Repeat("x", pushReadLimit) + `"}`
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(big)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Fatal("a request over the read limit was read")
+	}
+}
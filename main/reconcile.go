@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	BookFile            = "book.json"
+	ReconcileReportFile = "reconcile-report.json"
+
+	// DiscrepancyBalance is a difference in the balance of a holder
+	DiscrepancyBalance = "balance"
+	// DiscrepancyTotalSupply is a difference in the total supply of a token
+	DiscrepancyTotalSupply = "totalSupply"
+)
+
+// errUnexplained fails a reconciliation leaving discrepancies the Transfers don't account for.
+var errUnexplained = errors.New("unexplained discrepancies")
+
+// Book is the internal ledger: the balances and supply we expect of each token.
+type Book struct {
+	// Block is the block the book last matched the chain at, the Transfers
+	// after it explain the discrepancies
+	Block  uint64                        `json:"block"`
+	Tokens map[common.Address]*BookToken `json:"tokens"`
+}
+
+// BookToken holds the expected balances of a token, in base units.
+type BookToken struct {
+	// TotalSupply is not checked when unset
+	TotalSupply *big.Int                    `json:"totalSupply,omitempty"`
+	Balances    map[common.Address]*big.Int `json:"balances"`
+}
+
+// LoadBook reads the book file.
+func LoadBook(path string) (*Book, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var b Book
+	if err := json.Unmarshal(bs, &b); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &b, nil
+}
+
+// Discrepancy is a book amount differing from the chain at the pinned block.
+type Discrepancy struct {
+	Contract common.Address `json:"contract"`
+	Kind     string         `json:"kind"`
+	// Address is the holder, for a balance
+	Address *common.Address `json:"address,omitempty"`
+	Book    string          `json:"book"`
+	Chain   string          `json:"chain"`
+	// Delta is chain minus book
+	Delta string `json:"delta"`
+	// Explained is the part of Delta the Transfers add up to
+	Explained   string `json:"explained"`
+	Unexplained string `json:"unexplained"`
+	// Transfers are those moving the amount since the book block: from or to
+	// the holder, or the mints and burns for the supply
+	Transfers []*TokenEvent `json:"transfers"`
+}
+
+// ReconcileReport lists the discrepancies between a Book and the chain.
+type ReconcileReport struct {
+	ChainID uint64    `json:"chainId"`
+	Time    time.Time `json:"time"`
+	// Since is the book block, Block the pinned block the chain was read at
+	Since         uint64         `json:"since"`
+	Block         uint64         `json:"block"`
+	Checked       int            `json:"checked"`
+	Discrepancies []*Discrepancy `json:"discrepancies"`
+}
+
+// Unexplained counts the discrepancies the Transfers don't account for.
+func (r *ReconcileReport) Unexplained() int {
+	n := 0
+	for _, d := range r.Discrepancies {
+		if d.Unexplained != "0" {
+			n++
+		}
+	}
+	return n
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *ReconcileReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes a row per discrepancy, its transfers as space separated txHash:logIndex.
+func (r *ReconcileReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"block", "contract", "kind", "address", "book", "chain", "delta", "explained", "unexplained", "transfers"})
+	for _, d := range r.Discrepancies {
+		addr := ""
+		if d.Address != nil {
+			addr = d.Address.Hex()
+		}
+		transfers := make([]string, len(d.Transfers))
+		for i, e := range d.Transfers {
+			transfers[i] = e.TxHash.Hex() + ":" + strconv.FormatUint(uint64(e.LogIndex), 10)
+		}
+		cw.Write([]string{strconv.FormatUint(r.Block, 10), d.Contract.Hex(), d.Kind, addr, d.Book, d.Chain,
+			d.Delta, d.Explained, d.Unexplained, strings.Join(transfers, " ")})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReconcileBackend reads the balances and Transfers at a pinned block.
+type ReconcileBackend interface {
+	bind.ContractCaller
+	bind.ContractFilterer
+	BlockNumber(ctx context.Context) (uint64, error)
+}
+
+// Reconciler compares a Book with the balances and supplies on chain.
+type Reconciler struct {
+	Backend ReconcileBackend
+	ChainID *big.Int
+	// Confirms are the blocks under the head of the block pinned by default
+	Confirms uint64
+	// MaxRange is the most blocks scanned by one eth_getLogs
+	MaxRange uint64
+	// TopicChunk is the most holders filtered by one eth_getLogs, default to 500
+	TopicChunk int
+}
+
+// Reconcile reads the chain at block, or at Confirms under the head when 0, and
+// reports the amounts of book differing from it.
+func (r *Reconciler) Reconcile(ctx context.Context, book *Book, block uint64) (*ReconcileReport, error) {
+	if block == 0 {
+		head, err := r.Backend.BlockNumber(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if head < r.Confirms {
+			return nil, fmt.Errorf("head %d under %d confirmations", head, r.Confirms)
+		}
+		block = head - r.Confirms
+	}
+	if block < book.Block {
+		return nil, fmt.Errorf("block %d is before the book block %d", block, book.Block)
+	}
+	report := &ReconcileReport{
+		ChainID:       r.ChainID.Uint64(),
+		Time:          time.Now().UTC(),
+		Since:         book.Block,
+		Block:         block,
+		Discrepancies: []*Discrepancy{},
+	}
+	contracts := make([]common.Address, 0, len(book.Tokens))
+	for addr := range book.Tokens {
+		contracts = append(contracts, addr)
+	}
+	sort.Slice(contracts, func(i, j int) bool { return contracts[i].Hex() < contracts[j].Hex() })
+	for _, contract := range contracts {
+		ds, checked, err := r.reconcileToken(ctx, contract, book.Tokens[contract], book.Block, block)
+		if err != nil {
+			return nil, fmt.Errorf("reconcile %s: %w", contract, err)
+		}
+		report.Checked += checked
+		report.Discrepancies = append(report.Discrepancies, ds...)
+		reconcileDiscrepancies.WithLabelValues(r.ChainID.String(), contract.Hex()).Set(float64(len(ds)))
+	}
+	return report, nil
+}
+
+func (r *Reconciler) reconcileToken(ctx context.Context, contract common.Address, t *BookToken, since, block uint64) ([]*Discrepancy, int, error) {
+	caller, err := NewEIP20Caller(contract, r.Backend)
+	if err != nil {
+		return nil, 0, err
+	}
+	opts := &bind.CallOpts{BlockNumber: new(big.Int).SetUint64(block), Context: ctx}
+
+	holders := make([]common.Address, 0, len(t.Balances))
+	for addr := range t.Balances {
+		holders = append(holders, addr)
+	}
+	sort.Slice(holders, func(i, j int) bool { return holders[i].Hex() < holders[j].Hex() })
+	var ds []*Discrepancy
+	for _, holder := range holders {
+		balance, err := caller.BalanceOf(opts, holder)
+		if err != nil {
+			return nil, 0, fmt.Errorf("balanceOf %s: %w", holder, err)
+		}
+		if d := newDiscrepancy(contract, DiscrepancyBalance, t.Balances[holder], balance); d != nil {
+			holder := holder
+			d.Address = &holder
+			ds = append(ds, d)
+		}
+	}
+	checked := len(holders)
+	if t.TotalSupply != nil {
+		supply, err := caller.TotalSupply(opts)
+		if err != nil {
+			return nil, 0, fmt.Errorf("totalSupply: %w", err)
+		}
+		if d := newDiscrepancy(contract, DiscrepancyTotalSupply, t.TotalSupply, supply); d != nil {
+			ds = append(ds, d)
+		}
+		checked++
+	}
+	if len(ds) > 0 {
+		if err := r.explain(ctx, contract, ds, since, block); err != nil {
+			return nil, 0, err
+		}
+	}
+	return ds, checked, nil
+}
+
+func newDiscrepancy(contract common.Address, kind string, book, chain *big.Int) *Discrepancy {
+	if book == nil {
+		book = new(big.Int)
+	}
+	if book.Cmp(chain) == 0 {
+		return nil
+	}
+	return &Discrepancy{
+		Contract:  contract,
+		Kind:      kind,
+		Book:      book.String(),
+		Chain:     chain.String(),
+		Delta:     new(big.Int).Sub(chain, book).String(),
+		Transfers: []*TokenEvent{},
+	}
+}
+
+// explain gives each discrepancy the Transfers of the blocks after since up to block
+// moving its amount, and the part of the delta they add up to.
+func (r *Reconciler) explain(ctx context.Context, contract common.Address, ds []*Discrepancy, since, block uint64) error {
+	f, err := NewEIP20Filterer(contract, r.Backend)
+	if err != nil {
+		return err
+	}
+	var addrs []common.Address
+	for _, d := range ds {
+		if d.Address != nil {
+			addrs = append(addrs, *d.Address)
+		} else {
+			// the mints come from, and the burns go to, the zero address
+			addrs = append(addrs, common.Address{})
+		}
+	}
+	transfers, err := r.filterTransfers(ctx, f, addrs, since+1, block)
+	if err != nil {
+		return err
+	}
+	for _, d := range ds {
+		holder := common.Address{}
+		if d.Address != nil {
+			holder = *d.Address
+		}
+		net := new(big.Int)
+		for _, e := range transfers {
+			if !e.Involves(holder) {
+				continue
+			}
+			value, _ := new(big.Int).SetString(e.Value, 10)
+			switch {
+			case *e.From == *e.To:
+				continue
+			case d.Address == nil && *e.From == holder:
+				net.Add(net, value)
+			case d.Address == nil:
+				net.Sub(net, value)
+			case *e.To == holder:
+				net.Add(net, value)
+			default:
+				net.Sub(net, value)
+			}
+			d.Transfers = append(d.Transfers, e)
+		}
+		delta, _ := new(big.Int).SetString(d.Delta, 10)
+		d.Explained = net.String()
+		d.Unexplained = delta.Sub(delta, net).String()
+	}
+	return nil
+}
+
+// filterTransfers gets the Transfers from or to addrs, in chain order, once each.
+func (r *Reconciler) filterTransfers(ctx context.Context, f *EIP20Filterer, addrs []common.Address, start, end uint64) ([]*TokenEvent, error) {
+	maxRange := r.MaxRange
+	if maxRange == 0 {
+		maxRange = 1000
+	}
+	chunk := r.TopicChunk
+	if chunk <= 0 {
+		chunk = depositTopicChunk
+	}
+	seen := make(map[eventKey]bool)
+	var transfers []*TokenEvent
+	collect := func(it *EIP20TransferIterator, err error) error {
+		if err != nil {
+			return err
+		}
+		defer it.Close()
+		for it.Next() {
+			e := transferEvent(r.ChainID, it.Event)
+			if key := (eventKey{e.TxHash, e.LogIndex}); !it.Event.Raw.Removed && !seen[key] {
+				seen[key] = true
+				transfers = append(transfers, e)
+			}
+		}
+		return it.Error()
+	}
+	for next := start; next <= end; next += maxRange {
+		last := next + maxRange - 1
+		if last > end {
+			last = end
+		}
+		opts := &bind.FilterOpts{Start: next, End: &last, Context: ctx}
+		for i := 0; i < len(addrs); i += chunk {
+			j := i + chunk
+			if j > len(addrs) {
+				j = len(addrs)
+			}
+			if err := collect(f.FilterTransfer(opts, addrs[i:j], nil)); err != nil {
+				return nil, err
+			}
+			if err := collect(f.FilterTransfer(opts, nil, addrs[i:j])); err != nil {
+				return nil, err
+			}
+		}
+		if last == end {
+			break
+		}
+	}
+	sort.Slice(transfers, func(i, j int) bool {
+		a, b := transfers[i], transfers[j]
+		if a.BlockNumber != b.BlockNumber {
+			return a.BlockNumber < b.BlockNumber
+		}
+		return a.LogIndex < b.LogIndex
+	})
+	return transfers, nil
+}
+
+// WriteReport writes report to path, as CSV when it ends with .csv, else as JSON.
+func WriteReport(path string, report *ReconcileReport) error {
+	var sb strings.Builder
+	write := report.WriteJSON
+	if strings.HasSuffix(path, ".csv") {
+		write = report.WriteCSV
+	}
+	if err := write(&sb); err != nil {
+		return err
+	}
+	if path == "-" {
+		_, err := os.Stdout.WriteString(sb.String())
+		return err
+	}
+	return writeFileAtomic(path, []byte(sb.String()))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"math/big"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestReconcile(t *testing.T) {
+	env := newTestEnv(t, 1)
+	token, _, contract := env.deploy(10000)
+	owner := env.accounts[0].addr
+	alice, bob := newTestAccount(t).addr, newTestAccount(t).addr
+	deployed, _ := env.backend.BlockNumber(context.Background())
+
+	// the book matched the chain after the deploy, then missed a transfer
+	book := &Book{
+		Block: deployed,
+		Tokens: map[common.Address]*BookToken{
+			token: {
+				TotalSupply: big.NewInt(10000),
+				Balances: map[common.Address]*big.Int{
+					owner: big.NewInt(10000),
+					alice: big.NewInt(0),
+					bob:   big.NewInt(50),
+				},
+			},
+		},
+	}
+	tx, err := contract.Transfer(env.accounts[0].transactor(t), alice, big.NewInt(300))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.backend.Commit()
+	env.requireSuccess(tx)
+
+	r := &Reconciler{Backend: env.backend, ChainID: simChainID, MaxRange: 1}
+	report, err := r.Reconcile(context.Background(), book, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Block != deployed+1 || report.Checked != 4 || len(report.Discrepancies) != 3 {
+		t.Fatalf("block %d, checked %d, %d discrepancies", report.Block, report.Checked, len(report.Discrepancies))
+	}
+	want := map[common.Address]string{
+		owner: "-300 -300 0 1",
+		alice: "300 300 0 1",
+		bob:   "-50 0 -50 0",
+	}
+	for _, d := range report.Discrepancies {
+		got := strings.Join([]string{d.Delta, d.Explained, d.Unexplained, strconv.Itoa(len(d.Transfers))}, " ")
+		if d.Kind != DiscrepancyBalance || got != want[*d.Address] {
+			t.Errorf("%s of %s: got %q, want %q", d.Kind, d.Address, got, want[*d.Address])
+		}
+		if len(d.Transfers) > 0 && d.Transfers[0].TxHash != tx.Hash() {
+			t.Errorf("%s explained by %s, want %s", d.Address, d.Transfers[0].TxHash, tx.Hash())
+		}
+	}
+	if report.Unexplained() != 1 {
+		t.Fatalf("%d unexplained, want bob's", report.Unexplained())
+	}
+
+	var sb strings.Builder
+	if err := report.WriteCSV(&sb); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(sb.String())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 || rows[0][9] != "transfers" {
+		t.Fatalf("csv %q", rows)
+	}
+	for _, row := range rows[1:] {
+		if explained := row[7] != "0"; explained != strings.HasPrefix(row[9], tx.Hash().Hex()+":") {
+			t.Fatalf("csv row %q", row)
+		}
+	}
+
+	// the supply is checked too, the mints and burns would explain it
+	book.Tokens[token].TotalSupply = big.NewInt(9000)
+	if report, err = r.Reconcile(context.Background(), book, report.Block); err != nil {
+		t.Fatal(err)
+	}
+	if d := report.Discrepancies[len(report.Discrepancies)-1]; d.Kind != DiscrepancyTotalSupply || d.Unexplained != "1000" {
+		t.Fatalf("got %s %s unexplained, want the supply 1000", d.Kind, d.Unexplained)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const RegistryFile = "deployments.json"
+
+// ErrNotDeployed is returned when the registry has no contract of the name.
+var ErrNotDeployed = errors.New("contract not deployed")
+
+// DeployArgs are the constructor arguments of the EIP20 contract.
+type DeployArgs struct {
+	InitialAmount *big.Int `json:"initialAmount"`
+	Name          string   `json:"name"`
+	Decimals      uint8    `json:"decimals"`
+	Symbol        string   `json:"symbol"`
+}
+
+// Deployment records a contract deployed by this program.
+type Deployment struct {
+	Address      common.Address `json:"address"`
+	TxHash       common.Hash    `json:"txHash"`
+	BlockNumber  uint64         `json:"blockNumber"`
+	Args         DeployArgs     `json:"constructorArgs"`
+	BytecodeHash common.Hash    `json:"bytecodeHash"` // of the runtime code, without its metadata
+}
+
+// Registry keeps the deployed contracts, keyed by chain ID and contract name.
+// It's safe for concurrent use.
+type Registry struct {
+	path   string
+	mu     sync.RWMutex
+	chains map[string]map[string]*Deployment
+}
+
+// LoadRegistry reads the registry file. A missing file gives an empty registry.
+func LoadRegistry(path string) (*Registry, error) {
+	r := &Registry{path: path, chains: make(map[string]map[string]*Deployment)}
+	bs, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &r.chains); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Lookup returns the deployment of the contract named name on chainID.
+func (r *Registry) Lookup(chainID *big.Int, name string) (*Deployment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	d, ok := r.chains[chainID.String()][name]
+	if !ok {
+		return nil, fmt.Errorf("%w: no %q on chain %s", ErrNotDeployed, name, chainID)
+	}
+	return d, nil
+}
+
+// Names returns the names of the contracts deployed on chainID.
+func (r *Registry) Names(chainID *big.Int) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var names []string
+	for name := range r.chains[chainID.String()] {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Record adds the deployment and writes the registry back to disk.
+// A former deployment with the same name is replaced.
+func (r *Registry) Record(chainID *big.Int, name string, d *Deployment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := chainID.String()
+	if r.chains[key] == nil {
+		r.chains[key] = make(map[string]*Deployment)
+	}
+	r.chains[key][name] = d
+	return r.save()
+}
+
+func (r *Registry) save() error {
+	bs, err := json.MarshalIndent(r.chains, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(r.path, bs)
+}
+
+// writeFileAtomic writes to a temp file first and renames it to path,
+// so a crash never leaves a truncated file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
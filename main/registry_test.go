@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestRegistry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), RegistryFile)
+	r, err := LoadRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Lookup(big.NewInt(1), "dtoken"); err == nil {
+		t.Fatal("found a token in an empty registry")
+	}
+
+	d := &Deployment{
+		Address:     common.Address{0x1},
+		TxHash:      common.Hash{0x2},
+		BlockNumber: 3,
+		Args:        DeployArgs{big.NewInt(1e18), "dtoken", 8, "dt"},
+	}
+	if err := r.Record(big.NewInt(1), "dtoken", d); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = LoadRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.Lookup(big.NewInt(1), "dtoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Address != d.Address || got.TxHash != d.TxHash || got.BlockNumber != 3 || got.Args.InitialAmount.Cmp(d.Args.InitialAmount) != 0 {
+		t.Fatalf("got %+v, want %+v", got, d)
+	}
+	// keyed by chain
+	if _, err := r.Lookup(big.NewInt(5), "dtoken"); !errors.Is(err, ErrNotDeployed) {
+		t.Fatal("found the token on another chain")
+	}
+}
+
+// TestTokenServiceConcurrentDeploy records deployments while balances look the
+// registry up, run it with -race.
+func TestTokenServiceConcurrentDeploy(t *testing.T) {
+	env := newTestEnv(t, 1)
+	reg, err := LoadRegistry(filepath.Join(t.TempDir(), RegistryFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &TokenService{
+		Backend:  env.backend,
+		ChainID:  simChainID,
+		Registry: reg,
+		Transactor: func(ctx context.Context) (*bind.TransactOpts, error) {
+			return env.accounts[0].transactor(t), nil
+		},
+		Wait: func(ctx context.Context, tx *types.Transaction) (*types.Receipt, error) {
+			env.backend.Commit()
+			return env.backend.TransactionReceipt(ctx, tx.Hash())
+		},
+	}
+	ctx := context.Background()
+	args := DeployArgs{big.NewInt(1000), "dtoken", 8, "dt"}
+	if _, err := s.Deploy(ctx, "dtoken", args); err != nil {
+		t.Fatal(err)
+	}
+
+	deployed := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-deployed:
+					return
+				default:
+				}
+				bal, err := s.BalanceOf(ctx, "dtoken", env.accounts[0].addr)
+				if err != nil || bal.Int64() != 1000 {
+					t.Errorf("balance %v: %v", bal, err)
+					return
+				}
+				s.Names()
+			}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := s.Deploy(ctx, fmt.Sprintf("token%d", i), args); err != nil {
+			t.Error(err)
+			break
+		}
+	}
+	close(deployed)
+	wg.Wait()
+}
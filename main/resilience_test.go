@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// newProxiedClient serves env over JSON-RPC behind a fault proxy, and dials the proxy
+// over websocket if ws is set, or http otherwise.
+func newProxiedClient(env *testEnv, ws bool, scenario ...*faultStep) (*ethclient.Client, *faultProxy) {
+	env.t.Helper()
+	node := newSimRPCServer(env, false)
+	proxy := newFaultProxy(env.t, node.URL, scenario...)
+	url := proxy.URL()
+	if ws {
+		url = proxy.WSURL()
+	}
+	client, err := ethclient.Dial(url)
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	env.t.Cleanup(client.Close)
+	return client, proxy
+}
+
+// pendingTransfer sends a transfer without mining it.
+func (env *testEnv) pendingTransfer(contract *EIP20) *types.Transaction {
+	env.t.Helper()
+	tx, err := contract.Transfer(env.accounts[0].transactor(env.t), common.Address{0x11}, big.NewInt(1))
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	return tx
+}
+
+// reorg replaces the last depth blocks with an empty, longer side chain.
+func (env *testEnv) reorg(depth uint64) {
+	head := env.backend.Blockchain().CurrentBlock()
+	parent := env.backend.Blockchain().GetBlockByNumber(head.NumberU64() - depth)
+	if err := env.backend.Fork(context.Background(), parent.Hash()); err != nil {
+		env.t.Error(err)
+		return
+	}
+	for i := uint64(0); i <= depth; i++ {
+		env.backend.Commit()
+	}
+}
+
+func TestWaitReceiptIntermittentErrors(t *testing.T) {
+	env := newTestEnv(t, 1)
+	_, _, contract := env.deploy(1000)
+	client, proxy := newProxiedClient(env, false,
+		&faultStep{Kind: faultError, Method: "eth_getTransactionReceipt", Times: 2},
+		&faultStep{Kind: faultDrop, Method: "eth_getTransactionReceipt", Times: 1},
+	)
+	tx := env.pendingTransfer(contract)
+	env.mine(20 * time.Millisecond)
+
+	if _, err := WaitReceipt(context.Background(), client, tx.Hash(), 10*time.Millisecond, 10, 2); err != nil {
+		t.Fatal(err)
+	}
+	if n := proxy.Calls("eth_getTransactionReceipt"); n < 4 {
+		t.Fatalf("got the receipt after %d calls, the faults aren't injected", n)
+	}
+}
+
+func TestWaitReceiptGivesUp(t *testing.T) {
+	env := newTestEnv(t, 1)
+	_, _, contract := env.deploy(1000)
+	client, proxy := newProxiedClient(env, false,
+		&faultStep{Kind: faultError, Method: "eth_getTransactionReceipt"},
+	)
+	tx := env.pendingTransfer(contract)
+	env.mine(20 * time.Millisecond)
+
+	if _, err := WaitReceipt(context.Background(), client, tx.Hash(), 10*time.Millisecond, 3, 1); err == nil {
+		t.Fatal("got the receipt through a failing rpc-server")
+	}
+	if n := proxy.Calls("eth_getTransactionReceipt"); n != 3 {
+		t.Fatalf("tried %d times, want 3", n)
+	}
+}
+
+func TestWaitReceiptLatency(t *testing.T) {
+	env := newTestEnv(t, 1)
+	_, _, contract := env.deploy(1000)
+	client, _ := newProxiedClient(env, false,
+		&faultStep{Kind: faultLatency, Delay: 50 * time.Millisecond},
+	)
+	tx := env.pendingTransfer(contract)
+	env.mine(20 * time.Millisecond)
+
+	if _, err := WaitReceipt(context.Background(), client, tx.Hash(), 10*time.Millisecond, 3, 2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitReceiptStaleHead(t *testing.T) {
+	env := newTestEnv(t, 1)
+	_, _, contract := env.deploy(1000)
+	client, _ := newProxiedClient(env, false,
+		&faultStep{Kind: faultStaleHead, Method: "eth_blockNumber", Times: 5, Lag: 10},
+	)
+	tx := env.pendingTransfer(contract)
+	env.backend.Commit()
+	env.mine(20 * time.Millisecond)
+
+	receipt, err := WaitReceipt(context.Background(), client, tx.Hash(), 10*time.Millisecond, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the head reported below the receipt must not count as confirmed
+	head, _ := env.backend.BlockNumber(context.Background())
+	if head < receipt.BlockNumber.Uint64()+2 {
+		t.Fatalf("receipt at block %d returned at head %d", receipt.BlockNumber, head)
+	}
+}
+
+func TestWaitReceiptReorg(t *testing.T) {
+	env := newTestEnv(t, 1)
+	_, _, contract := env.deploy(1000)
+	client, proxy := newProxiedClient(env, false,
+		&faultStep{Kind: faultReorg, Method: "eth_getTransactionReceipt", After: 1, Times: 1},
+	)
+	proxy.reorg = func() { env.reorg(1) }
+	tx := env.pendingTransfer(contract)
+	env.backend.Commit()
+
+	// the receipt is seen once, then the reorg drops the transfer
+	if r, err := WaitReceipt(context.Background(), client, tx.Hash(), 10*time.Millisecond, 4, 5); err == nil {
+		t.Fatalf("got the receipt of a reorged tx at block %d", r.BlockNumber)
+	}
+}
+
+func TestWaitReceiptOnNewHeadStaleHead(t *testing.T) {
+	env := newTestEnv(t, 1)
+	_, _, contract := env.deploy(1000)
+	client, proxy := newProxiedClient(env, true,
+		&faultStep{Kind: faultStaleHead, Method: "eth_subscription", Times: 3},
+	)
+	tx := env.pendingTransfer(contract)
+	env.mine(20 * time.Millisecond)
+
+	if _, err := WaitReceiptOnNewHead(context.Background(), client, tx.Hash(), 5, 2); err != nil {
+		t.Fatal(err)
+	}
+	if n := proxy.Calls("eth_getTransactionReceipt"); n > 5 {
+		t.Fatalf("polled the receipt %d times on 3 heads", n)
+	}
+}
+
+func TestWatchTransferEventDroppedNotification(t *testing.T) {
+	env := newTestEnv(t, 1)
+	addr, _, contract := env.deploy(1000)
+	client, _ := newProxiedClient(env, true,
+		&faultStep{Kind: faultDrop, Method: "eth_subscription", Times: 1},
+	)
+	watched, err := NewEIP20(addr, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := goAsync(func() error {
+		return WatchTransferEvent(context.Background(), watched)
+	})
+
+	// the first transfer seen is dropped, the watcher returns on a later one
+	for i := 0; ; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i < 2 {
+				t.Fatalf("watcher returned after %d transfers", i)
+			}
+			return
+		case <-time.After(100 * time.Millisecond):
+			if i > 50 {
+				t.Fatal("watcher didn't return")
+			}
+			env.pendingTransfer(contract)
+			env.backend.Commit()
+		}
+	}
+}
+
+func TestWatchTransferEventDisconnect(t *testing.T) {
+	env := newTestEnv(t, 1)
+	addr, _, _ := env.deploy(1000)
+	client, proxy := newProxiedClient(env, true)
+	watched, err := NewEIP20(addr, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := goAsync(func() error {
+		return WatchTransferEvent(context.Background(), watched)
+	})
+	time.Sleep(100 * time.Millisecond)
+	proxy.Disconnect()
+	if err := waitResult(t, done, 5*time.Second); err == nil {
+		t.Fatal("the watcher returned no error on a disconnect")
+	}
+}
+
+func TestFilterTransferEventLatency(t *testing.T) {
+	env := newTestEnv(t, 1)
+	addr, _, contract := env.deploy(1000)
+	client, _ := newProxiedClient(env, false,
+		&faultStep{Kind: faultLatency, Delay: 50 * time.Millisecond},
+	)
+	filtered, err := NewEIP20(addr, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.pendingTransfer(contract)
+	env.backend.Commit()
+
+	done := goAsync(func() error {
+		return FilterTransferEvent(context.Background(), filtered, client, 0, nil, "")
+	})
+	if err := waitResult(t, done, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
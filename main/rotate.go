@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// actions of a RotationStep
+const (
+	// RotateFund sends the new account the ether of the gas of its approvals
+	RotateFund = "fund"
+	// RotateApprove approves a spender of the old account from the new one
+	RotateApprove = "approve"
+	// RotateRevoke sets the allowance of a spender of the old account to 0
+	RotateRevoke = "revoke"
+	// RotateTransfer moves the balance of the old account to the new one
+	RotateTransfer = "transfer"
+)
+
+// RotationStep is a tx of a key rotation.
+type RotationStep struct {
+	Token  common.Address `json:"token"`
+	Action string         `json:"action"`
+	// Spender of an approval, or the new account of a transfer or a funding
+	Address common.Address `json:"address"`
+	Amount  *big.Int       `json:"amount"`
+	TxHash  *common.Hash   `json:"txHash,omitempty"`
+}
+
+// RotateBackend is what the Rotator needs from the rpc-server, besides its Tokens.
+type RotateBackend interface {
+	bind.ContractBackend
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+}
+
+// Rotator moves the tokens of an account to a new one, the new account taking
+// over the outstanding approvals of the old one. The accounts sign with the
+// Transactor of Tokens, picked by withSigner.
+type Rotator struct {
+	Tokens  *TokenService
+	Backend RotateBackend
+}
+
+// Plan lists the steps moving the tokens and approvals of the old account
+// from to the new one. The approvals go first, then the revocations, so the
+// spenders of the old account can't spend its balance while it's moved.
+// The old account first funds the new one with the gas of its approvals, when
+// it holds less, and Plan fails if the old account can't pay for its steps.
+func (r *Rotator) Plan(ctx context.Context, tokens []common.Address, from, to common.Address) ([]*RotationStep, error) {
+	if from == to {
+		return nil, errors.New("rotation to the same account")
+	}
+	var steps, revokes, transfers []*RotationStep
+	for _, token := range tokens {
+		allowances, err := r.Tokens.Allowances(ctx, token.Hex(), from)
+		if err != nil {
+			return nil, fmt.Errorf("allowances of %s: %w", token, err)
+		}
+		for _, a := range allowances {
+			steps = append(steps, &RotationStep{Token: token, Action: RotateApprove, Address: a.Spender, Amount: a.Amount})
+			revokes = append(revokes, &RotationStep{Token: token, Action: RotateRevoke, Address: a.Spender, Amount: new(big.Int)})
+		}
+		balance, err := r.Tokens.BalanceOf(ctx, token.Hex(), from)
+		if err != nil {
+			return nil, fmt.Errorf("balance of %s: %w", token, err)
+		}
+		if balance.Sign() > 0 {
+			transfers = append(transfers, &RotationStep{Token: token, Action: RotateTransfer, Address: to, Amount: balance})
+		}
+	}
+	steps = append(append(steps, revokes...), transfers...)
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	return r.fund(ctx, steps, from, to)
+}
+
+// fund prepends to steps the funding of the new account, if it lacks the gas of
+// its approvals, and checks the old account holds the gas of the others and the funding.
+func (r *Rotator) fund(ctx context.Context, steps []*RotationStep, from, to common.Address) ([]*RotationStep, error) {
+	// the fee of the txs of the old account, those of the new one are priced alike
+	auth, err := r.Tokens.Transactor(withSigner(ctx, from.Hex()))
+	if err != nil {
+		return nil, err
+	}
+	price, err := maxFeePerGas(ctx, r.Backend, auth)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := EIP20MetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	needFrom, needTo := new(big.Int), new(big.Int)
+	for _, step := range steps {
+		method, signer, need := "approve", from, needFrom
+		switch step.Action {
+		case RotateApprove:
+			signer, need = to, needTo
+		case RotateTransfer:
+			method = "transfer"
+		}
+		data, err := parsed.Pack(method, step.Address, step.Amount)
+		if err != nil {
+			return nil, err
+		}
+		gas, err := r.Backend.EstimateGas(ctx, ethereum.CallMsg{From: signer, To: &step.Token, Data: data})
+		if err != nil {
+			return nil, fmt.Errorf("estimate %s %s of %s: %w", step.Action, step.Address, step.Token, err)
+		}
+		need.Add(need, new(big.Int).Mul(new(big.Int).SetUint64(gas), price))
+	}
+	have, err := r.Backend.BalanceAt(ctx, to, nil)
+	if err != nil {
+		return nil, err
+	}
+	if missing := new(big.Int).Sub(needTo, have); missing.Sign() > 0 {
+		steps = append([]*RotationStep{{Action: RotateFund, Address: to, Amount: missing}}, steps...)
+		needFrom.Add(needFrom, missing)
+		needFrom.Add(needFrom, new(big.Int).Mul(big.NewInt(int64(params.TxGas)), price))
+	}
+	if have, err = r.Backend.BalanceAt(ctx, from, nil); err != nil {
+		return nil, err
+	}
+	if have.Cmp(needFrom) < 0 {
+		return nil, fmt.Errorf("the old account %s holds %s wei, the rotation needs %s at %s wei per gas", from, have, needFrom, price)
+	}
+	return steps, nil
+}
+
+// Rotate sends the steps and waits for each, the approvals signed by the new
+// account to and the rest by the old one from, both names or addresses of
+// accounts. A step failing stops the rotation; planned again, it goes on from there.
+func (r *Rotator) Rotate(ctx context.Context, steps []*RotationStep, from, to string) error {
+	for _, step := range steps {
+		var tx *types.Transaction
+		var err error
+		switch step.Action {
+		case RotateFund:
+			tx, err = r.sendEther(withSigner(ctx, from), step.Address, step.Amount)
+		case RotateApprove:
+			tx, err = r.Tokens.Approve(withSigner(ctx, to), step.Token.Hex(), step.Address, step.Amount)
+		case RotateRevoke:
+			tx, err = r.Tokens.Approve(withSigner(ctx, from), step.Token.Hex(), step.Address, step.Amount)
+		case RotateTransfer:
+			tx, err = r.Tokens.Transfer(withSigner(ctx, from), step.Token.Hex(), step.Address, step.Amount)
+		default:
+			err = fmt.Errorf("unknown action %q", step.Action)
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s of %s: %w", step.Action, step.Address, step.Token, err)
+		}
+		hash := tx.Hash()
+		step.TxHash = &hash
+
+		// the tx is sent, see it through even if a shutdown starts
+		waitCtx, cancel := withGrace(ctx)
+		receipt, err := r.Tokens.Wait(waitCtx, tx)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if receipt.Status != types.ReceiptStatusSuccessful {
+			return fmt.Errorf("%s tx %s of %s failed", step.Action, hash, step.Token)
+		}
+		logger(withTxLog(ctx, tx)).Info("Rotated", "action", step.Action, "token", step.Token, "address", step.Address, "amount", step.Amount)
+	}
+	return nil
+}
+
+// sendEther sends amount wei to to, without waiting for it.
+func (r *Rotator) sendEther(ctx context.Context, to common.Address, amount *big.Int) (*types.Transaction, error) {
+	auth, err := r.Tokens.Transactor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// a fixed gas limit, bind refuses to estimate a send to an address without code
+	auth.Value, auth.GasLimit = amount, params.TxGas
+	return send(ctx, auth, "rotate.fund", bind.NewBoundContract(to, abi.ABI{}, r.Backend, r.Backend, r.Backend).Transfer)
+}
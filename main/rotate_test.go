@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestRotate(t *testing.T) {
+	env := newTestEnv(t, 1)
+	token, _, contract := env.deploy(10000)
+	// the new account holds no ether yet
+	old, next := env.accounts[0], newTestAccount(t)
+	spenderA, spenderB := common.Address{0x0a}, common.Address{0x0b}
+	for spender, amount := range map[common.Address]int64{spenderA: 300, spenderB: 40} {
+		tx, err := contract.Approve(old.transactor(t), spender, big.NewInt(amount))
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.backend.Commit()
+		env.requireSuccess(tx)
+	}
+
+	poor := newTestAccount(t)
+	tx, err := contract.Transfer(old.transactor(t), poor.addr, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.backend.Commit()
+	env.requireSuccess(tx)
+
+	byName := map[string]testAccount{"old": old, "next": next, "poor": poor}
+	r := &Rotator{Backend: env.backend, Tokens: &TokenService{
+		Backend:  env.backend,
+		ChainID:  simChainID,
+		Registry: &Registry{},
+		Transactor: func(ctx context.Context) (*bind.TransactOpts, error) {
+			for name, acc := range byName {
+				if signerOf(ctx) == name || signerOf(ctx) == acc.addr.Hex() {
+					return acc.transactor(t), nil
+				}
+			}
+			return nil, fmt.Errorf("no account %q", signerOf(ctx))
+		},
+		Wait: func(ctx context.Context, tx *types.Transaction) (*types.Receipt, error) {
+			env.backend.Commit()
+			return env.backend.TransactionReceipt(ctx, tx.Hash())
+		},
+	}}
+	ctx := context.Background()
+	steps, err := r.Plan(ctx, []common.Address{token}, old.addr, next.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, s := range steps {
+		actions = append(actions, s.Action)
+	}
+	if strings.Join(actions, " ") != "fund approve approve revoke revoke transfer" || steps[0].Address != next.addr {
+		t.Fatalf("planned %v", actions)
+	}
+	if err := r.Rotate(ctx, steps, "old", "next"); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range steps {
+		if s.TxHash == nil {
+			t.Fatalf("step %s %s not sent", s.Action, s.Address)
+		}
+	}
+
+	env.requireBalance(contract, old.addr, 0)
+	env.requireBalance(contract, next.addr, 9999)
+	for spender, want := range map[common.Address]int64{spenderA: 300, spenderB: 40} {
+		if a, _ := contract.Allowance(nil, next.addr, spender); a.Int64() != want {
+			t.Errorf("allowance of %s from the new account %s, want %d", spender, a, want)
+		}
+		if a, _ := contract.Allowance(nil, old.addr, spender); a.Sign() != 0 {
+			t.Errorf("allowance of %s from the old account %s, want 0", spender, a)
+		}
+	}
+
+	// nothing is left to rotate
+	if steps, err := r.Plan(ctx, []common.Address{token}, old.addr, next.addr); err != nil || len(steps) != 0 {
+		t.Fatalf("planned again %d steps: %v", len(steps), err)
+	}
+	if _, err := r.Plan(ctx, []common.Address{token}, old.addr, old.addr); err == nil {
+		t.Fatal("planned a rotation to the same account")
+	}
+	// an account without the gas of its transfer fails early
+	if _, err := r.Plan(ctx, []common.Address{token}, poor.addr, next.addr); err == nil || !strings.Contains(err.Error(), "holds 0 wei") {
+		t.Fatalf("planned the rotation of an account without ether: %v", err)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sort"
+
+	"github.com/0xcoolface/backend-dapp-demo/main/signing"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+const SafeTxFile = "safetx.json"
+
+// SafeCall is the operation of a SafeTx calling its To, the other one is a delegatecall.
+const SafeCall uint8 = 0
+
+var (
+	ErrNotSafeOwner   = errors.New("not an owner of the safe")
+	ErrSafeThreshold  = errors.New("not enough signatures of the owners")
+	ErrSafeNonce      = errors.New("safe nonce is not that of the transaction")
+	ErrSafeExecFailed = errors.New("safe transaction failed")
+)
+
+// SafeTx is a transaction of a Safe, in the fields of its EIP-712 SafeTx type,
+// with the owners and the threshold of the Safe when it was built. The owners
+// sign it offline, then anyone may execute it with their signatures.
+type SafeTx struct {
+	ChainID        uint64         `json:"chainId"`
+	Safe           common.Address `json:"safe"`
+	To             common.Address `json:"to"`
+	Value          *big.Int       `json:"value"`
+	Data           hexutil.Bytes  `json:"data"`
+	Operation      uint8          `json:"operation"`
+	SafeTxGas      *big.Int       `json:"safeTxGas"`
+	BaseGas        *big.Int       `json:"baseGas"`
+	GasPrice       *big.Int       `json:"gasPrice"`
+	GasToken       common.Address `json:"gasToken"`
+	RefundReceiver common.Address `json:"refundReceiver"`
+	Nonce          *big.Int       `json:"nonce"`
+
+	Owners    []common.Address `json:"owners"`
+	Threshold uint64           `json:"threshold"`
+	// Signatures of the owners, v 27 or 28 for the typed data, 31 or 32 for eth_sign of the hash
+	Signatures map[common.Address]hexutil.Bytes `json:"signatures"`
+	// ExecTxHash is the execTransaction tx, once sent
+	ExecTxHash *common.Hash `json:"execTxHash,omitempty"`
+}
+
+// SafeTokenCall packs a call of method of EIP20, like transfer or approve, for the To of a SafeTx.
+func SafeTokenCall(method string, args ...interface{}) ([]byte, error) {
+	parsed, err := EIP20MetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	return parsed.Pack(method, args...)
+}
+
+// LoadSafeTx reads a SafeTx saved by Save.
+func LoadSafeTx(path string) (*SafeTx, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t SafeTx
+	if err := json.Unmarshal(bs, &t); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &t, nil
+}
+
+// Save writes t to path, atomically.
+func (t *SafeTx) Save(path string) error {
+	bs, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, bs)
+}
+
+// TypedData returns t as the owners sign it with eth_signTypedData_v4.
+func (t *SafeTx) TypedData() *signing.TypedData {
+	return &signing.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": {
+				{Name: "chainId", Type: "uint256"},
+				{Name: "verifyingContract", Type: "address"},
+			},
+			"SafeTx": {
+				{Name: "to", Type: "address"},
+				{Name: "value", Type: "uint256"},
+				{Name: "data", Type: "bytes"},
+				{Name: "operation", Type: "uint8"},
+				{Name: "safeTxGas", Type: "uint256"},
+				{Name: "baseGas", Type: "uint256"},
+				{Name: "gasPrice", Type: "uint256"},
+				{Name: "gasToken", Type: "address"},
+				{Name: "refundReceiver", Type: "address"},
+				{Name: "nonce", Type: "uint256"},
+			},
+		},
+		PrimaryType: "SafeTx",
+		Domain: apitypes.TypedDataDomain{
+			ChainId:           math.NewHexOrDecimal256(int64(t.ChainID)),
+			VerifyingContract: t.Safe.Hex(),
+		},
+		Message: apitypes.TypedDataMessage{
+			"to":             t.To.Hex(),
+			"value":          t.Value.String(),
+			"data":           t.Data.String(),
+			"operation":      fmt.Sprint(t.Operation),
+			"safeTxGas":      t.SafeTxGas.String(),
+			"baseGas":        t.BaseGas.String(),
+			"gasPrice":       t.GasPrice.String(),
+			"gasToken":       t.GasToken.Hex(),
+			"refundReceiver": t.RefundReceiver.Hex(),
+			"nonce":          t.Nonce.String(),
+		},
+	}
+}
+
+// Hash returns the Safe tx hash of t, the one the owners sign.
+func (t *SafeTx) Hash() (common.Hash, error) {
+	return signing.TypedDataHash(t.TypedData())
+}
+
+// Sign adds the signature of an owner.
+func (t *SafeTx) Sign(s *signing.Signer) error {
+	if !t.isOwner(s.Address()) {
+		return fmt.Errorf("%w: %s", ErrNotSafeOwner, s.Address())
+	}
+	sig, err := s.SignTypedData(t.TypedData())
+	if err != nil {
+		return err
+	}
+	t.addSignature(s.Address(), sig)
+	return nil
+}
+
+// AddSignature adds a signature of an owner made elsewhere, of the typed data,
+// or of the hash with eth_sign, and returns the owner.
+func (t *SafeTx) AddSignature(sig []byte) (common.Address, error) {
+	hash, err := t.Hash()
+	if err != nil {
+		return common.Address{}, err
+	}
+	sig = common.CopyBytes(sig)
+	if len(sig) == crypto.SignatureLength && sig[crypto.RecoveryIDOffset] > 30 {
+		sig[crypto.RecoveryIDOffset] -= 4
+	}
+	if owner, err := signing.RecoverTypedData(t.TypedData(), sig); err == nil && t.isOwner(owner) {
+		t.addSignature(owner, sig)
+		return owner, nil
+	}
+	owner, err := signing.RecoverMessage(hash[:], sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if !t.isOwner(owner) {
+		return common.Address{}, fmt.Errorf("%w: signed by %s", ErrNotSafeOwner, owner)
+	}
+	// the Safe tells eth_sign signatures by v over 30
+	t.addSignature(owner, sig)
+	t.Signatures[owner][crypto.RecoveryIDOffset] += 4
+	return owner, nil
+}
+
+func (t *SafeTx) addSignature(owner common.Address, sig []byte) {
+	sig = common.CopyBytes(sig)
+	if sig[crypto.RecoveryIDOffset] < 27 {
+		sig[crypto.RecoveryIDOffset] += 27
+	}
+	if t.Signatures == nil {
+		t.Signatures = make(map[common.Address]hexutil.Bytes)
+	}
+	t.Signatures[owner] = sig
+}
+
+func (t *SafeTx) isOwner(addr common.Address) bool {
+	for _, owner := range t.Owners {
+		if owner == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// PackedSignatures returns the signatures of execTransaction, in the ascending
+// order of the owners the Safe checks them in.
+func (t *SafeTx) PackedSignatures() ([]byte, error) {
+	if uint64(len(t.Signatures)) < t.Threshold {
+		return nil, fmt.Errorf("%w: %d of %d", ErrSafeThreshold, len(t.Signatures), t.Threshold)
+	}
+	owners := make([]common.Address, 0, len(t.Signatures))
+	for owner := range t.Signatures {
+		owners = append(owners, owner)
+	}
+	sort.Slice(owners, func(i, j int) bool { return bytes.Compare(owners[i][:], owners[j][:]) < 0 })
+	var packed []byte
+	for _, owner := range owners {
+		packed = append(packed, t.Signatures[owner]...)
+	}
+	return packed, nil
+}
+
+// SafeService builds the transactions of a Safe, and executes them once signed.
+type SafeService struct {
+	Backend bind.ContractBackend
+	ChainID *big.Int
+	// Transactor signs the execTransaction txs, the account needs not be an owner
+	Transactor func(ctx context.Context) (*bind.TransactOpts, error)
+	Wait       func(ctx context.Context, tx *types.Transaction) (*types.Receipt, error)
+}
+
+// NewTx builds a call of to with the next nonce of safe, with no refund of the gas.
+// Its hash is checked against the one of the Safe.
+func (s *SafeService) NewTx(ctx context.Context, safe, to common.Address, value *big.Int, data []byte) (*SafeTx, error) {
+	caller, err := NewSafeCaller(safe, s.Backend)
+	if err != nil {
+		return nil, err
+	}
+	opts := &bind.CallOpts{Context: ctx}
+	nonce, err := caller.Nonce(opts)
+	if err != nil {
+		return nil, fmt.Errorf("nonce of safe %s: %w", safe, err)
+	}
+	owners, err := caller.GetOwners(opts)
+	if err != nil {
+		return nil, err
+	}
+	threshold, err := caller.GetThreshold(opts)
+	if err != nil {
+		return nil, err
+	}
+	t := &SafeTx{
+		ChainID:   s.ChainID.Uint64(),
+		Safe:      safe,
+		To:        to,
+		Value:     value,
+		Data:      data,
+		Operation: SafeCall,
+		SafeTxGas: new(big.Int),
+		BaseGas:   new(big.Int),
+		GasPrice:  new(big.Int),
+		Nonce:     nonce,
+		Owners:    owners,
+		Threshold: threshold.Uint64(),
+	}
+	hash, err := t.Hash()
+	if err != nil {
+		return nil, err
+	}
+	onchain, err := caller.GetTransactionHash(opts, t.To, t.Value, t.Data, t.Operation, t.SafeTxGas, t.BaseGas, t.GasPrice, t.GasToken, t.RefundReceiver, t.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	if hash != onchain {
+		return nil, fmt.Errorf("safe tx hash %s, the safe hashes it %s", hash, common.Hash(onchain))
+	}
+	return t, nil
+}
+
+// NewTokenTx builds a call of method of token, like transfer or approve.
+func (s *SafeService) NewTokenTx(ctx context.Context, safe, token common.Address, method string, args ...interface{}) (*SafeTx, error) {
+	data, err := SafeTokenCall(method, args...)
+	if err != nil {
+		return nil, err
+	}
+	return s.NewTx(ctx, safe, token, new(big.Int), data)
+}
+
+// Exec sends execTransaction with the signatures of t, and waits for the Safe to execute it.
+func (s *SafeService) Exec(ctx context.Context, t *SafeTx) (*types.Receipt, error) {
+	hash, err := t.Hash()
+	if err != nil {
+		return nil, err
+	}
+	sigs, err := t.PackedSignatures()
+	if err != nil {
+		return nil, err
+	}
+	safe, err := NewSafe(t.Safe, s.Backend)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := safe.Nonce(&bind.CallOpts{Context: ctx})
+	if err != nil {
+		return nil, err
+	}
+	if nonce.Cmp(t.Nonce) != 0 {
+		return nil, fmt.Errorf("%w: the safe is at %s, the transaction at %s", ErrSafeNonce, nonce, t.Nonce)
+	}
+	auth, err := s.Transactor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx = withLog(ctx, "safe", t.Safe, "safeTxHash", hash)
+	tx, err := send(ctx, auth, "Safe.execTransaction", func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return safe.ExecTransaction(opts, t.To, t.Value, t.Data, t.Operation, t.SafeTxGas, t.BaseGas, t.GasPrice, t.GasToken, t.RefundReceiver, sigs)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("execTransaction: %w", err)
+	}
+	txHash := tx.Hash()
+	t.ExecTxHash = &txHash
+	ctx = withTxLog(ctx, tx)
+	logger(ctx).Info("Send safe execTransaction tx", "to", t.To, "nonce", t.Nonce)
+
+	// the tx is sent, see it through even if a shutdown starts
+	waitCtx, cancel := withGrace(ctx)
+	defer cancel()
+	receipt, err := s.Wait(waitCtx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return receipt, fmt.Errorf("%w: execTransaction tx %s reverted", ErrSafeExecFailed, txHash)
+	}
+	for _, l := range receipt.Logs {
+		if l.Address != t.Safe {
+			continue
+		}
+		if ev, err := safe.ParseExecutionSuccess(*l); err == nil && ev.TxHash == hash {
+			logger(ctx).Info("Safe transaction is executed", "block", receipt.BlockNumber)
+			return receipt, nil
+		}
+		if ev, err := safe.ParseExecutionFailure(*l); err == nil && ev.TxHash == hash {
+			return receipt, fmt.Errorf("%w: the call of %s failed", ErrSafeExecFailed, t.To)
+		}
+	}
+	return receipt, fmt.Errorf("%w: no execution event of %s", ErrSafeExecFailed, hash)
+}
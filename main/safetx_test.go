@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0xcoolface/backend-dapp-demo/main/signing"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// deploySafe deploys testdata/TestSafe.sol, a Safe reduced to the owners, the
+// threshold and execTransaction.
+func (env *testEnv) deploySafe(owners []common.Address, threshold int64) common.Address {
+	env.t.Helper()
+	bs, err := ioutil.ReadFile("testdata/TestSafe.json")
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	var combined struct {
+		Contracts map[string]struct {
+			Abi string `json:"abi"`
+			Bin string `json:"bin"`
+		} `json:"contracts"`
+	}
+	if err := json.Unmarshal(bs, &combined); err != nil {
+		env.t.Fatal(err)
+	}
+	if len(combined.Contracts) != 1 {
+		env.t.Fatalf("%d contracts in TestSafe.json", len(combined.Contracts))
+	}
+	var contract struct{ Abi, Bin string }
+	for _, c := range combined.Contracts {
+		contract.Abi, contract.Bin = c.Abi, c.Bin
+	}
+	parsed, err := abi.JSON(strings.NewReader(contract.Abi))
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	addr, tx, _, err := bind.DeployContract(env.accounts[0].transactor(env.t), parsed, common.FromHex(contract.Bin), env.backend, owners, big.NewInt(threshold))
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	env.backend.Commit()
+	env.requireSuccess(tx)
+	return addr
+}
+
+func TestSafeTx(t *testing.T) {
+	env := newTestEnv(t, 1)
+	token, _, contract := env.deploy(10000)
+	a, b, c := newTestAccount(t), newTestAccount(t), newTestAccount(t)
+	safe := env.deploySafe([]common.Address{a.addr, b.addr, c.addr}, 2)
+	tx, err := contract.Transfer(env.accounts[0].transactor(t), safe, big.NewInt(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.backend.Commit()
+	env.requireSuccess(tx)
+
+	// the executor is not an owner
+	s := &SafeService{
+		Backend: env.backend,
+		ChainID: simChainID,
+		Transactor: func(ctx context.Context) (*bind.TransactOpts, error) {
+			return env.accounts[0].transactor(t), nil
+		},
+		Wait: func(ctx context.Context, tx *types.Transaction) (*types.Receipt, error) {
+			env.backend.Commit()
+			return env.backend.TransactionReceipt(ctx, tx.Hash())
+		},
+	}
+	ctx := context.Background()
+	recipient := common.Address{0x11}
+	st, err := s.NewTokenTx(ctx, safe, token, "transfer", recipient, big.NewInt(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Nonce.Sign() != 0 || st.Threshold != 2 || len(st.Owners) != 3 {
+		t.Fatalf("built %+v", st)
+	}
+	hash, err := st.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the owners sign offline, from the saved tx
+	path := filepath.Join(t.TempDir(), SafeTxFile)
+	if err := st.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if st, err = LoadSafeTx(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Sign(signing.NewSigner(a.key)); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Sign(signing.NewSigner(newTestAccount(t).key)); !errors.Is(err, ErrNotSafeOwner) {
+		t.Fatalf("signed by a stranger: %v", err)
+	}
+	if _, err := s.Exec(ctx, st); !errors.Is(err, ErrSafeThreshold) {
+		t.Fatalf("executed with 1 signature of 2: %v", err)
+	}
+	// b signs the hash with eth_sign
+	sig, err := signing.NewSigner(b.key).SignMessage(hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner, err := st.AddSignature(sig); err != nil || owner != b.addr {
+		t.Fatalf("added the eth_sign signature of %s: %v", owner, err)
+	}
+	if v := st.Signatures[b.addr][crypto.RecoveryIDOffset]; v != 31 && v != 32 {
+		t.Fatalf("eth_sign signature with v %d", v)
+	}
+	if _, err := s.Exec(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	if st.ExecTxHash == nil {
+		t.Fatal("no execTransaction tx recorded")
+	}
+	env.requireBalance(contract, recipient, 100)
+	env.requireBalance(contract, safe, 900)
+	if _, err := s.Exec(ctx, st); !errors.Is(err, ErrSafeNonce) {
+		t.Fatalf("executed twice: %v", err)
+	}
+
+	// c signs the typed data in a wallet, given as eth_signTypedData_v4 JSON
+	spender := common.Address{0x22}
+	st, err = s.NewTokenTx(ctx, safe, token, "approve", spender, big.NewInt(50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Nonce.Int64() != 1 {
+		t.Fatalf("second tx with nonce %s", st.Nonce)
+	}
+	bs, err := json.Marshal(st.TypedData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	td, err := signing.ParseTypedData(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, owner := range []testAccount{c, a} {
+		sig, err := signing.NewSigner(owner.key).SignTypedData(td)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := st.AddSignature(sig); err != nil || got != owner.addr {
+			t.Fatalf("added the signature of %s: %v", got, err)
+		}
+	}
+	if _, err := s.Exec(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	if allowance, _ := contract.Allowance(nil, safe, spender); allowance.Int64() != 50 {
+		t.Fatalf("allowance %s, want 50", allowance)
+	}
+
+	// a call failing in the Safe reverts execTransaction, with no refund of its gas
+	st, err = s.NewTokenTx(ctx, safe, token, "transfer", recipient, big.NewInt(5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Sign(signing.NewSigner(a.key))
+	st.Sign(signing.NewSigner(b.key))
+	if _, err := s.Exec(ctx, st); err == nil || !strings.Contains(err.Error(), "GS013") {
+		t.Fatalf("executed a transfer over the balance: %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// TokenService runs the token operations of every entry point:
+// the commands, the gRPC server and the http APIs.
+type TokenService struct {
+	Backend  bind.ContractBackend
+	ChainID  *big.Int
+	Registry *Registry
+	// Addresses are the tokens of the network profile by name, looked up before the registry
+	Addresses map[string]common.Address
+	// Transactor signs the txs sent under ctx
+	Transactor func(ctx context.Context) (*bind.TransactOpts, error)
+	// Wait waits for the receipt of a sent tx
+	Wait func(ctx context.Context, tx *types.Transaction) (*types.Receipt, error)
+}
+
+// TokenInfo is what EIP20Caller tells about a token.
+type TokenInfo struct {
+	Address     common.Address
+	Name        string
+	Symbol      string
+	Decimals    uint8
+	TotalSupply *big.Int
+}
+
+// TokenAddress looks the token registered as name up, or parses it as a hex address.
+func (s *TokenService) TokenAddress(name string) (common.Address, error) {
+	if common.IsHexAddress(name) {
+		return common.HexToAddress(name), nil
+	}
+	if addr, ok := s.Addresses[name]; ok {
+		return addr, nil
+	}
+	d, err := s.Registry.Lookup(s.ChainID, name)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return d.Address, nil
+}
+
+// Names returns the names of the tokens of the network profile and of the registry, sorted.
+func (s *TokenService) Names() []string {
+	names := s.Registry.Names(s.ChainID)
+	for name := range s.Addresses {
+		if _, err := s.Registry.Lookup(s.ChainID, name); err != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Deploy deploys an EIP20 token, waits for it and records it in the registry under name.
+func (s *TokenService) Deploy(ctx context.Context, name string, args DeployArgs) (*Deployment, error) {
+	auth, err := s.Transactor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var addr common.Address
+	tx, err := send(ctx, auth, "EIP20.deploy", func(opts *bind.TransactOpts) (tx *types.Transaction, err error) {
+		addr, tx, _, err = DeployEIP20(opts, s.Backend, args.InitialAmount, args.Name, args.Decimals, args.Symbol)
+		return tx, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("DeployEIP20: %w", err)
+	}
+	ctx = withTxLog(withLog(ctx, "contract", addr), tx)
+	logger(ctx).Info("Send deploy tx", "name", name)
+
+	// the tx is sent, see it through even if a shutdown starts
+	waitCtx, cancel := withGrace(ctx)
+	defer cancel()
+	receipt, err := s.Wait(waitCtx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if receipt.Status == types.ReceiptStatusSuccessful {
+		logger(ctx).Info("Contract is deployed", "block", receipt.BlockNumber)
+	} else {
+		strres, _ := json.Marshal(receipt)
+		logger(ctx).Error("Deploy tx failed", "receipt", string(strres))
+		return nil, errors.New("deploy contract failed")
+	}
+
+	runtime, err := RuntimeBytecode(common.FromHex(EIP20MetaData.Bin))
+	if err != nil {
+		return nil, err
+	}
+	d := &Deployment{
+		Address:      receipt.ContractAddress,
+		TxHash:       tx.Hash(),
+		BlockNumber:  receipt.BlockNumber.Uint64(),
+		Args:         args,
+		BytecodeHash: BytecodeHash(runtime),
+	}
+	if err := s.Registry.Record(s.ChainID, name, d); err != nil {
+		return nil, fmt.Errorf("record deployment: %w", err)
+	}
+	return d, nil
+}
+
+// Transfer sends a transfer of amount tokens to to, without waiting for it.
+func (s *TokenService) Transfer(ctx context.Context, token string, to common.Address, amount *big.Int) (*types.Transaction, error) {
+	return s.transact(ctx, token, "transfer", func(c *EIP20, opts *bind.TransactOpts) (*types.Transaction, error) {
+		return c.Transfer(opts, to, amount)
+	}, "to", to, "amount", amount)
+}
+
+// Approve sends an approval of spender to spend amount tokens, without waiting for it.
+func (s *TokenService) Approve(ctx context.Context, token string, spender common.Address, amount *big.Int) (*types.Transaction, error) {
+	return s.transact(ctx, token, "approve", func(c *EIP20, opts *bind.TransactOpts) (*types.Transaction, error) {
+		return c.Approve(opts, spender, amount)
+	}, "spender", spender, "amount", amount)
+}
+
+// TransferFrom sends a transfer of amount tokens from from to to, spending the
+// allowance of the account, without waiting for it.
+func (s *TokenService) TransferFrom(ctx context.Context, token string, from, to common.Address, amount *big.Int) (*types.Transaction, error) {
+	return s.transact(ctx, token, "transferFrom", func(c *EIP20, opts *bind.TransactOpts) (*types.Transaction, error) {
+		return c.TransferFrom(opts, from, to, amount)
+	}, "from", from, "to", to, "amount", amount)
+}
+
+// transact sends a tx calling method of token.
+func (s *TokenService) transact(ctx context.Context, token, method string, call func(*EIP20, *bind.TransactOpts) (*types.Transaction, error), logCtx ...interface{}) (*types.Transaction, error) {
+	addr, err := s.TokenAddress(token)
+	if err != nil {
+		return nil, err
+	}
+	ctx = withLog(ctx, "contract", addr)
+	contract, err := NewEIP20(addr, s.Backend)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := s.Transactor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := send(ctx, auth, "EIP20."+method, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return call(contract, opts)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", method, err)
+	}
+	logger(withTxLog(ctx, tx)).Info("Send erc20 "+method+" tx", logCtx...)
+	return tx, nil
+}
+
+// Info reads the name, symbol, decimals and total supply of token.
+func (s *TokenService) Info(ctx context.Context, token string) (*TokenInfo, error) {
+	caller, addr, err := s.caller(token)
+	if err != nil {
+		return nil, err
+	}
+	opts := &bind.CallOpts{Context: ctx}
+	info := &TokenInfo{Address: addr}
+	if info.Name, err = caller.Name(opts); err != nil {
+		return nil, err
+	}
+	if info.Symbol, err = caller.Symbol(opts); err != nil {
+		return nil, err
+	}
+	if info.Decimals, err = caller.Decimals(opts); err != nil {
+		return nil, err
+	}
+	if info.TotalSupply, err = caller.TotalSupply(opts); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// BalanceOf reads the balance of owner.
+func (s *TokenService) BalanceOf(ctx context.Context, token string, owner common.Address) (*big.Int, error) {
+	caller, _, err := s.caller(token)
+	if err != nil {
+		return nil, err
+	}
+	return caller.BalanceOf(&bind.CallOpts{Context: ctx}, owner)
+}
+
+// Allowance reads how much spender may spend of owner's tokens.
+func (s *TokenService) Allowance(ctx context.Context, token string, owner, spender common.Address) (*big.Int, error) {
+	caller, _, err := s.caller(token)
+	if err != nil {
+		return nil, err
+	}
+	return caller.Allowance(&bind.CallOpts{Context: ctx}, owner, spender)
+}
+
+// SpenderAllowance is the allowance of a spender.
+type SpenderAllowance struct {
+	Spender common.Address `json:"spender"`
+	Amount  *big.Int       `json:"amount"`
+}
+
+// Allowances lists the spenders owner ever approved, from the Approval events since
+// the deployment of token, with what they may spend now. The spent out ones are left out.
+func (s *TokenService) Allowances(ctx context.Context, token string, owner common.Address) ([]*SpenderAllowance, error) {
+	caller, addr, err := s.caller(token)
+	if err != nil {
+		return nil, err
+	}
+	start := uint64(0)
+	if d, err := s.Registry.Lookup(s.ChainID, token); err == nil {
+		start = d.BlockNumber
+	}
+	filterer, err := NewEIP20Filterer(addr, s.Backend)
+	if err != nil {
+		return nil, err
+	}
+	it, err := filterer.FilterApproval(&bind.FilterOpts{Start: start, Context: ctx}, []common.Address{owner}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	seen := make(map[common.Address]bool)
+	var spenders []common.Address
+	for it.Next() {
+		if !seen[it.Event.Spender] {
+			seen[it.Event.Spender] = true
+			spenders = append(spenders, it.Event.Spender)
+		}
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+	allowances := []*SpenderAllowance{}
+	for _, spender := range spenders {
+		amount, err := caller.Allowance(&bind.CallOpts{Context: ctx}, owner, spender)
+		if err != nil {
+			return nil, err
+		}
+		if amount.Sign() > 0 {
+			allowances = append(allowances, &SpenderAllowance{spender, amount})
+		}
+	}
+	return allowances, nil
+}
+
+func (s *TokenService) caller(token string) (*EIP20Caller, common.Address, error) {
+	addr, err := s.TokenAddress(token)
+	if err != nil {
+		return nil, common.Address{}, err
+	}
+	caller, err := NewEIP20Caller(addr, s.Backend)
+	return caller, addr, err
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/0xcoolface/backend-dapp-demo/main/signing"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// SignCredentialsFile lists the Credentials which may sign with the account of the service.
+const SignCredentialsFile = "sign-credentials.json"
+
+// SignPolicyFile is the SignPolicy of the signing API.
+const SignPolicyFile = "sign-policy.json"
+
+// ErrTypedDataNotAllowed is returned for typed data of no TypedDataRule.
+var ErrTypedDataNotAllowed = errors.New("typed data not allowed")
+
+// SignPolicy is what the signing API signs, and with which account.
+type SignPolicy struct {
+	// Account is the name of the account of the config signing, which may not be the default one
+	Account string `json:"account"`
+	// TypedData are the only typed data signed
+	TypedData []TypedDataRule `json:"typedData,omitempty"`
+}
+
+// TypedDataRule allows the typed data of PrimaryType in the domain of Name.
+// The other fields of the domain match any value when left out.
+type TypedDataRule struct {
+	PrimaryType       string          `json:"primaryType"`
+	Name              string          `json:"name"`
+	Version           string          `json:"version,omitempty"`
+	ChainID           uint64          `json:"chainId,omitempty"`
+	VerifyingContract *common.Address `json:"verifyingContract,omitempty"`
+}
+
+// LoadSignPolicy reads the policy file. A missing file gives nil, no signing.
+func LoadSignPolicy(path string) (*SignPolicy, error) {
+	bs, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p SignPolicy
+	if err := json.Unmarshal(bs, &p); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if p.Account == "" || p.Account == DefaultAccount {
+		return nil, fmt.Errorf("%s: the account must be a dedicated account of the config, not the default one", path)
+	}
+	for _, r := range p.TypedData {
+		if r.PrimaryType == "" || r.Name == "" {
+			return nil, fmt.Errorf("%s: a typed data rule needs a primaryType and a name", path)
+		}
+	}
+	return &p, nil
+}
+
+// Allows is true for the typed data of one of the rules.
+func (p *SignPolicy) Allows(td *signing.TypedData) bool {
+	for _, r := range p.TypedData {
+		if r.matches(td) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *TypedDataRule) matches(td *signing.TypedData) bool {
+	d := td.Domain
+	switch {
+	case td.PrimaryType != r.PrimaryType || d.Name != r.Name:
+		return false
+	case r.Version != "" && d.Version != r.Version:
+		return false
+	case r.ChainID != 0 && (d.ChainId == nil || (*big.Int)(d.ChainId).Cmp(new(big.Int).SetUint64(r.ChainID)) != 0):
+		return false
+	case r.VerifyingContract != nil && (!common.IsHexAddress(d.VerifyingContract) || common.HexToAddress(d.VerifyingContract) != *r.VerifyingContract):
+		return false
+	}
+	return true
+}
+
+// LoadCredentials reads a list of Credentials. A missing file gives nil.
+func LoadCredentials(path string) ([]Credential, error) {
+	bs, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var creds []Credential
+	if err := json.Unmarshal(bs, &creds); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return creds, nil
+}
+
+// SignAPI signs messages and typed data with the account of the service, and
+// verifies the signatures of anyone:
+//
+//	POST /sign/message         {"message": "text"} or {"data": "0x.."}
+//	POST /sign/typed-data      typed data as given to eth_signTypedData_v4
+//	POST /verify/message       {"message": "text", "signature": "0x..", "address": "0x.."}
+//	POST /verify/typed-data    {"typedData": {..}, "signature": "0x..", "address": "0x.."}
+//
+// Signing needs the bearer token of one of Credentials, and is not served
+// without a Signer and a Policy. Only the typed data the Policy allows are
+// signed, and no data of 32 bytes, a hash like that of a Safe tx which its
+// owners sign with eth_sign. The address to verify against is optional.
+type SignAPI struct {
+	Signer      *signing.Signer
+	Policy      *SignPolicy
+	Credentials []Credential
+}
+
+// SignResult is a signature of the Signer.
+type SignResult struct {
+	Address   common.Address `json:"address"`
+	Hash      common.Hash    `json:"hash"`
+	Signature hexutil.Bytes  `json:"signature"`
+}
+
+// VerifyResult tells who signed.
+type VerifyResult struct {
+	Signer common.Address `json:"signer"`
+	// Valid is whether the signer is the address asked
+	Valid bool `json:"valid"`
+}
+
+type messageBody struct {
+	Message *string       `json:"message"`
+	Data    hexutil.Bytes `json:"data"`
+}
+
+func (b *messageBody) bytes() ([]byte, error) {
+	switch {
+	case b.Message != nil && b.Data != nil:
+		return nil, errors.New("either a message or data, not both")
+	case b.Message != nil:
+		return []byte(*b.Message), nil
+	case b.Data != nil:
+		return b.Data, nil
+	}
+	return nil, errors.New("a message or data is required")
+}
+
+type verifyBody struct {
+	messageBody
+	TypedData json.RawMessage `json:"typedData"`
+	Signature hexutil.Bytes   `json:"signature"`
+	Address   *common.Address `json:"address"`
+}
+
+func (a *SignAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeResult(w, http.StatusMethodNotAllowed, apiError{"POST only"})
+		return
+	}
+	switch r.URL.Path {
+	case "/sign/message", "/sign/typed-data":
+		if a.Signer == nil || a.Policy == nil {
+			writeResult(w, http.StatusNotFound, apiError{"not found"})
+			return
+		}
+		if _, ok := authenticate(a.Credentials, bearerToken(r)); !ok {
+			writeResult(w, http.StatusUnauthorized, apiError{"a signing token is required"})
+			return
+		}
+		a.sign(w, r)
+	case "/verify/message", "/verify/typed-data":
+		a.verify(w, r)
+	default:
+		writeResult(w, http.StatusNotFound, apiError{"not found"})
+	}
+}
+
+func (a *SignAPI) sign(w http.ResponseWriter, r *http.Request) {
+	bs, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeResult(w, http.StatusBadRequest, apiError{err.Error()})
+		return
+	}
+	var hash common.Hash
+	var sig []byte
+	if r.URL.Path == "/sign/typed-data" {
+		td, err := signing.ParseTypedData(bs)
+		if err != nil {
+			writeResult(w, http.StatusBadRequest, apiError{"invalid typed data: " + err.Error()})
+			return
+		}
+		if !a.Policy.Allows(td) {
+			writeResult(w, http.StatusForbidden, apiError{fmt.Sprintf("%s: %s of %q", ErrTypedDataNotAllowed, td.PrimaryType, td.Domain.Name)})
+			return
+		}
+		if hash, err = signing.TypedDataHash(td); err == nil {
+			sig, err = a.Signer.SignTypedData(td)
+		}
+	} else {
+		var body messageBody
+		if err := json.Unmarshal(bs, &body); err != nil {
+			writeResult(w, http.StatusBadRequest, apiError{"invalid body: " + err.Error()})
+			return
+		}
+		msg, err := body.bytes()
+		if err != nil {
+			writeResult(w, http.StatusBadRequest, apiError{err.Error()})
+			return
+		}
+		if len(msg) == common.HashLength {
+			writeResult(w, http.StatusForbidden, apiError{"data of 32 bytes is a hash, not signed"})
+			return
+		}
+		hash = signing.MessageHash(msg)
+		sig, err = a.Signer.SignMessage(msg)
+	}
+	if err != nil {
+		writeResult(w, http.StatusBadRequest, apiError{err.Error()})
+		return
+	}
+	writeResult(w, http.StatusOK, &SignResult{Address: a.Signer.Address(), Hash: hash, Signature: sig})
+}
+
+func (a *SignAPI) verify(w http.ResponseWriter, r *http.Request) {
+	var body verifyBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeResult(w, http.StatusBadRequest, apiError{"invalid body: " + err.Error()})
+		return
+	}
+	if body.Address == nil {
+		writeResult(w, http.StatusBadRequest, apiError{"the address of the signer is required"})
+		return
+	}
+	var signer common.Address
+	var err error
+	if r.URL.Path == "/verify/typed-data" {
+		td, perr := parseTypedDataParam(body.TypedData)
+		if perr != nil {
+			writeResult(w, http.StatusBadRequest, apiError{"invalid typed data: " + perr.Error()})
+			return
+		}
+		signer, err = signing.RecoverTypedData(td, body.Signature)
+	} else {
+		msg, merr := body.bytes()
+		if merr != nil {
+			writeResult(w, http.StatusBadRequest, apiError{merr.Error()})
+			return
+		}
+		signer, err = signing.RecoverMessage(msg, body.Signature)
+	}
+	if err != nil {
+		writeResult(w, http.StatusBadRequest, apiError{err.Error()})
+		return
+	}
+	writeResult(w, http.StatusOK, &VerifyResult{Signer: signer, Valid: *body.Address == signer})
+}
+
+// parseTypedDataParam takes the typed data as an object, or as the JSON string
+// wallets pass to eth_signTypedData_v4.
+func parseTypedDataParam(raw json.RawMessage) (*signing.TypedData, error) {
+	if len(raw) == 0 {
+		return nil, errors.New("typedData is required")
+	}
+	if strings.HasPrefix(string(raw), `"`) {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		raw = json.RawMessage(s)
+	}
+	return signing.ParseTypedData(raw)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0xcoolface/backend-dapp-demo/main/signing"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const permitTypedData = `{
+  "types": {
+    "EIP712Domain": [
+      {"name": "name", "type": "string"},
+      {"name": "chainId", "type": "uint256"}
+    ],
+    "Permit": [
+      {"name": "owner", "type": "address"},
+      {"name": "value", "type": "uint256"}
+    ]
+  },
+  "primaryType": "Permit",
+  "domain": {"name": "DToken", "chainId": "0x539"},
+  "message": {"owner": "0x0100000000000000000000000000000000000000", "value": "100"}
+}`
+
+func TestSignAPI(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	signer := signing.NewSigner(key)
+	sum := sha256.Sum256([]byte("secret"))
+	policy := &SignPolicy{Account: "signing", TypedData: []TypedDataRule{{PrimaryType: "Order", Name: "DToken", ChainID: 1337}}}
+	srv := httptest.NewServer(&SignAPI{Signer: signer, Policy: policy, Credentials: []Credential{{Name: "ops", TokenHash: hex.EncodeToString(sum[:])}}})
+	defer srv.Close()
+
+	call := func(path, bearer string, body interface{}, out interface{}) int {
+		t.Helper()
+		bs, ok := body.([]byte)
+		if !ok {
+			bs, _ = json.Marshal(body)
+		}
+		req, _ := http.NewRequest("POST", srv.URL+path, bytes.NewReader(bs))
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if out != nil {
+			json.NewDecoder(resp.Body).Decode(out)
+		}
+		return resp.StatusCode
+	}
+
+	message := map[string]string{"message": "hello"}
+	if code := call("/sign/message", "", message, nil); code != http.StatusUnauthorized {
+		t.Fatalf("sign without a token: %d", code)
+	}
+	if code := call("/sign/message", "wrong", message, nil); code != http.StatusUnauthorized {
+		t.Fatalf("sign with a wrong token: %d", code)
+	}
+	var signed SignResult
+	if code := call("/sign/message", "secret", message, &signed); code != http.StatusOK || signed.Address != signer.Address() {
+		t.Fatalf("sign message: %d %+v", code, signed)
+	}
+	// data is signed as the bytes it decodes to
+	var signedData SignResult
+	if code := call("/sign/message", "secret", map[string]string{"data": "0x68656c6c6f"}, &signedData); code != http.StatusOK || !bytes.Equal(signedData.Signature, signed.Signature) {
+		t.Fatalf("sign data: %d %+v", code, signedData)
+	}
+	var verified VerifyResult
+	verify := map[string]interface{}{"message": "hello", "signature": signed.Signature, "address": signer.Address()}
+	if code := call("/verify/message", "", verify, &verified); code != http.StatusOK || !verified.Valid || verified.Signer != signer.Address() {
+		t.Fatalf("verify message: %d %+v", code, verified)
+	}
+	verify["address"] = common.Address{0x01}
+	if code := call("/verify/message", "", verify, &verified); code != http.StatusOK || verified.Valid {
+		t.Fatalf("verify message against another address: %d %+v", code, verified)
+	}
+	delete(verify, "address")
+	if code := call("/verify/message", "", verify, nil); code != http.StatusBadRequest {
+		t.Fatalf("verify message without an address: %d", code)
+	}
+
+	if code := call("/sign/typed-data", "secret", []byte(`{"primaryType": "Permit"}`), nil); code != http.StatusBadRequest {
+		t.Fatalf("sign invalid typed data: %d", code)
+	}
+	// a hash, like the safeTxHash of a Safe, is not signed
+	if code := call("/sign/message", "secret", map[string]string{"data": common.Hash{0x01}.Hex()}, nil); code != http.StatusForbidden {
+		t.Fatalf("sign a hash: %d", code)
+	}
+
+	orderTypedData := strings.ReplaceAll(permitTypedData, "Permit", "Order")
+	for _, notAllowed := range []string{
+		permitTypedData,
+		strings.Replace(orderTypedData, `"DToken"`, `"Other"`, 1),
+		strings.Replace(orderTypedData, "0x539", "0x1", 1),
+	} {
+		if code := call("/sign/typed-data", "secret", []byte(notAllowed), nil); code != http.StatusForbidden {
+			t.Fatalf("sign typed data of no rule: %d %s", code, notAllowed)
+		}
+	}
+	if code := call("/sign/typed-data", "secret", []byte(orderTypedData), &signed); code != http.StatusOK {
+		t.Fatalf("sign typed data: %d", code)
+	}
+	td, _ := signing.ParseTypedData([]byte(orderTypedData))
+	if hash, _ := signing.TypedDataHash(td); hash != signed.Hash {
+		t.Fatalf("signed hash %s, want %s", signed.Hash, hash)
+	}
+	// as an object, or as the string wallets are given
+	for _, typedData := range []interface{}{json.RawMessage(orderTypedData), orderTypedData} {
+		verify := map[string]interface{}{"typedData": typedData, "signature": signed.Signature, "address": signer.Address()}
+		if code := call("/verify/typed-data", "", verify, &verified); code != http.StatusOK || !verified.Valid {
+			t.Fatalf("verify typed data: %d %+v", code, verified)
+		}
+	}
+}
+
+func TestSignAPIWithoutSigner(t *testing.T) {
+	srv := httptest.NewServer(&SignAPI{})
+	defer srv.Close()
+	resp, err := http.Post(srv.URL+"/sign/message", "application/json", bytes.NewReader([]byte(`{"message": "hello"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("sign without a signer: %d", resp.StatusCode)
+	}
+}
+
+func TestLoadSignPolicy(t *testing.T) {
+	dir := t.TempDir()
+	if p, err := LoadSignPolicy(filepath.Join(dir, "none.json")); p != nil || err != nil {
+		t.Fatalf("loaded a missing policy: %+v %v", p, err)
+	}
+	for _, tc := range []struct {
+		json string
+		ok   bool
+	}{
+		{`{"account": "signing", "typedData": [{"primaryType": "Order", "name": "DToken"}]}`, true},
+		{`{"typedData": []}`, false},
+		{`{"account": "` + DefaultAccount + `"}`, false},
+		{`{"account": "signing", "typedData": [{"primaryType": "Order"}]}`, false},
+	} {
+		path := filepath.Join(dir, SignPolicyFile)
+		if err := ioutil.WriteFile(path, []byte(tc.json), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadSignPolicy(path); (err == nil) != tc.ok {
+			t.Errorf("load %s: %v", tc.json, err)
+		}
+	}
+}
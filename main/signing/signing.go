@@ -0,0 +1,140 @@
+// Package signing signs and verifies personal_sign messages and EIP-712 typed data,
+// with the signatures wallets give: 65 bytes, V of 27 or 28.
+package signing
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// ErrInvalidSignature is returned for a signature which can't be recovered.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// TypedData is EIP-712 typed data, in the eth_signTypedData_v4 JSON format.
+type TypedData = apitypes.TypedData
+
+// ParseTypedData reads typed data in the eth_signTypedData_v4 JSON format.
+// The chainId of the domain may be a number, a decimal or a hex string.
+func ParseTypedData(bs []byte) (*TypedData, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(bs, &raw); err != nil {
+		return nil, err
+	}
+	if domain, ok := raw["domain"]; ok {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(domain, &fields); err != nil {
+			return nil, fmt.Errorf("domain: %w", err)
+		}
+		// HexOrDecimal256 only reads strings
+		if id, ok := fields["chainId"]; ok && len(id) > 0 && id[0] != '"' && !bytes.Equal(id, []byte("null")) {
+			fields["chainId"] = append(append([]byte{'"'}, id...), '"')
+			domain, err := json.Marshal(fields)
+			if err != nil {
+				return nil, err
+			}
+			raw["domain"] = domain
+		}
+	}
+	bs, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var td TypedData
+	if err := json.Unmarshal(bs, &td); err != nil {
+		return nil, err
+	}
+	if td.PrimaryType == "" || td.Types["EIP712Domain"] == nil {
+		return nil, errors.New("typed data needs a primaryType and the EIP712Domain type")
+	}
+	return &td, nil
+}
+
+// TypedDataHash returns the EIP-712 hash of td, the one signed.
+func TypedDataHash(td *TypedData) (common.Hash, error) {
+	hash, _, err := apitypes.TypedDataAndHash(*td)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(hash), nil
+}
+
+// MessageHash returns the EIP-191 hash personal_sign signs for msg.
+func MessageHash(msg []byte) common.Hash {
+	return common.BytesToHash(accounts.TextHash(msg))
+}
+
+// Signer signs with a private key, like the wallet of its address would.
+type Signer struct {
+	key *ecdsa.PrivateKey
+}
+
+// NewSigner signs with key.
+func NewSigner(key *ecdsa.PrivateKey) *Signer {
+	return &Signer{key}
+}
+
+// Address returns the address of the key.
+func (s *Signer) Address() common.Address {
+	return crypto.PubkeyToAddress(s.key.PublicKey)
+}
+
+// SignMessage signs msg as personal_sign does.
+func (s *Signer) SignMessage(msg []byte) ([]byte, error) {
+	return s.signHash(MessageHash(msg))
+}
+
+// SignTypedData signs td as eth_signTypedData_v4 does.
+func (s *Signer) SignTypedData(td *TypedData) ([]byte, error) {
+	hash, err := TypedDataHash(td)
+	if err != nil {
+		return nil, err
+	}
+	return s.signHash(hash)
+}
+
+func (s *Signer) signHash(hash common.Hash) ([]byte, error) {
+	sig, err := crypto.Sign(hash[:], s.key)
+	if err != nil {
+		return nil, err
+	}
+	sig[crypto.RecoveryIDOffset] += 27
+	return sig, nil
+}
+
+// RecoverMessage returns the address which signed msg with personal_sign.
+func RecoverMessage(msg, sig []byte) (common.Address, error) {
+	return recoverHash(MessageHash(msg), sig)
+}
+
+// RecoverTypedData returns the address which signed td with eth_signTypedData_v4.
+func RecoverTypedData(td *TypedData, sig []byte) (common.Address, error) {
+	hash, err := TypedDataHash(td)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return recoverHash(hash, sig)
+}
+
+// recoverHash takes V as 27 or 28, or as 0 or 1.
+func recoverHash(hash common.Hash, sig []byte) (common.Address, error) {
+	if len(sig) != crypto.SignatureLength {
+		return common.Address{}, fmt.Errorf("%w: %d bytes, want %d", ErrInvalidSignature, len(sig), crypto.SignatureLength)
+	}
+	sig = common.CopyBytes(sig)
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	pub, err := crypto.SigToPub(hash[:], sig)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
@@ -0,0 +1,101 @@
+package signing
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// the Mail example of EIP-712, signed by the key keccak256("cow")
+const mailTypedData = `{
+  "types": {
+    "EIP712Domain": [
+      {"name": "name", "type": "string"},
+      {"name": "version", "type": "string"},
+      {"name": "chainId", "type": "uint256"},
+      {"name": "verifyingContract", "type": "address"}
+    ],
+    "Person": [
+      {"name": "name", "type": "string"},
+      {"name": "wallet", "type": "address"}
+    ],
+    "Mail": [
+      {"name": "from", "type": "Person"},
+      {"name": "to", "type": "Person"},
+      {"name": "contents", "type": "string"}
+    ]
+  },
+  "primaryType": "Mail",
+  "domain": {
+    "name": "Ether Mail",
+    "version": "1",
+    "chainId": 1,
+    "verifyingContract": "0xCcCCccccCCCCcCCCCCCcCcCccCcCCCcCcccccccC"
+  },
+  "message": {
+    "from": {"name": "Cow", "wallet": "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"},
+    "to": {"name": "Bob", "wallet": "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"},
+    "contents": "Hello, Bob!"
+  }
+}`
+
+func TestTypedData(t *testing.T) {
+	td, err := ParseTypedData([]byte(mailTypedData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := TypedDataHash(td)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := common.HexToHash("0xbe609aee343fb3c4b28e1df9e632fca64fcfaede20f02e86244efddf30957bd2"); hash != want {
+		t.Fatalf("hash %s, want %s", hash, want)
+	}
+
+	signer := NewSigner(crypto.ToECDSAUnsafe(crypto.Keccak256([]byte("cow"))))
+	if signer.Address() != common.HexToAddress("0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826") {
+		t.Fatalf("address of cow %s", signer.Address())
+	}
+	sig, err := signer.SignTypedData(td)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "0x4355c47d63924e8a72e509b65029052eb6c299d53a04e167c5775fd466751c9d07299936d304c153f6443dfa05f40ff007d72911b6f72307f996231605b915621c"
+	if got := hexutil.Encode(sig); got != want {
+		t.Fatalf("signature %s, want %s", got, want)
+	}
+	if addr, err := RecoverTypedData(td, sig); err != nil || addr != signer.Address() {
+		t.Fatalf("recovered %s: %v", addr, err)
+	}
+
+	// another message recovers another address
+	td.Message["contents"] = "Hello, Alice!"
+	if addr, err := RecoverTypedData(td, sig); err != nil || addr == signer.Address() {
+		t.Fatalf("recovered %s from a changed message: %v", addr, err)
+	}
+}
+
+func TestMessage(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	signer := NewSigner(key)
+	sig, err := signer.SignMessage([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := sig[crypto.RecoveryIDOffset]; v != 27 && v != 28 {
+		t.Fatalf("V is %d, want 27 or 28", v)
+	}
+	if addr, err := RecoverMessage([]byte("hello"), sig); err != nil || addr != signer.Address() {
+		t.Fatalf("recovered %s: %v", addr, err)
+	}
+	// as crypto.Sign gives it, V of 0 or 1
+	sig[crypto.RecoveryIDOffset] -= 27
+	if addr, err := RecoverMessage([]byte("hello"), sig); err != nil || addr != signer.Address() {
+		t.Fatalf("recovered %s with V of 0 or 1: %v", addr, err)
+	}
+	if _, err := RecoverMessage([]byte("hello"), sig[:64]); err == nil {
+		t.Fatal("recovered a short signature")
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/filters"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// simRPC serves the simulated backend over JSON-RPC, with the eth methods
+// ethclient uses, so the code can be tested against a real rpc-server.
+type simRPC struct {
+	backend simBackend
+	// commit mines a block after every sent transaction
+	autoCommit bool
+}
+
+// newSimRPCServer serves the backend over http and websocket.
+func newSimRPCServer(env *testEnv, autoCommit bool) *httptest.Server {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", &simRPC{env.backend, autoCommit}); err != nil {
+		env.t.Fatal(err)
+	}
+	ws := server.WebsocketHandler([]string{"*"})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			ws.ServeHTTP(w, r)
+			return
+		}
+		server.ServeHTTP(w, r)
+	}))
+	env.t.Cleanup(func() {
+		srv.Close()
+		server.Stop()
+	})
+	return srv
+}
+
+// blockArg maps latest and pending to nil, which the simulated backend takes as the head.
+func blockArg(n rpc.BlockNumber) *big.Int {
+	if n < 0 {
+		return nil
+	}
+	return big.NewInt(n.Int64())
+}
+
+type callArgs struct {
+	From     common.Address  `json:"from"`
+	To       *common.Address `json:"to"`
+	Gas      hexutil.Uint64  `json:"gas"`
+	GasPrice *hexutil.Big    `json:"gasPrice"`
+	Value    *hexutil.Big    `json:"value"`
+	Data     hexutil.Bytes   `json:"data"`
+}
+
+func (args callArgs) msg() ethereum.CallMsg {
+	return ethereum.CallMsg{
+		From:     args.From,
+		To:       args.To,
+		Gas:      uint64(args.Gas),
+		GasPrice: (*big.Int)(args.GasPrice),
+		Value:    (*big.Int)(args.Value),
+		Data:     args.Data,
+	}
+}
+
+func (api *simRPC) ChainId(ctx context.Context) (*hexutil.Big, error) {
+	id, err := api.backend.ChainID(ctx)
+	return (*hexutil.Big)(id), err
+}
+
+func (api *simRPC) BlockNumber(ctx context.Context) (hexutil.Uint64, error) {
+	n, err := api.backend.BlockNumber(ctx)
+	return hexutil.Uint64(n), err
+}
+
+func (api *simRPC) GasPrice(ctx context.Context) (*hexutil.Big, error) {
+	price, err := api.backend.SuggestGasPrice(ctx)
+	return (*hexutil.Big)(price), err
+}
+
+func (api *simRPC) MaxPriorityFeePerGas(ctx context.Context) (*hexutil.Big, error) {
+	tip, err := api.backend.SuggestGasTipCap(ctx)
+	return (*hexutil.Big)(tip), err
+}
+
+func (api *simRPC) GetBalance(ctx context.Context, addr common.Address, n rpc.BlockNumber) (*hexutil.Big, error) {
+	balance, err := api.backend.BalanceAt(ctx, addr, blockArg(n))
+	return (*hexutil.Big)(balance), err
+}
+
+func (api *simRPC) GetCode(ctx context.Context, addr common.Address, n rpc.BlockNumber) (hexutil.Bytes, error) {
+	if n == rpc.PendingBlockNumber {
+		return api.backend.PendingCodeAt(ctx, addr)
+	}
+	return api.backend.CodeAt(ctx, addr, blockArg(n))
+}
+
+func (api *simRPC) GetTransactionCount(ctx context.Context, addr common.Address, n rpc.BlockNumber) (hexutil.Uint64, error) {
+	var nonce uint64
+	var err error
+	if n == rpc.PendingBlockNumber {
+		nonce, err = api.backend.PendingNonceAt(ctx, addr)
+	} else {
+		nonce, err = api.backend.NonceAt(ctx, addr, blockArg(n))
+	}
+	return hexutil.Uint64(nonce), err
+}
+
+func (api *simRPC) Call(ctx context.Context, args callArgs, n rpc.BlockNumber) (hexutil.Bytes, error) {
+	if n == rpc.PendingBlockNumber {
+		return api.backend.PendingCallContract(ctx, args.msg())
+	}
+	return api.backend.CallContract(ctx, args.msg(), blockArg(n))
+}
+
+func (api *simRPC) EstimateGas(ctx context.Context, args callArgs) (hexutil.Uint64, error) {
+	gas, err := api.backend.EstimateGas(ctx, args.msg())
+	return hexutil.Uint64(gas), err
+}
+
+func (api *simRPC) SendRawTransaction(ctx context.Context, input hexutil.Bytes) (common.Hash, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(input); err != nil {
+		return common.Hash{}, err
+	}
+	if err := api.backend.SendTransaction(ctx, tx); err != nil {
+		return common.Hash{}, err
+	}
+	if api.autoCommit {
+		api.backend.Commit()
+	}
+	return tx.Hash(), nil
+}
+
+func (api *simRPC) GetTransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	r, err := api.backend.TransactionReceipt(ctx, hash)
+	if errors.Is(err, ethereum.NotFound) {
+		return nil, nil
+	}
+	return r, err
+}
+
+func (api *simRPC) GetTransactionByHash(ctx context.Context, hash common.Hash) (map[string]interface{}, error) {
+	tx, pending, err := api.backend.TransactionByHash(ctx, hash)
+	if errors.Is(err, ethereum.NotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields, err := jsonFields(tx)
+	if err != nil {
+		return nil, err
+	}
+	if !pending {
+		r, err := api.backend.TransactionReceipt(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+		fields["blockHash"] = r.BlockHash
+		fields["blockNumber"] = (*hexutil.Big)(r.BlockNumber)
+		fields["transactionIndex"] = hexutil.Uint(r.TransactionIndex)
+	}
+	return fields, nil
+}
+
+func (api *simRPC) GetBlockByNumber(ctx context.Context, n rpc.BlockNumber, full bool) (map[string]interface{}, error) {
+	block, err := api.backend.BlockByNumber(ctx, blockArg(n))
+	if err != nil {
+		return nil, nil
+	}
+	return blockFields(block, full)
+}
+
+func (api *simRPC) GetBlockByHash(ctx context.Context, hash common.Hash, full bool) (map[string]interface{}, error) {
+	block, err := api.backend.BlockByHash(ctx, hash)
+	if err != nil {
+		return nil, nil
+	}
+	return blockFields(block, full)
+}
+
+func (api *simRPC) GetLogs(ctx context.Context, crit filters.FilterCriteria) ([]types.Log, error) {
+	logs, err := api.backend.FilterLogs(ctx, ethereum.FilterQuery(crit))
+	if logs == nil {
+		logs = []types.Log{}
+	}
+	return logs, err
+}
+
+// NewHeads serves eth_subscribe("newHeads").
+func (api *simRPC) NewHeads(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, ok := rpc.NotifierFromContext(ctx)
+	if !ok {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+	headers := make(chan *types.Header)
+	sub, err := api.backend.SubscribeNewHead(context.Background(), headers)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case h := <-headers:
+				notifier.Notify(rpcSub.ID, h)
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
+
+// Logs serves eth_subscribe("logs").
+func (api *simRPC) Logs(ctx context.Context, crit filters.FilterCriteria) (*rpc.Subscription, error) {
+	notifier, ok := rpc.NotifierFromContext(ctx)
+	if !ok {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+	logs := make(chan types.Log)
+	sub, err := api.backend.SubscribeFilterLogs(context.Background(), ethereum.FilterQuery(crit), logs)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case l := <-logs:
+				notifier.Notify(rpcSub.ID, &l)
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
+
+func blockFields(block *types.Block, full bool) (map[string]interface{}, error) {
+	fields, err := jsonFields(block.Header())
+	if err != nil {
+		return nil, err
+	}
+	txs := make([]interface{}, len(block.Transactions()))
+	for i, tx := range block.Transactions() {
+		if !full {
+			txs[i] = tx.Hash()
+			continue
+		}
+		txFields, err := jsonFields(tx)
+		if err != nil {
+			return nil, err
+		}
+		txFields["blockHash"] = block.Hash()
+		txFields["blockNumber"] = (*hexutil.Big)(block.Number())
+		txFields["transactionIndex"] = hexutil.Uint(i)
+		txs[i] = txFields
+	}
+	fields["transactions"] = txs
+	fields["uncles"] = []common.Hash{}
+	return fields, nil
+}
+
+// jsonFields turns the JSON encoding of v into a map, to add the fields the
+// rpc-server returns on top of the types' own encoding.
+func jsonFields(v interface{}) (map[string]interface{}, error) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	err = json.Unmarshal(bs, &fields)
+	return fields, err
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xcoolface/backend-dapp-demo/main/signing"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const (
+	siweVersion  = "1"
+	siwePreamble = " wants you to sign in with your Ethereum account:"
+	// nonceChars are the alphanumeric characters of the nonces, as EIP-4361 requires
+	nonceChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+var (
+	// ErrInvalidSiwe is returned for a SIWE message which can't be parsed.
+	ErrInvalidSiwe = errors.New("invalid SIWE message")
+	// ErrSiweRejected is the cause of every login refused.
+	ErrSiweRejected = errors.New("SIWE login rejected")
+	// ErrTooManyNonces is returned when a client, or all of them, have too many nonces to sign.
+	ErrTooManyNonces = errors.New("too many outstanding nonces")
+)
+
+// default bounds of the outstanding nonces of SiweAuth
+const (
+	defaultNoncesPerClient = 5
+	defaultMaxNonces       = 100000
+)
+
+// SiweMessage is an EIP-4361 Sign-In with Ethereum message.
+type SiweMessage struct {
+	Domain         string
+	Address        common.Address
+	Statement      string
+	URI            string
+	Version        string
+	ChainID        uint64
+	Nonce          string
+	IssuedAt       time.Time
+	ExpirationTime *time.Time
+	NotBefore      *time.Time
+	RequestID      string
+	Resources      []string
+}
+
+// String formats the message as the text the wallet signs.
+func (m *SiweMessage) String() string {
+	var sb strings.Builder
+	sb.WriteString(m.Domain + siwePreamble + "\n")
+	sb.WriteString(m.Address.Hex() + "\n\n")
+	if m.Statement != "" {
+		sb.WriteString(m.Statement + "\n\n")
+	}
+	fmt.Fprintf(&sb, "URI: %s\nVersion: %s\nChain ID: %d\nNonce: %s\nIssued At: %s",
+		m.URI, m.Version, m.ChainID, m.Nonce, m.IssuedAt.UTC().Format(time.RFC3339))
+	if m.ExpirationTime != nil {
+		sb.WriteString("\nExpiration Time: " + m.ExpirationTime.UTC().Format(time.RFC3339))
+	}
+	if m.NotBefore != nil {
+		sb.WriteString("\nNot Before: " + m.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if m.RequestID != "" {
+		sb.WriteString("\nRequest ID: " + m.RequestID)
+	}
+	if len(m.Resources) > 0 {
+		sb.WriteString("\nResources:")
+		for _, r := range m.Resources {
+			sb.WriteString("\n- " + r)
+		}
+	}
+	return sb.String()
+}
+
+// ParseSiweMessage parses the text of an EIP-4361 message.
+func ParseSiweMessage(text string) (*SiweMessage, error) {
+	lines := strings.Split(text, "\n")
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s", ErrInvalidSiwe, fmt.Sprintf(format, args...))
+	}
+	if len(lines) < 8 || !strings.HasSuffix(lines[0], siwePreamble) {
+		return nil, invalid("no %q preamble", strings.TrimSpace(siwePreamble))
+	}
+	m := &SiweMessage{Domain: strings.TrimSuffix(lines[0], siwePreamble)}
+	if m.Domain == "" {
+		return nil, invalid("no domain")
+	}
+	if !common.IsHexAddress(lines[1]) || common.HexToAddress(lines[1]).Hex() != lines[1] {
+		return nil, invalid("address %q is not EIP-55 checksummed", lines[1])
+	}
+	m.Address = common.HexToAddress(lines[1])
+	if lines[2] != "" {
+		return nil, invalid("no empty line after the address")
+	}
+	i := 3
+	if !strings.HasPrefix(lines[i], "URI: ") {
+		m.Statement = lines[i]
+		if i+1 >= len(lines) || lines[i+1] != "" {
+			return nil, invalid("no empty line after the statement")
+		}
+		i += 2
+	}
+	// the fields, in their order, the optional ones may be missing
+	fields := []struct {
+		name     string
+		optional bool
+		set      func(string) error
+	}{
+		{"URI", false, func(v string) error { m.URI = v; return nil }},
+		{"Version", false, func(v string) error { m.Version = v; return nil }},
+		{"Chain ID", false, func(v string) (err error) { m.ChainID, err = strconv.ParseUint(v, 10, 64); return err }},
+		{"Nonce", false, func(v string) error { m.Nonce = v; return nil }},
+		{"Issued At", false, func(v string) (err error) { m.IssuedAt, err = time.Parse(time.RFC3339, v); return err }},
+		{"Expiration Time", true, func(v string) error {
+			t, err := time.Parse(time.RFC3339, v)
+			m.ExpirationTime = &t
+			return err
+		}},
+		{"Not Before", true, func(v string) error {
+			t, err := time.Parse(time.RFC3339, v)
+			m.NotBefore = &t
+			return err
+		}},
+		{"Request ID", true, func(v string) error { m.RequestID = v; return nil }},
+	}
+	for _, f := range fields {
+		prefix := f.name + ": "
+		if i >= len(lines) || !strings.HasPrefix(lines[i], prefix) {
+			if f.optional {
+				continue
+			}
+			return nil, invalid("no %s", f.name)
+		}
+		if err := f.set(strings.TrimPrefix(lines[i], prefix)); err != nil {
+			return nil, invalid("%s: %v", f.name, err)
+		}
+		i++
+	}
+	if i < len(lines) && lines[i] == "Resources:" {
+		for i++; i < len(lines) && strings.HasPrefix(lines[i], "- "); i++ {
+			m.Resources = append(m.Resources, strings.TrimPrefix(lines[i], "- "))
+		}
+	}
+	if i != len(lines) {
+		return nil, invalid("unexpected line %q", lines[i])
+	}
+	if m.Version != siweVersion {
+		return nil, invalid("version %q", m.Version)
+	}
+	if len(m.Nonce) < 8 {
+		return nil, invalid("nonce shorter than 8 characters")
+	}
+	return m, nil
+}
+
+// Session is a signed in address.
+type Session struct {
+	Token     string         `json:"token"`
+	Address   common.Address `json:"address"`
+	ExpiresAt time.Time      `json:"expiresAt"`
+}
+
+type sessionKey struct{}
+
+// SessionAddress returns the address signed in for the request of ctx.
+func SessionAddress(ctx context.Context) (common.Address, bool) {
+	s, ok := ctx.Value(sessionKey{}).(*Session)
+	if !ok {
+		return common.Address{}, false
+	}
+	return s.Address, true
+}
+
+// SiweAuth signs the accounts in with EIP-4361 messages, and keeps their sessions:
+//
+//	POST /auth/message {"address": "0x.."}            the message to sign, with a fresh nonce
+//	POST /auth/login   {"message": "..", "signature": "0x.."}
+//	POST /auth/logout
+//
+// The nonces are used once, the sessions are bearer tokens kept in memory.
+// Nothing is served without a Domain.
+type SiweAuth struct {
+	// Domain is the host the messages are for, never taken from the request
+	Domain    string
+	ChainID   *big.Int
+	Statement string
+	// NonceTTL is how long a message may be signed, SessionTTL how long a session lasts
+	NonceTTL   time.Duration
+	SessionTTL time.Duration
+	// NoncesPerClient and MaxNonces bound the nonces issued and not used yet, of
+	// each client address and of all, defaultNoncesPerClient and defaultMaxNonces if 0
+	NoncesPerClient int
+	MaxNonces       int
+
+	mu       sync.Mutex
+	nonces   map[string]siweNonce
+	clients  map[string]int
+	sessions map[string]*Session
+}
+
+// siweNonce is an outstanding nonce, issued to client.
+type siweNonce struct {
+	client  string
+	issued  time.Time
+	expires time.Time
+}
+
+// randomString returns n characters of chars, drawn uniformly.
+func randomString(n int, chars string) string {
+	b := make([]byte, n)
+	max := big.NewInt(int64(len(chars)))
+	for i := range b {
+		j, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(fmt.Sprintf("crypto/rand: %v", err))
+		}
+		b[i] = chars[j.Int64()]
+	}
+	return string(b)
+}
+
+// NewMessage issues a nonce to client, and returns the message address signs to log in.
+func (a *SiweAuth) NewMessage(client, uri string, address common.Address) (*SiweMessage, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	expires := now.Add(a.NonceTTL)
+	m := &SiweMessage{
+		Domain:         a.Domain,
+		Address:        address,
+		Statement:      a.Statement,
+		URI:            uri,
+		Version:        siweVersion,
+		ChainID:        a.ChainID.Uint64(),
+		Nonce:          randomString(16, nonceChars),
+		IssuedAt:       now,
+		ExpirationTime: &expires,
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.nonces == nil {
+		a.nonces = make(map[string]siweNonce)
+		a.clients = make(map[string]int)
+	}
+	for nonce, n := range a.nonces {
+		if now.After(n.expires) {
+			a.forget(nonce, n)
+		}
+	}
+	perClient, max := a.NoncesPerClient, a.MaxNonces
+	if perClient == 0 {
+		perClient = defaultNoncesPerClient
+	}
+	if max == 0 {
+		max = defaultMaxNonces
+	}
+	if a.clients[client] >= perClient {
+		return nil, fmt.Errorf("%w of %s", ErrTooManyNonces, client)
+	}
+	if len(a.nonces) >= max {
+		return nil, ErrTooManyNonces
+	}
+	a.nonces[m.Nonce] = siweNonce{client: client, issued: now, expires: expires}
+	a.clients[client]++
+	return m, nil
+}
+
+// forget removes the nonce n, used or expired.
+func (a *SiweAuth) forget(nonce string, n siweNonce) {
+	delete(a.nonces, nonce)
+	if a.clients[n.client]--; a.clients[n.client] <= 0 {
+		delete(a.clients, n.client)
+	}
+}
+
+// Login checks the message was signed by its address, for a URI on the Domain,
+// with a nonce issued at its IssuedAt and not used yet, and starts the session
+// of the address.
+func (a *SiweAuth) Login(text string, sig []byte) (*Session, error) {
+	m, err := ParseSiweMessage(text)
+	if err != nil {
+		return nil, err
+	}
+	rejected := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s", ErrSiweRejected, fmt.Sprintf(format, args...))
+	}
+	now := time.Now()
+	switch {
+	case m.Domain != a.Domain:
+		return nil, rejected("message for %s, not %s", m.Domain, a.Domain)
+	case !a.onDomain(m.URI):
+		return nil, rejected("URI %s is not on %s", m.URI, a.Domain)
+	case m.ChainID != a.ChainID.Uint64():
+		return nil, rejected("message for chain %d, not %s", m.ChainID, a.ChainID)
+	case m.ExpirationTime != nil && now.After(*m.ExpirationTime):
+		return nil, rejected("message expired at %s", m.ExpirationTime)
+	case m.NotBefore != nil && now.Before(*m.NotBefore):
+		return nil, rejected("message not valid before %s", m.NotBefore)
+	}
+	signer, err := signing.RecoverMessage([]byte(text), sig)
+	if err != nil {
+		return nil, rejected("invalid signature: %v", err)
+	}
+	if signer != m.Address {
+		return nil, rejected("signed by %s, not %s", signer, m.Address)
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("session token: %w", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n, ok := a.nonces[m.Nonce]
+	if !ok || now.After(n.expires) {
+		return nil, rejected("nonce %q was not issued, or is used or expired", m.Nonce)
+	}
+	if !m.IssuedAt.Equal(n.issued) {
+		return nil, rejected("issued at %s, not at %s", m.IssuedAt.UTC().Format(time.RFC3339), n.issued.Format(time.RFC3339))
+	}
+	a.forget(m.Nonce, n)
+	if a.sessions == nil {
+		a.sessions = make(map[string]*Session)
+	}
+	for token, s := range a.sessions {
+		if now.After(s.ExpiresAt) {
+			delete(a.sessions, token)
+		}
+	}
+	s := &Session{Token: hex.EncodeToString(b), Address: m.Address, ExpiresAt: now.Add(a.SessionTTL).UTC()}
+	a.sessions[s.Token] = s
+	return s, nil
+}
+
+// onDomain reports whether uri is an http(s) URI of the Domain.
+func (a *SiweAuth) onDomain(uri string) bool {
+	u, err := url.Parse(uri)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.User == nil && strings.EqualFold(u.Host, a.Domain)
+}
+
+// Session returns the live session of token.
+func (a *SiweAuth) Session(token string) (*Session, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[token]
+	if !ok || time.Now().After(s.ExpiresAt) {
+		return nil, false
+	}
+	return s, true
+}
+
+// Logout ends the session of token.
+func (a *SiweAuth) Logout(token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.sessions, token)
+}
+
+// Require serves next only the requests with the bearer token of a session,
+// whose address SessionAddress gives.
+func (a *SiweAuth) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := a.Session(bearerToken(r))
+		if !ok {
+			writeResult(w, http.StatusUnauthorized, apiError{"a session token is required, sign in at /auth/login"})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, s)))
+	})
+}
+
+// clientAddress is the IP address a request comes from.
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (a *SiweAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.Domain == "" {
+		writeResult(w, http.StatusNotFound, apiError{"sign-in is not served without a domain"})
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeResult(w, http.StatusMethodNotAllowed, apiError{"POST only"})
+		return
+	}
+	switch strings.TrimPrefix(r.URL.Path, "/auth/") {
+	case "message":
+		var body struct {
+			Address string `json:"address"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || !common.IsHexAddress(body.Address) {
+			writeResult(w, http.StatusBadRequest, apiError{"an address is required"})
+			return
+		}
+		scheme := "https"
+		if r.TLS == nil {
+			scheme = "http"
+		}
+		m, err := a.NewMessage(clientAddress(r), scheme+"://"+a.Domain, common.HexToAddress(body.Address))
+		if err != nil {
+			writeResult(w, http.StatusTooManyRequests, apiError{err.Error()})
+			return
+		}
+		writeResult(w, http.StatusOK, map[string]interface{}{"message": m.String(), "nonce": m.Nonce})
+	case "login":
+		var body struct {
+			Message   string `json:"message"`
+			Signature string `json:"signature"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeResult(w, http.StatusBadRequest, apiError{"invalid body: " + err.Error()})
+			return
+		}
+		sig, err := hexutil.Decode(body.Signature)
+		if err != nil {
+			writeResult(w, http.StatusBadRequest, apiError{"invalid signature: " + err.Error()})
+			return
+		}
+		s, err := a.Login(body.Message, sig)
+		switch {
+		case errors.Is(err, ErrInvalidSiwe):
+			writeResult(w, http.StatusBadRequest, apiError{err.Error()})
+		case err != nil:
+			writeResult(w, http.StatusUnauthorized, apiError{err.Error()})
+		default:
+			writeResult(w, http.StatusOK, s)
+		}
+	case "logout":
+		a.Logout(bearerToken(r))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeResult(w, http.StatusNotFound, apiError{"not found"})
+	}
+}
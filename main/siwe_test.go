@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// the example of EIP-4361
+const siweExample = `service.org wants you to sign in with your Ethereum account:
+0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2
+
+I accept the ServiceOrg Terms of Service: https://service.org/tos
+
+URI: https://service.org/login
+Version: 1
+Chain ID: 1
+Nonce: 32891756
+Issued At: 2021-09-30T16:25:24Z
+Resources:
+- ipfs://bafybeiemxf5abjwjbikoz4mc3a3dla6ual3jsgpdr4cjr3oz3evfyavhwq/
+- https://example.com/my-web2-claim.json`
+
+func TestParseSiweMessage(t *testing.T) {
+	m, err := ParseSiweMessage(siweExample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Domain != "service.org" || m.ChainID != 1 || m.Nonce != "32891756" || len(m.Resources) != 2 {
+		t.Fatalf("parsed %+v", m)
+	}
+	if got := m.String(); got != siweExample {
+		t.Fatalf("formatted back to\n%s", got)
+	}
+	for _, bad := range []string{
+		strings.Replace(siweExample, "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2", "0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2", 1),
+		strings.Replace(siweExample, "Version: 1", "Version: 2", 1),
+		strings.Replace(siweExample, "Nonce: 32891756\n", "", 1),
+		strings.Replace(siweExample, "Nonce: 32891756", "Nonce: 1234", 1),
+		siweExample + "\nSomething: else",
+	} {
+		if _, err := ParseSiweMessage(bad); !errors.Is(err, ErrInvalidSiwe) {
+			t.Errorf("parsed %q: %v", bad, err)
+		}
+	}
+}
+
+func TestSiweAuth(t *testing.T) {
+	env := newTestEnv(t, 1)
+	token, _, contract := env.deploy(10000)
+	owner := env.accounts[0]
+	spender := common.Address{0x5e}
+	tx, err := contract.Approve(owner.transactor(t), spender, big.NewInt(70))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.backend.Commit()
+	env.requireSuccess(tx)
+
+	auth := &SiweAuth{Domain: "dapp.example", ChainID: simChainID, Statement: "Sign in.", NonceTTL: time.Minute, SessionTTL: time.Hour}
+	mux := http.NewServeMux()
+	mux.Handle("/auth/", auth)
+	mux.Handle("/accounts/", auth.Require(&AccountAPI{Tokens: &TokenService{Backend: env.backend, ChainID: simChainID, Registry: &Registry{}}}))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	call := func(method, path, bearer string, body interface{}, out interface{}) int {
+		t.Helper()
+		bs, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, srv.URL+path, bytes.NewReader(bs))
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if out != nil {
+			json.NewDecoder(resp.Body).Decode(out)
+		}
+		return resp.StatusCode
+	}
+	sign := func(message string) string {
+		sig, err := crypto.Sign(accounts.TextHash([]byte(message)), owner.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig[crypto.RecoveryIDOffset] += 27
+		return hexutil.Encode(sig)
+	}
+	var issued struct {
+		Message string `json:"message"`
+	}
+	if code := call("POST", "/auth/message", "", map[string]string{"address": owner.addr.Hex()}, &issued); code != http.StatusOK {
+		t.Fatalf("message: %d", code)
+	}
+	// the domain configured, not the Host of the request
+	if m, err := ParseSiweMessage(issued.Message); err != nil || m.Domain != "dapp.example" || m.URI != "http://dapp.example" {
+		t.Fatalf("issued %q: %v", issued.Message, err)
+	}
+
+	// signed by another key
+	other, _ := crypto.GenerateKey()
+	sig, _ := crypto.Sign(accounts.TextHash([]byte(issued.Message)), other)
+	if code := call("POST", "/auth/login", "", map[string]string{"message": issued.Message, "signature": hexutil.Encode(sig)}, nil); code != http.StatusUnauthorized {
+		t.Fatalf("login signed by another key: %d", code)
+	}
+	// the URI and the issue time are those of the message issued
+	for _, tampered := range []string{
+		strings.Replace(issued.Message, "URI: http://dapp.example", "URI: https://evil.example", 1),
+		strings.Replace(issued.Message, "URI: http://dapp.example", "URI: http://user@dapp.example", 1),
+		regexp.MustCompile(`Issued At: .*`).ReplaceAllString(issued.Message, "Issued At: 2020-01-01T00:00:00Z"),
+	} {
+		if code := call("POST", "/auth/login", "", map[string]string{"message": tampered, "signature": sign(tampered)}, nil); code != http.StatusUnauthorized {
+			t.Fatalf("login with %q: %d", tampered, code)
+		}
+	}
+	var session Session
+	login := map[string]string{"message": issued.Message, "signature": sign(issued.Message)}
+	if code := call("POST", "/auth/login", "", login, &session); code != http.StatusOK || session.Address != owner.addr {
+		t.Fatalf("login: %d %+v", code, session)
+	}
+	if code := call("POST", "/auth/login", "", login, nil); code != http.StatusUnauthorized {
+		t.Fatalf("login with a used nonce: %d", code)
+	}
+
+	path := "/accounts/" + owner.addr.Hex() + "/allowances?token=" + token.Hex()
+	if code := call("GET", path, "", nil, nil); code != http.StatusUnauthorized {
+		t.Fatalf("allowances without a session: %d", code)
+	}
+	var allowances []*SpenderAllowance
+	if code := call("GET", path, session.Token, nil, &allowances); code != http.StatusOK || len(allowances) != 1 ||
+		allowances[0].Spender != spender || allowances[0].Amount.Int64() != 70 {
+		t.Fatalf("allowances: %d %v", code, allowances)
+	}
+	if code := call("GET", "/accounts/"+spender.Hex()+"/allowances?token="+token.Hex(), session.Token, nil, nil); code != http.StatusForbidden {
+		t.Fatalf("allowances of another account: %d", code)
+	}
+	if code := call("POST", "/auth/logout", session.Token, nil, nil); code != http.StatusNoContent {
+		t.Fatalf("logout: %d", code)
+	}
+	if code := call("GET", path, session.Token, nil, nil); code != http.StatusUnauthorized {
+		t.Fatalf("allowances after logout: %d", code)
+	}
+}
+
+func TestSiweNonceBounds(t *testing.T) {
+	auth := &SiweAuth{Domain: "dapp.example", ChainID: simChainID, NonceTTL: time.Minute, NoncesPerClient: 2, MaxNonces: 3}
+	addr := common.Address{0x11}
+	for _, tc := range []struct {
+		client string
+		ok     bool
+	}{
+		{"10.0.0.1", true},
+		{"10.0.0.1", true},
+		{"10.0.0.1", false},
+		{"10.0.0.2", true},
+		// all the nonces are out
+		{"10.0.0.3", false},
+	} {
+		m, err := auth.NewMessage(tc.client, "https://dapp.example", addr)
+		if (err == nil) != tc.ok {
+			t.Fatalf("nonce of %s: %v", tc.client, err)
+		}
+		if err == nil && strings.Trim(m.Nonce, nonceChars) != "" {
+			t.Fatalf("nonce %q", m.Nonce)
+		}
+		if err != nil && !errors.Is(err, ErrTooManyNonces) {
+			t.Fatal(err)
+		}
+	}
+
+	// the expired nonces are forgotten
+	auth = &SiweAuth{Domain: "dapp.example", ChainID: simChainID, NonceTTL: -time.Second, NoncesPerClient: 1}
+	for i := 0; i < 3; i++ {
+		if _, err := auth.NewMessage("10.0.0.1", "https://dapp.example", addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(auth.nonces) != 1 || len(auth.clients) != 1 {
+		t.Fatalf("%d nonces of %d clients are kept", len(auth.nonces), len(auth.clients))
+	}
+}
+
+func TestSiweAuthWithoutDomain(t *testing.T) {
+	srv := httptest.NewServer(&SiweAuth{ChainID: simChainID, NonceTTL: time.Minute})
+	defer srv.Close()
+	resp, err := http.Post(srv.URL+"/auth/message", "application/json", strings.NewReader(`{"address": "0x1100000000000000000000000000000000000000"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("message without a domain: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	SnapshotFile    = "snapshot.json"
+	SnapshotCSVFile = "snapshot.csv"
+	MerkleFile      = "merkle.json"
+)
+
+// ErrSnapshotMismatch is returned when the replayed balances differ from those on chain.
+var ErrSnapshotMismatch = errors.New("snapshot differs from the chain")
+
+// HolderBalance is the balance of a holder, in base units.
+type HolderBalance struct {
+	Address common.Address `json:"address"`
+	Balance *big.Int       `json:"balance"`
+}
+
+// Snapshot holds the balances of every holder of a token at a block.
+type Snapshot struct {
+	ChainID     uint64         `json:"chainId"`
+	Contract    common.Address `json:"contract"`
+	Block       uint64         `json:"block"`
+	TotalSupply *big.Int       `json:"totalSupply"`
+	// Verified tells the balances were checked with balanceOf at Block,
+	// which needs a node keeping the state of Block
+	Verified bool `json:"verified"`
+	// Mismatches list the balances differing from those on chain
+	Mismatches []string `json:"mismatches,omitempty"`
+	// Holders are sorted by address, the order of the Merkle leaves
+	Holders []*HolderBalance `json:"holders"`
+}
+
+// WriteJSON writes the snapshot as indented JSON.
+func (s *Snapshot) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// WriteCSV writes an address,balance row per holder.
+func (s *Snapshot) WriteCSV(w io.Writer) error {
+	return WriteBalancesCSV(w, s.Holders)
+}
+
+// WriteBalancesCSV writes an address,balance row per holder, after a header.
+func WriteBalancesCSV(w io.Writer, balances []*HolderBalance) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"address", "balance"})
+	for _, h := range balances {
+		cw.Write([]string{h.Address.Hex(), h.Balance.String()})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// SnapshotBackend replays the Transfers of a token since its deploy tx.
+type SnapshotBackend interface {
+	bind.ContractCaller
+	bind.ContractFilterer
+	TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+// Snapshotter takes the snapshots of an EIP20 token from its Transfer history.
+type Snapshotter struct {
+	Backend SnapshotBackend
+	ChainID *big.Int
+	// MaxRange is the most blocks scanned by one eth_getLogs
+	MaxRange uint64
+}
+
+// Take replays the Transfers of contract from its deploy tx up to block. The
+// constructor emits no Transfer, the initial amount of the deploy tx is given to its sender.
+func (s *Snapshotter) Take(ctx context.Context, contract common.Address, deployTx common.Hash, block uint64) (*Snapshot, error) {
+	receipt, err := s.Backend.TransactionReceipt(ctx, deployTx)
+	if err != nil {
+		return nil, fmt.Errorf("get receipt of %s: %w", deployTx, err)
+	}
+	if receipt.ContractAddress != contract {
+		return nil, fmt.Errorf("tx %s created %s, not %s", deployTx, receipt.ContractAddress, contract)
+	}
+	start := receipt.BlockNumber.Uint64()
+	if block < start {
+		return nil, fmt.Errorf("block %d is before the deploy block %d", block, start)
+	}
+	tx, _, err := s.Backend.TransactionByHash(ctx, deployTx)
+	if err != nil {
+		return nil, fmt.Errorf("get tx %s: %w", deployTx, err)
+	}
+	args, err := DecodeDeployArgs(tx.Data())
+	if err != nil {
+		return nil, err
+	}
+	deployer, err := types.Sender(types.LatestSignerForChainID(s.ChainID), tx)
+	if err != nil {
+		return nil, err
+	}
+
+	balances := map[common.Address]*big.Int{deployer: new(big.Int).Set(args.InitialAmount)}
+	if err := s.replay(ctx, contract, balances, start, block); err != nil {
+		return nil, err
+	}
+	snap := &Snapshot{ChainID: s.ChainID.Uint64(), Contract: contract, Block: block, TotalSupply: new(big.Int), Holders: []*HolderBalance{}}
+	for addr, balance := range balances {
+		if balance.Sign() < 0 {
+			snap.Mismatches = append(snap.Mismatches, fmt.Sprintf("%s replayed to %s", addr, balance))
+		}
+		if balance.Sign() > 0 {
+			snap.Holders = append(snap.Holders, &HolderBalance{addr, balance})
+			snap.TotalSupply.Add(snap.TotalSupply, balance)
+		}
+	}
+	sort.Slice(snap.Holders, func(i, j int) bool {
+		return bytes.Compare(snap.Holders[i].Address[:], snap.Holders[j].Address[:]) < 0
+	})
+	if err := s.verify(ctx, snap); err != nil {
+		return nil, err
+	}
+	if len(snap.Mismatches) > 0 {
+		return snap, fmt.Errorf("%w: %s", ErrSnapshotMismatch, strings.Join(snap.Mismatches, ", "))
+	}
+	return snap, nil
+}
+
+// replay applies the Transfers of the blocks from start to end to balances.
+func (s *Snapshotter) replay(ctx context.Context, contract common.Address, balances map[common.Address]*big.Int, start, end uint64) error {
+	f, err := NewEIP20Filterer(contract, s.Backend)
+	if err != nil {
+		return err
+	}
+	maxRange := s.MaxRange
+	if maxRange == 0 {
+		maxRange = 1000
+	}
+	add := func(addr common.Address, amount *big.Int) {
+		if balances[addr] == nil {
+			balances[addr] = new(big.Int)
+		}
+		balances[addr].Add(balances[addr], amount)
+	}
+	for next := start; next <= end; next += maxRange {
+		last := next + maxRange - 1
+		if last > end {
+			last = end
+		}
+		it, err := f.FilterTransfer(&bind.FilterOpts{Start: next, End: &last, Context: ctx}, nil, nil)
+		if err != nil {
+			return err
+		}
+		for it.Next() {
+			if it.Event.Raw.Removed {
+				continue
+			}
+			add(it.Event.From, new(big.Int).Neg(it.Event.Value))
+			add(it.Event.To, it.Event.Value)
+		}
+		it.Close()
+		if err := it.Error(); err != nil {
+			return err
+		}
+		if last == end {
+			break
+		}
+	}
+	return nil
+}
+
+// verify checks the balances and supply of snap with balanceOf and totalSupply at its block.
+// A node without the state of the block leaves the snapshot unverified.
+func (s *Snapshotter) verify(ctx context.Context, snap *Snapshot) error {
+	caller, err := NewEIP20Caller(snap.Contract, s.Backend)
+	if err != nil {
+		return err
+	}
+	opts := &bind.CallOpts{BlockNumber: new(big.Int).SetUint64(snap.Block), Context: ctx}
+	supply, err := caller.TotalSupply(opts)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Warn("No historical state, the snapshot is not verified", "contract", snap.Contract, "block", snap.Block, "err", err)
+		return nil
+	}
+	if supply.Cmp(snap.TotalSupply) != 0 {
+		snap.Mismatches = append(snap.Mismatches, fmt.Sprintf("totalSupply %s, replayed %s", supply, snap.TotalSupply))
+	}
+	for _, h := range snap.Holders {
+		balance, err := caller.BalanceOf(opts, h.Address)
+		if err != nil {
+			return fmt.Errorf("balanceOf %s: %w", h.Address, err)
+		}
+		if balance.Cmp(h.Balance) != 0 {
+			snap.Mismatches = append(snap.Mismatches, fmt.Sprintf("%s balanceOf %s, replayed %s", h.Address, balance, h.Balance))
+		}
+	}
+	snap.Verified = true
+	return nil
+}
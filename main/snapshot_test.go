@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestMerkleTree(t *testing.T) {
+	if root := NewMerkleTree(nil).Root(); root != (common.Hash{}) {
+		t.Fatalf("root of no leaves %s", root)
+	}
+	var leaves []common.Hash
+	for i := 0; i < 5; i++ {
+		leaves = append(leaves, crypto.Keccak256Hash([]byte{byte(i)}))
+	}
+	if tree := NewMerkleTree(leaves[:1]); tree.Root() != leaves[0] || len(tree.Proof(0)) != 0 {
+		t.Fatalf("a single leaf is its own root")
+	}
+	// ((0 1) (2 3)) 4
+	want := hashPair(hashPair(hashPair(leaves[0], leaves[1]), hashPair(leaves[2], leaves[3])), leaves[4])
+	tree := NewMerkleTree(leaves)
+	if tree.Root() != want {
+		t.Fatalf("root %s, want %s", tree.Root(), want)
+	}
+	for i, leaf := range leaves {
+		if !VerifyMerkleProof(tree.Root(), leaf, tree.Proof(i)) {
+			t.Errorf("proof of leaf %d does not verify", i)
+		}
+	}
+	if VerifyMerkleProof(tree.Root(), leaves[0], tree.Proof(1)) {
+		t.Fatal("proof of another leaf verified")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	env := newTestEnv(t, 2)
+	token, deployTx, contract := env.deploy(10000)
+	owner, alice, bob := env.accounts[0].addr, env.accounts[1].addr, newTestAccount(t).addr
+	transfer := func(from int, to common.Address, amount int64) {
+		t.Helper()
+		tx, err := contract.Transfer(env.accounts[from].transactor(t), to, big.NewInt(amount))
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.backend.Commit()
+		env.requireSuccess(tx)
+	}
+	transfer(0, alice, 300)
+	transfer(0, bob, 200)
+	before, _ := env.backend.BlockNumber(context.Background())
+	transfer(1, bob, 100)
+	head, _ := env.backend.BlockNumber(context.Background())
+
+	s := &Snapshotter{Backend: env.backend, ChainID: simChainID, MaxRange: 2}
+	snap, err := s.Take(context.Background(), token, deployTx.Hash(), head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !snap.Verified || snap.TotalSupply.Int64() != 10000 || len(snap.Holders) != 3 {
+		t.Fatalf("verified %v, supply %s, %d holders", snap.Verified, snap.TotalSupply, len(snap.Holders))
+	}
+	want := map[common.Address]int64{owner: 9500, alice: 200, bob: 300}
+	for _, h := range snap.Holders {
+		if h.Balance.Int64() != want[h.Address] {
+			t.Errorf("%s holds %s, want %d", h.Address, h.Balance, want[h.Address])
+		}
+	}
+
+	// the simulated backend keeps no historical state
+	old, err := s.Take(context.Background(), token, deployTx.Hash(), before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Verified || old.Block != before {
+		t.Fatalf("verified %v at %d", old.Verified, old.Block)
+	}
+	for _, h := range old.Holders {
+		if h.Address == alice && h.Balance.Int64() != 300 {
+			t.Fatalf("alice held %s before her transfer, want 300", h.Balance)
+		}
+	}
+
+	var sb strings.Builder
+	if err := snap.WriteCSV(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(sb.String()), "\n"); len(lines) != 4 || lines[0] != "address,balance" {
+		t.Fatalf("csv %q", lines)
+	}
+
+	dist := NewMerkleDistribution(snap.Holders)
+	if dist.Total.Int64() != 10000 {
+		t.Fatalf("total %s", dist.Total)
+	}
+	for _, c := range dist.Claims {
+		if !VerifyMerkleProof(dist.Root, BalanceLeaf(c.Account, c.Amount), c.Proof) {
+			t.Errorf("claim of %s does not verify", c.Account)
+		}
+	}
+	c := dist.Claim(alice)
+	if VerifyMerkleProof(dist.Root, BalanceLeaf(alice, big.NewInt(201)), c.Proof) {
+		t.Fatal("claim of another amount verified")
+	}
+}
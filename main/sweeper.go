@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+const SweepFile = "sweeps.json"
+
+// states of a Sweep
+const (
+	// SweepIdle waits for the token balance to reach the threshold
+	SweepIdle = "idle"
+	// SweepFunding waits for the gas top-up from the hot wallet
+	SweepFunding = "funding"
+	// SweepFunded holds the gas for the token transfer
+	SweepFunded = "funded"
+	// SweepSweeping waits for the token transfer to the treasury
+	SweepSweeping = "sweeping"
+	// SweepSwept has the gas left over to reclaim
+	SweepSwept = "swept"
+	// SweepReclaiming waits for the left over gas to go back to the hot wallet
+	SweepReclaiming = "reclaiming"
+)
+
+// Sweep is the state of sweeping the tokens of a deposit address.
+type Sweep struct {
+	ChainID uint64         `json:"chainId"`
+	Token   common.Address `json:"token"`
+	Index   uint32         `json:"index"`
+	Address common.Address `json:"address"`
+	State   string         `json:"state"`
+	// Amount is the token balance being swept
+	Amount *big.Int `json:"amount,omitempty"`
+	// the gas of the token transfer, which the top-up pays for, at a gas
+	// price on a legacy network, at a fee and tip cap otherwise
+	GasLimit  uint64   `json:"gasLimit,omitempty"`
+	GasPrice  *big.Int `json:"gasPrice,omitempty"`
+	GasFeeCap *big.Int `json:"gasFeeCap,omitempty"`
+	GasTipCap *big.Int `json:"gasTipCap,omitempty"`
+	// TxHash is the tx waited for in the funding, sweeping and reclaiming states,
+	// sent by TxFrom with TxNonce when the sweep was Updated
+	TxHash  *common.Hash    `json:"txHash,omitempty"`
+	TxFrom  *common.Address `json:"txFrom,omitempty"`
+	TxNonce uint64          `json:"txNonce,omitempty"`
+	// Swept counts the completed sweeps
+	Swept   int       `json:"swept"`
+	Error   string    `json:"error,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+// sweepKey tells the sweeps of each chain apart, the deposit addresses and
+// the token address may be the same on all of them.
+func sweepKey(chainID uint64, token, addr common.Address) string {
+	return fmt.Sprintf("%d/%s/%s", chainID, token.Hex(), addr.Hex())
+}
+
+// SweepStore keeps the sweeps, saved on every change, so a restart goes on
+// waiting for the txs sent instead of sending them again.
+type SweepStore struct {
+	path string
+
+	mu     sync.Mutex
+	sweeps map[string]*Sweep
+}
+
+// LoadSweeps reads the sweep file. A missing file gives no sweeps.
+func LoadSweeps(path string) (*SweepStore, error) {
+	s := &SweepStore{path: path, sweeps: make(map[string]*Sweep)}
+	bs, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &s.sweeps); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Get returns a copy of the sweep of addr on chainID, idle if there is none.
+func (s *SweepStore) Get(chainID uint64, token, addr common.Address, index uint32) Sweep {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sw, ok := s.sweeps[sweepKey(chainID, token, addr)]; ok {
+		return *sw
+	}
+	return Sweep{ChainID: chainID, Token: token, Index: index, Address: addr, State: SweepIdle}
+}
+
+// Put records sw and saves the sweeps.
+func (s *SweepStore) Put(sw Sweep) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sw.Updated = time.Now().UTC()
+	s.sweeps[sweepKey(sw.ChainID, sw.Token, sw.Address)] = &sw
+	bs, err := json.MarshalIndent(s.sweeps, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, bs)
+}
+
+// SweepBackend is what the Sweeper needs from the rpc-server.
+type SweepBackend interface {
+	bind.ContractBackend
+	SentTxBackend
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+}
+
+// Sweeper consolidates the tokens of the deposit addresses 0 to Count-1 in the
+// Treasury. Each address whose balance reaches Threshold is funded by the hot
+// wallet with the gas of the token transfer, transfers its whole balance,
+// then sends the gas left over back to the hot wallet.
+type Sweeper struct {
+	Backend   SweepBackend
+	ChainID   *big.Int
+	Token     common.Address
+	Keys      *DepositKeys
+	Count     uint32
+	Treasury  common.Address
+	Threshold *big.Int
+	// Hot signs the gas top-ups, and receives the gas left over
+	Hot func(ctx context.Context) (*bind.TransactOpts, error)
+	// Fee prices the txs of the deposit addresses, like those of the hot wallet
+	Fee FeeConfig
+	// Policy guards the txs of the deposit addresses
+	Policy       *SpendingPolicy
+	Store        *SweepStore
+	PollInterval time.Duration
+	// DropTimeout is how long a tx unknown to the rpc-server is waited for
+	// before it's sent again, 10 minutes by default
+	DropTimeout time.Duration
+}
+
+// Run sweeps until ctx is done.
+func (w *Sweeper) Run(ctx context.Context) error {
+	for {
+		w.Step(ctx)
+		if err := sleep(ctx, w.PollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// Step moves the sweep of every deposit address as far as it can go without waiting.
+func (w *Sweeper) Step(ctx context.Context) {
+	for i := uint32(0); i < w.Count; i++ {
+		addr, err := w.Keys.Address(i)
+		if errors.Is(err, errInvalidChild) {
+			continue
+		}
+		if err != nil {
+			log.Error("Derive deposit address failed", "index", i, "err", err)
+			continue
+		}
+		sw := w.Store.Get(w.ChainID.Uint64(), w.Token, addr, i)
+		l := log.New("contract", w.Token, "address", addr, "index", i)
+		for {
+			state := sw.State
+			if err := w.step(ctx, l, &sw); err != nil {
+				l.Warn("Sweep failed", "state", state, "err", err)
+				break
+			}
+			if sw.State == state {
+				break
+			}
+			sweepTransitions.WithLabelValues(w.ChainID.String(), sw.State).Inc()
+			l.Info("Sweep moved", "from", state, "to", sw.State, "txHash", sw.TxHash)
+			if err := w.Store.Put(sw); err != nil {
+				l.Error("Save sweep failed", "err", err)
+				break
+			}
+		}
+	}
+}
+
+// step makes the next transition of sw, if it can. A dropped or reverted tx
+// goes back to the state which sent it, but for the token transfer, which is
+// priced and funded again.
+func (w *Sweeper) step(ctx context.Context, l log.Logger, sw *Sweep) error {
+	switch sw.State {
+	case SweepIdle:
+		return w.fund(ctx, sw)
+	case SweepFunding:
+		receipt, err := w.receipt(ctx, sw)
+		if errors.Is(err, ErrTxDropped) {
+			sw.move(SweepIdle, "gas top-up dropped")
+			return nil
+		}
+		if receipt == nil || err != nil {
+			return err
+		}
+		if receipt.Status != types.ReceiptStatusSuccessful {
+			sw.move(SweepIdle, "gas top-up failed")
+			return nil
+		}
+		sw.move(SweepFunded, "")
+	case SweepFunded:
+		return w.transfer(ctx, sw)
+	case SweepSweeping:
+		receipt, err := w.receipt(ctx, sw)
+		if errors.Is(err, ErrTxDropped) {
+			// estimate and fund again
+			sw.move(SweepIdle, "token transfer dropped")
+			return nil
+		}
+		if receipt == nil || err != nil {
+			return err
+		}
+		if receipt.Status != types.ReceiptStatusSuccessful {
+			sw.move(SweepIdle, "token transfer failed")
+			return nil
+		}
+		l.Info("Swept deposit", "amount", sw.Amount, "treasury", w.Treasury)
+		sw.move(SweepSwept, "")
+		sw.Swept++
+	case SweepSwept:
+		return w.reclaim(ctx, sw)
+	case SweepReclaiming:
+		receipt, err := w.receipt(ctx, sw)
+		if errors.Is(err, ErrTxDropped) {
+			sw.move(SweepSwept, "gas reclaim dropped")
+			return nil
+		}
+		if receipt == nil || err != nil {
+			return err
+		}
+		if receipt.Status != types.ReceiptStatusSuccessful {
+			sw.move(SweepSwept, "gas reclaim failed")
+			return nil
+		}
+		sw.move(SweepIdle, "")
+		sw.Amount = nil
+	default:
+		return fmt.Errorf("unknown state %q", sw.State)
+	}
+	return nil
+}
+
+// move moves sw to state, for reason when it's a step back.
+func (sw *Sweep) move(state, reason string) {
+	sw.State, sw.Error = state, reason
+	sw.TxHash, sw.TxFrom, sw.TxNonce = nil, nil, 0
+}
+
+// sent moves sw to state, waiting for tx sent by from.
+func (sw *Sweep) sent(state string, tx *types.Transaction, from common.Address) {
+	hash := tx.Hash()
+	sw.State, sw.TxHash, sw.TxFrom, sw.TxNonce = state, &hash, &from, tx.Nonce()
+}
+
+// receipt returns the receipt of the tx of sw, nil until it's mined, or ErrTxDropped.
+func (w *Sweeper) receipt(ctx context.Context, sw *Sweep) (*types.Receipt, error) {
+	var from common.Address
+	if sw.TxFrom != nil {
+		from = *sw.TxFrom
+	}
+	timeout := w.DropTimeout
+	if timeout == 0 {
+		timeout = defaultDropTimeout
+	}
+	return sentReceipt(ctx, w.Backend, *sw.TxHash, from, sw.TxNonce, sw.Updated, timeout)
+}
+
+// fund prices the transfer of the balance of sw, and tops the gas of the address up to pay for it.
+func (w *Sweeper) fund(ctx context.Context, sw *Sweep) error {
+	caller, err := NewEIP20Caller(w.Token, w.Backend)
+	if err != nil {
+		return err
+	}
+	balance, err := caller.BalanceOf(&bind.CallOpts{Context: ctx}, sw.Address)
+	if err != nil {
+		return err
+	}
+	if balance.Sign() == 0 || balance.Cmp(w.Threshold) < 0 {
+		return nil
+	}
+	parsed, err := EIP20MetaData.GetAbi()
+	if err != nil {
+		return err
+	}
+	data, err := parsed.Pack("transfer", w.Treasury, balance)
+	if err != nil {
+		return err
+	}
+	gas, err := w.Backend.EstimateGas(ctx, ethereum.CallMsg{From: sw.Address, To: &w.Token, Data: data})
+	if err != nil {
+		return fmt.Errorf("estimate transfer: %w", err)
+	}
+	fee := new(bind.TransactOpts)
+	price, err := w.price(ctx, fee)
+	if err != nil {
+		return err
+	}
+	sw.Amount, sw.GasLimit = balance, gas
+	sw.GasPrice, sw.GasFeeCap, sw.GasTipCap = fee.GasPrice, fee.GasFeeCap, fee.GasTipCap
+
+	need := new(big.Int).Mul(new(big.Int).SetUint64(gas), price)
+	have, err := w.Backend.BalanceAt(ctx, sw.Address, nil)
+	if err != nil {
+		return err
+	}
+	if have.Cmp(need) >= 0 {
+		sw.State = SweepFunded
+		return nil
+	}
+	hot, err := w.Hot(ctx)
+	if err != nil {
+		return err
+	}
+	// a fixed gas limit, bind refuses to estimate a send to an address without code
+	hot.Value, hot.GasLimit = need.Sub(need, have), params.TxGas
+	tx, err := send(ctx, hot, "sweep.fund", bind.NewBoundContract(sw.Address, abi.ABI{}, w.Backend, w.Backend, w.Backend).Transfer)
+	if err != nil {
+		return fmt.Errorf("send gas top-up: %w", err)
+	}
+	sw.sent(SweepFunding, tx, hot.From)
+	return nil
+}
+
+// transfer sends the token balance of sw to the treasury, with the gas it was funded for.
+func (w *Sweeper) transfer(ctx context.Context, sw *Sweep) error {
+	auth, err := w.depositTransactor(sw)
+	if err != nil {
+		return err
+	}
+	auth.GasLimit, auth.GasPrice, auth.GasFeeCap, auth.GasTipCap = sw.GasLimit, sw.GasPrice, sw.GasFeeCap, sw.GasTipCap
+	contract, err := NewEIP20(w.Token, w.Backend)
+	if err != nil {
+		return err
+	}
+	tx, err := send(ctx, auth, "EIP20.transfer", func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return contract.Transfer(opts, w.Treasury, sw.Amount)
+	})
+	if err != nil {
+		return fmt.Errorf("send sweep: %w", err)
+	}
+	sw.sent(SweepSweeping, tx, sw.Address)
+	return nil
+}
+
+// reclaim sends the gas left over by the sweep of sw back to the hot wallet,
+// when it's worth more than the gas of sending it.
+func (w *Sweeper) reclaim(ctx context.Context, sw *Sweep) error {
+	auth, err := w.depositTransactor(sw)
+	if err != nil {
+		return err
+	}
+	price, err := w.price(ctx, auth)
+	if err != nil {
+		return err
+	}
+	left, err := w.Backend.BalanceAt(ctx, sw.Address, nil)
+	if err != nil {
+		return err
+	}
+	left.Sub(left, new(big.Int).Mul(big.NewInt(int64(params.TxGas)), price))
+	if left.Sign() <= 0 {
+		sw.State, sw.Amount = SweepIdle, nil
+		return nil
+	}
+	hot, err := w.Hot(ctx)
+	if err != nil {
+		return err
+	}
+	auth.Value, auth.GasLimit = left, params.TxGas
+	tx, err := send(ctx, auth, "sweep.reclaim", bind.NewBoundContract(hot.From, abi.ABI{}, w.Backend, w.Backend, w.Backend).Transfer)
+	if err != nil {
+		return fmt.Errorf("send reclaim: %w", err)
+	}
+	sw.sent(SweepReclaiming, tx, sw.Address)
+	return nil
+}
+
+// price prices auth with the Fee, and returns the most it pays per gas.
+func (w *Sweeper) price(ctx context.Context, auth *bind.TransactOpts) (*big.Int, error) {
+	if err := w.Fee.apply(ctx, auth, w.Backend); err != nil {
+		return nil, err
+	}
+	return maxFeePerGas(ctx, w.Backend, auth)
+}
+
+func (w *Sweeper) depositTransactor(sw *Sweep) (*bind.TransactOpts, error) {
+	key, err := w.Keys.Key(sw.Index)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := bind.NewKeyedTransactorWithChainID(key, w.ChainID)
+	if err != nil {
+		return nil, err
+	}
+	return w.Policy.Guard(auth), nil
+}
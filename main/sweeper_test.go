@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDepositKeys(t *testing.T) {
+	// BIP32 test vector 1, chain m/0H/1/2H
+	seed := common.FromHex("000102030405060708090a0b0c0d0e0f")
+	keys, err := NewDepositKeys(seed, accounts.DerivationPath{hardenedBit + 0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := keys.Key(hardenedBit + 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "cbce0d719ecf7431d88e6a89fa1483e02e35092af60c042b1df2ff59fa424dca"
+	if got := common.Bytes2Hex(crypto.FromECDSA(key)); got != want {
+		t.Fatalf("m/0H/1/2H = %s, want %s", got, want)
+	}
+}
+
+func TestSweeper(t *testing.T) {
+	t.Run("dynamic", func(t *testing.T) { testSweeper(t, FeeConfig{}) })
+	// the fees of the network, on a chain without EIP-1559 too
+	t.Run("legacy", func(t *testing.T) { testSweeper(t, FeeConfig{Legacy: true}) })
+}
+
+func testSweeper(t *testing.T, fee FeeConfig) {
+	env := newTestEnv(t, 1)
+	token, _, contract := env.deploy(1000)
+	keys, err := NewDepositKeys(common.FromHex("000102030405060708090a0b0c0d0e0f"), accounts.DefaultRootDerivationPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := LoadSweeps(filepath.Join(t.TempDir(), SweepFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hot := env.accounts[0]
+	treasury := common.Address{0x77}
+	w := &Sweeper{
+		Backend:   env.backend,
+		ChainID:   simChainID,
+		Token:     token,
+		Keys:      keys,
+		Count:     2,
+		Treasury:  treasury,
+		Threshold: big.NewInt(100),
+		Hot: func(ctx context.Context) (*bind.TransactOpts, error) {
+			auth := hot.transactor(t)
+			return auth, fee.apply(ctx, auth, env.backend)
+		},
+		Fee:   fee,
+		Store: store,
+	}
+
+	// deposit 0 is worth sweeping, deposit 1 is not
+	deposit0, _ := keys.Address(0)
+	deposit1, _ := keys.Address(1)
+	for addr, amount := range map[common.Address]int64{deposit0: 300, deposit1: 50} {
+		if _, err := contract.Transfer(hot.transactor(t), addr, big.NewInt(amount)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env.backend.Commit()
+
+	ctx := context.Background()
+	states := []string{SweepFunding, SweepSweeping, SweepReclaiming, SweepIdle}
+	if fee.Legacy {
+		// the top-up is spent at the price it was made for, nothing is left to reclaim
+		states = []string{SweepFunding, SweepSweeping, SweepIdle}
+	}
+	for _, want := range states {
+		w.Step(ctx)
+		sw := store.Get(simChainID.Uint64(), token, deposit0, 0)
+		if sw.State != want {
+			t.Fatalf("state = %s (%s), want %s", sw.State, sw.Error, want)
+		}
+		if sw.TxHash == nil {
+			env.backend.Commit()
+			continue
+		}
+		tx, _, err := env.backend.TransactionByHash(ctx, *sw.TxHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if legacy := tx.Type() == types.LegacyTxType; legacy != fee.Legacy || !legacy && tx.GasTipCap().Int64() != 1*params.GWei {
+			t.Fatalf("%s tx of type %d, tip %s", want, tx.Type(), tx.GasTipCap())
+		}
+		env.backend.Commit()
+	}
+	if sw := store.Get(simChainID.Uint64(), token, deposit1, 1); sw.State != SweepIdle || sw.Swept != 0 {
+		t.Fatalf("swept a deposit under the threshold: %+v", sw)
+	}
+	if sw := store.Get(simChainID.Uint64(), token, deposit0, 0); sw.Swept != 1 {
+		t.Fatalf("swept %d times, want 1", sw.Swept)
+	}
+	// the same deposit address and token on another chain has a sweep of its own
+	if sw := store.Get(1, token, deposit0, 0); sw.State != SweepIdle || sw.Swept != 0 || sw.ChainID != 1 {
+		t.Fatalf("chain 1 got the sweep of chain %d: %+v", simChainID, sw)
+	}
+	env.requireBalance(contract, treasury, 300)
+	env.requireBalance(contract, deposit0, 0)
+	if testutil.ToFloat64(sweepTransitions.WithLabelValues(simChainID.String(), SweepSwept)) == 0 {
+		t.Fatalf("no sweep counted on chain %s", simChainID)
+	}
+
+	// the gas left over is reclaimed, but for the gas of reclaiming it
+	left, err := env.backend.BalanceAt(ctx, deposit0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	price, _ := w.price(ctx, new(bind.TransactOpts))
+	if max := new(big.Int).Mul(big.NewInt(int64(params.TxGas)), price); left.Cmp(max) > 0 {
+		t.Fatalf("%s wei left on the deposit address, want at most %s", left, max)
+	}
+
+	// nothing more to do
+	w.Step(ctx)
+	if sw := store.Get(simChainID.Uint64(), token, deposit0, 0); sw.State != SweepIdle || sw.Swept != 1 {
+		t.Fatalf("got %+v after the sweep", sw)
+	}
+}
+
+func TestSweeperDropped(t *testing.T) {
+	env := newTestEnv(t, 1)
+	token, _, contract := env.deploy(1000)
+	keys, err := NewDepositKeys(common.FromHex("000102030405060708090a0b0c0d0e0f"), accounts.DefaultRootDerivationPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := LoadSweeps(filepath.Join(t.TempDir(), SweepFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hot := env.accounts[0]
+	hotFrom := hot.addr
+	w := &Sweeper{
+		Backend:   env.backend,
+		ChainID:   simChainID,
+		Token:     token,
+		Keys:      keys,
+		Count:     1,
+		Treasury:  common.Address{0x77},
+		Threshold: big.NewInt(100),
+		Hot: func(ctx context.Context) (*bind.TransactOpts, error) {
+			auth := hot.transactor(t)
+			auth.From = hotFrom
+			return auth, nil
+		},
+		Store:       store,
+		DropTimeout: time.Hour,
+	}
+	deposit, _ := keys.Address(0)
+	if _, err := contract.Transfer(hot.transactor(t), deposit, big.NewInt(300)); err != nil {
+		t.Fatal(err)
+	}
+	env.backend.Commit()
+	ctx := context.Background()
+	get := func() Sweep { return store.Get(simChainID.Uint64(), token, deposit, 0) }
+
+	w.Step(ctx)
+	funding := get()
+	if funding.State != SweepFunding || *funding.TxFrom != hot.addr {
+		t.Fatalf("got %+v, want the gas top-up sent by the hot wallet", funding)
+	}
+
+	// the rpc-server forgets the top-up: waited for until the timeout
+	env.backend.Rollback()
+	w.Step(ctx)
+	if sw := get(); *sw.TxHash != *funding.TxHash {
+		t.Fatalf("got %+v, want the top-up waited for", sw)
+	}
+	w.DropTimeout = time.Nanosecond
+	w.Step(ctx)
+	refunding := get()
+	if refunding.State != SweepFunding || refunding.Error != "gas top-up dropped" {
+		t.Fatalf("got %+v, want the top-up sent again", refunding)
+	}
+	if _, _, err := env.backend.TransactionByHash(ctx, *refunding.TxHash); err != nil {
+		t.Fatalf("top-up not sent again: %v", err)
+	}
+	w.DropTimeout = time.Hour
+
+	// another tx of the hot wallet takes the nonce of the top-up
+	env.backend.Rollback()
+	if _, err := contract.Transfer(hot.transactor(t), common.Address{0x11}, big.NewInt(1)); err != nil {
+		t.Fatal(err)
+	}
+	env.backend.Commit()
+	w.Step(ctx)
+	if sw := get(); sw.State != SweepFunding || *sw.TxHash == *refunding.TxHash || sw.TxNonce != refunding.TxNonce+1 {
+		t.Fatalf("got %+v, want the top-up sent again with the next nonce", sw)
+	}
+	env.backend.Commit()
+	w.Step(ctx)
+	env.backend.Commit()
+	w.Step(ctx)
+	if sw := get(); sw.State != SweepReclaiming {
+		t.Fatalf("state = %s (%s), want %s", sw.State, sw.Error, SweepReclaiming)
+	}
+	env.backend.Commit()
+	env.requireBalance(contract, w.Treasury, 300)
+
+	// a reverted reclaim is not a success: the token contract takes no ether
+	w.Step(ctx)
+	sw := get()
+	sw.move(SweepSwept, "")
+	if err := store.Put(sw); err != nil {
+		t.Fatal(err)
+	}
+	auth := hot.transactor(t)
+	auth.Value, auth.GasLimit = big.NewInt(params.Ether), params.TxGas
+	if _, err := bind.NewBoundContract(deposit, abi.ABI{}, env.backend, env.backend, env.backend).Transfer(auth); err != nil {
+		t.Fatal(err)
+	}
+	env.backend.Commit()
+	hotFrom = token
+	w.Step(ctx)
+	env.backend.Commit()
+	w.Step(ctx)
+	if sw := get(); sw.State != SweepReclaiming || sw.Error != "gas reclaim failed" {
+		t.Fatalf("got %+v, want the reclaim sent again", sw)
+	}
+}
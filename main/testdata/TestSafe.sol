@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.0;
+
+// TestSafe is a Safe reduced to what the tests need: the owners, the threshold,
+// the nonce and execTransaction, with the ABI, the EIP-712 hashing, the checks
+// of the signatures and the error codes of Safe v1.3.0. There are no refunds,
+// no delegatecalls, no modules and no guard.
+contract TestSafe {
+    event ExecutionFailure(bytes32 txHash, uint256 payment);
+    event ExecutionSuccess(bytes32 txHash, uint256 payment);
+
+    // keccak256("EIP712Domain(uint256 chainId,address verifyingContract)")
+    bytes32 private constant DOMAIN_SEPARATOR_TYPEHASH = 0x47e79534a245952e8b16893a336b85a3d9ea9fa8c573f3d803afb92a79469218;
+    // keccak256("SafeTx(address to,uint256 value,bytes data,uint8 operation,uint256 safeTxGas,uint256 baseGas,uint256 gasPrice,address gasToken,address refundReceiver,uint256 nonce)")
+    bytes32 private constant SAFE_TX_TYPEHASH = 0xbb8310d486368db6bd6f849402fdd73ad53d316b5a4b2644ad6efe0f941286d8;
+
+    address[] private owners;
+    mapping(address => bool) private owned;
+    uint256 private threshold;
+    uint256 public nonce;
+
+    constructor(address[] memory _owners, uint256 _threshold) {
+        require(_threshold > 0 && _threshold <= _owners.length, "GS201");
+        for (uint256 i = 0; i < _owners.length; i++) {
+            require(_owners[i] != address(0) && !owned[_owners[i]], "GS203");
+            owned[_owners[i]] = true;
+        }
+        owners = _owners;
+        threshold = _threshold;
+    }
+
+    receive() external payable {}
+
+    function getOwners() public view returns (address[] memory) {
+        return owners;
+    }
+
+    function getThreshold() public view returns (uint256) {
+        return threshold;
+    }
+
+    function isOwner(address owner) public view returns (bool) {
+        return owned[owner];
+    }
+
+    function domainSeparator() public view returns (bytes32) {
+        return keccak256(abi.encode(DOMAIN_SEPARATOR_TYPEHASH, block.chainid, this));
+    }
+
+    function encodeTransactionData(
+        address to,
+        uint256 value,
+        bytes calldata data,
+        uint8 operation,
+        uint256 safeTxGas,
+        uint256 baseGas,
+        uint256 gasPrice,
+        address gasToken,
+        address refundReceiver,
+        uint256 _nonce
+    ) public view returns (bytes memory) {
+        bytes32 safeTxHash = keccak256(
+            abi.encode(SAFE_TX_TYPEHASH, to, value, keccak256(data), operation, safeTxGas, baseGas, gasPrice, gasToken, refundReceiver, _nonce)
+        );
+        return abi.encodePacked(bytes1(0x19), bytes1(0x01), domainSeparator(), safeTxHash);
+    }
+
+    function getTransactionHash(
+        address to,
+        uint256 value,
+        bytes calldata data,
+        uint8 operation,
+        uint256 safeTxGas,
+        uint256 baseGas,
+        uint256 gasPrice,
+        address gasToken,
+        address refundReceiver,
+        uint256 _nonce
+    ) public view returns (bytes32) {
+        return keccak256(encodeTransactionData(to, value, data, operation, safeTxGas, baseGas, gasPrice, gasToken, refundReceiver, _nonce));
+    }
+
+    function execTransaction(
+        address to,
+        uint256 value,
+        bytes calldata data,
+        uint8 operation,
+        uint256 safeTxGas,
+        uint256 baseGas,
+        uint256 gasPrice,
+        address gasToken,
+        address payable refundReceiver,
+        bytes memory signatures
+    ) public payable returns (bool success) {
+        require(operation == 0 && gasPrice == 0, "TestSafe: calls without refund only");
+        bytes32 txHash = keccak256(encodeTransactionData(to, value, data, operation, safeTxGas, baseGas, gasPrice, gasToken, refundReceiver, nonce));
+        nonce++;
+        checkSignatures(txHash, signatures);
+        require(gasleft() >= ((safeTxGas * 64) / 63) + 2500, "GS010");
+        (success, ) = to.call{value: value, gas: safeTxGas == 0 ? gasleft() - 2500 : safeTxGas}(data);
+        require(success || safeTxGas != 0, "GS013");
+        if (success) emit ExecutionSuccess(txHash, 0);
+        else emit ExecutionFailure(txHash, 0);
+    }
+
+    // checkSignatures takes threshold signatures of the owners, in ascending
+    // order of the owners: ECDSA signatures of the hash, v 27 or 28, or eth_sign
+    // signatures of it, v 31 or 32.
+    function checkSignatures(bytes32 dataHash, bytes memory signatures) internal view {
+        require(signatures.length >= threshold * 65, "GS020");
+        address lastOwner = address(0);
+        for (uint256 i = 0; i < threshold; i++) {
+            (uint8 v, bytes32 r, bytes32 s) = signatureSplit(signatures, i);
+            address currentOwner;
+            if (v > 30) {
+                currentOwner = ecrecover(keccak256(abi.encodePacked("\x19Ethereum Signed Message:\n32", dataHash)), v - 4, r, s);
+            } else {
+                currentOwner = ecrecover(dataHash, v, r, s);
+            }
+            require(currentOwner > lastOwner && owned[currentOwner], "GS026");
+            lastOwner = currentOwner;
+        }
+    }
+
+    function signatureSplit(bytes memory signatures, uint256 pos) internal pure returns (uint8 v, bytes32 r, bytes32 s) {
+        assembly {
+            let signaturePos := mul(0x41, pos)
+            r := mload(add(signatures, add(signaturePos, 0x20)))
+            s := mload(add(signatures, add(signaturePos, 0x40)))
+            v := and(mload(add(signatures, add(signaturePos, 0x41))), 0xff)
+        }
+    }
+}
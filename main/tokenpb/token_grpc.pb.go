@@ -0,0 +1,454 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: token.proto
+
+package tokenpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// TokenServiceClient is the client API for TokenService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TokenServiceClient interface {
+	Deploy(ctx context.Context, in *DeployRequest, opts ...grpc.CallOption) (*DeployResponse, error)
+	Transfer(ctx context.Context, in *TransferRequest, opts ...grpc.CallOption) (*TxResponse, error)
+	Approve(ctx context.Context, in *ApproveRequest, opts ...grpc.CallOption) (*TxResponse, error)
+	TransferFrom(ctx context.Context, in *TransferFromRequest, opts ...grpc.CallOption) (*TxResponse, error)
+	GetTokenInfo(ctx context.Context, in *TokenRequest, opts ...grpc.CallOption) (*TokenInfo, error)
+	BalanceOf(ctx context.Context, in *BalanceOfRequest, opts ...grpc.CallOption) (*AmountResponse, error)
+	Allowance(ctx context.Context, in *AllowanceRequest, opts ...grpc.CallOption) (*AmountResponse, error)
+	// WatchTransfers streams the Transfer events of a token as they are mined,
+	// again on each confirmation, and as removed when a reorg drops them.
+	WatchTransfers(ctx context.Context, in *WatchTransfersRequest, opts ...grpc.CallOption) (TokenService_WatchTransfersClient, error)
+	// WatchTx streams the status changes of a tx until it's confirmed or failed.
+	WatchTx(ctx context.Context, in *WatchTxRequest, opts ...grpc.CallOption) (TokenService_WatchTxClient, error)
+}
+
+type tokenServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTokenServiceClient(cc grpc.ClientConnInterface) TokenServiceClient {
+	return &tokenServiceClient{cc}
+}
+
+func (c *tokenServiceClient) Deploy(ctx context.Context, in *DeployRequest, opts ...grpc.CallOption) (*DeployResponse, error) {
+	out := new(DeployResponse)
+	err := c.cc.Invoke(ctx, "/dapp.token.v1.TokenService/Deploy", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenServiceClient) Transfer(ctx context.Context, in *TransferRequest, opts ...grpc.CallOption) (*TxResponse, error) {
+	out := new(TxResponse)
+	err := c.cc.Invoke(ctx, "/dapp.token.v1.TokenService/Transfer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenServiceClient) Approve(ctx context.Context, in *ApproveRequest, opts ...grpc.CallOption) (*TxResponse, error) {
+	out := new(TxResponse)
+	err := c.cc.Invoke(ctx, "/dapp.token.v1.TokenService/Approve", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenServiceClient) TransferFrom(ctx context.Context, in *TransferFromRequest, opts ...grpc.CallOption) (*TxResponse, error) {
+	out := new(TxResponse)
+	err := c.cc.Invoke(ctx, "/dapp.token.v1.TokenService/TransferFrom", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenServiceClient) GetTokenInfo(ctx context.Context, in *TokenRequest, opts ...grpc.CallOption) (*TokenInfo, error) {
+	out := new(TokenInfo)
+	err := c.cc.Invoke(ctx, "/dapp.token.v1.TokenService/GetTokenInfo", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenServiceClient) BalanceOf(ctx context.Context, in *BalanceOfRequest, opts ...grpc.CallOption) (*AmountResponse, error) {
+	out := new(AmountResponse)
+	err := c.cc.Invoke(ctx, "/dapp.token.v1.TokenService/BalanceOf", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenServiceClient) Allowance(ctx context.Context, in *AllowanceRequest, opts ...grpc.CallOption) (*AmountResponse, error) {
+	out := new(AmountResponse)
+	err := c.cc.Invoke(ctx, "/dapp.token.v1.TokenService/Allowance", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenServiceClient) WatchTransfers(ctx context.Context, in *WatchTransfersRequest, opts ...grpc.CallOption) (TokenService_WatchTransfersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TokenService_ServiceDesc.Streams[0], "/dapp.token.v1.TokenService/WatchTransfers", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &tokenServiceWatchTransfersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TokenService_WatchTransfersClient interface {
+	Recv() (*TokenEvent, error)
+	grpc.ClientStream
+}
+
+type tokenServiceWatchTransfersClient struct {
+	grpc.ClientStream
+}
+
+func (x *tokenServiceWatchTransfersClient) Recv() (*TokenEvent, error) {
+	m := new(TokenEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *tokenServiceClient) WatchTx(ctx context.Context, in *WatchTxRequest, opts ...grpc.CallOption) (TokenService_WatchTxClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TokenService_ServiceDesc.Streams[1], "/dapp.token.v1.TokenService/WatchTx", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &tokenServiceWatchTxClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TokenService_WatchTxClient interface {
+	Recv() (*TxStatus, error)
+	grpc.ClientStream
+}
+
+type tokenServiceWatchTxClient struct {
+	grpc.ClientStream
+}
+
+func (x *tokenServiceWatchTxClient) Recv() (*TxStatus, error) {
+	m := new(TxStatus)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TokenServiceServer is the server API for TokenService service.
+// All implementations must embed UnimplementedTokenServiceServer
+// for forward compatibility
+type TokenServiceServer interface {
+	Deploy(context.Context, *DeployRequest) (*DeployResponse, error)
+	Transfer(context.Context, *TransferRequest) (*TxResponse, error)
+	Approve(context.Context, *ApproveRequest) (*TxResponse, error)
+	TransferFrom(context.Context, *TransferFromRequest) (*TxResponse, error)
+	GetTokenInfo(context.Context, *TokenRequest) (*TokenInfo, error)
+	BalanceOf(context.Context, *BalanceOfRequest) (*AmountResponse, error)
+	Allowance(context.Context, *AllowanceRequest) (*AmountResponse, error)
+	// WatchTransfers streams the Transfer events of a token as they are mined,
+	// again on each confirmation, and as removed when a reorg drops them.
+	WatchTransfers(*WatchTransfersRequest, TokenService_WatchTransfersServer) error
+	// WatchTx streams the status changes of a tx until it's confirmed or failed.
+	WatchTx(*WatchTxRequest, TokenService_WatchTxServer) error
+	mustEmbedUnimplementedTokenServiceServer()
+}
+
+// UnimplementedTokenServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedTokenServiceServer struct {
+}
+
+func (UnimplementedTokenServiceServer) Deploy(context.Context, *DeployRequest) (*DeployResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Deploy not implemented")
+}
+func (UnimplementedTokenServiceServer) Transfer(context.Context, *TransferRequest) (*TxResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Transfer not implemented")
+}
+func (UnimplementedTokenServiceServer) Approve(context.Context, *ApproveRequest) (*TxResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Approve not implemented")
+}
+func (UnimplementedTokenServiceServer) TransferFrom(context.Context, *TransferFromRequest) (*TxResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TransferFrom not implemented")
+}
+func (UnimplementedTokenServiceServer) GetTokenInfo(context.Context, *TokenRequest) (*TokenInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTokenInfo not implemented")
+}
+func (UnimplementedTokenServiceServer) BalanceOf(context.Context, *BalanceOfRequest) (*AmountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BalanceOf not implemented")
+}
+func (UnimplementedTokenServiceServer) Allowance(context.Context, *AllowanceRequest) (*AmountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Allowance not implemented")
+}
+func (UnimplementedTokenServiceServer) WatchTransfers(*WatchTransfersRequest, TokenService_WatchTransfersServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchTransfers not implemented")
+}
+func (UnimplementedTokenServiceServer) WatchTx(*WatchTxRequest, TokenService_WatchTxServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchTx not implemented")
+}
+func (UnimplementedTokenServiceServer) mustEmbedUnimplementedTokenServiceServer() {}
+
+// UnsafeTokenServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TokenServiceServer will
+// result in compilation errors.
+type UnsafeTokenServiceServer interface {
+	mustEmbedUnimplementedTokenServiceServer()
+}
+
+func RegisterTokenServiceServer(s grpc.ServiceRegistrar, srv TokenServiceServer) {
+	s.RegisterService(&TokenService_ServiceDesc, srv)
+}
+
+func _TokenService_Deploy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeployRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServiceServer).Deploy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dapp.token.v1.TokenService/Deploy",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServiceServer).Deploy(ctx, req.(*DeployRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenService_Transfer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransferRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServiceServer).Transfer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dapp.token.v1.TokenService/Transfer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServiceServer).Transfer(ctx, req.(*TransferRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenService_Approve_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ApproveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServiceServer).Approve(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dapp.token.v1.TokenService/Approve",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServiceServer).Approve(ctx, req.(*ApproveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenService_TransferFrom_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransferFromRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServiceServer).TransferFrom(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dapp.token.v1.TokenService/TransferFrom",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServiceServer).TransferFrom(ctx, req.(*TransferFromRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenService_GetTokenInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServiceServer).GetTokenInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dapp.token.v1.TokenService/GetTokenInfo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServiceServer).GetTokenInfo(ctx, req.(*TokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenService_BalanceOf_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BalanceOfRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServiceServer).BalanceOf(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dapp.token.v1.TokenService/BalanceOf",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServiceServer).BalanceOf(ctx, req.(*BalanceOfRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenService_Allowance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AllowanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServiceServer).Allowance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dapp.token.v1.TokenService/Allowance",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServiceServer).Allowance(ctx, req.(*AllowanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenService_WatchTransfers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchTransfersRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TokenServiceServer).WatchTransfers(m, &tokenServiceWatchTransfersServer{stream})
+}
+
+type TokenService_WatchTransfersServer interface {
+	Send(*TokenEvent) error
+	grpc.ServerStream
+}
+
+type tokenServiceWatchTransfersServer struct {
+	grpc.ServerStream
+}
+
+func (x *tokenServiceWatchTransfersServer) Send(m *TokenEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _TokenService_WatchTx_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchTxRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TokenServiceServer).WatchTx(m, &tokenServiceWatchTxServer{stream})
+}
+
+type TokenService_WatchTxServer interface {
+	Send(*TxStatus) error
+	grpc.ServerStream
+}
+
+type tokenServiceWatchTxServer struct {
+	grpc.ServerStream
+}
+
+func (x *tokenServiceWatchTxServer) Send(m *TxStatus) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// TokenService_ServiceDesc is the grpc.ServiceDesc for TokenService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TokenService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dapp.token.v1.TokenService",
+	HandlerType: (*TokenServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Deploy",
+			Handler:    _TokenService_Deploy_Handler,
+		},
+		{
+			MethodName: "Transfer",
+			Handler:    _TokenService_Transfer_Handler,
+		},
+		{
+			MethodName: "Approve",
+			Handler:    _TokenService_Approve_Handler,
+		},
+		{
+			MethodName: "TransferFrom",
+			Handler:    _TokenService_TransferFrom_Handler,
+		},
+		{
+			MethodName: "GetTokenInfo",
+			Handler:    _TokenService_GetTokenInfo_Handler,
+		},
+		{
+			MethodName: "BalanceOf",
+			Handler:    _TokenService_BalanceOf_Handler,
+		},
+		{
+			MethodName: "Allowance",
+			Handler:    _TokenService_Allowance_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchTransfers",
+			Handler:       _TokenService_WatchTransfers_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchTx",
+			Handler:       _TokenService_WatchTx_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "token.proto",
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	lru "github.com/hashicorp/golang-lru"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// trace exporters of the config
+const (
+	TraceExporterNone   = ""
+	TraceExporterOTLP   = "otlp"
+	TraceExporterStdout = "stdout"
+	TraceExporterFile   = "file"
+)
+
+const serviceName = "backend-dapp-demo"
+
+var tracer = otel.Tracer("github.com/0xcoolface/backend-dapp-demo/main")
+
+// setupTracing installs the global tracer provider exporting to the exporter of c.
+// The returned func flushes and stops it.
+func setupTracing(ctx context.Context, c *Config) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+	var err error
+	switch c.TraceExporter {
+	case TraceExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case TraceExporterOTLP:
+		// without an endpoint, OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4318 is used
+		var opts []otlptracehttp.Option
+		if c.TraceEndpoint != "" {
+			u, err := url.Parse(c.TraceEndpoint)
+			if err != nil || u.Host == "" {
+				return nil, fmt.Errorf("invalid trace endpoint %q", c.TraceEndpoint)
+			}
+			opts = append(opts, otlptracehttp.WithEndpoint(u.Host))
+			if u.Scheme == "http" {
+				opts = append(opts, otlptracehttp.WithInsecure())
+			}
+			if u.Path != "" && u.Path != "/" {
+				opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+			}
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	case TraceExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case TraceExporterFile:
+		if file, err = os.OpenFile(c.TraceFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			return nil, err
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	default:
+		return nil, fmt.Errorf("unsupported trace exporter %q", c.TraceExporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+	)
+	otel.SetTracerProvider(tp)
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if file != nil {
+			file.Close()
+		}
+		return err
+	}, nil
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// sentTxs keeps the span context which sent a tx, by tx hash, to link the
+// delivery of its events back to the request.
+var sentTxs, _ = lru.New(4096)
+
+// send runs a transact method of a contract binding, like EIP20Transactor.Transfer,
+// in a span named after method. The rpc calls made for it are children of that span.
+// Unless auth has a nonce, the tx of a config account takes the next of its NonceManager.
+func send(ctx context.Context, auth *bind.TransactOpts, method string, transact func(*bind.TransactOpts) (*types.Transaction, error)) (tx *types.Transaction, err error) {
+	ctx, span := tracer.Start(ctx, method, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("eth.from", auth.From.Hex())))
+	defer func() { endSpan(span, err) }()
+
+	opts := *auth
+	opts.Context = ctx
+	var nonces *NonceManager
+	if auth.Nonce == nil {
+		if nonces = signers.Nonces(auth.From); nonces != nil {
+			n, err := nonces.Next(ctx)
+			if err != nil {
+				return nil, err
+			}
+			opts.Nonce = new(big.Int).SetUint64(n)
+		}
+	}
+	if tx, err = transact(&opts); err != nil {
+		if nonces != nil {
+			// the nonce may be used or not, ask the rpc-server
+			nonces.Reset()
+		}
+		return nil, err
+	}
+	span.SetAttributes(
+		attribute.String("eth.tx_hash", tx.Hash().Hex()),
+		attribute.Int64("eth.nonce", int64(tx.Nonce())),
+	)
+	sentTxs.Add(tx.Hash(), span.SpanContext())
+	return tx, nil
+}
+
+// traceEvent starts the span delivering an event of txHash, linked to the
+// span which sent the tx if it was sent by this process.
+func traceEvent(ctx context.Context, name string, raw types.Log) (context.Context, trace.Span) {
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("eth.contract", raw.Address.Hex()),
+			attribute.String("eth.tx_hash", raw.TxHash.Hex()),
+			attribute.Int64("eth.block", int64(raw.BlockNumber)),
+			attribute.Int64("eth.log_index", int64(raw.Index)),
+		),
+	}
+	if sc, ok := sentSpan(raw.TxHash); ok {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: sc}))
+	}
+	return tracer.Start(ctx, name, opts...)
+}
+
+func sentSpan(txHash common.Hash) (trace.SpanContext, bool) {
+	v, ok := sentTxs.Get(txHash)
+	if !ok {
+		return trace.SpanContext{}, false
+	}
+	return v.(trace.SpanContext), true
+}
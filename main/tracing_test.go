@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	recorderOnce sync.Once
+	recorder     *tracetest.SpanRecorder
+)
+
+// spanRecorder records the spans of every tracer; the global tracer provider
+// can only be installed once.
+func spanRecorder() *tracetest.SpanRecorder {
+	recorderOnce.Do(func() {
+		recorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	})
+	return recorder
+}
+
+func TestTraceTransfer(t *testing.T) {
+	rec := spanRecorder()
+	env := newTestEnv(t, 1)
+	addr, _, _ := env.deploy(1000)
+	node := newSimRPCServer(env, true)
+	t.Cleanup(node.Close)
+
+	ctx := context.Background()
+	c, err := Dial(ctx, node.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	contract, err := NewEIP20(addr, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, root := tracer.Start(ctx, "request")
+	tx, err := send(ctx, env.accounts[0].transactor(t), "EIP20.transfer", func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return contract.Transfer(opts, common.Address{0x11}, big.NewInt(1))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WaitReceipt(ctx, c, tx.Hash(), 10*time.Millisecond, 3, 0); err != nil {
+		t.Fatal(err)
+	}
+	root.End()
+
+	// the event is delivered apart from the request
+	it, err := contract.FilterTransfer(&bind.FilterOpts{Start: 0}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it.Next() {
+		if it.Event.Raw.TxHash == tx.Hash() {
+			deliverTransfer(context.Background(), logger(ctx), simChainID, it.Event)
+		}
+	}
+	it.Close()
+
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, s := range rec.Ended() {
+		if s.SpanContext().TraceID() == root.SpanContext().TraceID() || s.Name() == "EIP20Transfer" {
+			spans[s.Name()] = append(spans[s.Name()], s)
+		}
+	}
+	if len(spans["EIP20.transfer"]) != 1 {
+		t.Fatalf("got %d send spans, want 1", len(spans["EIP20.transfer"]))
+	}
+	sendSpan := spans["EIP20.transfer"][0]
+	if sendSpan.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Fatal("send span is not a child of the request")
+	}
+	for _, method := range []string{"eth_getTransactionCount", "eth_estimateGas", "eth_sendRawTransaction"} {
+		if len(spans[method]) == 0 || spans[method][0].Parent().SpanID() != sendSpan.SpanContext().SpanID() {
+			t.Fatalf("no %s span under the send span", method)
+		}
+	}
+	if len(spans["WaitReceipt.try"]) == 0 {
+		t.Fatal("no span for the receipt wait")
+	}
+	for _, s := range spans["WaitReceipt.try"] {
+		if s.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Fatal("receipt wait span is not a child of the request")
+		}
+	}
+	var linked bool
+	for _, s := range spans["EIP20Transfer"] {
+		for _, l := range s.Links() {
+			linked = linked || l.SpanContext.SpanID() == sendSpan.SpanContext().SpanID()
+		}
+	}
+	if !linked {
+		t.Fatal("event span is not linked to the send span")
+	}
+}
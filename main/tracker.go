@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// TrackerBackend is what the Tracker needs from the rpc-server.
+type TrackerBackend interface {
+	IndexerBackend
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// liveEvents feeds the events seen by the Tracker, before they are final.
+var liveEvents event.Feed
+
+// SubscribeLiveEvents sends the events of the Tracker to ch: each event as
+// soon as it's mined, again on each new confirmation, and with Removed set
+// when a reorg drops it.
+func SubscribeLiveEvents(ch chan<- *TokenEvent) event.Subscription {
+	return liveEvents.Subscribe(ch)
+}
+
+type eventKey struct {
+	txHash   common.Hash
+	logIndex uint
+}
+
+// Tracker follows the Transfer and Approval events of the tokens as they are
+// mined, like WatchTransferEvent, and follows their confirmations until
+// Confirms, checking every new head for reorgs.
+type Tracker struct {
+	Backend  TrackerBackend
+	ChainID  *big.Int
+	Tokens   []common.Address
+	Confirms uint64
+	// Subscribe watches the events and heads with eth_subscribe, instead of polling every PollInterval
+	Subscribe    bool
+	PollInterval time.Duration
+
+	head    uint64
+	pending map[eventKey]*TokenEvent
+}
+
+// Run tracks until ctx is done.
+func (t *Tracker) Run(ctx context.Context) error {
+	t.pending = make(map[eventKey]*TokenEvent)
+	if !t.Subscribe {
+		return t.poll(ctx)
+	}
+	backoff := time.Second
+	for {
+		err := t.follow(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Warn("Event subscription failed, subscribe again", "chainId", t.ChainID, "err", err, "backoff", backoff)
+		if err := sleep(ctx, backoff); err != nil {
+			return err
+		}
+		subscriptionReconnects.WithLabelValues(t.ChainID.String(), "logs").Inc()
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+// follow subscribes to the events of the tokens and the new heads.
+func (t *Tracker) follow(ctx context.Context) error {
+	transfers := make(chan *EIP20Transfer)
+	approvals := make(chan *EIP20Approval)
+	opts := &bind.WatchOpts{Context: ctx}
+	// the first error of the event subscriptions
+	subErrs := make(chan error, 1)
+	watchErr := func(sub event.Subscription) {
+		if err := <-sub.Err(); err != nil {
+			select {
+			case subErrs <- err:
+			default:
+			}
+		}
+	}
+	for _, token := range t.Tokens {
+		f, err := NewEIP20Filterer(token, t.Backend)
+		if err != nil {
+			return err
+		}
+		sub, err := f.WatchTransfer(opts, transfers, nil, nil)
+		if err != nil {
+			return err
+		}
+		defer sub.Unsubscribe()
+		go watchErr(sub)
+		sub, err = f.WatchApproval(opts, approvals, nil, nil)
+		if err != nil {
+			return err
+		}
+		defer sub.Unsubscribe()
+		go watchErr(sub)
+	}
+	headers := make(chan *types.Header)
+	sub, err := t.Backend.SubscribeNewHead(ctx, headers)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-sub.Err():
+			return err
+		case err := <-subErrs:
+			return err
+		case e := <-transfers:
+			t.onEvent(transferEvent(t.ChainID, e))
+		case e := <-approvals:
+			t.onEvent(approvalEvent(t.ChainID, e))
+		case header := <-headers:
+			t.onHead(ctx, header.Number.Uint64())
+		}
+	}
+}
+
+// poll scans the new blocks for the events of the tokens every PollInterval.
+func (t *Tracker) poll(ctx context.Context) error {
+	var filterers []*EIP20Filterer
+	for _, token := range t.Tokens {
+		f, err := NewEIP20Filterer(token, t.Backend)
+		if err != nil {
+			return err
+		}
+		filterers = append(filterers, f)
+	}
+	next := uint64(0)
+	for {
+		head, err := t.Backend.BlockNumber(ctx)
+		if err != nil {
+			log.Warn("Get BlockNumber failed", "chainId", t.ChainID, "err", err)
+		} else {
+			if next == 0 || next > head+1 {
+				// start at the head, and rescan after a reorg to a shorter chain
+				next = head
+			}
+			if err := t.scan(ctx, filterers, next, head); err != nil {
+				log.Warn("Scan events failed", "chainId", t.ChainID, "err", err)
+			} else {
+				next = head + 1
+			}
+			if reorged, ok := t.onHead(ctx, head); ok && reorged < next {
+				// scan the new blocks replacing the reorged ones
+				next = reorged
+			}
+		}
+		if err := sleep(ctx, t.PollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+func (t *Tracker) scan(ctx context.Context, filterers []*EIP20Filterer, start, end uint64) error {
+	if start > end {
+		return nil
+	}
+	opts := &bind.FilterOpts{Start: start, End: &end, Context: ctx}
+	for _, f := range filterers {
+		transfers, err := f.FilterTransfer(opts, nil, nil)
+		if err != nil {
+			return err
+		}
+		for transfers.Next() {
+			t.onEvent(transferEvent(t.ChainID, transfers.Event))
+		}
+		transfers.Close()
+		if err := transfers.Error(); err != nil {
+			return err
+		}
+		approvals, err := f.FilterApproval(opts, nil, nil)
+		if err != nil {
+			return err
+		}
+		for approvals.Next() {
+			t.onEvent(approvalEvent(t.ChainID, approvals.Event))
+		}
+		approvals.Close()
+		if err := approvals.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// onEvent starts tracking e, or retracts it if it was removed.
+func (t *Tracker) onEvent(e *TokenEvent) {
+	key := eventKey{e.TxHash, e.LogIndex}
+	if e.Removed {
+		if _, ok := t.pending[key]; ok {
+			delete(t.pending, key)
+			t.send(e)
+		}
+		return
+	}
+	if old, ok := t.pending[key]; ok && old.BlockHash == e.BlockHash {
+		return
+	}
+	if t.head >= e.BlockNumber {
+		e.Confirmations = t.head - e.BlockNumber
+	}
+	t.pending[key] = e
+	t.send(e)
+	if e.Confirmations >= t.Confirms {
+		delete(t.pending, key)
+	}
+}
+
+// onHead updates the confirmations of the pending events, and retracts
+// those whose block is no longer canonical. It returns the lowest of those blocks.
+func (t *Tracker) onHead(ctx context.Context, head uint64) (reorged uint64, ok bool) {
+	t.head = head
+	canonical := make(map[uint64]common.Hash)
+	for key, e := range t.pending {
+		if e.BlockNumber > head {
+			// a lagging rpc-server, check on the next head
+			continue
+		}
+		hash, known := canonical[e.BlockNumber]
+		if !known {
+			h, err := t.Backend.HeaderByNumber(ctx, new(big.Int).SetUint64(e.BlockNumber))
+			if err != nil {
+				continue
+			}
+			hash = h.Hash()
+			canonical[e.BlockNumber] = hash
+		}
+		if hash != e.BlockHash {
+			removed := *e
+			removed.Removed = true
+			delete(t.pending, key)
+			t.send(&removed)
+			if !ok || e.BlockNumber < reorged {
+				reorged, ok = e.BlockNumber, true
+			}
+			continue
+		}
+		if confirms := head - e.BlockNumber; confirms != e.Confirmations {
+			e.Confirmations = confirms
+			t.send(e)
+		}
+		if e.Confirmations >= t.Confirms {
+			delete(t.pending, key)
+		}
+	}
+	return reorged, ok
+}
+
+// send feeds a copy, as the pending event keeps changing.
+func (t *Tracker) send(e *TokenEvent) {
+	c := *e
+	liveEvents.Send(&c)
+}
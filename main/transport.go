@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Transport is how an rpc-server is reached, told from its url as rpc.DialContext does.
+type Transport string
+
+const (
+	TransportHTTP  Transport = "http"
+	TransportHTTPS Transport = "https"
+	TransportWS    Transport = "ws"
+	TransportWSS   Transport = "wss"
+	// TransportIPC is a unix socket or a windows named pipe, given by its path or a file:// url
+	TransportIPC Transport = "ipc"
+)
+
+// ErrUnknownTransport is returned for an rpc url of no known transport.
+var ErrUnknownTransport = errors.New("unknown transport")
+
+// ParseTransport classifies rawurl: a url of scheme http, https, ws or wss, or
+// an ipc file given by a path with no scheme, like a windows \\.\pipe\ or
+// C:\ path, or by a file:// url. Any other scheme is ErrUnknownTransport.
+func ParseTransport(rawurl string) (Transport, error) {
+	rawurl = strings.TrimSpace(rawurl)
+	if rawurl == "" {
+		return "", fmt.Errorf("%w: no url", ErrUnknownTransport)
+	}
+	if windowsPath.MatchString(rawurl) {
+		return TransportIPC, nil
+	}
+	m := urlScheme.FindStringSubmatch(rawurl)
+	if m == nil {
+		return TransportIPC, nil
+	}
+	if !strings.HasPrefix(rawurl[len(m[0]):], "//") {
+		return "", fmt.Errorf("%w: %s is no path nor scheme:// url", ErrUnknownTransport, rawurl)
+	}
+	switch strings.ToLower(m[1]) {
+	case "http":
+		return TransportHTTP, nil
+	case "https":
+		return TransportHTTPS, nil
+	case "ws":
+		return TransportWS, nil
+	case "wss":
+		return TransportWSS, nil
+	case "file":
+		if u, err := url.Parse(rawurl); err != nil || (u.Host != "" && u.Host != "localhost") {
+			return "", fmt.Errorf("%w: %s is no local file", ErrUnknownTransport, rawurl)
+		}
+		return TransportIPC, nil
+	}
+	return "", fmt.Errorf("%w: scheme %s of %s", ErrUnknownTransport, m[1], rawurl)
+}
+
+var (
+	// windowsPath is a named pipe or a path from a drive letter
+	windowsPath = regexp.MustCompile(`^(\\\\\.\\pipe\\|[A-Za-z]:[\\/])`)
+	urlScheme   = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9+.-]*):`)
+)
+
+// ipcFile is the path of the ipc file of rawurl, a path or a file:// url.
+func ipcFile(rawurl string) string {
+	rawurl = strings.TrimSpace(rawurl)
+	if m := urlScheme.FindStringSubmatch(rawurl); m != nil && !windowsPath.MatchString(rawurl) {
+		if u, err := url.Parse(rawurl); err == nil {
+			return u.Path
+		}
+	}
+	return rawurl
+}
+
+// Capabilities are what an rpc-server was found to support.
+type Capabilities struct {
+	// Subscribe is true when eth_subscribe works, the events are polled otherwise
+	Subscribe bool
+}
+
+// Probe detects the capabilities of the rpc-server. http can't carry
+// notifications; over the other transports a newHeads subscription is tried,
+// since an rpc-server, or a proxy in front of it, may not serve eth_subscribe.
+func (c *Client) Probe(ctx context.Context) Capabilities {
+	switch c.Transport {
+	case TransportHTTP, TransportHTTPS:
+		return Capabilities{}
+	}
+	var err error
+	defer c.observe(ctx, "eth_subscribe")(&err)
+	sub, err := c.rpc.EthSubscribe(ctx, make(chan *types.Header), "newHeads")
+	if err != nil {
+		logger(ctx).Warn("No eth_subscribe, poll", "endpoint", c.endpoint, "err", err)
+		return Capabilities{}
+	}
+	sub.Unsubscribe()
+	return Capabilities{Subscribe: true}
+}
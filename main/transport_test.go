@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestParseTransport(t *testing.T) {
+	for rawurl, want := range map[string]Transport{
+		"http://localhost:8545":        TransportHTTP,
+		"https://mainnet.example/v3/k": TransportHTTPS,
+		"ws://localhost:8546":          TransportWS,
+		" wss://mainnet.example/ws":    TransportWSS,
+		"/data/geth/geth.ipc":          TransportIPC,
+		"geth.ipc":                     TransportIPC,
+		`\\.\pipe\geth.ipc`:            TransportIPC,
+		`C:\data\geth.ipc`:             TransportIPC,
+		"c:/data/geth.ipc":             TransportIPC,
+		"file:///data/geth/geth.ipc":   TransportIPC,
+		"HTTP://localhost:8545":        TransportHTTP,
+	} {
+		if got, err := ParseTransport(rawurl); err != nil || got != want {
+			t.Errorf("ParseTransport(%q) = %q, %v, want %q", rawurl, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "  ", "ftp://localhost", "htps://node", "wss:/node", "http:node", "file://node/geth.ipc"} {
+		if got, err := ParseTransport(bad); !errors.Is(err, ErrUnknownTransport) {
+			t.Errorf("ParseTransport(%q) = %q, %v", bad, got, err)
+		}
+	}
+}
+
+// chainIDOnlyRPC is an rpc-server, or a proxy, which serves no eth_subscribe.
+type chainIDOnlyRPC struct{}
+
+func (chainIDOnlyRPC) ChainId(ctx context.Context) (*hexutil.Big, error) {
+	return (*hexutil.Big)(big.NewInt(1337)), nil
+}
+
+func TestProbe(t *testing.T) {
+	env := newTestEnv(t, 1)
+	srv := newSimRPCServer(env, false)
+
+	noSub := rpc.NewServer()
+	if err := noSub.RegisterName("eth", chainIDOnlyRPC{}); err != nil {
+		t.Fatal(err)
+	}
+	noSubSrv := httptest.NewServer(noSub.WebsocketHandler([]string{"*"}))
+	defer noSubSrv.Close()
+
+	ipc := rpc.NewServer()
+	if err := ipc.RegisterName("eth", &simRPC{env.backend, false}); err != nil {
+		t.Fatal(err)
+	}
+	ipcPath := filepath.Join(t.TempDir(), "sim.ipc")
+	l, err := net.Listen("unix", ipcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ipc.ServeListener(l)
+	defer ipc.Stop()
+
+	for _, tc := range []struct {
+		url       string
+		transport Transport
+		subscribe bool
+	}{
+		{srv.URL, TransportHTTP, false},
+		{"ws" + strings.TrimPrefix(srv.URL, "http"), TransportWS, true},
+		{ipcPath, TransportIPC, true},
+		{"file://" + ipcPath, TransportIPC, true},
+		// a websocket is not enough
+		{"ws" + strings.TrimPrefix(noSubSrv.URL, "http"), TransportWS, false},
+	} {
+		ctx := context.Background()
+		c, err := Dial(ctx, tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.ChainID(ctx); err != nil {
+			t.Fatalf("%s: %v", tc.url, err)
+		}
+		if caps := c.Probe(ctx); c.Transport != tc.transport || caps.Subscribe != tc.subscribe {
+			t.Errorf("%s is %s with %+v, want %s subscribing %v", tc.url, c.Transport, caps, tc.transport, tc.subscribe)
+		}
+		c.Close()
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// VerifyBackend is what VerifyDeployment needs from the rpc-server.
+type VerifyBackend interface {
+	ethereum.ChainStateReader
+	ethereum.TransactionReader
+}
+
+// RuntimeBytecode extracts the runtime code from the creation code emitted by solc.
+// The init code ends with
+//
+//	PUSH len DUP1 PUSH offset PUSH1 0 CODECOPY PUSH1 0 RETURN
+//
+// which copies the runtime code into memory and returns it.
+func RuntimeBytecode(creation []byte) ([]byte, error) {
+	for pc := 0; pc < len(creation); pc++ {
+		op := vm.OpCode(creation[pc])
+		if !op.IsPush() {
+			continue
+		}
+		length, next, ok := readPush(creation, pc)
+		if ok && next < len(creation) && vm.OpCode(creation[next]) == vm.DUP1 {
+			offset, rest, ok := readPush(creation, next+1)
+			tail := []byte{byte(vm.PUSH1), 0, byte(vm.CODECOPY), byte(vm.PUSH1), 0, byte(vm.RETURN)}
+			if ok && bytes.HasPrefix(creation[rest:], tail) {
+				end := offset + length
+				if end > len(creation) {
+					return nil, errors.New("runtime code out of range")
+				}
+				return creation[offset:end], nil
+			}
+		}
+		pc += int(op - vm.PUSH1 + 1) // skip the immediate
+	}
+	return nil, errors.New("runtime code not found")
+}
+
+// readPush decodes the PUSH instruction at pc, returning its value and the next pc.
+func readPush(code []byte, pc int) (int, int, bool) {
+	op := vm.OpCode(code[pc])
+	if !op.IsPush() || op > vm.PUSH4 {
+		return 0, 0, false
+	}
+	n := int(op - vm.PUSH1 + 1)
+	if pc+1+n > len(code) {
+		return 0, 0, false
+	}
+	return int(new(big.Int).SetBytes(code[pc+1 : pc+1+n]).Int64()), pc + 1 + n, true
+}
+
+// StripMetadata removes the CBOR encoded metadata solc appends to the runtime code.
+// Its length is stored in the last two bytes.
+func StripMetadata(code []byte) []byte {
+	if len(code) < 2 {
+		return code
+	}
+	n := int(binary.BigEndian.Uint16(code[len(code)-2:]))
+	start := len(code) - 2 - n
+	if n == 0 || start < 0 || code[start]&0xf0 != 0xa0 { // CBOR map
+		return code
+	}
+	return code[:start]
+}
+
+// BytecodeHash returns the hash of the runtime code without its metadata, the same for
+// the code deployed and the code compiled from the same source with other metadata.
+func BytecodeHash(runtime []byte) common.Hash {
+	return crypto.Keccak256Hash(StripMetadata(runtime))
+}
+
+// DecodeDeployArgs decodes the constructor arguments appended to the EIP20 creation code.
+func DecodeDeployArgs(input []byte) (*DeployArgs, error) {
+	creation := common.FromHex(EIP20MetaData.Bin)
+	if len(input) < len(creation) {
+		return nil, errors.New("input is shorter than the EIP20 creation code")
+	}
+	if !bytes.Equal(StripMetadata(input[:len(creation)]), StripMetadata(creation)) {
+		return nil, errors.New("input is not the EIP20 creation code")
+	}
+	abi, err := EIP20MetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	values, err := abi.Constructor.Inputs.Unpack(input[len(creation):])
+	if err != nil {
+		return nil, fmt.Errorf("unpack constructor args: %w", err)
+	}
+	return &DeployArgs{
+		InitialAmount: values[0].(*big.Int),
+		Name:          values[1].(string),
+		Decimals:      values[2].(uint8),
+		Symbol:        values[3].(string),
+	}, nil
+}
+
+// VerifyDeployment checks the code at addr is our EIP20 token, created by the tx txHash,
+// and returns the constructor arguments it was deployed with.
+func VerifyDeployment(ctx context.Context, backend VerifyBackend, addr common.Address, txHash common.Hash) (*DeployArgs, error) {
+	runtime, err := RuntimeBytecode(common.FromHex(EIP20MetaData.Bin))
+	if err != nil {
+		return nil, err
+	}
+	code, err := backend.CodeAt(ctx, addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(code) == 0 {
+		return nil, fmt.Errorf("no code at %s", addr)
+	}
+	if !bytes.Equal(StripMetadata(code), StripMetadata(runtime)) {
+		return nil, fmt.Errorf("code at %s does not match EIP20", addr)
+	}
+
+	receipt, err := backend.TransactionReceipt(ctx, txHash)
+	if err != nil {
+		return nil, fmt.Errorf("get receipt of %s: %w", txHash, err)
+	}
+	if receipt.ContractAddress != addr {
+		return nil, fmt.Errorf("tx %s created %s, not %s", txHash, receipt.ContractAddress, addr)
+	}
+	tx, _, err := backend.TransactionByHash(ctx, txHash)
+	if err != nil {
+		return nil, fmt.Errorf("get tx %s: %w", txHash, err)
+	}
+	return DecodeDeployArgs(tx.Data())
+}
+
+// Mismatches lists the fields of args that differ from want.
+// The empty names and nil amount of want are not compared.
+func (args *DeployArgs) Mismatches(want *DeployArgs) []string {
+	var diffs []string
+	if want.InitialAmount != nil && args.InitialAmount.Cmp(want.InitialAmount) != 0 {
+		diffs = append(diffs, fmt.Sprintf("initialAmount %s != %s", args.InitialAmount, want.InitialAmount))
+	}
+	if want.Name != "" && args.Name != want.Name {
+		diffs = append(diffs, fmt.Sprintf("name %q != %q", args.Name, want.Name))
+	}
+	if args.Decimals != want.Decimals {
+		diffs = append(diffs, fmt.Sprintf("decimals %d != %d", args.Decimals, want.Decimals))
+	}
+	if want.Symbol != "" && args.Symbol != want.Symbol {
+		diffs = append(diffs, fmt.Sprintf("symbol %q != %q", args.Symbol, want.Symbol))
+	}
+	return diffs
+}
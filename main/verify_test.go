@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestRuntimeBytecode(t *testing.T) {
+	env := newTestEnv(t, 1)
+	addr, _, _ := env.deploy(1000)
+
+	runtime, err := RuntimeBytecode(common.FromHex(EIP20MetaData.Bin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := env.backend.CodeAt(context.Background(), addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(code, runtime) {
+		t.Fatal("deployed code differs from the runtime code")
+	}
+	if len(StripMetadata(code)) >= len(code) {
+		t.Fatal("metadata is not stripped")
+	}
+	if BytecodeHash(code) != BytecodeHash(runtime) || BytecodeHash(code) == crypto.Keccak256Hash(code) {
+		t.Fatal("bytecode hash is not the hash of the runtime code without metadata")
+	}
+}
+
+func TestVerifyDeployment(t *testing.T) {
+	env := newTestEnv(t, 1)
+	addr, tx, _ := env.deploy(1000)
+
+	args, err := VerifyDeployment(context.Background(), env.backend, addr, tx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &DeployArgs{big.NewInt(1000), "dtoken", 8, "dt"}
+	if diffs := args.Mismatches(want); len(diffs) > 0 {
+		t.Fatalf("mismatches: %v", diffs)
+	}
+	if diffs := args.Mismatches(&DeployArgs{big.NewInt(1), "other", 18, "dt"}); len(diffs) != 3 {
+		t.Fatalf("got mismatches %v, want 3", diffs)
+	}
+
+	// an account without code
+	if _, err := VerifyDeployment(context.Background(), env.backend, env.accounts[0].addr, tx.Hash()); err == nil {
+		t.Fatal("verified an account without code")
+	}
+	// the deploy tx of another contract
+	other, _, _ := env.deploy(1000)
+	if _, err := VerifyDeployment(context.Background(), env.backend, other, tx.Hash()); err == nil {
+		t.Fatal("verified with the deploy tx of another contract")
+	}
+}
+
+func TestStripMetadata(t *testing.T) {
+	code := common.FromHex("0x6080fe")
+	if !bytes.Equal(StripMetadata(code), code) {
+		t.Fatal("stripped code without metadata")
+	}
+	meta := common.FromHex("0xa164736f6c6343000812000a") // {"solc": 0.8.18}
+	if !bytes.Equal(StripMetadata(append(append([]byte{}, code...), meta...)), code) {
+		t.Fatal("metadata is not stripped")
+	}
+}
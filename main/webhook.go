@@ -0,0 +1,561 @@
+package main
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	WebhookFile    = "webhooks.json"
+	DeadLetterFile = "webhooks-dead.jsonl"
+	// PendingDeliveryFile keeps the pending deliveries over a restart
+	PendingDeliveryFile = "webhooks-pending.json"
+)
+
+// headers of a webhook request
+const (
+	WebhookDeliveryHeader  = "X-Dapp-Delivery"
+	WebhookTimestampHeader = "X-Dapp-Timestamp"
+	WebhookSignatureHeader = "X-Dapp-Signature"
+)
+
+// WebhookSubscriber is a consumer of the token events.
+type WebhookSubscriber struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Secret is the HMAC-SHA256 key signing the payloads
+	Secret string `json:"secret"`
+	// Contracts are the tokens to deliver the events of, all by default
+	Contracts []common.Address `json:"contracts,omitempty"`
+	// Addresses only deliver the events involving one of them, all by default
+	Addresses []common.Address `json:"addresses,omitempty"`
+	// Events are the event types to deliver, all by default
+	Events []string `json:"events,omitempty"`
+}
+
+// Wants tells if the subscriber should get e.
+func (s *WebhookSubscriber) Wants(e *TokenEvent) bool {
+	if len(s.Contracts) > 0 && !containsAddress(s.Contracts, e.Contract) {
+		return false
+	}
+	if len(s.Events) > 0 {
+		ok := false
+		for _, typ := range s.Events {
+			ok = ok || typ == e.Type
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(s.Addresses) == 0 {
+		return true
+	}
+	for _, addr := range s.Addresses {
+		if e.Involves(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAddress(addrs []common.Address, addr common.Address) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadWebhooks reads the subscribers file. A missing file gives none.
+func LoadWebhooks(path string) ([]*WebhookSubscriber, error) {
+	bs, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var subs []*WebhookSubscriber
+	if err := json.Unmarshal(bs, &subs); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i, s := range subs {
+		if s.ID == "" || s.URL == "" || s.Secret == "" {
+			return nil, fmt.Errorf("webhook %d: id, url and secret are required", i)
+		}
+	}
+	return subs, nil
+}
+
+// SignPayload is the hex HMAC-SHA256 of "timestamp.body" under secret,
+// sent as "sha256=<hex>" in the X-Dapp-Signature header.
+// Subscribers check it and the timestamp to reject forged or replayed requests.
+func SignPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// Delivery is the delivery of one event to one subscriber.
+type Delivery struct {
+	ID         string      `json:"id"`
+	Subscriber string      `json:"subscriber"`
+	Event      *TokenEvent `json:"event"`
+	Status     string      `json:"status"`
+	Attempts   int         `json:"attempts"`
+	LastCode   int         `json:"lastCode,omitempty"`
+	LastError  string      `json:"lastError,omitempty"`
+	UpdatedAt  time.Time   `json:"updatedAt"`
+
+	// due is when the next attempt is made
+	due time.Time
+}
+
+// deliveryHeap orders the waiting deliveries by when they are due.
+type deliveryHeap []*Delivery
+
+func (h deliveryHeap) Len() int           { return len(h) }
+func (h deliveryHeap) Less(i, j int) bool { return h[i].due.Before(h[j].due) }
+func (h deliveryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *deliveryHeap) Push(x interface{}) { *h = append(*h, x.(*Delivery)) }
+
+func (h *deliveryHeap) Pop() interface{} {
+	old := *h
+	dl := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return dl
+}
+
+// deliveryID is the same for every delivery of an event to a subscriber,
+// for the subscriber to drop duplicates, and differs from chain to chain.
+func deliveryID(sub string, e *TokenEvent) string {
+	id := fmt.Sprintf("%s-%d-%s-%d", sub, e.ChainID, e.TxHash.Hex(), e.LogIndex)
+	if e.Removed {
+		id += "-removed"
+	}
+	return id
+}
+
+// Dispatcher POSTs the token events as JSON to the webhook subscribers.
+// Failed deliveries are retried with exponential backoff, and are appended to
+// the dead-letter file once MaxAttempts is reached or the subscriber rejects them.
+// Dispatching never waits for the subscribers: the deliveries wait in memory,
+// up to MaxPending, and are saved to the Pending file on shutdown.
+type Dispatcher struct {
+	Subscribers []*WebhookSubscriber
+	Client      *http.Client
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubled up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Workers    int
+	// MaxPending is the most deliveries waiting, the others go to the dead letters
+	MaxPending int
+	DeadLetter string
+	// Pending is the file the pending deliveries are resumed from, without
+	// one they are appended to the dead letters on shutdown
+	Pending string
+	// Credentials may list the deliveries, nobody without them
+	Credentials []Credential
+
+	ready chan *Delivery
+	wake  chan struct{}
+
+	mu         sync.Mutex
+	deliveries map[string]*Delivery
+	waiting    deliveryHeap
+	// done keeps the finished deliveries in order, to forget the oldest
+	done []string
+}
+
+// keptDeliveries is how many finished deliveries Deliveries reports.
+const keptDeliveries = 10000
+
+// NewDispatcher makes a dispatcher with the default retry policy.
+func NewDispatcher(subs []*WebhookSubscriber, deadLetter string) *Dispatcher {
+	return &Dispatcher{
+		Subscribers: subs,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 8,
+		Backoff:     time.Second,
+		MaxBackoff:  5 * time.Minute,
+		Workers:     4,
+		MaxPending:  100000,
+		DeadLetter:  deadLetter,
+	}
+}
+
+// Run delivers the events, like those of SubscribeTokenEvents, until ctx is done.
+// It resumes the deliveries of the Pending file, and saves those left pending on return.
+func (d *Dispatcher) Run(ctx context.Context, events <-chan *TokenEvent) error {
+	d.ready = make(chan *Delivery)
+	d.wake = make(chan struct{}, 1)
+	d.mu.Lock()
+	d.deliveries = make(map[string]*Delivery)
+	d.waiting = nil
+	d.mu.Unlock()
+	if err := d.resume(); err != nil {
+		log.Error("Resume webhook deliveries failed", "file", d.Pending, "err", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < d.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case dl := <-d.ready:
+					d.deliver(ctx, dl)
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.schedule(ctx)
+	}()
+	defer func() {
+		wg.Wait()
+		d.save()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e := <-events:
+			d.dispatch(e)
+		}
+	}
+}
+
+// dispatch adds the deliveries of e to the subscribers which want it,
+// or dead-letters them when MaxPending deliveries are waiting.
+func (d *Dispatcher) dispatch(e *TokenEvent) {
+	for _, s := range d.Subscribers {
+		if !s.Wants(e) {
+			continue
+		}
+		dl := &Delivery{
+			ID:         deliveryID(s.ID, e),
+			Subscriber: s.ID,
+			Event:      e,
+			Status:     DeliveryPending,
+			UpdatedAt:  time.Now(),
+			due:        time.Now(),
+		}
+		d.mu.Lock()
+		if old := d.deliveries[dl.ID]; old != nil && old.Status == DeliveryPending {
+			// already pending, resumed from the last run
+			d.mu.Unlock()
+			continue
+		}
+		d.deliveries[dl.ID] = dl
+		full := len(d.waiting) >= d.MaxPending
+		if !full {
+			heap.Push(&d.waiting, dl)
+		}
+		d.mu.Unlock()
+		if full {
+			d.finish(dl, DeliveryDead, 0, errors.New("too many pending deliveries"))
+			webhookDeliveries.WithLabelValues(DeliveryDead).Inc()
+			log.Error("Webhook backlog full, move to the dead letters", "webhook", s.ID, "delivery", dl.ID, "pending", d.MaxPending)
+			d.deadLetter(dl)
+			continue
+		}
+		d.notify()
+	}
+}
+
+// notify wakes the scheduler up, for a delivery which may be due sooner.
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// schedule hands the waiting deliveries over to the workers once they are due.
+func (d *Dispatcher) schedule(ctx context.Context) {
+	for {
+		var dl *Delivery
+		wait := time.Minute
+		d.mu.Lock()
+		if len(d.waiting) > 0 {
+			if wait = time.Until(d.waiting[0].due); wait <= 0 {
+				dl = heap.Pop(&d.waiting).(*Delivery)
+			}
+		}
+		d.mu.Unlock()
+		if dl != nil {
+			select {
+			case d.ready <- dl:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-d.wake:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// resume adds the deliveries saved pending by the last run.
+func (d *Dispatcher) resume() error {
+	if d.Pending == "" {
+		return nil
+	}
+	bs, err := ioutil.ReadFile(d.Pending)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []*Delivery
+	if err := json.Unmarshal(bs, &list); err != nil {
+		return fmt.Errorf("parse %s: %w", d.Pending, err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, dl := range list {
+		if d.subscriber(dl.Subscriber) == nil {
+			log.Warn("Drop the webhook delivery of a removed subscriber", "webhook", dl.Subscriber, "delivery", dl.ID)
+			continue
+		}
+		dl.due = time.Now()
+		d.deliveries[dl.ID] = dl
+		heap.Push(&d.waiting, dl)
+	}
+	if len(list) > 0 {
+		log.Info("Resume webhook deliveries", "file", d.Pending, "pending", len(d.waiting))
+	}
+	return nil
+}
+
+// save writes the pending deliveries to the Pending file, or else appends
+// them to the dead letters.
+func (d *Dispatcher) save() {
+	list := d.Deliveries(DeliveryPending)
+	if d.Pending == "" {
+		for i := range list {
+			dl := &list[i]
+			dl.Status, dl.LastError = DeliveryDead, "not delivered before shutdown"
+			d.deadLetter(dl)
+		}
+		return
+	}
+	bs, err := json.MarshalIndent(list, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(d.Pending, bs)
+	}
+	if err != nil {
+		log.Error("Save pending webhook deliveries failed", "file", d.Pending, "pending", len(list), "err", err)
+		return
+	}
+	if len(list) > 0 {
+		log.Info("Saved pending webhook deliveries", "file", d.Pending, "pending", len(list))
+	}
+}
+
+func (d *Dispatcher) subscriber(id string) *WebhookSubscriber {
+	for _, s := range d.Subscribers {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// deliver makes one attempt to post dl. A failed attempt which may succeed
+// later waits again for the backoff, so the workers don't wait for it.
+func (d *Dispatcher) deliver(ctx context.Context, dl *Delivery) {
+	s := d.subscriber(dl.Subscriber)
+	body, err := json.Marshal(dl.Event)
+	if err != nil {
+		d.finish(dl, DeliveryDead, 0, err)
+		return
+	}
+	l := log.New("webhook", s.ID, "delivery", dl.ID)
+	code, err := d.post(ctx, s, dl.ID, body)
+	d.mu.Lock()
+	dl.Attempts++
+	attempts := dl.Attempts
+	d.mu.Unlock()
+	if err == nil {
+		d.finish(dl, DeliveryDelivered, code, nil)
+		webhookDeliveries.WithLabelValues(DeliveryDelivered).Inc()
+		l.Debug("Webhook delivered", "attempts", attempts)
+		return
+	}
+	if ctx.Err() != nil {
+		// left pending, it's not a failure of the subscriber
+		return
+	}
+	if !retryable(code) || attempts >= d.MaxAttempts {
+		d.finish(dl, DeliveryDead, code, err)
+		webhookDeliveries.WithLabelValues(DeliveryDead).Inc()
+		l.Error("Webhook delivery failed, move to the dead letters", "attempts", attempts, "code", code, "err", err)
+		d.deadLetter(dl)
+		return
+	}
+
+	d.update(dl, code, err)
+	webhookDeliveries.WithLabelValues("retry").Inc()
+	backoff := d.backoff(attempts)
+	l.Warn("Webhook delivery failed, will retry", "attempts", attempts, "code", code, "err", err, "backoff", backoff)
+	d.mu.Lock()
+	dl.due = time.Now().Add(backoff)
+	heap.Push(&d.waiting, dl)
+	d.mu.Unlock()
+	d.notify()
+}
+
+// backoff is the wait after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	b := d.Backoff
+	for i := 1; i < attempts && b < d.MaxBackoff; i++ {
+		b *= 2
+	}
+	if b > d.MaxBackoff {
+		b = d.MaxBackoff
+	}
+	return b
+}
+
+// post sends one attempt. A status other than 2xx is an error.
+func (d *Dispatcher) post(ctx context.Context, s *WebhookSubscriber, id string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookDeliveryHeader, id)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(WebhookSignatureHeader, "sha256="+SignPayload(s.Secret, ts, body))
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, fmt.Errorf("status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryable tells if an attempt ending with status code may succeed later.
+// 0 is a network error.
+func retryable(code int) bool {
+	return code == 0 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+func (d *Dispatcher) update(dl *Delivery, code int, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dl.LastCode, dl.UpdatedAt = code, time.Now()
+	dl.LastError = ""
+	if err != nil {
+		dl.LastError = err.Error()
+	}
+}
+
+func (d *Dispatcher) finish(dl *Delivery, status string, code int, err error) {
+	d.update(dl, code, err)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dl.Status = status
+	d.done = append(d.done, dl.ID)
+	if len(d.done) > keptDeliveries {
+		delete(d.deliveries, d.done[0])
+		d.done = d.done[1:]
+	}
+}
+
+// deadLetter appends dl as a JSON line to the dead-letter file.
+func (d *Dispatcher) deadLetter(dl *Delivery) {
+	if d.DeadLetter == "" {
+		return
+	}
+	d.mu.Lock()
+	line, err := json.Marshal(dl)
+	d.mu.Unlock()
+	if err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(d.DeadLetter, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err == nil {
+			_, err = f.Write(append(line, '\n'))
+			f.Close()
+		}
+	}
+	if err != nil {
+		log.Error("Write dead letter failed", "delivery", dl.ID, "err", err)
+	}
+}
+
+// Deliveries returns the pending and the latest finished deliveries,
+// of one status or all if status is empty.
+func (d *Dispatcher) Deliveries(status string) []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var list []Delivery
+	for _, dl := range d.deliveries {
+		if status == "" || dl.Status == status {
+			list = append(list, *dl)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UpdatedAt.Before(list[j].UpdatedAt) })
+	return list
+}
+
+// ServeHTTP lists the deliveries as JSON to a credential, filtered by the
+// status query parameter.
+func (d *Dispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authenticate(d.Credentials, bearerToken(r)); !ok {
+		writeResult(w, http.StatusUnauthorized, apiError{"an admin token is required"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.Deliveries(r.URL.Query().Get("status")))
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// webhookServer is a stand-in subscriber answering each request with the
+// next status of codes, then 200.
+type webhookServer struct {
+	*httptest.Server
+	t      *testing.T
+	secret string
+
+	mu       sync.Mutex
+	codes    []int
+	received []*TokenEvent
+	ids      []string
+}
+
+func newWebhookServer(t *testing.T, secret string, codes ...int) *webhookServer {
+	s := &webhookServer{t: t, secret: secret, codes: codes}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *webhookServer) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	ts, _ := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+	if r.Header.Get(WebhookSignatureHeader) != "sha256="+SignPayload(s.secret, ts, body) {
+		s.t.Errorf("bad signature %q", r.Header.Get(WebhookSignatureHeader))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code := http.StatusOK
+	if len(s.codes) > 0 {
+		code, s.codes = s.codes[0], s.codes[1:]
+	}
+	if code == http.StatusOK {
+		var e TokenEvent
+		if err := json.Unmarshal(body, &e); err != nil {
+			s.t.Errorf("bad payload: %v", err)
+		}
+		s.received = append(s.received, &e)
+		s.ids = append(s.ids, r.Header.Get(WebhookDeliveryHeader))
+	}
+	w.WriteHeader(code)
+}
+
+func (s *webhookServer) events() []*TokenEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*TokenEvent(nil), s.received...)
+}
+
+func testTransferEvent(from, to common.Address, logIndex uint) *TokenEvent {
+	return &TokenEvent{
+		Type:     EventTransfer,
+		ChainID:  1337,
+		Contract: common.Address{0xee},
+		TxHash:   common.Hash{0x01},
+		LogIndex: logIndex,
+		From:     &from,
+		To:       &to,
+		Value:    "100",
+	}
+}
+
+// runDispatcher runs d until the test ends and returns the channel feeding it.
+func runDispatcher(t *testing.T, d *Dispatcher) chan<- *TokenEvent {
+	events := make(chan *TokenEvent)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := goAsync(func() error { return d.Run(ctx, events) })
+	t.Cleanup(func() {
+		cancel()
+		waitResult(t, done, 5*time.Second)
+	})
+	return events
+}
+
+func testDispatcher(subs []*WebhookSubscriber, deadLetter string) *Dispatcher {
+	d := NewDispatcher(subs, deadLetter)
+	d.Backoff, d.MaxBackoff = 10*time.Millisecond, 40*time.Millisecond
+	d.MaxAttempts = 3
+	return d
+}
+
+// waitDeliveries waits until n deliveries have status.
+func waitDeliveries(t *testing.T, d *Dispatcher, status string, n int) []Delivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		list := d.Deliveries(status)
+		if len(list) >= n {
+			return list
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d %s deliveries, want %d", len(list), status, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	alice, bob := common.Address{0xa1}, common.Address{0xb0}
+	all := newWebhookServer(t, "s1")
+	onlyBob := newWebhookServer(t, "s2")
+	d := testDispatcher([]*WebhookSubscriber{
+		{ID: "all", URL: all.URL, Secret: "s1"},
+		{ID: "bob", URL: onlyBob.URL, Secret: "s2", Addresses: []common.Address{bob}},
+	}, "")
+	events := runDispatcher(t, d)
+
+	events <- testTransferEvent(alice, common.Address{0xc0}, 0)
+	events <- testTransferEvent(alice, bob, 1)
+	waitDeliveries(t, d, DeliveryDelivered, 3)
+
+	if got := len(all.events()); got != 2 {
+		t.Fatalf("subscriber of all got %d events, want 2", got)
+	}
+	got := onlyBob.events()
+	if len(got) != 1 || *got[0].To != bob || got[0].LogIndex != 1 || got[0].Value != "100" {
+		t.Fatalf("subscriber of bob got %+v", got)
+	}
+	if onlyBob.ids[0] != deliveryID("bob", got[0]) {
+		t.Fatalf("delivery id %q", onlyBob.ids[0])
+	}
+	// the same tx hash and log index on another chain is another event
+	other := *got[0]
+	other.ChainID++
+	if deliveryID("bob", &other) == onlyBob.ids[0] {
+		t.Fatal("same delivery id on another chain")
+	}
+}
+
+func TestWebhookDeliveriesAuth(t *testing.T) {
+	d := testDispatcher([]*WebhookSubscriber{{ID: "all", URL: newWebhookServer(t, "s1").URL, Secret: "s1"}}, "")
+	d.Credentials = []Credential{{Name: "ops", TokenHash: tokenHash("ops-token")}}
+	events := runDispatcher(t, d)
+	events <- testTransferEvent(common.Address{0xa1}, common.Address{0xb0}, 0)
+	waitDeliveries(t, d, DeliveryDelivered, 1)
+
+	for token, want := range map[string]int{"": http.StatusUnauthorized, "other": http.StatusUnauthorized, "ops-token": http.StatusOK} {
+		req := httptest.NewRequest("GET", "/webhooks/deliveries", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		d.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Fatalf("token %q: %d, want %d", token, rec.Code, want)
+		}
+		var list []Delivery
+		if want == http.StatusOK && (json.Unmarshal(rec.Body.Bytes(), &list) != nil || len(list) != 1) {
+			t.Fatalf("deliveries %s", rec.Body)
+		}
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	srv := newWebhookServer(t, "s", http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	d := testDispatcher([]*WebhookSubscriber{{ID: "a", URL: srv.URL, Secret: "s"}}, "")
+	events := runDispatcher(t, d)
+
+	events <- testTransferEvent(common.Address{1}, common.Address{2}, 0)
+	list := waitDeliveries(t, d, DeliveryDelivered, 1)
+	if list[0].Attempts != 3 {
+		t.Fatalf("delivered after %d attempts, want 3", list[0].Attempts)
+	}
+	if len(srv.events()) != 1 {
+		t.Fatal("event not received")
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	deadLetter := filepath.Join(t.TempDir(), DeadLetterFile)
+	down := newWebhookServer(t, "s", 500, 500, 500, 500)
+	rejecting := newWebhookServer(t, "s", http.StatusBadRequest)
+	d := testDispatcher([]*WebhookSubscriber{
+		{ID: "down", URL: down.URL, Secret: "s"},
+		{ID: "rejecting", URL: rejecting.URL, Secret: "s"},
+	}, deadLetter)
+	events := runDispatcher(t, d)
+
+	events <- testTransferEvent(common.Address{1}, common.Address{2}, 0)
+	list := waitDeliveries(t, d, DeliveryDead, 2)
+	attempts := map[string]int{}
+	for _, dl := range list {
+		attempts[dl.Subscriber] = dl.Attempts
+	}
+	// a rejected payload isn't retried
+	if attempts["down"] != 3 || attempts["rejecting"] != 1 {
+		t.Fatalf("attempts = %v", attempts)
+	}
+
+	f, err := os.Open(deadLetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	for sc := bufio.NewScanner(f); sc.Scan(); lines++ {
+		var dl Delivery
+		if err := json.Unmarshal(sc.Bytes(), &dl); err != nil || dl.Status != DeliveryDead || dl.Event == nil {
+			t.Fatalf("bad dead letter %s: %v", sc.Text(), err)
+		}
+	}
+	if lines != 2 {
+		t.Fatalf("got %d dead letters, want 2", lines)
+	}
+}
+
+func TestWebhookPendingOverRestart(t *testing.T) {
+	pending := filepath.Join(t.TempDir(), PendingDeliveryFile)
+	down := newWebhookServer(t, "s", 500, 500, 500, 500, 500, 500)
+	d := testDispatcher([]*WebhookSubscriber{{ID: "a", URL: down.URL, Secret: "s"}}, "")
+	d.Pending, d.MaxAttempts, d.Backoff = pending, 100, time.Hour
+	events := make(chan *TokenEvent)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := goAsync(func() error { return d.Run(ctx, events) })
+	e := testTransferEvent(common.Address{1}, common.Address{2}, 0)
+	events <- e
+	deadline := time.Now().Add(5 * time.Second)
+	for list := d.Deliveries(DeliveryPending); len(list) == 0 || list[0].Attempts == 0; list = d.Deliveries(DeliveryPending) {
+		if time.Now().After(deadline) {
+			t.Fatal("no attempt")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	waitResult(t, done, 5*time.Second)
+
+	// the next run delivers it, with the same id
+	up := newWebhookServer(t, "s")
+	d = testDispatcher([]*WebhookSubscriber{{ID: "a", URL: up.URL, Secret: "s"}}, "")
+	d.Pending = pending
+	runDispatcher(t, d)
+	list := waitDeliveries(t, d, DeliveryDelivered, 1)
+	if list[0].Attempts != 2 || len(up.events()) != 1 || up.ids[0] != deliveryID("a", e) {
+		t.Fatalf("resumed %+v, received %v", list[0], up.ids)
+	}
+}
+
+func TestWebhookBacklog(t *testing.T) {
+	srv := newWebhookServer(t, "s")
+	d := testDispatcher([]*WebhookSubscriber{{ID: "a", URL: srv.URL, Secret: "s"}}, "")
+	// no worker takes the deliveries, they pile up
+	d.Workers, d.MaxPending = 0, 2
+	events := runDispatcher(t, d)
+	for i := 0; i < 10; i++ {
+		select {
+		case events <- testTransferEvent(common.Address{1}, common.Address{2}, uint(i)):
+		case <-time.After(5 * time.Second):
+			t.Fatal("dispatching waits for the subscribers")
+		}
+	}
+	// two wait, one is handed over to no worker
+	waitDeliveries(t, d, DeliveryDead, 7)
+	if n := len(d.Deliveries(DeliveryPending)); n < 2 || n > 3 {
+		t.Fatalf("%d pending deliveries", n)
+	}
+}
+
+func TestWebhookSubscriberWants(t *testing.T) {
+	token, alice := common.Address{0xee}, common.Address{0xa1}
+	e := testTransferEvent(alice, common.Address{0xb0}, 0)
+	for _, tc := range []struct {
+		sub  WebhookSubscriber
+		want bool
+	}{
+		{WebhookSubscriber{}, true},
+		{WebhookSubscriber{Contracts: []common.Address{token}}, true},
+		{WebhookSubscriber{Contracts: []common.Address{{0xef}}}, false},
+		{WebhookSubscriber{Addresses: []common.Address{alice}}, true},
+		{WebhookSubscriber{Addresses: []common.Address{{0xc0}}}, false},
+		{WebhookSubscriber{Events: []string{EventApproval}}, false},
+		{WebhookSubscriber{Events: []string{EventTransfer}, Addresses: []common.Address{alice}}, true},
+	} {
+		if got := tc.sub.Wants(e); got != tc.want {
+			t.Errorf("%+v wants = %v, want %v", tc.sub, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	WithdrawalFile       = "withdrawals.json"
+	WithdrawalPolicyFile = "withdrawal-policy.json"
+	WithdrawalAuditFile  = "withdrawals-audit.jsonl"
+
+	// withdrawalLimitWindow is the period of the daily limits
+	withdrawalLimitWindow = 24 * time.Hour
+)
+
+// states of a Withdrawal
+const (
+	WithdrawalPendingApproval = "pending_approval"
+	WithdrawalApproved        = "approved"
+	// WithdrawalSigned has a nonce and a signed tx, which is sent again until the rpc-server takes it
+	WithdrawalSigned    = "signed"
+	WithdrawalSent      = "sent"
+	WithdrawalConfirmed = "confirmed"
+	// WithdrawalFailed is a reverted transfer
+	WithdrawalFailed   = "failed"
+	WithdrawalRejected = "rejected"
+)
+
+var (
+	ErrWithdrawalNotFound  = errors.New("withdrawal not found")
+	ErrIdempotencyConflict = errors.New("idempotency key already used by another withdrawal")
+	ErrWithdrawalState     = errors.New("withdrawal not in this state")
+	ErrInvalidWithdrawal   = errors.New("invalid withdrawal")
+)
+
+// Credential authenticates a caller of the withdrawal API by the SHA-256 of its bearer token.
+type Credential struct {
+	Name string `json:"name"`
+	// TokenHash is the hex SHA-256 of the token
+	TokenHash string `json:"tokenHash"`
+	// Accounts are the names of the accounts of the config the caller may sign with, where it picks one
+	Accounts []string `json:"accounts,omitempty"`
+}
+
+// WithdrawalPolicy limits the withdrawals of each token, in its base units.
+type WithdrawalPolicy struct {
+	// PerAddressDaily limits the withdrawals to an address over 24 hours
+	PerAddressDaily *big.Int `json:"perAddressDaily,omitempty"`
+	// GlobalDaily limits all the withdrawals over 24 hours
+	GlobalDaily *big.Int `json:"globalDaily,omitempty"`
+	// ApprovalThreshold is the least amount needing ApprovalsRequired approvals
+	ApprovalThreshold *big.Int     `json:"approvalThreshold,omitempty"`
+	ApprovalsRequired int          `json:"approvalsRequired"`
+	Approvers         []Credential `json:"approvers"`
+	// Submitters may request withdrawals, at least one is required
+	Submitters []Credential `json:"submitters"`
+}
+
+// LoadWithdrawalPolicy reads the policy file. A missing file gives nil, no withdrawals.
+func LoadWithdrawalPolicy(path string) (*WithdrawalPolicy, error) {
+	bs, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p WithdrawalPolicy
+	if err := json.Unmarshal(bs, &p); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if len(p.Submitters) == 0 {
+		return nil, fmt.Errorf("%s: no submitters, anyone could request withdrawals", path)
+	}
+	if p.ApprovalThreshold != nil && (p.ApprovalsRequired < 1 || p.ApprovalsRequired > len(p.Approvers)) {
+		return nil, fmt.Errorf("%s: %d approvals required of %d approvers", path, p.ApprovalsRequired, len(p.Approvers))
+	}
+	return &p, nil
+}
+
+// authenticate returns the name of the credential of token.
+func authenticate(creds []Credential, token string) (string, bool) {
+	c, ok := credentialOf(creds, token)
+	if !ok {
+		return "", false
+	}
+	return c.Name, true
+}
+
+// credentialOf returns the credential of token, none of "".
+func credentialOf(creds []Credential, token string) (*Credential, bool) {
+	if token == "" {
+		return nil, false
+	}
+	sum := sha256.Sum256([]byte(token))
+	hash := hex.EncodeToString(sum[:])
+	for i, c := range creds {
+		if subtle.ConstantTimeCompare([]byte(strings.ToLower(c.TokenHash)), []byte(hash)) == 1 {
+			return &creds[i], true
+		}
+	}
+	return nil, false
+}
+
+// MaySign is true for the accounts the credential may sign with: those of
+// Accounts, or only the DefaultAccount without them.
+func (c *Credential) MaySign(account string) bool {
+	if len(c.Accounts) == 0 {
+		return account == DefaultAccount
+	}
+	for _, a := range c.Accounts {
+		if a == account {
+			return true
+		}
+	}
+	return false
+}
+
+// WithdrawalRequest asks for a withdrawal. The same IdempotencyKey gives the same withdrawal.
+type WithdrawalRequest struct {
+	IdempotencyKey string         `json:"idempotencyKey"`
+	Token          common.Address `json:"token"`
+	To             common.Address `json:"to"`
+	Amount         *big.Int       `json:"amount"`
+}
+
+// Withdrawal is a transfer of tokens out of the hot wallet, as it goes through the queue.
+type Withdrawal struct {
+	ID             string         `json:"id"`
+	IdempotencyKey string         `json:"idempotencyKey"`
+	Token          common.Address `json:"token"`
+	To             common.Address `json:"to"`
+	Amount         *big.Int       `json:"amount"`
+	State          string         `json:"state"`
+	Approvals      []string       `json:"approvals,omitempty"`
+	// Reason tells why it's rejected or failed
+	Reason  string        `json:"reason,omitempty"`
+	Nonce   *uint64       `json:"nonce,omitempty"`
+	TxHash  *common.Hash  `json:"txHash,omitempty"`
+	RawTx   hexutil.Bytes `json:"rawTx,omitempty"`
+	Created time.Time     `json:"created"`
+	Updated time.Time     `json:"updated"`
+}
+
+// counts tells if the withdrawal counts against the limits.
+func (w *Withdrawal) counts() bool {
+	return w.State != WithdrawalRejected && w.State != WithdrawalFailed
+}
+
+// WithdrawalBackend is what the WithdrawalQueue needs from the rpc-server.
+type WithdrawalBackend interface {
+	bind.ContractBackend
+	SentTxBackend
+	BlockNumber(ctx context.Context) (uint64, error)
+}
+
+// WithdrawalQueue takes the withdrawal requests, checks them against the
+// Policy and gets them approved, then sends them one at a time with nonces of
+// Nonces. Each transition is written to the Audit log, and the withdrawals
+// are saved on every change.
+type WithdrawalQueue struct {
+	Backend WithdrawalBackend
+	Policy  *WithdrawalPolicy
+	// Transactor signs the transfers, with the account of Nonces
+	Transactor   func(ctx context.Context) (*bind.TransactOpts, error)
+	Nonces       *NonceManager
+	Audit        *AuditLog
+	PollInterval time.Duration
+	// Confirms are the blocks on top of a transfer before it's confirmed
+	Confirms uint64
+	// DropTimeout is how long a tx unknown to the rpc-server is waited for
+	// before it's sent again, 10 minutes by default
+	DropTimeout time.Duration
+
+	path        string
+	mu          sync.Mutex
+	withdrawals map[string]*Withdrawal
+	byKey       map[string]*Withdrawal
+}
+
+// NewWithdrawalQueue reads the withdrawals of the file. A missing file gives none.
+func NewWithdrawalQueue(path string) (*WithdrawalQueue, error) {
+	q := &WithdrawalQueue{
+		path:        path,
+		withdrawals: make(map[string]*Withdrawal),
+		byKey:       make(map[string]*Withdrawal),
+	}
+	bs, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &q.withdrawals); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, w := range q.withdrawals {
+		q.byKey[w.IdempotencyKey] = w
+	}
+	return q, nil
+}
+
+// Submit queues req, rejected if it's over a daily limit. A request with the
+// IdempotencyKey of a queued withdrawal gives that withdrawal, and false.
+func (q *WithdrawalQueue) Submit(req *WithdrawalRequest, actor string) (*Withdrawal, bool, error) {
+	if req.IdempotencyKey == "" {
+		return nil, false, fmt.Errorf("%w: the idempotency key is required", ErrInvalidWithdrawal)
+	}
+	if req.Amount == nil || req.Amount.Sign() <= 0 {
+		return nil, false, fmt.Errorf("%w: the amount must be positive", ErrInvalidWithdrawal)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if w, ok := q.byKey[req.IdempotencyKey]; ok {
+		if w.Token != req.Token || w.To != req.To || w.Amount.Cmp(req.Amount) != 0 {
+			return nil, false, ErrIdempotencyConflict
+		}
+		return w.copy(), false, nil
+	}
+
+	now := time.Now().UTC()
+	w := &Withdrawal{
+		ID:             newRequestID(),
+		IdempotencyKey: req.IdempotencyKey,
+		Token:          req.Token,
+		To:             req.To,
+		Amount:         new(big.Int).Set(req.Amount),
+		Created:        now,
+	}
+	state, detail := WithdrawalApproved, ""
+	if reason := q.overLimit(w, now); reason != "" {
+		state, detail = WithdrawalRejected, reason
+		w.Reason = reason
+	} else if t := q.Policy.ApprovalThreshold; t != nil && w.Amount.Cmp(t) >= 0 {
+		state = WithdrawalPendingApproval
+	}
+	if err := q.transition(w, state, actor, detail); err != nil {
+		return nil, false, err
+	}
+	q.byKey[w.IdempotencyKey] = w
+	return w.copy(), true, nil
+}
+
+// overLimit tells which daily limit w would go over, if any.
+func (q *WithdrawalQueue) overLimit(w *Withdrawal, now time.Time) string {
+	toAddr, all := new(big.Int).Set(w.Amount), new(big.Int).Set(w.Amount)
+	for _, o := range q.withdrawals {
+		if o.Token != w.Token || !o.counts() || now.Sub(o.Created) >= withdrawalLimitWindow {
+			continue
+		}
+		all.Add(all, o.Amount)
+		if o.To == w.To {
+			toAddr.Add(toAddr, o.Amount)
+		}
+	}
+	if l := q.Policy.PerAddressDaily; l != nil && toAddr.Cmp(l) > 0 {
+		return fmt.Sprintf("over the daily limit of %s to %s", l, w.To)
+	}
+	if l := q.Policy.GlobalDaily; l != nil && all.Cmp(l) > 0 {
+		return fmt.Sprintf("over the global daily limit of %s", l)
+	}
+	return ""
+}
+
+// Approve records the approval of approver, which approves the withdrawal once there are enough of them.
+func (q *WithdrawalQueue) Approve(id, approver string) (*Withdrawal, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	w, ok := q.withdrawals[id]
+	if !ok {
+		return nil, ErrWithdrawalNotFound
+	}
+	if w.State != WithdrawalPendingApproval {
+		return nil, fmt.Errorf("%w: %s is %s", ErrWithdrawalState, id, w.State)
+	}
+	for _, a := range w.Approvals {
+		if a == approver {
+			return w.copy(), nil
+		}
+	}
+	w.Approvals = append(w.Approvals, approver)
+	detail := fmt.Sprintf("approval %d of %d", len(w.Approvals), q.Policy.ApprovalsRequired)
+	to := WithdrawalPendingApproval
+	if len(w.Approvals) >= q.Policy.ApprovalsRequired {
+		to = WithdrawalApproved
+	}
+	if err := q.transition(w, to, approver, detail); err != nil {
+		w.Approvals = w.Approvals[:len(w.Approvals)-1]
+		return nil, err
+	}
+	return w.copy(), nil
+}
+
+// Reject rejects a withdrawal pending approval.
+func (q *WithdrawalQueue) Reject(id, approver, reason string) (*Withdrawal, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	w, ok := q.withdrawals[id]
+	if !ok {
+		return nil, ErrWithdrawalNotFound
+	}
+	if w.State != WithdrawalPendingApproval {
+		return nil, fmt.Errorf("%w: %s is %s", ErrWithdrawalState, id, w.State)
+	}
+	if reason == "" {
+		reason = "rejected by " + approver
+	}
+	w.Reason = reason
+	if err := q.transition(w, WithdrawalRejected, approver, reason); err != nil {
+		return nil, err
+	}
+	return w.copy(), nil
+}
+
+// Get returns the withdrawal id.
+func (q *WithdrawalQueue) Get(id string) (*Withdrawal, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	w, ok := q.withdrawals[id]
+	if !ok {
+		return nil, ErrWithdrawalNotFound
+	}
+	return w.copy(), nil
+}
+
+// List returns the withdrawals in state, or all of them, oldest first.
+func (q *WithdrawalQueue) List(state string) []*Withdrawal {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.list(state)
+}
+
+func (q *WithdrawalQueue) list(states ...string) []*Withdrawal {
+	list := []*Withdrawal{}
+	for _, w := range q.withdrawals {
+		for _, s := range states {
+			if s == "" || s == w.State {
+				list = append(list, w.copy())
+				break
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+// transition moves w to state, audited before it's saved. The caller holds q.mu.
+func (q *WithdrawalQueue) transition(w *Withdrawal, state, actor, detail string) error {
+	if err := q.Audit.Append(AuditEntry{Subject: w.ID, From: w.State, To: state, Actor: actor, Detail: detail}); err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	w.State, w.Updated = state, time.Now().UTC()
+	q.withdrawals[w.ID] = w
+	bs, err := json.MarshalIndent(q.withdrawals, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(q.path, bs)
+}
+
+// update applies change to the withdrawal id and moves it to state.
+func (q *WithdrawalQueue) update(id, state, detail string, change func(w *Withdrawal)) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	w := q.withdrawals[id]
+	if change != nil {
+		change(w)
+	}
+	return q.transition(w, state, "queue", detail)
+}
+
+func (w *Withdrawal) copy() *Withdrawal {
+	c := *w
+	c.Approvals = append([]string(nil), w.Approvals...)
+	return &c
+}
+
+// Run sends the approved withdrawals until ctx is done.
+func (q *WithdrawalQueue) Run(ctx context.Context) error {
+	q.observeNonces()
+	for {
+		q.Step(ctx)
+		if err := sleep(ctx, q.PollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// observeNonces skips the nonces of the txs signed before a restart, and
+// holds those not sent yet, which are not pending.
+func (q *WithdrawalQueue) observeNonces() {
+	for _, w := range q.List("") {
+		switch {
+		case w.Nonce == nil:
+		case w.State == WithdrawalSigned:
+			q.Nonces.Hold(*w.Nonce)
+		default:
+			q.Nonces.Observe(*w.Nonce)
+		}
+	}
+}
+
+// Step signs and sends the approved withdrawals in order, and follows the sent ones.
+func (q *WithdrawalQueue) Step(ctx context.Context) {
+	q.mu.Lock()
+	list := q.list(WithdrawalApproved, WithdrawalSigned, WithdrawalSent)
+	q.mu.Unlock()
+	for _, w := range list {
+		l := log.New("withdrawal", w.ID, "contract", w.Token, "to", w.To, "amount", w.Amount)
+		var err error
+		switch w.State {
+		case WithdrawalApproved:
+			var signed *Withdrawal
+			if signed, err = q.sign(ctx, w); err == nil {
+				err = q.broadcast(ctx, signed)
+			} else if errors.Is(err, ErrPolicyViolation) {
+				// never signed, as long as the policy holds
+				err = q.update(w.ID, WithdrawalFailed, err.Error(), func(w *Withdrawal) { w.Reason = err.Error() })
+			}
+		case WithdrawalSigned:
+			err = q.broadcast(ctx, w)
+		case WithdrawalSent:
+			err = q.follow(ctx, w)
+		}
+		if err != nil {
+			l.Warn("Process withdrawal failed", "state", w.State, "err", err)
+		}
+	}
+}
+
+// sign signs the transfer of w with the next nonce, and saves it before it's sent.
+func (q *WithdrawalQueue) sign(ctx context.Context, w *Withdrawal) (*Withdrawal, error) {
+	contract, err := NewEIP20(w.Token, q.Backend)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := q.Transactor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := q.Nonces.Next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	auth.Nonce, auth.NoSend = new(big.Int).SetUint64(nonce), true
+	tx, err := send(ctx, auth, "EIP20.transfer", func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return contract.Transfer(opts, w.To, w.Amount)
+	})
+	if err != nil {
+		// the nonce is not used
+		q.Nonces.Reset()
+		return nil, err
+	}
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		q.Nonces.Reset()
+		return nil, err
+	}
+	txHash := tx.Hash()
+	err = q.update(w.ID, WithdrawalSigned, fmt.Sprintf("nonce %d, tx %s", nonce, txHash.Hex()), func(w *Withdrawal) {
+		w.Nonce, w.TxHash, w.RawTx = &nonce, &txHash, raw
+	})
+	if err != nil {
+		q.Nonces.Reset()
+		return nil, err
+	}
+	// until it's sent, a reset must not hand the nonce out again
+	q.Nonces.Hold(nonce)
+	w.State, w.Nonce, w.TxHash, w.RawTx = WithdrawalSigned, &nonce, &txHash, raw
+	return w, nil
+}
+
+// broadcast sends the signed tx of w, again after a restart or a failure.
+func (q *WithdrawalQueue) broadcast(ctx context.Context, w *Withdrawal) error {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(w.RawTx); err != nil {
+		return err
+	}
+	err := q.Backend.SendTransaction(ctx, tx)
+	if err != nil && !strings.Contains(err.Error(), "already known") {
+		// mined already, or stuck: follow it if there's a receipt, else try again
+		if _, rerr := q.Backend.TransactionReceipt(ctx, tx.Hash()); rerr != nil {
+			return fmt.Errorf("send %s: %w", tx.Hash(), err)
+		}
+	}
+	logger(withTxLog(ctx, tx)).Info("Send withdrawal tx", "withdrawal", w.ID, "to", w.To, "amount", w.Amount)
+	if err := q.update(w.ID, WithdrawalSent, "", nil); err != nil {
+		return err
+	}
+	q.Nonces.Release(tx.Nonce())
+	return nil
+}
+
+// follow confirms or fails w once it's mined under Confirms blocks. A tx the
+// rpc-server dropped is sent again, one whose nonce another tx took fails.
+func (q *WithdrawalQueue) follow(ctx context.Context, w *Withdrawal) error {
+	timeout := q.DropTimeout
+	if timeout == 0 {
+		timeout = defaultDropTimeout
+	}
+	receipt, err := sentReceipt(ctx, q.Backend, *w.TxHash, q.Nonces.Account, *w.Nonce, w.Updated, timeout)
+	switch {
+	case errors.Is(err, ErrNonceTaken):
+		return q.update(w.ID, WithdrawalFailed, err.Error(), func(w *Withdrawal) { w.Reason = "transfer dropped, its nonce is taken" })
+	case errors.Is(err, ErrTxDropped):
+		return q.rebroadcast(ctx, w)
+	case receipt == nil || err != nil:
+		return err
+	}
+	head, err := q.Backend.BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	if confirmations(head, receipt) < q.Confirms {
+		return nil
+	}
+	detail := fmt.Sprintf("block %d", receipt.BlockNumber)
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return q.update(w.ID, WithdrawalFailed, detail, func(w *Withdrawal) { w.Reason = "transfer reverted" })
+	}
+	return q.update(w.ID, WithdrawalConfirmed, detail, nil)
+}
+
+// rebroadcast sends the signed tx of w again, the rpc-server dropped it.
+func (q *WithdrawalQueue) rebroadcast(ctx context.Context, w *Withdrawal) error {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(w.RawTx); err != nil {
+		return err
+	}
+	if err := q.Backend.SendTransaction(ctx, tx); err != nil && !strings.Contains(err.Error(), "already known") {
+		return fmt.Errorf("send %s again: %w", tx.Hash(), err)
+	}
+	logger(withTxLog(ctx, tx)).Warn("Send dropped withdrawal tx again", "withdrawal", w.ID)
+	return q.update(w.ID, WithdrawalSent, "sent again, the rpc-server dropped it", nil)
+}
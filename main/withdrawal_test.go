@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newTestQueue queues withdrawals of the tokens of the first account of env.
+func newTestQueue(t *testing.T, env *testEnv) (*WithdrawalQueue, string) {
+	t.Helper()
+	dir := t.TempDir()
+	q, err := NewWithdrawalQueue(filepath.Join(dir, WithdrawalFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditFile := filepath.Join(dir, WithdrawalAuditFile)
+	audit, err := OpenAuditLog(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { audit.Close() })
+	q.Backend, q.Audit = env.backend, audit
+	q.Policy = &WithdrawalPolicy{
+		PerAddressDaily:   big.NewInt(500),
+		GlobalDaily:       big.NewInt(800),
+		ApprovalThreshold: big.NewInt(300),
+		ApprovalsRequired: 2,
+		Approvers: []Credential{
+			{Name: "alice", TokenHash: tokenHash("alice-token")},
+			{Name: "bob", TokenHash: tokenHash("bob-token")},
+			{Name: "carol", TokenHash: tokenHash("carol-token")},
+		},
+		Submitters: []Credential{{Name: "payments", TokenHash: tokenHash("payments-token")}},
+	}
+	q.Transactor = func(ctx context.Context) (*bind.TransactOpts, error) {
+		return env.accounts[0].transactor(t), nil
+	}
+	q.Nonces = &NonceManager{Backend: env.backend, Account: env.accounts[0].addr}
+	return q, auditFile
+}
+
+func TestWithdrawalQueue(t *testing.T) {
+	env := newTestEnv(t, 1)
+	token, _, contract := env.deploy(10000)
+	q, auditFile := newTestQueue(t, env)
+
+	submit := func(key string, to common.Address, amount int64) *Withdrawal {
+		t.Helper()
+		w, _, err := q.Submit(&WithdrawalRequest{key, token, to, big.NewInt(amount)}, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+	small := submit("w1", common.Address{0x01}, 100)
+	if small.State != WithdrawalApproved {
+		t.Fatalf("small withdrawal is %s, want approved", small.State)
+	}
+	// the same key gives the same withdrawal, unless the params differ
+	if again, created, _ := q.Submit(&WithdrawalRequest{"w1", token, common.Address{0x01}, big.NewInt(100)}, "test"); created || again.ID != small.ID {
+		t.Fatalf("resubmit created %v %s, want %s", created, again.ID, small.ID)
+	}
+	if _, _, err := q.Submit(&WithdrawalRequest{"w1", token, common.Address{0x01}, big.NewInt(101)}, "test"); !errors.Is(err, ErrIdempotencyConflict) {
+		t.Fatalf("resubmit with another amount: %v", err)
+	}
+
+	big1 := submit("w2", common.Address{0x02}, 400)
+	if big1.State != WithdrawalPendingApproval {
+		t.Fatalf("big withdrawal is %s, want pending_approval", big1.State)
+	}
+	if w := submit("w3", common.Address{0x01}, 450); w.State != WithdrawalRejected || !strings.Contains(w.Reason, "daily limit of 500") {
+		t.Fatalf("got %s %q, want rejected over the address limit", w.State, w.Reason)
+	}
+	if w := submit("w4", common.Address{0x03}, 301); w.State != WithdrawalRejected || !strings.Contains(w.Reason, "global") {
+		t.Fatalf("got %s %q, want rejected over the global limit", w.State, w.Reason)
+	}
+
+	if w, _ := q.Approve(big1.ID, "alice"); w.State != WithdrawalPendingApproval {
+		t.Fatalf("approved by one of two: %s", w.State)
+	}
+	if w, _ := q.Approve(big1.ID, "alice"); len(w.Approvals) != 1 {
+		t.Fatalf("approvals %v, alice counted twice", w.Approvals)
+	}
+	if w, _ := q.Approve(big1.ID, "bob"); w.State != WithdrawalApproved {
+		t.Fatalf("approved by two of two: %s", w.State)
+	}
+	if _, err := q.Reject(big1.ID, "carol", ""); !errors.Is(err, ErrWithdrawalState) {
+		t.Fatalf("rejected an approved withdrawal: %v", err)
+	}
+
+	ctx := context.Background()
+	q.Step(ctx)
+	for _, id := range []string{small.ID, big1.ID} {
+		if w, _ := q.Get(id); w.State != WithdrawalSent {
+			t.Fatalf("%s is %s, want sent", id, w.State)
+		}
+	}
+	env.backend.Commit()
+	q.Step(ctx)
+	s, _ := q.Get(small.ID)
+	b, _ := q.Get(big1.ID)
+	if s.State != WithdrawalConfirmed || b.State != WithdrawalConfirmed || *b.Nonce != *s.Nonce+1 {
+		t.Fatalf("got %s nonce %d and %s nonce %d", s.State, *s.Nonce, b.State, *b.Nonce)
+	}
+	env.requireBalance(contract, common.Address{0x01}, 100)
+	env.requireBalance(contract, common.Address{0x02}, 400)
+
+	// every transition of the big withdrawal is audited
+	f, err := os.Open(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var trail []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Subject == big1.ID {
+			trail = append(trail, e.Actor+":"+e.To)
+		}
+	}
+	want := "test:pending_approval alice:pending_approval bob:approved queue:signed queue:sent queue:confirmed"
+	if got := strings.Join(trail, " "); got != want {
+		t.Fatalf("audit trail %q, want %q", got, want)
+	}
+}
+
+func TestWithdrawalRestart(t *testing.T) {
+	env := newTestEnv(t, 1)
+	token, _, contract := env.deploy(10000)
+	q, _ := newTestQueue(t, env)
+	w, _, err := q.Submit(&WithdrawalRequest{"w1", token, common.Address{0x01}, big.NewInt(100)}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := q.sign(ctx, w); err != nil {
+		t.Fatal(err)
+	}
+
+	// signed, but stopped before sending it
+	restarted, err := NewWithdrawalQueue(q.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted.Backend, restarted.Policy, restarted.Audit = q.Backend, q.Policy, q.Audit
+	restarted.Transactor, restarted.Nonces = q.Transactor, &NonceManager{Backend: env.backend, Account: env.accounts[0].addr}
+	if w, _ := restarted.Get(w.ID); w.State != WithdrawalSigned {
+		t.Fatalf("restarted with %s, want signed", w.State)
+	}
+	restarted.observeNonces()
+	restarted.Step(ctx)
+	env.backend.Commit()
+	restarted.Step(ctx)
+	if w, _ := restarted.Get(w.ID); w.State != WithdrawalConfirmed {
+		t.Fatalf("got %s, want confirmed", w.State)
+	}
+	env.requireBalance(contract, common.Address{0x01}, 100)
+	if n, _ := restarted.Nonces.Next(ctx); n != 2 {
+		t.Fatalf("next nonce %d, want 2 after the deploy and the withdrawal", n)
+	}
+}
+
+func TestWithdrawalDropped(t *testing.T) {
+	env := newTestEnv(t, 1)
+	token, _, contract := env.deploy(10000)
+	q, _ := newTestQueue(t, env)
+	q.Confirms, q.DropTimeout = 2, time.Hour
+	ctx := context.Background()
+	submit := func(key string) *Withdrawal {
+		t.Helper()
+		w, _, err := q.Submit(&WithdrawalRequest{key, token, common.Address{0x01}, big.NewInt(100)}, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.Step(ctx)
+		if w, _ = q.Get(w.ID); w.State != WithdrawalSent {
+			t.Fatalf("got %s, want sent", w.State)
+		}
+		return w
+	}
+
+	// the rpc-server forgets the transfer: waited for until the timeout, then sent again
+	w := submit("w1")
+	env.backend.Rollback()
+	q.Step(ctx)
+	if got, _ := q.Get(w.ID); got.State != WithdrawalSent || !got.Updated.Equal(w.Updated) {
+		t.Fatalf("got %s updated %s, want the transfer waited for", got.State, got.Updated)
+	}
+	q.DropTimeout = time.Nanosecond
+	q.Step(ctx)
+	if _, _, err := env.backend.TransactionByHash(ctx, *w.TxHash); err != nil {
+		t.Fatalf("transfer not sent again: %v", err)
+	}
+	q.DropTimeout = time.Hour
+
+	// confirmed under two blocks only
+	env.backend.Commit()
+	q.Step(ctx)
+	env.backend.Commit()
+	q.Step(ctx)
+	if got, _ := q.Get(w.ID); got.State != WithdrawalSent {
+		t.Fatalf("got %s under one block, want sent", got.State)
+	}
+	env.backend.Commit()
+	q.Step(ctx)
+	if got, _ := q.Get(w.ID); got.State != WithdrawalConfirmed {
+		t.Fatalf("got %s under two blocks, want confirmed", got.State)
+	}
+	env.requireBalance(contract, common.Address{0x01}, 100)
+
+	// another tx takes the nonce of the dropped transfer
+	w = submit("w2")
+	env.backend.Rollback()
+	if _, err := contract.Transfer(env.accounts[0].transactor(t), common.Address{0x02}, big.NewInt(1)); err != nil {
+		t.Fatal(err)
+	}
+	env.backend.Commit()
+	q.Step(ctx)
+	if got, _ := q.Get(w.ID); got.State != WithdrawalFailed || !strings.Contains(got.Reason, "nonce is taken") {
+		t.Fatalf("got %s %q, want failed", got.State, got.Reason)
+	}
+	env.requireBalance(contract, common.Address{0x01}, 100)
+}
+
+func TestWithdrawalAPI(t *testing.T) {
+	env := newTestEnv(t, 1)
+	token, _, _ := env.deploy(10000)
+	q, _ := newTestQueue(t, env)
+	srv := httptest.NewServer(&WithdrawalAPI{Queue: q, Tokens: &TokenService{ChainID: simChainID}})
+	defer srv.Close()
+
+	call := func(method, path, bearer, body string, out interface{}) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if out != nil {
+			json.NewDecoder(resp.Body).Decode(out)
+		}
+		return resp.StatusCode
+	}
+
+	body := `{"idempotencyKey": "k1", "token": "` + token.Hex() + `", "to": "0x0100000000000000000000000000000000000000", "amount": "300"}`
+	var w Withdrawal
+	for _, bearer := range []string{"", "mallory-token", "alice-token"} {
+		if code := call("POST", "/withdrawals", bearer, body, nil); code != http.StatusUnauthorized {
+			t.Fatalf("submit with token %q: %d", bearer, code)
+		}
+	}
+	if code := call("POST", "/withdrawals", "payments-token", body, &w); code != http.StatusCreated || w.State != WithdrawalPendingApproval {
+		t.Fatalf("submit: %d %s", code, w.State)
+	}
+	if code := call("POST", "/withdrawals", "payments-token", body, nil); code != http.StatusOK {
+		t.Fatalf("resubmit: %d, want 200", code)
+	}
+	if code := call("POST", "/withdrawals/"+w.ID+"/approve", "", "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("approve without a token: %d", code)
+	}
+	if code := call("POST", "/withdrawals/"+w.ID+"/approve", "mallory-token", "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("approve with a bad token: %d", code)
+	}
+	if code := call("POST", "/withdrawals/"+w.ID+"/reject", "carol-token", `{"reason": "suspicious"}`, &w); code != http.StatusOK || w.State != WithdrawalRejected || w.Reason != "suspicious" {
+		t.Fatalf("reject: %d %s %q", code, w.State, w.Reason)
+	}
+	for _, path := range []string{"/withdrawals", "/withdrawals/" + w.ID} {
+		if code := call("GET", path, "", "", nil); code != http.StatusUnauthorized {
+			t.Fatalf("read %s without a token: %d", path, code)
+		}
+	}
+	var list []*Withdrawal
+	if code := call("GET", "/withdrawals?state=rejected", "payments-token", "", &list); code != http.StatusOK || len(list) != 1 {
+		t.Fatalf("list: %d %d", code, len(list))
+	}
+	if code := call("GET", "/withdrawals/"+w.ID, "bob-token", "", &w); code != http.StatusOK || w.State != WithdrawalRejected {
+		t.Fatalf("get as an approver: %d %s", code, w.State)
+	}
+	if code := call("GET", "/withdrawals/nope", "payments-token", "", nil); code != http.StatusNotFound {
+		t.Fatalf("get unknown: %d", code)
+	}
+}
+
+func TestLoadWithdrawalPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), WithdrawalPolicyFile)
+	if p, err := LoadWithdrawalPolicy(path); err != nil || p != nil {
+		t.Fatalf("loaded a missing policy: %v, %v", p, err)
+	}
+	if err := ioutil.WriteFile(path, []byte(`{"approvers": [{"name": "alice", "tokenHash": "00"}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadWithdrawalPolicy(path); err == nil {
+		t.Fatal("loaded a policy without submitters")
+	}
+	if err := ioutil.WriteFile(path, []byte(`{"submitters": [{"name": "payments", "tokenHash": "00"}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := LoadWithdrawalPolicy(path); err != nil || len(p.Submitters) != 1 {
+		t.Fatalf("loaded %+v: %v", p, err)
+	}
+}
+
+// flakySend refuses the txs sent while down, like an rpc-server out of reach.
+type flakySend struct {
+	simBackend
+	down bool
+}
+
+func (b *flakySend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	if b.down {
+		return errors.New("connection refused")
+	}
+	return b.simBackend.SendTransaction(ctx, tx)
+}
+
+func TestWithdrawalSignFailure(t *testing.T) {
+	env := newTestEnv(t, 1)
+	token, _, contract := env.deploy(10000)
+	q, _ := newTestQueue(t, env)
+	backend := &flakySend{simBackend: env.backend, down: true}
+	q.Backend = backend
+	// the second transfer fails to sign, after its nonce is handed out
+	signs := 0
+	q.Transactor = func(ctx context.Context) (*bind.TransactOpts, error) {
+		auth := env.accounts[0].transactor(t)
+		if signs++; signs == 2 {
+			auth.Signer = func(common.Address, *types.Transaction) (*types.Transaction, error) {
+				return nil, errors.New("signer unavailable")
+			}
+		}
+		return auth, nil
+	}
+	var ids []string
+	for i, key := range []string{"w1", "w2", "w3"} {
+		w, _, err := q.Submit(&WithdrawalRequest{key, token, common.Address{byte(i + 1)}, big.NewInt(100)}, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, w.ID)
+		// the batch goes in the order of submission
+		time.Sleep(time.Millisecond)
+	}
+
+	// w1 is signed but not sent, w2 fails and resets the nonces, w3 must not reuse the nonce of w1
+	ctx := context.Background()
+	q.Step(ctx)
+	states := func() []string {
+		var got []string
+		for _, id := range ids {
+			w, _ := q.Get(id)
+			got = append(got, w.State)
+		}
+		return got
+	}
+	if got := strings.Join(states(), " "); got != "signed approved signed" {
+		t.Fatalf("states %s after a failed sign", got)
+	}
+	w1, _ := q.Get(ids[0])
+	w3, _ := q.Get(ids[2])
+	if *w3.Nonce != *w1.Nonce+1 {
+		t.Fatalf("w3 signed with nonce %d after w1 with %d", *w3.Nonce, *w1.Nonce)
+	}
+
+	// the simulated backend refuses w2 until w3 is sent, where a node would queue it
+	backend.down = false
+	for i := 0; i < 2; i++ {
+		q.Step(ctx)
+		env.backend.Commit()
+	}
+	q.Step(ctx)
+	if got := strings.Join(states(), " "); got != "confirmed confirmed confirmed" {
+		t.Fatalf("states %s", got)
+	}
+	if w2, _ := q.Get(ids[1]); *w2.Nonce != *w3.Nonce+1 {
+		t.Fatalf("w2 signed again with nonce %d after w3 with %d", *w2.Nonce, *w3.Nonce)
+	}
+	for i := range ids {
+		env.requireBalance(contract, common.Address{byte(i + 1)}, 100)
+	}
+}
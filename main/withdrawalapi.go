@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// WithdrawalAPI serves the WithdrawalQueue over http:
+//
+//	POST /withdrawals                {"idempotencyKey": "..", "token": "dtoken", "to": "0x..", "amount": "100"}
+//	GET  /withdrawals?state=pending_approval
+//	GET  /withdrawals/<id>
+//	POST /withdrawals/<id>/approve
+//	POST /withdrawals/<id>/reject    {"reason": ".."}
+//
+// The approvals need the bearer token of an approver, the requests that of a
+// submitter, and the reads that of either.
+type WithdrawalAPI struct {
+	Queue  *WithdrawalQueue
+	Tokens *TokenService
+}
+
+type withdrawalBody struct {
+	IdempotencyKey string `json:"idempotencyKey"`
+	// Token is a registry name or an address
+	Token  string `json:"token"`
+	To     string `json:"to"`
+	Amount string `json:"amount"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeResult(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (a *WithdrawalAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/withdrawals"), "/")
+	parts := strings.Split(path, "/")
+	if r.Method == http.MethodGet && !a.mayRead(r) {
+		writeResult(w, http.StatusUnauthorized, apiError{"a submitter or approver token is required"})
+		return
+	}
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		writeResult(w, http.StatusOK, a.Queue.List(r.URL.Query().Get("state")))
+	case path == "" && r.Method == http.MethodPost:
+		a.submit(w, r)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		wd, err := a.Queue.Get(parts[0])
+		a.reply(w, wd, err)
+	case len(parts) == 2 && r.Method == http.MethodPost && (parts[1] == "approve" || parts[1] == "reject"):
+		approver, ok := authenticate(a.Queue.Policy.Approvers, bearerToken(r))
+		if !ok {
+			writeResult(w, http.StatusUnauthorized, apiError{"an approver token is required"})
+			return
+		}
+		if parts[1] == "approve" {
+			wd, err := a.Queue.Approve(parts[0], approver)
+			a.reply(w, wd, err)
+			return
+		}
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		wd, err := a.Queue.Reject(parts[0], approver, body.Reason)
+		a.reply(w, wd, err)
+	default:
+		writeResult(w, http.StatusNotFound, apiError{"not found"})
+	}
+}
+
+// mayRead is true for the requests of the submitters and of the approvers.
+func (a *WithdrawalAPI) mayRead(r *http.Request) bool {
+	token := bearerToken(r)
+	_, submitter := authenticate(a.Queue.Policy.Submitters, token)
+	_, approver := authenticate(a.Queue.Policy.Approvers, token)
+	return submitter || approver
+}
+
+func (a *WithdrawalAPI) submit(w http.ResponseWriter, r *http.Request) {
+	submitter, ok := authenticate(a.Queue.Policy.Submitters, bearerToken(r))
+	if !ok {
+		writeResult(w, http.StatusUnauthorized, apiError{"a submitter token is required"})
+		return
+	}
+	var body withdrawalBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeResult(w, http.StatusBadRequest, apiError{"invalid body: " + err.Error()})
+		return
+	}
+	if body.IdempotencyKey == "" {
+		body.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	}
+	token, err := a.Tokens.TokenAddress(body.Token)
+	if err != nil {
+		writeResult(w, http.StatusBadRequest, apiError{err.Error()})
+		return
+	}
+	amount, ok := new(big.Int).SetString(body.Amount, 10)
+	if !ok || !common.IsHexAddress(body.To) {
+		writeResult(w, http.StatusBadRequest, apiError{"a to address and a decimal amount are required"})
+		return
+	}
+	req := &WithdrawalRequest{IdempotencyKey: body.IdempotencyKey, Token: token, To: common.HexToAddress(body.To), Amount: amount}
+	wd, created, err := a.Queue.Submit(req, submitter)
+	switch {
+	case errors.Is(err, ErrIdempotencyConflict):
+		writeResult(w, http.StatusConflict, apiError{err.Error()})
+	case errors.Is(err, ErrInvalidWithdrawal):
+		writeResult(w, http.StatusBadRequest, apiError{err.Error()})
+	case err != nil:
+		writeResult(w, http.StatusInternalServerError, apiError{err.Error()})
+	case created:
+		writeResult(w, http.StatusCreated, wd)
+	default:
+		writeResult(w, http.StatusOK, wd)
+	}
+}
+
+func (a *WithdrawalAPI) reply(w http.ResponseWriter, wd *Withdrawal, err error) {
+	switch {
+	case errors.Is(err, ErrWithdrawalNotFound):
+		writeResult(w, http.StatusNotFound, apiError{err.Error()})
+	case errors.Is(err, ErrWithdrawalState):
+		writeResult(w, http.StatusConflict, apiError{err.Error()})
+	case err != nil:
+		writeResult(w, http.StatusInternalServerError, apiError{err.Error()})
+	default:
+		writeResult(w, http.StatusOK, wd)
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(auth, "Bearer ")
+}